@@ -5,6 +5,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,13 +13,22 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
 
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
 	"asisaid.cn/JzSE/internal/common/config"
+	"asisaid.cn/JzSE/internal/common/errors"
 	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/common/metrics"
+	"asisaid.cn/JzSE/internal/common/tracing"
 	"asisaid.cn/JzSE/internal/coordinator/conflict"
 	"asisaid.cn/JzSE/internal/coordinator/metadata"
 	"asisaid.cn/JzSE/internal/coordinator/registry"
 	coordsync "asisaid.cn/JzSE/internal/coordinator/sync"
+	grpcapi "asisaid.cn/JzSE/pkg/api/grpc"
+	"asisaid.cn/JzSE/pkg/api/openapi"
 	"go.uber.org/zap"
 )
 
@@ -56,15 +66,45 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(ctx, tracing.Config{
+		Enabled:        cfg.Tracing.Enabled,
+		Exporter:       cfg.Tracing.Exporter,
+		Endpoint:       cfg.Tracing.Endpoint,
+		Insecure:       cfg.Tracing.Insecure,
+		SampleRatio:    cfg.Tracing.SampleRatio,
+		ServiceName:    "jzse-coordinator",
+		ServiceVersion: version,
+	})
+	if err != nil {
+		log.Fatal("failed to initialize tracing", zap.Error(err))
+	}
+	defer func() {
+		// Flush the spans of the last requests
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			log.Warn("failed to flush traces", zap.Error(err))
+		}
+	}()
+
 	// Initialize metadata manager
-	metaManager, err := metadata.NewEtcdManager(metadata.ManagerConfig{
+	etcdManager, err := metadata.NewEtcdManager(metadata.ManagerConfig{
 		Endpoints:   cfg.Coordinator.Endpoints,
 		DialTimeout: cfg.Coordinator.DialTimeout,
 	})
 	if err != nil {
 		log.Fatal("failed to initialize metadata manager", zap.Error(err))
 	}
-	defer metaManager.Close()
+	defer etcdManager.Close()
+
+	reg := metrics.NewRegistry()
+	var metaManager metadata.Manager = etcdManager
+	if cfg.Metrics.Enabled {
+		if metaManager, err = metadata.NewInstrumentedManager(etcdManager, reg); err != nil {
+			log.Fatal("failed to instrument metadata manager", zap.Error(err))
+		}
+	}
 
 	// Initialize region registry
 	regionRegistry := registry.NewRegistry()
@@ -86,18 +126,52 @@ func main() {
 
 	// Initialize conflict resolver
 	conflictResolver := conflict.NewResolver(conflict.StrategyLWW)
-	_ = conflictResolver // Will be used in API handlers
+
+	if cfg.Metrics.Enabled {
+		for _, register := range []func(prometheus.Registerer) error{
+			regionRegistry.RegisterMetrics,
+			syncEngine.RegisterMetrics,
+			conflictResolver.RegisterMetrics,
+		} {
+			if err := register(reg); err != nil {
+				log.Fatal("failed to register metrics", zap.Error(err))
+			}
+		}
+	}
 
 	// Setup Gin
 	if !cfg.Logger.Development {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.New()
-	router.Use(gin.Recovery())
+	router.Use(apierror.RequestContext())
+	router.Use(apierror.Recovery())
 	router.Use(ginLogger())
 
+	// Serve metrics outside the API, which authenticates requests
+	if cfg.Metrics.Enabled {
+		httpMetrics, err := metrics.NewHTTP(reg)
+		if err != nil {
+			log.Fatal("failed to register HTTP metrics", zap.Error(err))
+		}
+		router.Use(httpMetrics.Middleware())
+		router.GET(cfg.Metrics.Path, metrics.Handler(reg))
+	}
+
+	// Trace API requests, continuing the traces of callers
+	router.Use(tracing.Middleware())
+
+	// Initialize authentication
+	authn, err := auth.FromConfig(cfg.Auth)
+	if err != nil {
+		log.Fatal("invalid auth configuration", zap.Error(err))
+	}
+	if authn == nil {
+		log.Warn("no authentication configured, coordinator API is open to anyone")
+	}
+
 	// Register API routes
-	registerRoutes(router, metaManager, regionRegistry, syncEngine)
+	registerRoutes(router, authn, cfg.Auth.Required, metaManager, regionRegistry, syncEngine)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -115,6 +189,33 @@ func main() {
 		}
 	}()
 
+	// Start the gRPC server. Region calls always require credentials, as
+	// heartbeats do over HTTP.
+	var grpcServer *grpc.Server
+	if cfg.Server.GRPCAddr != "" {
+		creds, err := grpcapi.ServerTLS(cfg.Server.GRPCCertFile, cfg.Server.GRPCKeyFile)
+		if err != nil {
+			log.Fatal("failed to load gRPC TLS certificate", zap.Error(err))
+		}
+		grpcServer = grpcapi.NewServer(authn, auth.GRPCOptions{
+			Required:  cfg.Auth.Required,
+			Protected: grpcapi.RegionMethods,
+			TLS:       creds,
+		})
+		grpcapi.NewCoordinatorServer(metaManager, regionRegistry, syncEngine).Register(grpcServer)
+
+		go func() {
+			log.Info("gRPC server starting", zap.String("addr", cfg.Server.GRPCAddr))
+			lis, err := net.Listen("tcp", cfg.Server.GRPCAddr)
+			if err != nil {
+				log.Fatal("failed to listen for gRPC", zap.Error(err))
+			}
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatal("failed to start gRPC server", zap.Error(err))
+			}
+		}()
+	}
+
 	// Wait for interrupt
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -128,14 +229,38 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Error("server forced to shutdown", zap.Error(err))
 	}
+	if grpcServer != nil {
+		if err := grpcapi.Shutdown(shutdownCtx, grpcServer); err != nil {
+			log.Error("gRPC server forced to shutdown", zap.Error(err))
+		}
+	}
 
 	log.Info("server exited")
 }
 
-// registerRoutes registers all coordinator API routes.
-func registerRoutes(r *gin.Engine, metaManager metadata.Manager, reg *registry.Registry, sync *coordsync.Engine) {
+// registerRoutes registers all coordinator API routes. With an
+// authenticator, region heartbeats and pulls of pending changes always
+// require credentials, those of the region they are made for, and other
+// routes do if required is set.
+// Requests are validated against the OpenAPI specification.
+func registerRoutes(r *gin.Engine, authn auth.Authenticator, required bool, metaManager metadata.Manager, reg *registry.Registry, sync *coordsync.Engine) {
+	r.NoRoute(apierror.NoRoute)
+
+	spec := openapi.Coordinator()
 	api := r.Group("/api/v1")
+	requireAuth := func(c *gin.Context) { c.Next() }
+	if authn != nil {
+		api.Use(auth.Middleware(authn, auth.MiddlewareOptions{
+			Required:    required,
+			PublicPaths: []string{"/api/v1/health", openapi.SpecPath},
+		}))
+		requireAuth = auth.Require(authn)
+	}
+	api.Use(openapi.Validator(spec))
 	{
+		// API specification
+		api.GET("/openapi.json", openapi.Serve(spec))
+
 		// Health check
 		api.GET("/health", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"status": "healthy"})
@@ -146,7 +271,7 @@ func registerRoutes(r *gin.Engine, metaManager metadata.Manager, reg *registry.R
 			fileID := c.Param("id")
 			meta, err := metaManager.Get(c.Request.Context(), fileID)
 			if err != nil {
-				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				apierror.Abort(c, err)
 				return
 			}
 			c.JSON(http.StatusOK, meta)
@@ -164,21 +289,21 @@ func registerRoutes(r *gin.Engine, metaManager metadata.Manager, reg *registry.R
 				regionID := c.Param("id")
 				info, err := reg.GetRegion(c.Request.Context(), regionID)
 				if err != nil {
-					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					apierror.Abort(c, err)
 					return
 				}
 				c.JSON(http.StatusOK, info)
 			})
 
-			regions.POST("/:id/heartbeat", func(c *gin.Context) {
+			regions.POST("/:id/heartbeat", requireAuth, auth.RequireRegion("id"), func(c *gin.Context) {
 				regionID := c.Param("id")
 				var status registry.RegionStatus
 				if err := c.ShouldBindJSON(&status); err != nil {
-					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					apierror.Abort(c, errors.E("coordinator.Heartbeat", errors.ErrInvalidInput, err, "region status"))
 					return
 				}
 				if err := reg.Heartbeat(c.Request.Context(), regionID, &status); err != nil {
-					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					apierror.Abort(c, err)
 					return
 				}
 				c.Status(http.StatusNoContent)
@@ -186,15 +311,20 @@ func registerRoutes(r *gin.Engine, metaManager metadata.Manager, reg *registry.R
 		}
 
 		// Sync operations
-		api.GET("/sync/pending/:region_id", func(c *gin.Context) {
+		api.GET("/sync/pending/:region_id", requireAuth, auth.RequireRegion("region_id"), func(c *gin.Context) {
 			regionID := c.Param("region_id")
-			events, _ := sync.GetPendingChanges(c.Request.Context(), regionID)
+			events, err := sync.GetPendingChanges(c.Request.Context(), regionID)
+			if err != nil {
+				apierror.Abort(c, err)
+				return
+			}
 			c.JSON(http.StatusOK, events)
 		})
 	}
 }
 
-// ginLogger returns a Gin middleware for request logging.
+// ginLogger returns a Gin middleware for request logging, with the
+// request fields of the request context.
 func ginLogger() gin.HandlerFunc {
 	log := logger.WithComponent("http")
 
@@ -204,7 +334,7 @@ func ginLogger() gin.HandlerFunc {
 
 		c.Next()
 
-		log.Info("request",
+		logger.FromContext(c.Request.Context(), log).Info("request",
 			zap.String("method", c.Request.Method),
 			zap.String("path", path),
 			zap.Int("status", c.Writer.Status()),
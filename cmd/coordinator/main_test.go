@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/coordinator/metadata"
+	"asisaid.cn/JzSE/internal/coordinator/registry"
+	coordsync "asisaid.cn/JzSE/internal/coordinator/sync"
+	"asisaid.cn/JzSE/pkg/api/openapi"
+)
+
+func TestRegisterRoutes_Documented(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerRoutes(router, nil, false, nil, nil, nil)
+
+	undocumented, unregistered := openapi.Diff(openapi.Coordinator(), router.Routes())
+	if len(undocumented) > 0 {
+		t.Errorf("routes missing from the OpenAPI specification: %v", undocumented)
+	}
+	if len(unregistered) > 0 {
+		t.Errorf("documented operations without a route: %v", unregistered)
+	}
+}
+
+func TestRegisterRoutes_RegionIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	authn := auth.NewAPIKeyAuthenticator(map[string]auth.APIKey{
+		"a-key": {UserID: "region-a"},
+		"b-key": {UserID: "region-b"},
+	})
+	reg := registry.NewRegistry()
+	ctx := context.Background()
+	for _, id := range []string{"region-a", "region-b"} {
+		if err := reg.Register(ctx, &registry.RegionInfo{ID: id, Name: id}); err != nil {
+			t.Fatalf("Register(%v): %v", id, err)
+		}
+	}
+	metaManager, err := metadata.NewEtcdManager(metadata.ManagerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metaManager.Close()
+	engine := coordsync.NewEngine(coordsync.EngineConfig{DefaultStrategy: "eager"}, metaManager)
+	engine.RegisterRegion("region-a")
+	engine.RegisterRegion("region-b")
+	change := &coordsync.ChangeEvent{ID: "event-1", Type: "CREATE", FileID: "file-1", RegionID: "region-b", Metadata: &metadata.GlobalFileMetadata{}}
+	change.Metadata.ID = "file-1"
+	change.Metadata.Path = "/a.txt"
+	if err := engine.HandleChange(ctx, change); err != nil {
+		t.Fatalf("HandleChange: %v", err)
+	}
+	router := gin.New()
+	registerRoutes(router, authn, false, nil, reg, engine)
+
+	serve := func(method, path, key, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name, method, path, key, body string
+		want                          int
+	}{
+		{"anonymous heartbeat", "POST", "/api/v1/regions/region-a/heartbeat", "", `{"state":"healthy"}`, http.StatusUnauthorized},
+		{"own heartbeat", "POST", "/api/v1/regions/region-a/heartbeat", "a-key", `{"state":"healthy"}`, http.StatusNoContent},
+		{"heartbeat for another region", "POST", "/api/v1/regions/region-a/heartbeat", "b-key", `{"state":"offline"}`, http.StatusForbidden},
+		{"anonymous pull", "GET", "/api/v1/sync/pending/region-a", "", "", http.StatusUnauthorized},
+		{"pull for another region", "GET", "/api/v1/sync/pending/region-a", "b-key", "", http.StatusForbidden},
+		{"own pull", "GET", "/api/v1/sync/pending/region-b", "b-key", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := serve(tt.method, tt.path, tt.key, tt.body); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if info, _ := reg.GetRegion(ctx, "region-a"); info.Status.State != "healthy" {
+		t.Errorf("state of region-a = %q, want healthy", info.Status.State)
+	}
+	if events, _ := engine.GetPendingChanges(ctx, "region-a"); len(events) != 1 {
+		t.Errorf("pending changes of region-a = %d, want 1 left after refused pulls", len(events))
+	}
+}
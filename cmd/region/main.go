@@ -5,6 +5,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,13 +13,26 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
 
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
 	"asisaid.cn/JzSE/internal/common/config"
 	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/common/metrics"
+	"asisaid.cn/JzSE/internal/common/tracing"
+	"asisaid.cn/JzSE/internal/coordinator/registry"
+	"asisaid.cn/JzSE/internal/region/events"
 	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/precommit"
+	"asisaid.cn/JzSE/internal/region/presign"
 	"asisaid.cn/JzSE/internal/region/service"
 	"asisaid.cn/JzSE/internal/region/storage"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+	"asisaid.cn/JzSE/internal/region/webhook"
+	grpcapi "asisaid.cn/JzSE/pkg/api/grpc"
 	httpapi "asisaid.cn/JzSE/pkg/api/http"
+	"asisaid.cn/JzSE/pkg/api/s3"
 	"go.uber.org/zap"
 )
 
@@ -56,6 +70,32 @@ func main() {
 		zap.String("region_id", cfg.Region.ID),
 	)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(ctx, tracing.Config{
+		Enabled:        cfg.Tracing.Enabled,
+		Exporter:       cfg.Tracing.Exporter,
+		Endpoint:       cfg.Tracing.Endpoint,
+		Insecure:       cfg.Tracing.Insecure,
+		SampleRatio:    cfg.Tracing.SampleRatio,
+		ServiceName:    "jzse-region",
+		ServiceVersion: version,
+		InstanceID:     cfg.Region.ID,
+	})
+	if err != nil {
+		log.Fatal("failed to initialize tracing", zap.Error(err))
+	}
+	defer func() {
+		// Flush the spans of the last requests
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			log.Warn("failed to flush traces", zap.Error(err))
+		}
+	}()
+
 	// Initialize storage backend
 	storageBackend, err := storage.NewBackend(cfg.Storage.Backend, cfg.Storage.Path)
 	if err != nil {
@@ -64,26 +104,181 @@ func main() {
 	defer storageBackend.Close()
 
 	// Initialize metadata store
-	metaStore, err := metadata.NewBadgerStore(cfg.Metadata.DBPath)
+	metaStore, err := newMetadataStore(cfg.Metadata)
 	if err != nil {
 		log.Fatal("failed to initialize metadata store", zap.Error(err))
 	}
 	defer metaStore.Close()
 
+	// Instrument and trace storage and metadata. Maintenance and status
+	// reports use the metadata store directly.
+	reg := metrics.NewRegistry()
+	var (
+		backend storage.Backend = storageBackend
+		store   metadata.Store  = metaStore
+	)
+	if cfg.Metrics.Enabled {
+		if backend, err = storage.NewInstrumentedBackend(storageBackend, reg); err != nil {
+			log.Fatal("failed to instrument storage", zap.Error(err))
+		}
+		if store, err = metadata.NewInstrumentedStore(metaStore, reg); err != nil {
+			log.Fatal("failed to instrument metadata store", zap.Error(err))
+		}
+	}
+	if cfg.Tracing.Enabled {
+		backend = storage.NewTracedBackend(backend)
+		store = metadata.NewTracedStore(store)
+	}
+
+	// Initialize sync agent
+	syncAgent := regionsync.NewAgent(regionsync.AgentConfig{
+		RegionID:      cfg.Region.ID,
+		Mode:          cfg.Sync.Mode,
+		BatchSize:     cfg.Sync.BatchSize,
+		BatchInterval: cfg.Sync.BatchInterval,
+		RetryInterval: cfg.Sync.RetryInterval,
+		MaxRetries:    cfg.Sync.MaxRetries,
+
+		HeartbeatInterval: cfg.Sync.HeartbeatInterval,
+	}, store)
+	if cfg.Metrics.Enabled {
+		if err := syncAgent.RegisterMetrics(reg); err != nil {
+			log.Fatal("failed to register sync metrics", zap.Error(err))
+		}
+	}
+	if cfg.Coordinator.GRPCAddr != "" {
+		coordinator, err := newCoordinatorClient(cfg)
+		if err != nil {
+			log.Fatal("failed to initialize coordinator client", zap.Error(err))
+		}
+		defer coordinator.Close()
+		syncAgent.SetCoordinator(coordinator)
+	} else {
+		log.Warn("coordinator.grpc_addr not set, changes are not synced")
+	}
+	if err := syncAgent.Start(ctx); err != nil {
+		log.Fatal("failed to start sync agent", zap.Error(err))
+	}
+	defer syncAgent.Stop()
+
+	// Initialize tombstone compactor
+	compactor := metadata.NewCompactor(metadata.CompactorConfig{
+		TombstoneTTL: cfg.Metadata.TombstoneTTL,
+		Interval:     cfg.Metadata.CompactInterval,
+	}, store)
+	if err := compactor.Start(ctx); err != nil {
+		log.Fatal("failed to start tombstone compactor", zap.Error(err))
+	}
+	defer compactor.Stop()
+
 	// Create file service
-	fileService := service.NewFileService(cfg.Region.ID, storageBackend, metaStore)
+	fileService := service.NewFileService(cfg.Region.ID, backend, store)
+	if err := os.MkdirAll(cfg.Storage.TempPath, 0755); err != nil {
+		log.Fatal("failed to create temp directory", zap.Error(err))
+	}
+	fileService.SetTempDir(cfg.Storage.TempPath)
+	fileService.SetAdminGroup(cfg.Auth.AdminGroup)
+
+	// Initialize change notifications and webhooks, fed by the same events
+	// as the sync agent
+	notifiers := service.Notifiers{syncAgent}
+	var eventBroker *events.Broker
+	if cfg.Events.Enabled {
+		eventBroker = events.NewBroker(events.BrokerConfig{
+			HistorySize: cfg.Events.HistorySize,
+			BufferSize:  cfg.Events.BufferSize,
+		})
+		notifiers = append(notifiers, eventBroker)
+	}
+	var dispatcher *webhook.Dispatcher
+	if len(cfg.Webhooks.Subscriptions) > 0 {
+		dispatcher, err = newWebhookDispatcher(cfg)
+		if err != nil {
+			log.Fatal("failed to initialize webhooks", zap.Error(err))
+		}
+		dispatcher.Start(ctx)
+		defer dispatcher.Stop()
+		notifiers = append(notifiers, dispatcher)
+	}
+	fileService.SetChangeNotifier(notifiers)
+	if hooks := newPreCommitHooks(cfg.Hooks); hooks != nil {
+		fileService.SetPreCommitHooks(hooks)
+	}
 
 	// Create HTTP handler
 	handler := httpapi.NewHandler(fileService)
+	handler.SetMetadataMaintainer(metaStore)
+	handler.SetStatusReporter(service.NewStatusReporter(cfg.Region.ID, storageBackend, metaStore, syncAgent, service.StatusConfig{
+		MaxContactAge: cfg.Status.MaxContactAge,
+		MaxSyncLag:    cfg.Status.MaxSyncLag,
+		MinFreeRatio:  cfg.Status.MinFreeRatio,
+	}))
+
+	maxUploadSize, err := config.ParseSize(cfg.Server.MaxUploadSize)
+	if err != nil {
+		log.Fatal("invalid server.max_upload_size", zap.Error(err))
+	}
+	handler.SetMaxUploadSize(maxUploadSize)
+
+	maxArchiveSize, err := config.ParseSize(cfg.Server.MaxArchiveSize)
+	if err != nil {
+		log.Fatal("invalid server.max_archive_size", zap.Error(err))
+	}
+	handler.SetMaxArchiveSize(maxArchiveSize)
+	handler.SetMaxArchiveEntries(cfg.Server.MaxArchiveEntries)
+	handler.SetMaxBatchSize(cfg.Server.MaxBatchSize)
+	handler.SetWebDAV(cfg.Server.WebDAV)
+
+	if cfg.Region.Secret != "" {
+		signer, err := presign.NewSigner([]byte(cfg.Region.Secret))
+		if err != nil {
+			log.Fatal("invalid region.secret", zap.Error(err))
+		}
+		handler.SetPresigner(signer, cfg.Server.PresignMaxExpiry)
+	} else {
+		log.Warn("region.secret not set, presigned URLs disabled")
+	}
+
+	if eventBroker != nil {
+		handler.SetEventBroker(eventBroker, cfg.Events.KeepAlive)
+	}
+	if dispatcher != nil {
+		handler.SetWebhookDispatcher(dispatcher)
+	}
+
+	authn, err := auth.FromConfig(cfg.Auth)
+	if err != nil {
+		log.Fatal("invalid auth configuration", zap.Error(err))
+	}
+	if authn != nil {
+		handler.SetAuthenticator(authn, cfg.Auth.Required)
+		handler.SetAdminGroup(cfg.Auth.AdminGroup)
+	} else {
+		log.Warn("no authentication configured, API requests are anonymous and admin endpoints are refused")
+	}
 
 	// Setup Gin
 	if !cfg.Logger.Development {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.New()
-	router.Use(gin.Recovery())
+	router.Use(apierror.RequestContext(logger.RegionID(cfg.Region.ID)))
+	router.Use(apierror.Recovery())
 	router.Use(ginLogger())
 
+	// Serve metrics outside the API, which authenticates requests
+	var httpMetrics *metrics.HTTP
+	if cfg.Metrics.Enabled {
+		if httpMetrics, err = metrics.NewHTTP(reg); err != nil {
+			log.Fatal("failed to register HTTP metrics", zap.Error(err))
+		}
+		router.Use(httpMetrics.Middleware())
+		router.GET(cfg.Metrics.Path, metrics.Handler(reg))
+	}
+
+	// Trace API requests, continuing the traces of callers
+	router.Use(tracing.Middleware())
+
 	// Register routes
 	handler.RegisterRoutes(router)
 
@@ -94,6 +289,10 @@ func main() {
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 	}
+	if eventBroker != nil {
+		// End event streams, which would otherwise hold up shutdown
+		server.RegisterOnShutdown(eventBroker.Close)
+	}
 
 	// Start server in goroutine
 	go func() {
@@ -103,6 +302,46 @@ func main() {
 		}
 	}()
 
+	// Start the S3 gateway on its own listener, as S3 clients expect
+	// buckets at the root of the URL space
+	var s3Server *http.Server
+	if cfg.S3.Addr != "" {
+		s3Server, err = newS3Server(cfg, fileService, maxUploadSize, httpMetrics)
+		if err != nil {
+			log.Fatal("failed to initialize S3 gateway", zap.Error(err))
+		}
+		go func() {
+			log.Info("S3 gateway starting", zap.String("addr", cfg.S3.Addr))
+			if err := s3Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal("failed to start S3 gateway", zap.Error(err))
+			}
+		}()
+	}
+
+	// Start the gRPC server
+	var grpcServer *grpc.Server
+	if cfg.Server.GRPCAddr != "" {
+		creds, err := grpcapi.ServerTLS(cfg.Server.GRPCCertFile, cfg.Server.GRPCKeyFile)
+		if err != nil {
+			log.Fatal("failed to load gRPC TLS certificate", zap.Error(err))
+		}
+		grpcServer = grpcapi.NewServer(authn, auth.GRPCOptions{Required: cfg.Auth.Required, TLS: creds}, logger.RegionID(cfg.Region.ID))
+		fileServer := grpcapi.NewFileServer(fileService)
+		fileServer.SetMaxUploadSize(maxUploadSize)
+		fileServer.Register(grpcServer)
+
+		go func() {
+			log.Info("gRPC server starting", zap.String("addr", cfg.Server.GRPCAddr))
+			lis, err := net.Listen("tcp", cfg.Server.GRPCAddr)
+			if err != nil {
+				log.Fatal("failed to listen for gRPC", zap.Error(err))
+			}
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatal("failed to start gRPC server", zap.Error(err))
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -111,17 +350,177 @@ func main() {
 	log.Info("shutting down server...")
 
 	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer shutdownCancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Error("server forced to shutdown", zap.Error(err))
 	}
+	if s3Server != nil {
+		if err := s3Server.Shutdown(shutdownCtx); err != nil {
+			log.Error("S3 gateway forced to shutdown", zap.Error(err))
+		}
+	}
+	if grpcServer != nil {
+		if err := grpcapi.Shutdown(shutdownCtx, grpcServer); err != nil {
+			log.Error("gRPC server forced to shutdown", zap.Error(err))
+		}
+	}
 
 	log.Info("server exited")
 }
 
-// ginLogger returns a Gin middleware that logs requests using zap.
+// newMetadataStore opens the metadata store with the configured Badger tuning.
+func newMetadataStore(cfg config.MetadataConfig) (*metadata.BadgerStore, error) {
+	sizes := map[string]string{
+		"cache_size":       cfg.CacheSize,
+		"index_cache_size": cfg.IndexCacheSize,
+		"memtable_size":    cfg.MemTableSize,
+		"value_threshold":  cfg.ValueThreshold,
+	}
+	parsed := make(map[string]int64, len(sizes))
+	for key, value := range sizes {
+		size, err := config.ParseSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("metadata.%s: %w", key, err)
+		}
+		parsed[key] = size
+	}
+
+	return metadata.NewBadgerStore(metadata.StoreConfig{
+		Path:           cfg.DBPath,
+		BlockCacheSize: parsed["cache_size"],
+		IndexCacheSize: parsed["index_cache_size"],
+		MemTableSize:   parsed["memtable_size"],
+		ValueThreshold: parsed["value_threshold"],
+		SyncWrites:     cfg.SyncWrites,
+		Compression:    cfg.Compression,
+		GCInterval:     cfg.GCInterval,
+		GCDiscardRatio: cfg.GCDiscardRatio,
+	})
+}
+
+func newPreCommitHooks(cfg config.HooksConfig) *precommit.Pipeline {
+	var hooks []precommit.Hook
+	if len(cfg.BlockedExtensions) > 0 || len(cfg.BlockedMimeTypes) > 0 {
+		hooks = append(hooks, precommit.NewFileTypeHook(cfg.BlockedExtensions, cfg.BlockedMimeTypes))
+	}
+	for _, hc := range cfg.HTTP {
+		name := hc.Name
+		if name == "" {
+			name = hc.URL
+		}
+		hooks = append(hooks, precommit.NewHTTPHook(name, hc.URL))
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	return precommit.NewPipeline(precommit.PipelineConfig{
+		Timeout:  cfg.Timeout,
+		FailOpen: cfg.FailOpen,
+	}, hooks...)
+}
+
+func newWebhookDispatcher(cfg *config.Config) (*webhook.Dispatcher, error) {
+	subs := make([]*webhook.Subscription, 0, len(cfg.Webhooks.Subscriptions))
+	for _, sc := range cfg.Webhooks.Subscriptions {
+		sub := &webhook.Subscription{
+			ID:     sc.ID,
+			URL:    sc.URL,
+			Secret: []byte(sc.Secret),
+			Paths:  sc.Paths,
+		}
+		for _, e := range sc.Events {
+			sub.Events = append(sub.Events, events.Type(e))
+		}
+		subs = append(subs, sub)
+	}
+
+	store, err := webhook.NewFileStore(cfg.Webhooks.QueuePath)
+	if err != nil {
+		return nil, err
+	}
+	return webhook.NewDispatcher(webhook.DispatcherConfig{
+		RegionID:       cfg.Region.ID,
+		MaxAttempts:    cfg.Webhooks.MaxAttempts,
+		InitialBackoff: cfg.Webhooks.InitialBackoff,
+		MaxBackoff:     cfg.Webhooks.MaxBackoff,
+		Timeout:        cfg.Webhooks.Timeout,
+	}, subs, store)
+}
+
+// newS3Server creates the server of the S3 gateway. Requests are recorded
+// in httpMetrics unless it is nil.
+func newS3Server(cfg *config.Config, fileService *service.FileService, maxUploadSize int64, httpMetrics *metrics.HTTP) (*http.Server, error) {
+	keys, err := auth.HMACKeys(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	gateway, err := s3.NewGateway(s3.GatewayConfig{
+		Region:         cfg.S3.Region,
+		MaxSkew:        cfg.Auth.HMACMaxSkew,
+		AllowAnonymous: !cfg.Auth.Required,
+		MaxUploadSize:  maxUploadSize,
+		MultipartPath:  cfg.S3.MultipartPath,
+		MultipartTTL:   cfg.S3.MultipartTTL,
+	}, fileService, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	router := gin.New()
+	router.Use(apierror.RequestContext(logger.RegionID(cfg.Region.ID)))
+	router.Use(apierror.Recovery())
+	router.Use(ginLogger())
+	if httpMetrics != nil {
+		router.Use(httpMetrics.Middleware())
+	}
+	router.Use(tracing.Middleware())
+	gateway.RegisterRoutes(router)
+
+	return &http.Server{
+		Addr:         cfg.S3.Addr,
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}, nil
+}
+
+// newCoordinatorClient creates the client the sync agent reaches the
+// coordinator with, over TLS if configured, signing calls with the
+// region's HMAC key if one is configured.
+func newCoordinatorClient(cfg *config.Config) (*grpcapi.CoordinatorClient, error) {
+	clientCfg := grpcapi.ClientConfig{
+		Addr:    cfg.Coordinator.GRPCAddr,
+		Timeout: cfg.Coordinator.CallTimeout,
+		Region: registry.RegionInfo{
+			ID:       cfg.Region.ID,
+			Name:     cfg.Region.Name,
+			Endpoint: cfg.Server.GRPCAddr,
+			Location: registry.GeoLocation{City: cfg.Region.Location},
+		},
+	}
+	if cfg.Coordinator.TLS {
+		creds, err := grpcapi.ClientTLS(cfg.Coordinator.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientCfg.TLS = creds
+	}
+	if cfg.Coordinator.KeyID != "" {
+		if !cfg.Coordinator.TLS {
+			return nil, fmt.Errorf("coordinator.key_id requires coordinator.tls: signed calls are only sent over TLS")
+		}
+		clientCfg.Credentials = auth.HMACCredentials{
+			KeyID:  cfg.Coordinator.KeyID,
+			Secret: []byte(cfg.Coordinator.KeySecret),
+		}
+	}
+	return grpcapi.NewCoordinatorClient(clientCfg)
+}
+
+// ginLogger returns a Gin middleware that logs requests using zap, with
+// the request fields of their context.
 func ginLogger() gin.HandlerFunc {
 	log := logger.WithComponent("http")
 
@@ -134,7 +533,7 @@ func ginLogger() gin.HandlerFunc {
 		latency := time.Since(start)
 		status := c.Writer.Status()
 
-		log.Info("request",
+		logger.FromContext(c.Request.Context(), log).Info("request",
 			zap.String("method", c.Request.Method),
 			zap.String("path", path),
 			zap.Int("status", status),
@@ -0,0 +1,249 @@
+// Package apierror renders errors as HTTP responses for the region and
+// coordinator APIs. Error kinds map to status codes and a stable JSON
+// envelope:
+//
+//	{"error": {"code": "not_found", "message": "...", "request_id": "...", "retryable": false}}
+//
+// Messages never include operation names or the causes of server errors;
+// those are logged instead.
+package apierror
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+)
+
+// Code is a machine-readable error code.
+type Code string
+
+// Error codes.
+const (
+	CodeInvalidInput       Code = "invalid_input"
+	CodeUnauthorized       Code = "unauthorized"
+	CodeForbidden          Code = "forbidden"
+	CodeNotFound           Code = "not_found"
+	CodeAlreadyExists      Code = "already_exists"
+	CodeConflict           Code = "conflict"
+	CodePreconditionFailed Code = "precondition_failed"
+	CodeTooLarge           Code = "too_large"
+	CodeRejected           Code = "content_rejected"
+	CodeStorageFull        Code = "storage_full"
+	CodeQueueFull          Code = "queue_full"
+	CodeUnavailable        Code = "unavailable"
+	CodeTimeout            Code = "timeout"
+	CodeSyncFailed         Code = "sync_failed"
+	CodeNotImplemented     Code = "not_implemented"
+	CodeInternal           Code = "internal"
+)
+
+// Request ID header and the gin context key it is stored under.
+const (
+	RequestIDHeader = "X-Request-ID"
+	RequestIDKey    = "request_id"
+)
+
+// Longest client-supplied request ID that is accepted.
+const maxRequestIDLength = 128
+
+// Body is the content of the error envelope.
+type Body struct {
+	Code      Code   `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+// Envelope is the JSON body of every error response.
+type Envelope struct {
+	Error Body `json:"error"`
+}
+
+// class is the HTTP rendering of an error kind.
+type class struct {
+	status    int
+	code      Code
+	retryable bool
+}
+
+// classes maps error kinds to their rendering, checked in order since an
+// error may wrap several kinds.
+var classes = []struct {
+	kinds []error
+	class
+}{
+	{[]error{errors.ErrTooLarge}, class{http.StatusRequestEntityTooLarge, CodeTooLarge, false}},
+	{[]error{errors.ErrNotFound}, class{http.StatusNotFound, CodeNotFound, false}},
+	{[]error{errors.ErrAlreadyExists}, class{http.StatusConflict, CodeAlreadyExists, false}},
+	{[]error{errors.ErrConflict}, class{http.StatusConflict, CodeConflict, false}},
+	{[]error{errors.ErrInvalidInput}, class{http.StatusBadRequest, CodeInvalidInput, false}},
+	{[]error{errors.ErrVersionMismatch}, class{http.StatusPreconditionFailed, CodePreconditionFailed, false}},
+	{[]error{errors.ErrUnauthorized}, class{http.StatusUnauthorized, CodeUnauthorized, false}},
+	{[]error{errors.ErrForbidden}, class{http.StatusForbidden, CodeForbidden, false}},
+	{[]error{errors.ErrRejected}, class{http.StatusUnprocessableEntity, CodeRejected, false}},
+	{[]error{errors.ErrNotImplemented}, class{http.StatusNotImplemented, CodeNotImplemented, false}},
+	{[]error{errors.ErrStorageFull}, class{http.StatusInsufficientStorage, CodeStorageFull, false}},
+	{[]error{errors.ErrQueueFull}, class{http.StatusServiceUnavailable, CodeQueueFull, true}},
+	{[]error{
+		errors.ErrRegionOffline,
+		errors.ErrRegionUnavailable,
+		errors.ErrCoordinatorUnavailable,
+		errors.ErrNoHealthyRegion,
+	}, class{http.StatusServiceUnavailable, CodeUnavailable, true}},
+	{[]error{errors.ErrSyncTimeout, context.DeadlineExceeded}, class{http.StatusGatewayTimeout, CodeTimeout, true}},
+	{[]error{errors.ErrSyncFailed}, class{http.StatusBadGateway, CodeSyncFailed, true}},
+}
+
+var internal = class{http.StatusInternalServerError, CodeInternal, false}
+
+// classify returns the rendering of err.
+func classify(err error) class {
+	var maxBytesErr *http.MaxBytesError
+	if stderrors.As(err, &maxBytesErr) {
+		return class{http.StatusRequestEntityTooLarge, CodeTooLarge, false}
+	}
+	for _, c := range classes {
+		for _, kind := range c.kinds {
+			if stderrors.Is(err, kind) {
+				return c.class
+			}
+		}
+	}
+	return internal
+}
+
+// Status returns the HTTP status code for err.
+func Status(err error) int {
+	return classify(err).status
+}
+
+// CodeOf returns the error code for err.
+func CodeOf(err error) Code {
+	return classify(err).code
+}
+
+// Message returns the client-facing message of err: the error without
+// operation names. Server errors only report their kind.
+func Message(err error) string {
+	if Status(err) >= http.StatusInternalServerError {
+		var e *errors.JzSEError
+		if stderrors.As(err, &e) && e.Kind != nil {
+			return e.Kind.Error()
+		}
+		return "internal error"
+	}
+	return message(err)
+}
+
+// message formats err like JzSEError.Error, without operation names.
+func message(err error) string {
+	var e *errors.JzSEError
+	if !stderrors.As(err, &e) {
+		return err.Error()
+	}
+
+	var parts []string
+	if e.Kind != nil {
+		parts = append(parts, e.Kind.Error())
+	}
+	if e.Err != nil {
+		parts = append(parts, message(e.Err))
+	}
+	msg := strings.Join(parts, ": ")
+	if e.Details != "" {
+		msg += " (" + e.Details + ")"
+	}
+	return msg
+}
+
+// New returns the envelope for err.
+func New(c *gin.Context, err error) *Envelope {
+	cl := classify(err)
+	return &Envelope{Error: Body{
+		Code:      cl.code,
+		Message:   Message(err),
+		RequestID: RequestID(c),
+		Retryable: cl.retryable,
+	}}
+}
+
+// Abort writes the error envelope for err and aborts the handler chain.
+// Server errors are logged with their full cause.
+func Abort(c *gin.Context, err error) {
+	envelope := New(c, err)
+	status := Status(err)
+	if status >= http.StatusInternalServerError {
+		ctx := logger.ContextWith(c.Request.Context(), logger.RequestID(envelope.Error.RequestID))
+		logger.FromContext(ctx, logger.WithComponent("api")).Error("request failed",
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", status),
+			zap.Error(err),
+		)
+	}
+	if status == http.StatusServiceUnavailable {
+		c.Header("Retry-After", "1")
+	}
+	c.AbortWithStatusJSON(status, envelope)
+}
+
+// AbortStatus aborts with only the status code of err, for HEAD requests.
+func AbortStatus(c *gin.Context, err error) {
+	c.AbortWithStatus(Status(err))
+}
+
+// RequestID returns the ID of the request, taken from the X-Request-ID
+// header or generated if the header is missing or too long. The ID is
+// echoed in the response header.
+func RequestID(c *gin.Context) string {
+	if id := c.GetString(RequestIDKey); id != "" {
+		return id
+	}
+
+	id := NewRequestID(c.GetHeader(RequestIDHeader))
+	c.Set(RequestIDKey, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
+
+// NewRequestID returns the ID a caller supplied for its request, or a
+// generated one if it supplied none or one that is too long.
+func NewRequestID(supplied string) string {
+	if supplied == "" || len(supplied) > maxRequestIDLength {
+		return uuid.NewString()
+	}
+	return supplied
+}
+
+// RequestContext returns a middleware that assigns each request its ID
+// and stores the ID, with fields such as the region serving the request,
+// in the request context. Lines logged through logger.FromContext while
+// serving the request carry them.
+func RequestContext(fields ...zap.Field) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := logger.ContextWith(c.Request.Context(), logger.RequestID(RequestID(c)))
+		c.Request = c.Request.WithContext(logger.ContextWith(ctx, fields...))
+		c.Next()
+	}
+}
+
+// Recovery returns a middleware that answers panics with an internal error.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		Abort(c, fmt.Errorf("panic: %v", recovered))
+	})
+}
+
+// NoRoute answers requests that match no route.
+func NoRoute(c *gin.Context) {
+	Abort(c, errors.E("apierror.NoRoute", errors.ErrNotFound, nil, c.Request.Method+" "+c.Request.URL.Path))
+}
@@ -0,0 +1,207 @@
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		status    int
+		code      Code
+		retryable bool
+	}{
+		{"not found", errors.E("Op", errors.ErrNotFound, nil), http.StatusNotFound, CodeNotFound, false},
+		{"bare sentinel", errors.ErrNotFound, http.StatusNotFound, CodeNotFound, false},
+		{"already exists", errors.E("Op", errors.ErrAlreadyExists, nil), http.StatusConflict, CodeAlreadyExists, false},
+		{"conflict", errors.E("Op", errors.ErrConflict, nil), http.StatusConflict, CodeConflict, false},
+		{"invalid input", errors.E("Op", errors.ErrInvalidInput, nil), http.StatusBadRequest, CodeInvalidInput, false},
+		{"version mismatch", errors.E("Op", errors.ErrVersionMismatch, nil), http.StatusPreconditionFailed, CodePreconditionFailed, false},
+		{"unauthorized", errors.E("Op", errors.ErrUnauthorized, nil), http.StatusUnauthorized, CodeUnauthorized, false},
+		{"forbidden", errors.E("Op", errors.ErrForbidden, nil), http.StatusForbidden, CodeForbidden, false},
+		{"too large", errors.E("Op", errors.ErrTooLarge, nil), http.StatusRequestEntityTooLarge, CodeTooLarge, false},
+		{"rejected", errors.E("Op", errors.ErrRejected, nil), http.StatusUnprocessableEntity, CodeRejected, false},
+		{"body limit", fmt.Errorf("read: %w", &http.MaxBytesError{Limit: 1}), http.StatusRequestEntityTooLarge, CodeTooLarge, false},
+		{"storage full", errors.E("Op", errors.ErrStorageFull, nil), http.StatusInsufficientStorage, CodeStorageFull, false},
+		{"queue full", errors.E("Op", errors.ErrQueueFull, nil), http.StatusServiceUnavailable, CodeQueueFull, true},
+		{"region offline", errors.E("Op", errors.ErrRegionOffline, nil), http.StatusServiceUnavailable, CodeUnavailable, true},
+		{"no healthy region", errors.ErrNoHealthyRegion, http.StatusServiceUnavailable, CodeUnavailable, true},
+		{"sync timeout", errors.E("Op", errors.ErrSyncTimeout, nil), http.StatusGatewayTimeout, CodeTimeout, true},
+		{"deadline", errors.E("Op", errors.ErrInvalidMetadata, context.DeadlineExceeded), http.StatusGatewayTimeout, CodeTimeout, true},
+		{"sync failed", errors.E("Op", errors.ErrSyncFailed, nil), http.StatusBadGateway, CodeSyncFailed, true},
+		{"not implemented", errors.E("Op", errors.ErrNotImplemented, nil), http.StatusNotImplemented, CodeNotImplemented, false},
+		{"invalid metadata", errors.E("Op", errors.ErrInvalidMetadata, nil), http.StatusInternalServerError, CodeInternal, false},
+		{"unknown", fmt.Errorf("boom"), http.StatusInternalServerError, CodeInternal, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := classify(tt.err)
+			if c.status != tt.status || c.code != tt.code || c.retryable != tt.retryable {
+				t.Errorf("classify() = %d %s %v, want %d %s %v",
+					c.status, c.code, c.retryable, tt.status, tt.code, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"details", errors.E("FileService.Upload", errors.ErrNotFound, nil, "directory /a"), "resource not found (directory /a)"},
+		{"nested", errors.E("Outer", errors.ErrForbidden, errors.E("Inner", errors.ErrInvalidInput, nil, "x")), "forbidden: invalid input (x)"},
+		{"plain cause", errors.E("Op", errors.ErrInvalidInput, fmt.Errorf("bad json")), "invalid input: bad json"},
+		{"server error hides cause", errors.E("BadgerStore.Save", errors.ErrInvalidMetadata, fmt.Errorf("disk on fire"), "key files:1"), "invalid metadata"},
+		{"unknown server error", fmt.Errorf("boom"), "internal error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Message(tt.err); got != tt.want {
+				t.Errorf("Message() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    codes.Code
+		message string
+	}{
+		{"not found", errors.E("Op", errors.ErrNotFound, nil, "file 1"), codes.NotFound, "resource not found (file 1)"},
+		{"conflict", errors.E("Op", errors.ErrConflict, nil), codes.Aborted, "conflict detected"},
+		{"precondition", errors.E("Op", errors.ErrVersionMismatch, nil), codes.FailedPrecondition, "version mismatch"},
+		{"canceled", errors.E("Op", errors.ErrInvalidMetadata, context.Canceled), codes.Canceled, "invalid metadata"},
+		{"server error hides cause", errors.E("Op", errors.ErrInvalidMetadata, fmt.Errorf("disk on fire")), codes.Internal, "invalid metadata"},
+		{"status error", status.Error(codes.Unavailable, "down"), codes.Unavailable, "down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(GRPCStatus("/test.Service/Method", tt.err))
+			if st.Code() != tt.code || st.Message() != tt.message {
+				t.Errorf("GRPCStatus() = %v %q, want %v %q", st.Code(), st.Message(), tt.code, tt.message)
+			}
+		})
+	}
+	if GRPCStatus("/test.Service/Method", nil) != nil {
+		t.Error("GRPCStatus(nil) should be nil")
+	}
+}
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(Recovery())
+	r.NoRoute(NoRoute)
+	r.GET("/busy", func(c *gin.Context) {
+		Abort(c, errors.E("Op", errors.ErrQueueFull, nil))
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	serve := func(path, requestID string) (*httptest.ResponseRecorder, *Envelope) {
+		req := httptest.NewRequest("GET", path, nil)
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var envelope Envelope
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("invalid envelope %q: %v", w.Body.String(), err)
+		}
+		return w, &envelope
+	}
+
+	w, envelope := serve("/busy", "req-1")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %v, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	want := Body{Code: CodeQueueFull, Message: "sync queue full", RequestID: "req-1", Retryable: true}
+	if envelope.Error != want {
+		t.Errorf("envelope = %+v, want %+v", envelope.Error, want)
+	}
+	if got := w.Header().Get(RequestIDHeader); got != "req-1" {
+		t.Errorf("%s = %q, want req-1", RequestIDHeader, got)
+	}
+
+	w, envelope = serve("/panic", strings.Repeat("x", maxRequestIDLength+1))
+	if w.Code != http.StatusInternalServerError || envelope.Error.Code != CodeInternal || envelope.Error.Message != "internal error" {
+		t.Errorf("panic = %v %+v", w.Code, envelope.Error)
+	}
+	if id := envelope.Error.RequestID; id == "" || len(id) > maxRequestIDLength {
+		t.Errorf("generated request ID = %q", id)
+	}
+
+	w, envelope = serve("/missing", "")
+	if w.Code != http.StatusNotFound || envelope.Error.Code != CodeNotFound {
+		t.Errorf("no route = %v %+v", w.Code, envelope.Error)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var ctx context.Context
+	r := gin.New()
+	r.Use(RequestContext(logger.RegionID("region-a")))
+	r.GET("/files", func(c *gin.Context) {
+		ctx = c.Request.Context()
+		Abort(c, errors.E("Op", errors.ErrNotFound, nil))
+	})
+
+	serve := func(requestID string) (*httptest.ResponseRecorder, *Envelope) {
+		req := httptest.NewRequest("GET", "/files", nil)
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var envelope Envelope
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("invalid envelope %q: %v", w.Body.String(), err)
+		}
+		return w, &envelope
+	}
+
+	// The ID of the caller is kept
+	w, envelope := serve("req-1")
+	if id := logger.RequestIDFrom(ctx); id != "req-1" {
+		t.Errorf("request ID in context = %q, want req-1", id)
+	}
+	if envelope.Error.RequestID != "req-1" || w.Header().Get(RequestIDHeader) != "req-1" {
+		t.Errorf("request ID = %q, %s = %q", envelope.Error.RequestID, RequestIDHeader, w.Header().Get(RequestIDHeader))
+	}
+
+	// Otherwise one is generated, the same everywhere
+	w, envelope = serve("")
+	id := logger.RequestIDFrom(ctx)
+	if id == "" || envelope.Error.RequestID != id || w.Header().Get(RequestIDHeader) != id {
+		t.Errorf("generated request ID = %q, envelope has %q, %s = %q", id, envelope.Error.RequestID, RequestIDHeader, w.Header().Get(RequestIDHeader))
+	}
+}
@@ -0,0 +1,65 @@
+// Package apierror provides the gRPC rendering of errors.
+package apierror
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"asisaid.cn/JzSE/internal/common/logger"
+)
+
+// grpcCodes maps error codes to gRPC status codes.
+var grpcCodes = map[Code]codes.Code{
+	CodeInvalidInput:       codes.InvalidArgument,
+	CodeUnauthorized:       codes.Unauthenticated,
+	CodeForbidden:          codes.PermissionDenied,
+	CodeNotFound:           codes.NotFound,
+	CodeAlreadyExists:      codes.AlreadyExists,
+	CodeConflict:           codes.Aborted,
+	CodePreconditionFailed: codes.FailedPrecondition,
+	CodeTooLarge:           codes.ResourceExhausted,
+	CodeRejected:           codes.InvalidArgument,
+	CodeStorageFull:        codes.ResourceExhausted,
+	CodeQueueFull:          codes.Unavailable,
+	CodeUnavailable:        codes.Unavailable,
+	CodeTimeout:            codes.DeadlineExceeded,
+	CodeSyncFailed:         codes.Unavailable,
+	CodeNotImplemented:     codes.Unimplemented,
+	CodeInternal:           codes.Internal,
+}
+
+// GRPCCode returns the gRPC status code for err.
+func GRPCCode(err error) codes.Code {
+	if stderrors.Is(err, context.Canceled) {
+		return codes.Canceled
+	}
+	return grpcCodes[CodeOf(err)]
+}
+
+// GRPCStatus returns err as a gRPC status error, with the message REST
+// responses carry. Errors that already are status errors are returned
+// unchanged. Server errors are logged with their full cause, under the
+// full method name of the call.
+func GRPCStatus(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	code := GRPCCode(err)
+	if Status(err) >= http.StatusInternalServerError && code != codes.Canceled {
+		logger.WithComponent("api").Error("call failed",
+			zap.String("method", method),
+			zap.String("code", code.String()),
+			zap.Error(err),
+		)
+	}
+	return status.Error(code, Message(err))
+}
@@ -0,0 +1,89 @@
+// Package auth provides pluggable request authentication for the region
+// and coordinator APIs.
+package auth
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// Authentication methods reported in Identity.Method.
+const (
+	MethodAPIKey = "api_key"
+	MethodHMAC   = "hmac"
+	MethodJWT    = "jwt"
+)
+
+// Identity is an authenticated caller.
+type Identity struct {
+	UserID string
+	Groups []string // Groups the caller belongs to, for access control
+	Method string
+}
+
+// InGroup reports whether the caller belongs to group.
+func (i *Identity) InGroup(group string) bool {
+	return slices.Contains(i.Groups, group)
+}
+
+// AuthorizeRegion checks that identity may act for a region. Regions
+// authenticate as the user named after them, usually by an HMAC key of
+// that ID, so that the key of one region cannot register, heartbeat, push
+// or pull for another. Anonymous calls pass: they only reach region calls
+// when no authentication is configured.
+func AuthorizeRegion(identity *Identity, op, regionID string) error {
+	if identity == nil || identity.UserID == regionID {
+		return nil
+	}
+	return errors.E(op, errors.ErrForbidden, nil, identity.UserID+" may not act for region "+regionID)
+}
+
+// Authenticator authenticates requests with one scheme.
+//
+// Authenticate returns a nil identity and a nil error if the request does
+// not carry credentials for the scheme, so that the next authenticator can
+// try. Credentials that are present but invalid fail with ErrUnauthorized.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+
+	// Scheme names the scheme in WWW-Authenticate challenges.
+	Scheme() string
+}
+
+// Chain tries several authenticators in order.
+type Chain []Authenticator
+
+// Authenticate returns the identity from the first authenticator that
+// recognizes the request's credentials.
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	for _, authn := range c {
+		identity, err := authn.Authenticate(r)
+		if err != nil || identity != nil {
+			return identity, err
+		}
+	}
+	return nil, nil
+}
+
+// Scheme lists the schemes of all authenticators.
+func (c Chain) Scheme() string {
+	schemes := make([]string, len(c))
+	for i, authn := range c {
+		schemes[i] = authn.Scheme()
+	}
+	return strings.Join(schemes, ", ")
+}
+
+// unauthorized returns an ErrUnauthorized error with a reason.
+func unauthorized(op, reason string) error {
+	return errors.E(op, errors.ErrUnauthorized, nil, reason)
+}
+
+// authorization splits the Authorization header into scheme and credentials.
+func authorization(r *http.Request) (scheme, credentials string) {
+	scheme, credentials, _ = strings.Cut(r.Header.Get("Authorization"), " ")
+	return scheme, strings.TrimSpace(credentials)
+}
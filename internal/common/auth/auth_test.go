@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"asisaid.cn/JzSE/internal/common/config"
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	authn := NewAPIKeyAuthenticator(map[string]APIKey{"secret-key": {UserID: "alice"}})
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		wantUser string
+		wantErr  bool
+	}{
+		{"no credentials", "", "", "", false},
+		{"header", "X-API-Key", "secret-key", "alice", false},
+		{"authorization", "Authorization", "ApiKey secret-key", "alice", false},
+		{"basic", "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte("any:secret-key")), "alice", false},
+		{"basic unknown key", "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:wrong")), "", true},
+		{"unknown key", "X-API-Key", "wrong", "", true},
+		{"other scheme", "Authorization", "Bearer token", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			identity, err := authn.Authenticate(req)
+			if tt.wantErr {
+				if !errors.IsUnauthorized(err) {
+					t.Errorf("Authenticate() error = %v, want unauthorized", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() failed: %v", err)
+			}
+			if got := userOf(identity); got != tt.wantUser {
+				t.Errorf("Authenticate() user = %q, want %q", got, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secret := []byte("shared-secret")
+	authn := NewHMACAuthenticator(map[string]HMACKey{
+		"region-a": {Secret: secret},
+		"ops":      {Secret: []byte("ops-secret"), UserID: "operator"},
+	}, 5*time.Minute)
+	authn.now = func() time.Time { return now }
+
+	signed := func() *http.Request {
+		req := httptest.NewRequest("POST", "/api/v1/regions/a/heartbeat?b=2&a=1", nil)
+		req.Header.Set("X-Content-SHA256", "abc")
+		SignRequest(req, "region-a", secret, now)
+		return req
+	}
+
+	identity, err := authn.Authenticate(signed())
+	if err != nil {
+		t.Fatalf("Authenticate() failed: %v", err)
+	}
+	if identity.UserID != "region-a" || identity.Method != MethodHMAC {
+		t.Errorf("Authenticate() = %+v, want region-a via hmac", identity)
+	}
+
+	tamper := map[string]func(r *http.Request){
+		"path":   func(r *http.Request) { r.URL.Path = "/api/v1/regions/b/heartbeat" },
+		"query":  func(r *http.Request) { r.URL.RawQuery = "a=1" },
+		"method": func(r *http.Request) { r.Method = "DELETE" },
+		"digest": func(r *http.Request) { r.Header.Set("X-Content-SHA256", "def") },
+		"key":    func(r *http.Request) { r.Header.Set("Authorization", r.Header.Get("Authorization")+"0") },
+		"skew": func(r *http.Request) {
+			SignRequest(r, "region-a", secret, now.Add(-10*time.Minute))
+		},
+		"unknown key": func(r *http.Request) { SignRequest(r, "nobody", secret, now) },
+		"no date":     func(r *http.Request) { r.Header.Del(HMACDateHeader) },
+	}
+	for name, modify := range tamper {
+		t.Run(name, func(t *testing.T) {
+			req := signed()
+			modify(req)
+			if _, err := authn.Authenticate(req); !errors.IsUnauthorized(err) {
+				t.Errorf("Authenticate() error = %v, want unauthorized", err)
+			}
+		})
+	}
+
+	if identity, err := authn.Authenticate(httptest.NewRequest("GET", "/", nil)); identity != nil || err != nil {
+		t.Errorf("Authenticate(unsigned) = %v, %v, want nil, nil", identity, err)
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	ecPublic, err := ParseJWTKey("ES256", "", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParseJWTKey(ES256) failed: %v", err)
+	}
+	hmacKey, err := ParseJWTKey("HS256", "jwt-secret", nil)
+	if err != nil {
+		t.Fatalf("ParseJWTKey(HS256) failed: %v", err)
+	}
+
+	authn, err := NewJWTAuthenticator(map[string]JWTKey{"ec": ecPublic, "hs": hmacKey}, JWTOptions{
+		Issuer:   "https://auth.example.com",
+		Audience: "jzse",
+	})
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator failed: %v", err)
+	}
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":    "alice",
+			"groups": []string{"eng", "ops"},
+			"iss":    "https://auth.example.com",
+			"aud":    "jzse",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	token := func(method jwt.SigningMethod, kid string, claims jwt.MapClaims, key any) string {
+		tok := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatalf("SignedString failed: %v", err)
+		}
+		return s
+	}
+	bearer := func(token string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	for _, tok := range []string{
+		token(jwt.SigningMethodES256, "ec", claims(), ecKey),
+		token(jwt.SigningMethodHS256, "hs", claims(), []byte("jwt-secret")),
+	} {
+		identity, err := authn.Authenticate(bearer(tok))
+		if err != nil {
+			t.Fatalf("Authenticate() failed: %v", err)
+		}
+		if identity.UserID != "alice" || identity.Method != MethodJWT {
+			t.Errorf("Authenticate() = %+v, want alice via jwt", identity)
+		}
+		if len(identity.Groups) != 2 || identity.Groups[0] != "eng" {
+			t.Errorf("Authenticate() groups = %v, want [eng ops]", identity.Groups)
+		}
+	}
+
+	expired := claims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noExpiry := claims()
+	delete(noExpiry, "exp")
+	otherAudience := claims()
+	otherAudience["aud"] = "other"
+	noSubject := claims()
+	delete(noSubject, "sub")
+
+	invalid := map[string]string{
+		"expired":          token(jwt.SigningMethodHS256, "hs", expired, []byte("jwt-secret")),
+		"no expiry":        token(jwt.SigningMethodHS256, "hs", noExpiry, []byte("jwt-secret")),
+		"wrong audience":   token(jwt.SigningMethodHS256, "hs", otherAudience, []byte("jwt-secret")),
+		"no subject":       token(jwt.SigningMethodHS256, "hs", noSubject, []byte("jwt-secret")),
+		"wrong secret":     token(jwt.SigningMethodHS256, "hs", claims(), []byte("guess")),
+		"unknown kid":      token(jwt.SigningMethodHS256, "nope", claims(), []byte("jwt-secret")),
+		"missing kid":      token(jwt.SigningMethodHS256, "", claims(), []byte("jwt-secret")),
+		"algorithm switch": token(jwt.SigningMethodHS256, "ec", claims(), []byte("jwt-secret")),
+		"garbage":          "not.a.token",
+	}
+	for name, tok := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := authn.Authenticate(bearer(tok)); !errors.IsUnauthorized(err) {
+				t.Errorf("Authenticate() error = %v, want unauthorized", err)
+			}
+		})
+	}
+
+	if _, err := ParseJWTKey("none", "", nil); !errors.IsInvalidInput(err) {
+		t.Errorf("ParseJWTKey(none) error = %v, want invalid input", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	authn := Chain{NewAPIKeyAuthenticator(map[string]APIKey{
+		"key":       {UserID: "alice"},
+		"admin-key": {UserID: "root", Groups: []string{"admins"}},
+	})}
+
+	newRouter := func(opts MiddlewareOptions) *gin.Engine {
+		r := gin.New()
+		r.Use(Middleware(authn, opts))
+		handler := func(c *gin.Context) {
+			c.String(http.StatusOK, c.GetString(UserIDKey))
+		}
+		r.GET("/health", handler)
+		r.GET("/files", handler)
+		r.POST("/heartbeat", Require(authn), handler)
+		r.POST("/admin", RequireGroup(authn, "admins"), handler)
+		return r
+	}
+	serve := func(r *gin.Engine, method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	optional := newRouter(MiddlewareOptions{})
+	if w := serve(optional, "GET", "/files", ""); w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Errorf("anonymous request = %v %q, want 200 anonymous", w.Code, w.Body.String())
+	}
+	if w := serve(optional, "GET", "/files", "key"); w.Body.String() != "alice" {
+		t.Errorf("authenticated user = %q, want alice", w.Body.String())
+	}
+	if w := serve(optional, "GET", "/files", "bad"); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "ApiKey" {
+		t.Errorf("invalid key = %v %v, want 401 with challenge", w.Code, w.Header())
+	}
+	if w := serve(optional, "POST", "/heartbeat", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous heartbeat status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+	if w := serve(optional, "POST", "/admin", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous admin status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+	if w := serve(optional, "POST", "/admin", "key"); w.Code != http.StatusForbidden {
+		t.Errorf("non-admin status = %v, want %v", w.Code, http.StatusForbidden)
+	}
+	if w := serve(optional, "POST", "/admin", "admin-key"); w.Code != http.StatusOK || w.Body.String() != "root" {
+		t.Errorf("admin = %v %q, want 200 root", w.Code, w.Body.String())
+	}
+
+	required := newRouter(MiddlewareOptions{
+		Required:    true,
+		PublicPaths: []string{"/health"},
+		Skip:        func(c *gin.Context) bool { return c.Query("presigned") != "" },
+	})
+	if w := serve(required, "GET", "/files", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous request status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+	if w := serve(required, "GET", "/health", ""); w.Code != http.StatusOK {
+		t.Errorf("public path status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if w := serve(required, "GET", "/files?presigned=1", ""); w.Code != http.StatusOK {
+		t.Errorf("skipped request status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	basic := newRouter(MiddlewareOptions{Required: true, Challenge: `Basic realm="test"`})
+	if w := serve(basic, "GET", "/files", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Basic realm="test"` {
+		t.Errorf("challenge = %v %v, want 401 with Basic challenge", w.Code, w.Header())
+	}
+}
+
+func TestGRPCInterceptor(t *testing.T) {
+	secret := []byte("hmac-secret")
+	authn := Chain{
+		NewAPIKeyAuthenticator(map[string]APIKey{"key": {UserID: "alice"}}),
+		NewHMACAuthenticator(map[string]HMACKey{"bob-key": {Secret: secret, UserID: "bob"}}, time.Minute),
+	}
+	const (
+		files     = "/jzse.v1.FileService/Stat"
+		heartbeat = "/jzse.v1.CoordinatorService/Heartbeat"
+	)
+
+	call := func(opts GRPCOptions, method string, md metadata.MD) (string, error) {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		resp, err := UnaryServerInterceptor(authn, opts)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req any) (any, error) {
+				return userOf(IdentityFrom(ctx)), nil
+			})
+		user, _ := resp.(string)
+		return user, err
+	}
+
+	optional := GRPCOptions{Protected: []string{heartbeat}}
+	if user, err := call(optional, files, nil); err != nil || user != "" {
+		t.Errorf("anonymous call = %q, %v, want anonymous", user, err)
+	}
+	if user, _ := call(optional, files, metadata.Pairs("x-api-key", "key")); user != "alice" {
+		t.Errorf("authenticated user = %q, want alice", user)
+	}
+	if _, err := call(optional, files, metadata.Pairs("x-api-key", "bad")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("invalid key = %v, want %v", status.Code(err), codes.Unauthenticated)
+	}
+	if _, err := call(optional, heartbeat, nil); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("anonymous protected call = %v, want %v", status.Code(err), codes.Unauthenticated)
+	}
+	if _, err := call(GRPCOptions{Required: true}, files, nil); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("anonymous call when required = %v, want %v", status.Code(err), codes.Unauthenticated)
+	}
+
+	// Signatures cover the method
+	req := httptest.NewRequest("POST", heartbeat, nil)
+	SignRequest(req, "bob-key", secret, time.Now())
+	signed := metadata.Pairs("authorization", req.Header.Get("Authorization"), "x-jzse-date", req.Header.Get(HMACDateHeader))
+	if user, err := call(optional, heartbeat, signed); err != nil || user != "bob" {
+		t.Errorf("signed call = %q, %v, want bob", user, err)
+	}
+	if _, err := call(optional, files, signed); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("signature replayed on another method = %v, want %v", status.Code(err), codes.Unauthenticated)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	authn, err := FromConfig(config.AuthConfig{})
+	if err != nil || authn != nil {
+		t.Errorf("FromConfig(empty) = %v, %v, want nil, nil", authn, err)
+	}
+
+	if _, err := FromConfig(config.AuthConfig{Required: true}); !errors.IsInvalidInput(err) {
+		t.Errorf("FromConfig(required without keys) error = %v, want invalid input", err)
+	}
+
+	authn, err = FromConfig(config.AuthConfig{
+		APIKeys:     []config.APIKeyConfig{{Key: "key", UserID: "alice"}},
+		HMACKeys:    []config.HMACKeyConfig{{ID: "region-a", Secret: "secret"}},
+		HMACMaxSkew: time.Minute,
+		JWT: config.JWTConfig{
+			Keys: []config.JWTKeyConfig{{ID: "hs", Algorithm: "HS256", Secret: "jwt-secret"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("FromConfig failed: %v", err)
+	}
+	if got := authn.Scheme(); got != "ApiKey, JzSE-HMAC-SHA256, Bearer" {
+		t.Errorf("Scheme() = %q", got)
+	}
+
+	if _, err := FromConfig(config.AuthConfig{
+		JWT: config.JWTConfig{Keys: []config.JWTKeyConfig{{ID: "rs", Algorithm: "RS256", PublicKeyFile: "/nonexistent.pem"}}},
+	}); !errors.IsInvalidInput(err) {
+		t.Errorf("FromConfig(missing key file) error = %v, want invalid input", err)
+	}
+}
+
+func userOf(identity *Identity) string {
+	if identity == nil {
+		return ""
+	}
+	return identity.UserID
+}
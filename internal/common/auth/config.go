@@ -0,0 +1,86 @@
+// Package auth provides construction of authenticators from configuration.
+package auth
+
+import (
+	"os"
+
+	"asisaid.cn/JzSE/internal/common/config"
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// FromConfig builds the authenticator chain of the configured schemes,
+// tried in the order API key, HMAC, JWT. It returns nil if no scheme has
+// keys configured, which is an error if authentication is required.
+func FromConfig(cfg config.AuthConfig) (Authenticator, error) {
+	var chain Chain
+
+	if len(cfg.APIKeys) > 0 {
+		keys := make(map[string]APIKey, len(cfg.APIKeys))
+		for _, k := range cfg.APIKeys {
+			if k.Key == "" || k.UserID == "" {
+				return nil, errors.E("auth.FromConfig", errors.ErrInvalidInput, nil, "API keys need a key and a user_id")
+			}
+			keys[k.Key] = APIKey{UserID: k.UserID, Groups: k.Groups}
+		}
+		chain = append(chain, NewAPIKeyAuthenticator(keys))
+	}
+
+	if len(cfg.HMACKeys) > 0 {
+		keys, err := HMACKeys(cfg)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, NewHMACAuthenticator(keys, cfg.HMACMaxSkew))
+	}
+
+	if len(cfg.JWT.Keys) > 0 {
+		keys := make(map[string]JWTKey, len(cfg.JWT.Keys))
+		for _, k := range cfg.JWT.Keys {
+			var pem []byte
+			if k.PublicKeyFile != "" {
+				var err error
+				if pem, err = os.ReadFile(k.PublicKeyFile); err != nil {
+					return nil, errors.E("auth.FromConfig", errors.ErrInvalidInput, err, "JWT key "+k.ID)
+				}
+			}
+			key, err := ParseJWTKey(k.Algorithm, k.Secret, pem)
+			if err != nil {
+				return nil, err
+			}
+			keys[k.ID] = key
+		}
+
+		jwtAuthn, err := NewJWTAuthenticator(keys, JWTOptions{
+			Issuer:      cfg.JWT.Issuer,
+			Audience:    cfg.JWT.Audience,
+			UserClaim:   cfg.JWT.UserClaim,
+			GroupsClaim: cfg.JWT.GroupsClaim,
+			Leeway:      cfg.JWT.Leeway,
+		})
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, jwtAuthn)
+	}
+
+	if len(chain) == 0 {
+		if cfg.Required {
+			return nil, errors.E("auth.FromConfig", errors.ErrInvalidInput, nil, "authentication required but no keys configured")
+		}
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// HMACKeys returns the configured HMAC keys by ID. They also serve as
+// the access keys of the S3 gateway.
+func HMACKeys(cfg config.AuthConfig) (map[string]HMACKey, error) {
+	keys := make(map[string]HMACKey, len(cfg.HMACKeys))
+	for _, k := range cfg.HMACKeys {
+		if k.ID == "" || k.Secret == "" {
+			return nil, errors.E("auth.HMACKeys", errors.ErrInvalidInput, nil, "HMAC keys need an id and a secret")
+		}
+		keys[k.ID] = HMACKey{Secret: []byte(k.Secret), UserID: k.UserID, Groups: k.Groups}
+	}
+	return keys, nil
+}
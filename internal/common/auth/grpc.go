@@ -0,0 +1,183 @@
+// Package auth provides gRPC authentication interceptors and call
+// credentials.
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+)
+
+// identityKey is the context key of the identity of a gRPC call.
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the identity of a gRPC call.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity of a gRPC call, or nil for anonymous
+// calls.
+func IdentityFrom(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// GRPCOptions configures the authentication of gRPC calls.
+type GRPCOptions struct {
+	// Required rejects calls without credentials. Otherwise they proceed
+	// anonymously.
+	Required bool
+
+	// Protected are full method names, such as
+	// "/jzse.v1.CoordinatorService/Heartbeat", that always require
+	// credentials.
+	Protected []string
+
+	// TLS secures the connections of the server. Without it the server
+	// is plaintext, and clients do not send HMAC credentials to it.
+	TLS credentials.TransportCredentials
+}
+
+// grpcAuthenticator authenticates gRPC calls with an Authenticator.
+type grpcAuthenticator struct {
+	authn     Authenticator
+	required  bool
+	protected map[string]bool
+	logger    *zap.Logger
+}
+
+func newGRPCAuthenticator(authn Authenticator, opts GRPCOptions) *grpcAuthenticator {
+	protected := make(map[string]bool, len(opts.Protected))
+	for _, method := range opts.Protected {
+		protected[method] = true
+	}
+	return &grpcAuthenticator{
+		authn:     authn,
+		required:  opts.Required,
+		protected: protected,
+		logger:    logger.WithComponent("auth"),
+	}
+}
+
+// UnaryServerInterceptor returns an interceptor that authenticates unary
+// calls with authn and stores the caller's identity in the call context,
+// where IdentityFrom finds it. Invalid credentials are always refused with
+// codes.Unauthenticated.
+func UnaryServerInterceptor(authn Authenticator, opts GRPCOptions) grpc.UnaryServerInterceptor {
+	a := newGRPCAuthenticator(authn, opts)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the counterpart of UnaryServerInterceptor for
+// streaming calls.
+func StreamServerInterceptor(authn Authenticator, opts GRPCOptions) grpc.StreamServerInterceptor {
+	a := newGRPCAuthenticator(authn, opts)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identifiedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate authenticates a call and returns its context with the
+// caller's identity.
+func (a *grpcAuthenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	identity, err := a.authn.Authenticate(callRequest(ctx, method))
+	if err == nil && identity == nil && (a.required || a.protected[method]) {
+		err = errors.E("auth.Interceptor", errors.ErrUnauthorized, nil, "credentials required")
+	}
+	if err != nil {
+		logger.FromContext(ctx, a.logger).Warn("call not authenticated",
+			zap.String("method", method),
+			zap.Error(err),
+		)
+		return nil, apierror.GRPCStatus(method, err)
+	}
+
+	if identity != nil {
+		ctx = logger.ContextWith(WithIdentity(ctx, identity), logger.UserID(identity.UserID))
+	}
+	return ctx, nil
+}
+
+// callRequest presents a gRPC call to authenticators as an HTTP request:
+// a POST to the full method name, with the call metadata as headers.
+// HMAC signatures of calls thus cover the method and the signing date.
+func callRequest(ctx context.Context, method string) *http.Request {
+	header := make(http.Header)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") {
+			continue
+		}
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	return (&http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: method},
+		Header: header,
+	}).WithContext(ctx)
+}
+
+// identifiedStream is a server stream whose context carries the caller's
+// identity.
+type identifiedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identifiedStream) Context() context.Context {
+	return s.ctx
+}
+
+// HMACCredentials signs gRPC calls with an HMAC key, for servers using an
+// HMACAuthenticator.
+type HMACCredentials struct {
+	KeyID  string
+	Secret []byte
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (c HMACCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	info, ok := credentials.RequestInfoFromContext(ctx)
+	if !ok {
+		return nil, errors.E("HMACCredentials.GetRequestMetadata", errors.ErrInvalidInput, nil, "no call information")
+	}
+
+	r := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: info.Method}, Header: make(http.Header)}
+	SignRequest(r, c.KeyID, c.Secret, time.Now())
+	return map[string]string{
+		"authorization":                 r.Header.Get("Authorization"),
+		strings.ToLower(HMACDateHeader): r.Header.Get(HMACDateHeader),
+	}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+// Signatures cover the method and the date of a call but not its
+// messages, so a signature captured on a plaintext connection could be
+// replayed with other messages: calls are only signed over TLS.
+func (c HMACCredentials) RequireTransportSecurity() bool {
+	return true
+}
@@ -0,0 +1,171 @@
+// Package auth provides JWT bearer token validation.
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// JWTKey is a key that tokens may be signed with.
+type JWTKey struct {
+	Algorithm string // HS256, RS256, ES256, EdDSA, ...
+	Key       any    // []byte for HMAC algorithms, a public key otherwise
+}
+
+// ParseJWTKey builds a JWTKey from a shared secret for HMAC algorithms, or
+// a PEM encoded public key for the others.
+func ParseJWTKey(algorithm, secret string, publicKeyPEM []byte) (JWTKey, error) {
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil || method == jwt.SigningMethodNone {
+		return JWTKey{}, errors.E("auth.ParseJWTKey", errors.ErrInvalidInput, nil, "unsupported algorithm "+algorithm)
+	}
+
+	var key any
+	var err error
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if secret == "" {
+			return JWTKey{}, errors.E("auth.ParseJWTKey", errors.ErrInvalidInput, nil, algorithm+" requires a secret")
+		}
+		key = []byte(secret)
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		key, err = jwt.ParseRSAPublicKeyFromPEM(publicKeyPEM)
+	case *jwt.SigningMethodECDSA:
+		key, err = jwt.ParseECPublicKeyFromPEM(publicKeyPEM)
+	case *jwt.SigningMethodEd25519:
+		key, err = jwt.ParseEdPublicKeyFromPEM(publicKeyPEM)
+	}
+	if err != nil {
+		return JWTKey{}, errors.E("auth.ParseJWTKey", errors.ErrInvalidInput, err, algorithm+" public key")
+	}
+
+	return JWTKey{Algorithm: algorithm, Key: key}, nil
+}
+
+// JWTOptions are the claims checks of a JWTAuthenticator.
+type JWTOptions struct {
+	Issuer      string        // Required "iss", empty to accept any
+	Audience    string        // Required "aud", empty to accept any
+	UserClaim   string        // Claim holding the user ID, "sub" if empty
+	GroupsClaim string        // Claim holding the user's groups, "groups" if empty
+	Leeway      time.Duration // Tolerated clock skew for exp and nbf
+}
+
+// JWTAuthenticator validates "Authorization: Bearer <token>" against a
+// configured key set. Tokens select their key by the "kid" header; with a
+// single configured key, "kid" may be omitted. Tokens must expire.
+type JWTAuthenticator struct {
+	keys    map[string]JWTKey
+	opts    JWTOptions
+	parser  *jwt.Parser
+	methods []string
+}
+
+// NewJWTAuthenticator creates a JWTAuthenticator from a map of key IDs to keys.
+func NewJWTAuthenticator(keys map[string]JWTKey, opts JWTOptions) (*JWTAuthenticator, error) {
+	if len(keys) == 0 {
+		return nil, errors.E("auth.NewJWTAuthenticator", errors.ErrInvalidInput, nil, "no keys")
+	}
+	if opts.UserClaim == "" {
+		opts.UserClaim = "sub"
+	}
+	if opts.GroupsClaim == "" {
+		opts.GroupsClaim = "groups"
+	}
+
+	a := &JWTAuthenticator{keys: keys, opts: opts}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			a.methods = append(a.methods, key.Algorithm)
+		}
+	}
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(a.methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(opts.Leeway),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	a.parser = jwt.NewParser(parserOpts...)
+
+	return a, nil
+}
+
+// Authenticate implements Authenticator.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	scheme, credentials := authorization(r)
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(credentials, claims, a.key); err != nil {
+		return nil, errors.E("JWTAuthenticator.Authenticate", errors.ErrUnauthorized, err)
+	}
+
+	userID, _ := claims[a.opts.UserClaim].(string)
+	if userID == "" {
+		return nil, unauthorized("JWTAuthenticator.Authenticate", "token has no "+a.opts.UserClaim+" claim")
+	}
+	return &Identity{UserID: userID, Groups: claimStrings(claims[a.opts.GroupsClaim]), Method: MethodJWT}, nil
+}
+
+// claimStrings reads a claim holding either a list of strings or a single
+// space separated string. Values of other types are ignored.
+func claimStrings(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Scheme implements Authenticator.
+func (a *JWTAuthenticator) Scheme() string {
+	return "Bearer"
+}
+
+// key selects the verification key of a token.
+func (a *JWTAuthenticator) key(token *jwt.Token) (any, error) {
+	var key JWTKey
+	kid, _ := token.Header["kid"].(string)
+	switch {
+	case kid != "":
+		var ok bool
+		if key, ok = a.keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+	case len(a.keys) == 1:
+		for _, only := range a.keys {
+			key = only
+		}
+	default:
+		return nil, fmt.Errorf("token has no kid")
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("key %q does not use %s", kid, token.Method.Alg())
+	}
+	return key.Key, nil
+}
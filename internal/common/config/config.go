@@ -3,6 +3,7 @@ package config
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,15 +18,34 @@ type Config struct {
 	Storage     StorageConfig     `mapstructure:"storage"`
 	Metadata    MetadataConfig    `mapstructure:"metadata"`
 	Sync        SyncConfig        `mapstructure:"sync"`
+	Status      StatusConfig      `mapstructure:"status"`
+	Events      EventsConfig      `mapstructure:"events"`
+	Webhooks    WebhooksConfig    `mapstructure:"webhooks"`
+	Hooks       HooksConfig       `mapstructure:"hooks"`
+	S3          S3Config          `mapstructure:"s3"`
 	Logger      LoggerConfig      `mapstructure:"logger"`
+	Metrics     MetricsConfig     `mapstructure:"metrics"`
+	Tracing     TracingConfig     `mapstructure:"tracing"`
+	Auth        AuthConfig        `mapstructure:"auth"`
 }
 
 // ServerConfig holds HTTP/gRPC server configuration.
 type ServerConfig struct {
-	HTTPAddr     string        `mapstructure:"http_addr"`
-	GRPCAddr     string        `mapstructure:"grpc_addr"`
-	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
-	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	HTTPAddr          string        `mapstructure:"http_addr"`
+	GRPCAddr          string        `mapstructure:"grpc_addr"`
+	ReadTimeout       time.Duration `mapstructure:"read_timeout"`
+	WriteTimeout      time.Duration `mapstructure:"write_timeout"`
+	MaxUploadSize     string        `mapstructure:"max_upload_size"`     // e.g. 5GB, empty for unlimited
+	MaxArchiveSize    string        `mapstructure:"max_archive_size"`    // Total file size of directory archives
+	MaxArchiveEntries int           `mapstructure:"max_archive_entries"` // Entries of an extracted archive
+	MaxBatchSize      int           `mapstructure:"max_batch_size"`      // Items of a batch request
+	PresignMaxExpiry  time.Duration `mapstructure:"presign_max_expiry"`  // Longest lifetime of a presigned URL
+	WebDAV            bool          `mapstructure:"webdav"`              // Serve the region tree over WebDAV at /webdav
+
+	// TLS certificate and key of the gRPC server, as PEM files. Without
+	// them the server is plaintext, and regions cannot sign calls to it.
+	GRPCCertFile string `mapstructure:"grpc_cert_file"`
+	GRPCKeyFile  string `mapstructure:"grpc_key_file"`
 }
 
 // RegionConfig holds region-specific configuration.
@@ -33,25 +53,46 @@ type RegionConfig struct {
 	ID       string `mapstructure:"id"`
 	Name     string `mapstructure:"name"`
 	Location string `mapstructure:"location"`
+	Secret   string `mapstructure:"secret"` // Signs presigned URLs, empty disables them
 }
 
 // CoordinatorConfig holds coordinator connection configuration.
 type CoordinatorConfig struct {
 	Endpoints   []string      `mapstructure:"endpoints"`
 	DialTimeout time.Duration `mapstructure:"dial_timeout"`
+
+	// gRPC connection of a region to the coordinator, for the sync
+	// protocol. Changes are only tracked locally while GRPCAddr is empty.
+	GRPCAddr    string        `mapstructure:"grpc_addr"`
+	CallTimeout time.Duration `mapstructure:"call_timeout"`
+	KeyID       string        `mapstructure:"key_id"` // HMAC key the region signs calls with
+	KeySecret   string        `mapstructure:"key_secret"`
+	TLS         bool          `mapstructure:"tls"`     // Connect over TLS, required to sign calls
+	CAFile      string        `mapstructure:"ca_file"` // Verifies the coordinator, empty for the system roots
 }
 
 // StorageConfig holds storage backend configuration.
 type StorageConfig struct {
 	Backend  string `mapstructure:"backend"` // local_fs, minio, s3
 	Path     string `mapstructure:"path"`
-	TempPath string `mapstructure:"temp_path"`
+	TempPath string `mapstructure:"temp_path"` // Spools archives being extracted
 }
 
 // MetadataConfig holds metadata storage configuration.
 type MetadataConfig struct {
-	DBPath    string `mapstructure:"db_path"`
-	CacheSize string `mapstructure:"cache_size"`
+	DBPath          string        `mapstructure:"db_path"`
+	CacheSize       string        `mapstructure:"cache_size"`       // Block cache size, e.g. 256MB
+	IndexCacheSize  string        `mapstructure:"index_cache_size"` // Empty keeps all indexes in memory
+	MemTableSize    string        `mapstructure:"memtable_size"`
+	ValueThreshold  string        `mapstructure:"value_threshold"` // Values larger than this go to the value log
+	SyncWrites      bool          `mapstructure:"sync_writes"`
+	Compression     string        `mapstructure:"compression"`      // none, snappy, zstd
+	TombstoneTTL    time.Duration `mapstructure:"tombstone_ttl"`    // Minimum age before synced tombstones are purged
+	CompactInterval time.Duration `mapstructure:"compact_interval"` // How often tombstones are compacted
+
+	// Value log garbage collection
+	GCInterval     time.Duration `mapstructure:"gc_interval"` // Zero disables scheduled GC
+	GCDiscardRatio float64       `mapstructure:"gc_discard_ratio"`
 }
 
 // SyncConfig holds sync agent configuration.
@@ -61,6 +102,70 @@ type SyncConfig struct {
 	BatchInterval time.Duration `mapstructure:"batch_interval"`
 	RetryInterval time.Duration `mapstructure:"retry_interval"`
 	MaxRetries    int           `mapstructure:"max_retries"`
+
+	// Interval of heartbeats to the coordinator
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+}
+
+// StatusConfig holds the thresholds past which a region reports itself
+// degraded.
+type StatusConfig struct {
+	MaxContactAge time.Duration `mapstructure:"max_contact_age"` // Since the last successful coordinator call
+	MaxSyncLag    time.Duration `mapstructure:"max_sync_lag"`    // Age of the oldest change not yet pushed
+	MinFreeRatio  float64       `mapstructure:"min_free_ratio"`  // Of storage capacity
+}
+
+// EventsConfig holds change notification configuration.
+type EventsConfig struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	HistorySize int           `mapstructure:"history_size"` // Events kept for resuming subscriptions
+	BufferSize  int           `mapstructure:"buffer_size"`  // Events queued per subscriber before it is dropped
+	KeepAlive   time.Duration `mapstructure:"keep_alive"`   // Interval of keep-alive messages on idle streams
+}
+
+// WebhooksConfig holds outbound webhook configuration. Webhooks are
+// enabled when subscriptions are configured.
+type WebhooksConfig struct {
+	QueuePath      string          `mapstructure:"queue_path"`      // Directory of pending and dead deliveries
+	MaxAttempts    int             `mapstructure:"max_attempts"`    // Attempts before a delivery is dead-lettered
+	InitialBackoff time.Duration   `mapstructure:"initial_backoff"` // Doubled after each failed attempt
+	MaxBackoff     time.Duration   `mapstructure:"max_backoff"`
+	Timeout        time.Duration   `mapstructure:"timeout"` // Per request
+	Subscriptions  []WebhookConfig `mapstructure:"subscriptions"`
+}
+
+// WebhookConfig is a webhook subscription.
+type WebhookConfig struct {
+	ID     string   `mapstructure:"id"`
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"` // Signs payloads
+	Paths  []string `mapstructure:"paths"`  // Files at or below these paths; all if empty
+	Events []string `mapstructure:"events"` // create, update, delete, conflict; all if empty
+}
+
+// HooksConfig holds pre-commit upload hook configuration. Hooks run in
+// order: the file type filter first, then the HTTP hooks.
+type HooksConfig struct {
+	Timeout           time.Duration    `mapstructure:"timeout"`            // Per hook call
+	FailOpen          bool             `mapstructure:"fail_open"`          // Accept uploads when a hook fails
+	BlockedExtensions []string         `mapstructure:"blocked_extensions"` // e.g. exe, .bat
+	BlockedMimeTypes  []string         `mapstructure:"blocked_mime_types"` // e.g. application/x-msdownload, video/*
+	HTTP              []HTTPHookConfig `mapstructure:"http"`
+}
+
+// HTTPHookConfig is an external content scanner.
+type HTTPHookConfig struct {
+	Name string `mapstructure:"name"`
+	URL  string `mapstructure:"url"`
+}
+
+// S3Config holds S3 gateway configuration. The gateway authenticates
+// requests with the auth.hmac_keys, which serve as access keys.
+type S3Config struct {
+	Addr          string        `mapstructure:"addr"`           // Listen address, empty disables the gateway
+	Region        string        `mapstructure:"region"`         // Reported to clients as the bucket location
+	MultipartPath string        `mapstructure:"multipart_path"` // Directory of the parts of unfinished uploads
+	MultipartTTL  time.Duration `mapstructure:"multipart_ttl"`  // Age at which unfinished uploads are dropped
 }
 
 // LoggerConfig holds logger configuration.
@@ -71,35 +176,136 @@ type LoggerConfig struct {
 	Development bool   `mapstructure:"development"`
 }
 
+// MetricsConfig holds Prometheus metrics configuration.
+type MetricsConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Path    string `mapstructure:"path"` // Served on the HTTP server, outside the API and its authentication
+}
+
+// TracingConfig holds OpenTelemetry tracing configuration.
+type TracingConfig struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Exporter    string  `mapstructure:"exporter"`     // otlp, stdout
+	Endpoint    string  `mapstructure:"endpoint"`     // OTLP collector, over gRPC
+	Insecure    bool    `mapstructure:"insecure"`     // Reach the collector without TLS
+	SampleRatio float64 `mapstructure:"sample_ratio"` // Of traces started here; callers' decisions are followed
+}
+
+// AuthConfig holds API authentication configuration. Schemes without
+// keys are disabled; with none configured, all requests are anonymous.
+type AuthConfig struct {
+	Required    bool            `mapstructure:"required"` // Reject requests without credentials
+	APIKeys     []APIKeyConfig  `mapstructure:"api_keys"`
+	HMACKeys    []HMACKeyConfig `mapstructure:"hmac_keys"`
+	HMACMaxSkew time.Duration   `mapstructure:"hmac_max_skew"` // Tolerated clock skew of signed requests
+	JWT         JWTConfig       `mapstructure:"jwt"`
+	AdminGroup  string          `mapstructure:"admin_group"` // Members may use the admin endpoints and manage every ACL
+}
+
+// APIKeyConfig maps a static API key to a user.
+type APIKeyConfig struct {
+	Key    string   `mapstructure:"key"`
+	UserID string   `mapstructure:"user_id"`
+	Groups []string `mapstructure:"groups"` // Matched by group ACL entries
+}
+
+// HMACKeyConfig is a shared secret for HMAC request signing.
+type HMACKeyConfig struct {
+	ID     string   `mapstructure:"id"`
+	Secret string   `mapstructure:"secret"`
+	UserID string   `mapstructure:"user_id"` // Defaults to the key ID
+	Groups []string `mapstructure:"groups"`
+}
+
+// JWTConfig holds JWT validation configuration.
+type JWTConfig struct {
+	Issuer      string         `mapstructure:"issuer"`
+	Audience    string         `mapstructure:"audience"`
+	UserClaim   string         `mapstructure:"user_claim"`   // Claim holding the user ID
+	GroupsClaim string         `mapstructure:"groups_claim"` // Claim holding the user's groups
+	Leeway      time.Duration  `mapstructure:"leeway"`
+	Keys        []JWTKeyConfig `mapstructure:"keys"`
+}
+
+// JWTKeyConfig is a key that tokens may be signed with.
+type JWTKeyConfig struct {
+	ID            string `mapstructure:"id"`              // Matched against the token's kid
+	Algorithm     string `mapstructure:"algorithm"`       // HS256, RS256, ES256, EdDSA, ...
+	Secret        string `mapstructure:"secret"`          // For HMAC algorithms
+	PublicKeyFile string `mapstructure:"public_key_file"` // PEM file for the others
+}
+
 // DefaultConfig returns the default configuration.
 func DefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			HTTPAddr:     ":8080",
-			GRPCAddr:     ":9090",
-			ReadTimeout:  30 * time.Second,
-			WriteTimeout: 30 * time.Second,
+			HTTPAddr:          ":8080",
+			GRPCAddr:          ":9090",
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			MaxArchiveEntries: 10000,
+			MaxBatchSize:      1000,
+			PresignMaxExpiry:  24 * time.Hour,
+			WebDAV:            true,
 		},
 		Region: RegionConfig{
 			ID:       "region-default",
 			Name:     "Default Region",
 			Location: "unknown",
 		},
+		Coordinator: CoordinatorConfig{
+			CallTimeout: 10 * time.Second,
+		},
 		Storage: StorageConfig{
 			Backend:  "local_fs",
 			Path:     "./data/storage",
 			TempPath: "./data/temp",
 		},
 		Metadata: MetadataConfig{
-			DBPath:    "./data/metadata",
-			CacheSize: "256MB",
+			DBPath:          "./data/metadata",
+			CacheSize:       "256MB",
+			MemTableSize:    "64MB",
+			ValueThreshold:  "1MB",
+			SyncWrites:      false,
+			Compression:     "snappy",
+			TombstoneTTL:    7 * 24 * time.Hour,
+			CompactInterval: time.Hour,
+			GCInterval:      10 * time.Minute,
+			GCDiscardRatio:  0.5,
 		},
 		Sync: SyncConfig{
-			Mode:          "push",
-			BatchSize:     100,
-			BatchInterval: 5 * time.Second,
-			RetryInterval: 30 * time.Second,
-			MaxRetries:    10,
+			Mode:              "push",
+			BatchSize:         100,
+			BatchInterval:     5 * time.Second,
+			RetryInterval:     30 * time.Second,
+			MaxRetries:        10,
+			HeartbeatInterval: 15 * time.Second,
+		},
+		Status: StatusConfig{
+			MaxContactAge: time.Minute,
+			MaxSyncLag:    5 * time.Minute,
+			MinFreeRatio:  0.05,
+		},
+		Events: EventsConfig{
+			Enabled:     true,
+			HistorySize: 1000,
+			BufferSize:  256,
+			KeepAlive:   30 * time.Second,
+		},
+		Webhooks: WebhooksConfig{
+			QueuePath:      "./data/webhooks",
+			MaxAttempts:    10,
+			InitialBackoff: time.Second,
+			MaxBackoff:     time.Hour,
+			Timeout:        10 * time.Second,
+		},
+		Hooks: HooksConfig{
+			Timeout: 30 * time.Second,
+		},
+		S3: S3Config{
+			Region:        "us-east-1",
+			MultipartPath: "./data/multipart",
+			MultipartTTL:  7 * 24 * time.Hour,
 		},
 		Logger: LoggerConfig{
 			Level:       "info",
@@ -107,6 +313,26 @@ func DefaultConfig() *Config {
 			Output:      "stdout",
 			Development: false,
 		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Tracing: TracingConfig{
+			Enabled:     false,
+			Exporter:    "otlp",
+			Endpoint:    "localhost:4317",
+			Insecure:    true,
+			SampleRatio: 1,
+		},
+		Auth: AuthConfig{
+			HMACMaxSkew: 5 * time.Minute,
+			AdminGroup:  "admins",
+			JWT: JWTConfig{
+				UserClaim:   "sub",
+				GroupsClaim: "groups",
+				Leeway:      30 * time.Second,
+			},
+		},
 	}
 }
 
@@ -149,11 +375,28 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("server.grpc_addr", defaults.Server.GRPCAddr)
 	v.SetDefault("server.read_timeout", defaults.Server.ReadTimeout)
 	v.SetDefault("server.write_timeout", defaults.Server.WriteTimeout)
+	v.SetDefault("server.max_upload_size", defaults.Server.MaxUploadSize)
+	v.SetDefault("server.max_archive_size", defaults.Server.MaxArchiveSize)
+	v.SetDefault("server.max_archive_entries", defaults.Server.MaxArchiveEntries)
+	v.SetDefault("server.max_batch_size", defaults.Server.MaxBatchSize)
+	v.SetDefault("server.presign_max_expiry", defaults.Server.PresignMaxExpiry)
+	v.SetDefault("server.webdav", defaults.Server.WebDAV)
+	v.SetDefault("server.grpc_cert_file", defaults.Server.GRPCCertFile)
+	v.SetDefault("server.grpc_key_file", defaults.Server.GRPCKeyFile)
 
 	// Region defaults
 	v.SetDefault("region.id", defaults.Region.ID)
 	v.SetDefault("region.name", defaults.Region.Name)
 	v.SetDefault("region.location", defaults.Region.Location)
+	v.SetDefault("region.secret", defaults.Region.Secret)
+
+	// Coordinator connection defaults
+	v.SetDefault("coordinator.grpc_addr", defaults.Coordinator.GRPCAddr)
+	v.SetDefault("coordinator.call_timeout", defaults.Coordinator.CallTimeout)
+	v.SetDefault("coordinator.key_id", defaults.Coordinator.KeyID)
+	v.SetDefault("coordinator.key_secret", defaults.Coordinator.KeySecret)
+	v.SetDefault("coordinator.tls", defaults.Coordinator.TLS)
+	v.SetDefault("coordinator.ca_file", defaults.Coordinator.CAFile)
 
 	// Storage defaults
 	v.SetDefault("storage.backend", defaults.Storage.Backend)
@@ -163,6 +406,15 @@ func setDefaults(v *viper.Viper) {
 	// Metadata defaults
 	v.SetDefault("metadata.db_path", defaults.Metadata.DBPath)
 	v.SetDefault("metadata.cache_size", defaults.Metadata.CacheSize)
+	v.SetDefault("metadata.index_cache_size", defaults.Metadata.IndexCacheSize)
+	v.SetDefault("metadata.memtable_size", defaults.Metadata.MemTableSize)
+	v.SetDefault("metadata.value_threshold", defaults.Metadata.ValueThreshold)
+	v.SetDefault("metadata.sync_writes", defaults.Metadata.SyncWrites)
+	v.SetDefault("metadata.compression", defaults.Metadata.Compression)
+	v.SetDefault("metadata.tombstone_ttl", defaults.Metadata.TombstoneTTL)
+	v.SetDefault("metadata.compact_interval", defaults.Metadata.CompactInterval)
+	v.SetDefault("metadata.gc_interval", defaults.Metadata.GCInterval)
+	v.SetDefault("metadata.gc_discard_ratio", defaults.Metadata.GCDiscardRatio)
 
 	// Sync defaults
 	v.SetDefault("sync.mode", defaults.Sync.Mode)
@@ -170,10 +422,100 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("sync.batch_interval", defaults.Sync.BatchInterval)
 	v.SetDefault("sync.retry_interval", defaults.Sync.RetryInterval)
 	v.SetDefault("sync.max_retries", defaults.Sync.MaxRetries)
+	v.SetDefault("sync.heartbeat_interval", defaults.Sync.HeartbeatInterval)
+
+	// Status defaults
+	v.SetDefault("status.max_contact_age", defaults.Status.MaxContactAge)
+	v.SetDefault("status.max_sync_lag", defaults.Status.MaxSyncLag)
+	v.SetDefault("status.min_free_ratio", defaults.Status.MinFreeRatio)
+
+	// Events defaults
+	v.SetDefault("events.enabled", defaults.Events.Enabled)
+	v.SetDefault("events.history_size", defaults.Events.HistorySize)
+	v.SetDefault("events.buffer_size", defaults.Events.BufferSize)
+	v.SetDefault("events.keep_alive", defaults.Events.KeepAlive)
+
+	// Webhooks defaults
+	v.SetDefault("webhooks.queue_path", defaults.Webhooks.QueuePath)
+	v.SetDefault("webhooks.max_attempts", defaults.Webhooks.MaxAttempts)
+	v.SetDefault("webhooks.initial_backoff", defaults.Webhooks.InitialBackoff)
+	v.SetDefault("webhooks.max_backoff", defaults.Webhooks.MaxBackoff)
+	v.SetDefault("webhooks.timeout", defaults.Webhooks.Timeout)
+
+	// Pre-commit hooks defaults
+	v.SetDefault("hooks.timeout", defaults.Hooks.Timeout)
+	v.SetDefault("hooks.fail_open", defaults.Hooks.FailOpen)
+
+	// S3 gateway defaults
+	v.SetDefault("s3.addr", defaults.S3.Addr)
+	v.SetDefault("s3.region", defaults.S3.Region)
+	v.SetDefault("s3.multipart_path", defaults.S3.MultipartPath)
+	v.SetDefault("s3.multipart_ttl", defaults.S3.MultipartTTL)
 
 	// Logger defaults
 	v.SetDefault("logger.level", defaults.Logger.Level)
 	v.SetDefault("logger.format", defaults.Logger.Format)
 	v.SetDefault("logger.output", defaults.Logger.Output)
 	v.SetDefault("logger.development", defaults.Logger.Development)
+
+	// Metrics defaults
+	v.SetDefault("metrics.enabled", defaults.Metrics.Enabled)
+	v.SetDefault("metrics.path", defaults.Metrics.Path)
+
+	// Tracing defaults
+	v.SetDefault("tracing.enabled", defaults.Tracing.Enabled)
+	v.SetDefault("tracing.exporter", defaults.Tracing.Exporter)
+	v.SetDefault("tracing.endpoint", defaults.Tracing.Endpoint)
+	v.SetDefault("tracing.insecure", defaults.Tracing.Insecure)
+	v.SetDefault("tracing.sample_ratio", defaults.Tracing.SampleRatio)
+
+	// Auth defaults
+	v.SetDefault("auth.required", defaults.Auth.Required)
+	v.SetDefault("auth.hmac_max_skew", defaults.Auth.HMACMaxSkew)
+	v.SetDefault("auth.jwt.user_claim", defaults.Auth.JWT.UserClaim)
+	v.SetDefault("auth.jwt.groups_claim", defaults.Auth.JWT.GroupsClaim)
+	v.SetDefault("auth.jwt.leeway", defaults.Auth.JWT.Leeway)
+	v.SetDefault("auth.admin_group", defaults.Auth.AdminGroup)
+}
+
+// Size unit multipliers, in binary (1024-based) units.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a human readable size such as "256MB" or "1GiB" into bytes.
+// An empty string parses as zero.
+func ParseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	if str == "" {
+		return 0, nil
+	}
+	str = strings.Replace(str, "IB", "B", 1)
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(str, unit.suffix) {
+			multiplier = unit.multiplier
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
 }
@@ -0,0 +1,45 @@
+package config
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1024", 1024, false},
+		{"512B", 512, false},
+		{"64KB", 64 << 10, false},
+		{"256MB", 256 << 20, false},
+		{"256mb", 256 << 20, false},
+		{"1GiB", 1 << 30, false},
+		{"1.5G", 3 << 29, false},
+		{" 2 TB ", 2 << 40, false},
+		{"abc", 0, true},
+		{"-1MB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultConfig_MetadataSizes(t *testing.T) {
+	cfg := DefaultConfig()
+
+	for _, size := range []string{cfg.Metadata.CacheSize, cfg.Metadata.MemTableSize, cfg.Metadata.ValueThreshold} {
+		if _, err := ParseSize(size); err != nil {
+			t.Errorf("default size %q does not parse: %v", size, err)
+		}
+	}
+}
@@ -37,6 +37,11 @@ var (
 
 	// Validation errors
 	ErrInvalidInput = errors.New("invalid input")
+	ErrTooLarge     = errors.New("size limit exceeded")
+	ErrRejected     = errors.New("content rejected") // By a pre-commit hook
+
+	// Feature errors
+	ErrNotImplemented = errors.New("not implemented")
 )
 
 // JzSEError is a custom error type with additional context.
@@ -97,12 +102,42 @@ func IsNotFound(err error) bool {
 	return errors.Is(err, ErrNotFound)
 }
 
+// IsAlreadyExists checks if the error is an already exists error.
+func IsAlreadyExists(err error) bool {
+	return errors.Is(err, ErrAlreadyExists)
+}
+
+// IsInvalidInput checks if the error is an invalid input error.
+func IsInvalidInput(err error) bool {
+	return errors.Is(err, ErrInvalidInput)
+}
+
 // IsConflict checks if the error is a conflict error.
 func IsConflict(err error) bool {
 	return errors.Is(err, ErrConflict)
 }
 
+// IsTooLarge checks if the error is a size limit error.
+func IsTooLarge(err error) bool {
+	return errors.Is(err, ErrTooLarge)
+}
+
+// IsVersionMismatch checks if the error is a version mismatch error.
+func IsVersionMismatch(err error) bool {
+	return errors.Is(err, ErrVersionMismatch)
+}
+
 // IsUnauthorized checks if the error is an unauthorized error.
 func IsUnauthorized(err error) bool {
 	return errors.Is(err, ErrUnauthorized)
 }
+
+// IsForbidden checks if the error is a forbidden error.
+func IsForbidden(err error) bool {
+	return errors.Is(err, ErrForbidden)
+}
+
+// IsRejected checks if the error is a content rejection.
+func IsRejected(err error) bool {
+	return errors.Is(err, ErrRejected)
+}
@@ -132,6 +132,60 @@ func TestIsUnauthorized(t *testing.T) {
 	}
 }
 
+func TestIsAlreadyExists(t *testing.T) {
+	if !IsAlreadyExists(E("Op", ErrAlreadyExists, nil)) {
+		t.Error("IsAlreadyExists(wrapped ErrAlreadyExists) should be true")
+	}
+	if IsAlreadyExists(ErrConflict) {
+		t.Error("IsAlreadyExists(ErrConflict) should be false")
+	}
+}
+
+func TestIsInvalidInput(t *testing.T) {
+	if !IsInvalidInput(E("Op", ErrInvalidInput, nil)) {
+		t.Error("IsInvalidInput(wrapped ErrInvalidInput) should be true")
+	}
+	if IsInvalidInput(ErrNotFound) {
+		t.Error("IsInvalidInput(ErrNotFound) should be false")
+	}
+}
+
+func TestIsVersionMismatch(t *testing.T) {
+	if !IsVersionMismatch(E("Op", ErrVersionMismatch, nil)) {
+		t.Error("IsVersionMismatch(wrapped ErrVersionMismatch) should be true")
+	}
+	if IsVersionMismatch(ErrConflict) {
+		t.Error("IsVersionMismatch(ErrConflict) should be false")
+	}
+}
+
+func TestIsTooLarge(t *testing.T) {
+	if !IsTooLarge(E("Op", ErrTooLarge, nil)) {
+		t.Error("IsTooLarge(wrapped ErrTooLarge) should be true")
+	}
+	if IsTooLarge(ErrStorageFull) {
+		t.Error("IsTooLarge(ErrStorageFull) should be false")
+	}
+}
+
+func TestIsForbidden(t *testing.T) {
+	if !IsForbidden(E("Op", ErrForbidden, nil)) {
+		t.Error("IsForbidden(wrapped ErrForbidden) should be true")
+	}
+	if IsForbidden(ErrUnauthorized) {
+		t.Error("IsForbidden(ErrUnauthorized) should be false")
+	}
+}
+
+func TestIsRejected(t *testing.T) {
+	if !IsRejected(E("Op", ErrRejected, nil, "malware")) {
+		t.Error("IsRejected(wrapped ErrRejected) should be true")
+	}
+	if IsRejected(ErrForbidden) {
+		t.Error("IsRejected(ErrForbidden) should be false")
+	}
+}
+
 // contains checks if s contains substr
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsHelper(s, substr))
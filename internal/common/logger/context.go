@@ -0,0 +1,83 @@
+// Package logger provides the fields of a request that are carried by its
+// context and attached to every line logged while serving it.
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// Keys of the request fields.
+const (
+	RequestIDKey = "request_id"
+	UserIDKey    = "user_id"
+	FileIDKey    = "file_id"
+	RegionIDKey  = "region_id"
+)
+
+// RequestID returns the field of a request ID.
+func RequestID(id string) zap.Field { return zap.String(RequestIDKey, id) }
+
+// UserID returns the field of the user making a request.
+func UserID(id string) zap.Field { return zap.String(UserIDKey, id) }
+
+// FileID returns the field of the file a request operates on.
+func FileID(id string) zap.Field { return zap.String(FileIDKey, id) }
+
+// RegionID returns the field of the region serving or sending a request.
+func RegionID(id string) zap.Field { return zap.String(RegionIDKey, id) }
+
+// fieldsKey is the context key of the request fields.
+type fieldsKey struct{}
+
+// ContextWith returns a context carrying fields in addition to those of
+// ctx. A field replaces an earlier one with the same key.
+func ContextWith(ctx context.Context, fields ...zap.Field) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+
+	existing := fieldsFrom(ctx)
+	merged := make([]zap.Field, 0, len(existing)+len(fields))
+	for _, field := range existing {
+		if !hasKey(fields, field.Key) {
+			merged = append(merged, field)
+		}
+	}
+	merged = append(merged, fields...)
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FromContext returns log with the request fields carried by ctx.
+func FromContext(ctx context.Context, log *zap.Logger) *zap.Logger {
+	fields := fieldsFrom(ctx)
+	if len(fields) == 0 {
+		return log
+	}
+	return log.With(fields...)
+}
+
+// RequestIDFrom returns the request ID carried by ctx, or an empty string.
+func RequestIDFrom(ctx context.Context) string {
+	for _, field := range fieldsFrom(ctx) {
+		if field.Key == RequestIDKey {
+			return field.String
+		}
+	}
+	return ""
+}
+
+func fieldsFrom(ctx context.Context) []zap.Field {
+	fields, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+	return fields
+}
+
+func hasKey(fields []zap.Field, key string) bool {
+	for _, field := range fields {
+		if field.Key == key {
+			return true
+		}
+	}
+	return false
+}
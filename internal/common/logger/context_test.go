@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFromContext(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	log := zap.New(core)
+
+	FromContext(context.Background(), log).Info("no request")
+
+	ctx := ContextWith(context.Background(), RequestID("req-1"), RegionID("region-a"))
+	ctx = ContextWith(ctx, UserID("alice"), FileID("file-1"))
+	ctx = ContextWith(ctx, FileID("file-2"))
+	FromContext(ctx, log).Info("upload", zap.Int64("size", 5))
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if len(entries[0].Context) != 0 {
+		t.Errorf("fields without a request = %v", entries[0].Context)
+	}
+	want := map[string]any{
+		RequestIDKey: "req-1",
+		RegionIDKey:  "region-a",
+		UserIDKey:    "alice",
+		FileIDKey:    "file-2", // The later field replaces the earlier one
+		"size":       int64(5),
+	}
+	got := entries[1].ContextMap()
+	if len(entries[1].Context) != len(want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+
+	if id := RequestIDFrom(ctx); id != "req-1" {
+		t.Errorf("RequestIDFrom() = %q, want req-1", id)
+	}
+	if id := RequestIDFrom(context.Background()); id != "" {
+		t.Errorf("RequestIDFrom() without a request = %q", id)
+	}
+}
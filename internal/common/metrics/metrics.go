@@ -0,0 +1,129 @@
+// Package metrics provides the Prometheus registry, the /metrics endpoint
+// and the HTTP and operation metrics shared by the region and coordinator.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+)
+
+// Namespace prefixes the names of all JzSE metrics.
+const Namespace = "jzse"
+
+// NewRegistry creates a registry with the Go runtime and process metrics.
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// Handler serves the metrics of a registry in the Prometheus exposition
+// format.
+func Handler(reg *prometheus.Registry) gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
+}
+
+// Register registers collectors, stopping at the first that fails.
+func Register(reg prometheus.Registerer, cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HTTP holds the metrics of HTTP requests.
+type HTTP struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewHTTP creates and registers the metrics of HTTP requests.
+func NewHTTP(reg prometheus.Registerer) (*HTTP, error) {
+	m := &HTTP{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+	if err := Register(reg, m.requests, m.duration); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Middleware records each request under its route pattern, such as
+// "/api/v1/files/:id", so that the number of series stays bounded.
+// Requests matching no route are recorded as "unmatched".
+func (m *HTTP) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.duration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Operations holds the latency and errors of the operations of a
+// component, such as a storage backend.
+type Operations struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// NewOperations creates and registers the metrics of the operations of a
+// subsystem. Errors are counted by operation and error code.
+func NewOperations(reg prometheus.Registerer, subsystem, component string) (*Operations, error) {
+	m := &Operations{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: subsystem,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of " + component + " operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: subsystem,
+			Name:      "operation_errors_total",
+			Help:      "Failed " + component + " operations by error code.",
+		}, []string{"operation", "code"}),
+	}
+	if err := Register(reg, m.duration, m.errors); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Observe records an operation that started at start and ended with err.
+func (m *Operations) Observe(operation string, start time.Time, err error) {
+	m.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(operation, string(apierror.CodeOf(err))).Inc()
+	}
+}
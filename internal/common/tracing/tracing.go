@@ -0,0 +1,182 @@
+// Package tracing provides OpenTelemetry tracing for the JzSE system: the
+// tracer provider and its exporters, spans around HTTP requests, and the
+// trace context carried by messages between regions and the coordinator.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// instrumentationName is the instrumentation scope of all JzSE spans.
+const instrumentationName = "asisaid.cn/JzSE"
+
+// Exporters of finished spans.
+const (
+	ExporterOTLP   = "otlp"   // To an OTLP collector over gRPC
+	ExporterStdout = "stdout" // Printed to standard output, for local testing
+)
+
+// Config holds tracing configuration.
+type Config struct {
+	Enabled        bool
+	Exporter       string  // otlp, stdout
+	Endpoint       string  // Address of the OTLP collector, such as localhost:4317
+	Insecure       bool    // Connect to the OTLP collector without TLS
+	SampleRatio    float64 // Of the traces started here; others follow the caller's decision
+	ServiceName    string  // jzse-region, jzse-coordinator
+	ServiceVersion string
+	InstanceID     string // Region ID, for regions
+}
+
+// Init installs the global tracer provider and the W3C trace context
+// propagator, and returns the function flushing and stopping the provider.
+// When tracing is disabled only the propagator is installed, so that
+// trace context passes through the service unchanged.
+func Init(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServiceName(cfg.ServiceName), semconv.ServiceVersion(cfg.ServiceVersion)}
+	if cfg.InstanceID != "" {
+		attrs = append(attrs, semconv.ServiceInstanceID(cfg.InstanceID))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+	if err != nil {
+		return nil, errors.Wrap("tracing.Init", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newExporter creates the exporter of finished spans.
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, errors.E("tracing.Init", errors.ErrInvalidInput, err, cfg.Endpoint)
+		}
+		return exporter, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, errors.Wrap("tracing.Init", err)
+		}
+		return exporter, nil
+	default:
+		return nil, errors.E("tracing.Init", errors.ErrInvalidInput, nil, "unknown exporter "+cfg.Exporter)
+	}
+}
+
+// Start starts a span named after the operation, such as "storage.Put",
+// as a child of the span in ctx.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End ends a span, recording err and its API error code if it is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error.code", string(apierror.CodeOf(err))))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject returns the trace context of ctx in a form that travels inside
+// a message, such as a change event. It is nil when ctx holds no span.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx with the trace context carried by a message, so
+// that spans started from it continue the trace of the message.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// Continue starts a span continuing the trace carried by a message. The
+// span in ctx, such as that of the call delivering the message, is linked
+// to it.
+func Continue(ctx context.Context, carrier map[string]string, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if len(carrier) > 0 {
+		opts = append(opts, trace.WithLinks(trace.LinkFromContext(ctx)))
+		ctx = Extract(ctx, carrier)
+	}
+	return Start(ctx, name, opts...)
+}
+
+// Middleware starts a span for each request under its route pattern,
+// continuing the trace of the caller if the request carries one.
+// Requests matching no route are named "unmatched".
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// record installs a tracer provider keeping finished spans in memory.
+func record(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	if _, err := Init(context.Background(), Config{}); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter
+}
+
+func TestInit_UnknownExporter(t *testing.T) {
+	if _, err := Init(context.Background(), Config{Enabled: true, Exporter: "zipkin"}); !errors.IsInvalidInput(err) {
+		t.Errorf("Init with an unknown exporter = %v, want invalid input", err)
+	}
+}
+
+func TestContinue(t *testing.T) {
+	exporter := record(t)
+
+	ctx, span := Start(context.Background(), "upload")
+	carrier := Inject(ctx)
+	span.End()
+	if carrier["traceparent"] == "" {
+		t.Fatalf("carrier = %v, want a traceparent", carrier)
+	}
+	if Inject(context.Background()) != nil {
+		t.Error("Inject without a span should return nil")
+	}
+
+	// The receiver continues the trace of the message, linking the call
+	// that delivered it
+	callCtx, call := Start(context.Background(), "call")
+	_, received := Continue(callCtx, carrier, "receive")
+	End(received, errors.E("receive", errors.ErrConflict, nil, "concurrent update"))
+	call.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	upload, receive := spans[0], spans[1]
+	if receive.Parent.SpanID() != upload.SpanContext.SpanID() || receive.SpanContext.TraceID() != upload.SpanContext.TraceID() {
+		t.Errorf("receive span is not a child of the upload span")
+	}
+	if len(receive.Links) != 1 || receive.Links[0].SpanContext.SpanID() != spans[2].SpanContext.SpanID() {
+		t.Errorf("links = %v, want the call span", receive.Links)
+	}
+	if receive.Status.Code != codes.Error || len(receive.Events) != 1 {
+		t.Errorf("status = %v with %d events, want the recorded error", receive.Status, len(receive.Events))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := record(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/files/:id", func(c *gin.Context) {
+		if !trace.SpanContextFromContext(c.Request.Context()).IsValid() {
+			t.Error("handler context holds no span")
+		}
+		c.Status(http.StatusInternalServerError)
+	})
+
+	// The caller's trace is continued
+	parentCtx, parent := Start(context.Background(), "client")
+	parent.End()
+	req := httptest.NewRequest("GET", "/files/file-1", nil)
+	for key, value := range Inject(parentCtx) {
+		req.Header.Set(key, value)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	server := spans[1]
+	if server.Name != "GET /files/:id" || server.SpanKind != trace.SpanKindServer {
+		t.Errorf("span = %v (%v), want the server span of the route", server.Name, server.SpanKind)
+	}
+	if server.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("server span does not continue the caller's trace")
+	}
+	if server.Status.Code != codes.Error {
+		t.Errorf("status = %v, want an error for a 500 response", server.Status)
+	}
+	if spans[2].Name != "GET unmatched" {
+		t.Errorf("span of an unknown route = %v, want GET unmatched", spans[2].Name)
+	}
+}
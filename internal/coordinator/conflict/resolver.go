@@ -6,8 +6,10 @@ import (
 	"time"
 
 	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/common/metrics"
 	"asisaid.cn/JzSE/internal/coordinator/metadata"
 	regionmeta "asisaid.cn/JzSE/internal/region/metadata"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -43,6 +45,8 @@ type Resolution struct {
 // Resolver handles conflict detection and resolution.
 type Resolver struct {
 	defaultStrategy Strategy
+	detected        prometheus.Counter
+	resolved        *prometheus.CounterVec // By strategy
 	logger          *zap.Logger
 }
 
@@ -50,10 +54,28 @@ type Resolver struct {
 func NewResolver(defaultStrategy Strategy) *Resolver {
 	return &Resolver{
 		defaultStrategy: defaultStrategy,
-		logger:          logger.WithComponent("ConflictResolver"),
+		detected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "coordinator",
+			Name:      "conflicts_detected_total",
+			Help:      "Conflicting versions of files detected.",
+		}),
+		resolved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "coordinator",
+			Name:      "conflicts_resolved_total",
+			Help:      "Conflicts resolved, by strategy.",
+		}, []string{"strategy"}),
+		logger: logger.WithComponent("ConflictResolver"),
 	}
 }
 
+// RegisterMetrics registers the metrics of the resolver: the conflicts
+// detected, and resolved by strategy.
+func (r *Resolver) RegisterMetrics(reg prometheus.Registerer) error {
+	return metrics.Register(reg, r.detected, r.resolved)
+}
+
 // Detect checks if two metadata versions are in conflict.
 func (r *Resolver) Detect(local, remote *metadata.GlobalFileMetadata) *Conflict {
 	relation := local.CompareClock(remote.VectorClock)
@@ -71,6 +93,7 @@ func (r *Resolver) Detect(local, remote *metadata.GlobalFileMetadata) *Conflict
 		Status:        "pending",
 	}
 
+	r.detected.Inc()
 	r.logger.Warn("conflict detected",
 		zap.String("conflict_id", conflict.ID),
 		zap.String("file_id", conflict.FileID),
@@ -111,6 +134,7 @@ func (r *Resolver) Resolve(ctx context.Context, conflict *Conflict, strategy Str
 	}
 
 	conflict.Status = "resolved"
+	r.resolved.WithLabelValues(string(strategy)).Inc()
 
 	r.logger.Info("conflict resolved",
 		zap.String("conflict_id", conflict.ID),
@@ -5,6 +5,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
 	"asisaid.cn/JzSE/internal/coordinator/metadata"
 	regionmeta "asisaid.cn/JzSE/internal/region/metadata"
 )
@@ -77,6 +79,10 @@ func TestResolver_Detect(t *testing.T) {
 			t.Errorf("Status = %v, want pending", conflict.Status)
 		}
 	})
+
+	if detected := testutil.ToFloat64(resolver.detected); detected != 1 {
+		t.Errorf("conflicts detected = %v, want 1", detected)
+	}
 }
 
 func TestResolver_Resolve_LWW(t *testing.T) {
@@ -127,6 +133,9 @@ func TestResolver_Resolve_LWW(t *testing.T) {
 	if conflict.Status != "resolved" {
 		t.Errorf("conflict.Status = %v, want resolved", conflict.Status)
 	}
+	if resolved := testutil.ToFloat64(resolver.resolved.WithLabelValues(string(StrategyLWW))); resolved != 1 {
+		t.Errorf("conflicts resolved by %v = %v, want 1", StrategyLWW, resolved)
+	}
 }
 
 func TestResolver_Resolve_Fork(t *testing.T) {
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"asisaid.cn/JzSE/internal/common/errors"
@@ -62,6 +63,7 @@ type EtcdManager struct {
 	config ManagerConfig
 	// client *clientv3.Client // Will be added when etcd is integrated
 	store  map[string]*GlobalFileMetadata // In-memory store for now
+	mu     sync.RWMutex                   // Guards store
 	logger *zap.Logger
 }
 
@@ -82,6 +84,9 @@ func NewEtcdManager(cfg ManagerConfig) (*EtcdManager, error) {
 
 // Get retrieves file metadata.
 func (m *EtcdManager) Get(ctx context.Context, fileID string) (*GlobalFileMetadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	meta, ok := m.store[fileID]
 	if !ok {
 		return nil, errors.ErrNotFound
@@ -91,6 +96,9 @@ func (m *EtcdManager) Get(ctx context.Context, fileID string) (*GlobalFileMetada
 
 // Update updates file metadata.
 func (m *EtcdManager) Update(ctx context.Context, meta *GlobalFileMetadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	existing, ok := m.store[meta.ID]
 	if !ok {
 		return errors.ErrNotFound
@@ -99,14 +107,14 @@ func (m *EtcdManager) Update(ctx context.Context, meta *GlobalFileMetadata) erro
 	// Check vector clock for conflicts
 	relation := existing.CompareClock(meta.VectorClock)
 	if relation == regionmeta.ClockConcurrent {
-		m.logger.Warn("conflict detected",
+		logger.FromContext(ctx, m.logger).Warn("conflict detected",
 			zap.String("file_id", meta.ID),
 		)
 		return errors.ErrConflict
 	}
 
 	m.store[meta.ID] = meta
-	m.logger.Debug("metadata updated",
+	logger.FromContext(ctx, m.logger).Debug("metadata updated",
 		zap.String("file_id", meta.ID),
 		zap.Int64("version", meta.Version),
 	)
@@ -115,12 +123,15 @@ func (m *EtcdManager) Update(ctx context.Context, meta *GlobalFileMetadata) erro
 
 // Register registers a new file.
 func (m *EtcdManager) Register(ctx context.Context, meta *GlobalFileMetadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if _, ok := m.store[meta.ID]; ok {
 		return errors.ErrAlreadyExists
 	}
 
 	m.store[meta.ID] = meta
-	m.logger.Debug("file registered",
+	logger.FromContext(ctx, m.logger).Debug("file registered",
 		zap.String("file_id", meta.ID),
 		zap.String("path", meta.Path),
 	)
@@ -129,17 +140,23 @@ func (m *EtcdManager) Register(ctx context.Context, meta *GlobalFileMetadata) er
 
 // Delete removes file metadata.
 func (m *EtcdManager) Delete(ctx context.Context, fileID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if _, ok := m.store[fileID]; !ok {
 		return errors.ErrNotFound
 	}
 
 	delete(m.store, fileID)
-	m.logger.Debug("file deleted", zap.String("file_id", fileID))
+	logger.FromContext(ctx, m.logger).Debug("file deleted", zap.String("file_id", fileID))
 	return nil
 }
 
 // GetLocations returns file locations.
 func (m *EtcdManager) GetLocations(ctx context.Context, fileID string) ([]RegionLocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	meta, ok := m.store[fileID]
 	if !ok {
 		return nil, errors.ErrNotFound
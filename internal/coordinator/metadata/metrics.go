@@ -0,0 +1,70 @@
+// Package metadata provides the Prometheus instrumentation of global
+// metadata managers.
+package metadata
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"asisaid.cn/JzSE/internal/common/metrics"
+)
+
+// InstrumentedManager records the latency and errors of the operations of
+// a Manager. Updates refused for concurrent vector clocks are counted as
+// errors with the "conflict" code.
+type InstrumentedManager struct {
+	manager    Manager
+	operations *metrics.Operations
+}
+
+var _ Manager = (*InstrumentedManager)(nil)
+
+// NewInstrumentedManager wraps a manager and registers its metrics.
+func NewInstrumentedManager(manager Manager, reg prometheus.Registerer) (*InstrumentedManager, error) {
+	operations, err := metrics.NewOperations(reg, "global_metadata", "global metadata")
+	if err != nil {
+		return nil, err
+	}
+	return &InstrumentedManager{manager: manager, operations: operations}, nil
+}
+
+// Get retrieves file metadata.
+func (m *InstrumentedManager) Get(ctx context.Context, fileID string) (meta *GlobalFileMetadata, err error) {
+	defer m.observe("get", time.Now(), &err)
+	return m.manager.Get(ctx, fileID)
+}
+
+// Update updates file metadata.
+func (m *InstrumentedManager) Update(ctx context.Context, meta *GlobalFileMetadata) (err error) {
+	defer m.observe("update", time.Now(), &err)
+	return m.manager.Update(ctx, meta)
+}
+
+// Register registers a new file.
+func (m *InstrumentedManager) Register(ctx context.Context, meta *GlobalFileMetadata) (err error) {
+	defer m.observe("register", time.Now(), &err)
+	return m.manager.Register(ctx, meta)
+}
+
+// Delete removes file metadata.
+func (m *InstrumentedManager) Delete(ctx context.Context, fileID string) (err error) {
+	defer m.observe("delete", time.Now(), &err)
+	return m.manager.Delete(ctx, fileID)
+}
+
+// GetLocations returns file locations.
+func (m *InstrumentedManager) GetLocations(ctx context.Context, fileID string) (locations []RegionLocation, err error) {
+	defer m.observe("get_locations", time.Now(), &err)
+	return m.manager.GetLocations(ctx, fileID)
+}
+
+// Close closes the manager.
+func (m *InstrumentedManager) Close() error {
+	return m.manager.Close()
+}
+
+func (m *InstrumentedManager) observe(operation string, start time.Time, err *error) {
+	m.operations.Observe(operation, start, *err)
+}
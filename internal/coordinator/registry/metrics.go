@@ -0,0 +1,58 @@
+// Package registry provides the Prometheus metrics of the region registry.
+package registry
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"asisaid.cn/JzSE/internal/common/metrics"
+)
+
+// regionStates are the health states regions are counted in, reported
+// even when no region is in them.
+var regionStates = []string{"healthy", "degraded", "offline"}
+
+var (
+	regionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "coordinator", "regions"),
+		"Registered regions by health state.",
+		[]string{"state"}, nil,
+	)
+	regionSyncLagDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.Namespace, "coordinator", "region_sync_lag"),
+		"Changes a region reported it has yet to push.",
+		[]string{"region"}, nil,
+	)
+)
+
+// RegisterMetrics registers the metrics of the registry: the regions by
+// health state and the sync lag they report.
+func (r *Registry) RegisterMetrics(reg prometheus.Registerer) error {
+	return reg.Register(registryCollector{r})
+}
+
+// registryCollector reads the regions of the registry at scrape time.
+type registryCollector struct {
+	registry *Registry
+}
+
+func (c registryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- regionsDesc
+	ch <- regionSyncLagDesc
+}
+
+func (c registryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.registry.mu.RLock()
+	defer c.registry.mu.RUnlock()
+
+	counts := make(map[string]int, len(regionStates))
+	for _, state := range regionStates {
+		counts[state] = 0
+	}
+	for _, region := range c.registry.regions {
+		counts[region.Status.State]++
+		ch <- prometheus.MustNewConstMetric(regionSyncLagDesc, prometheus.GaugeValue, float64(region.Status.SyncLag), region.ID)
+	}
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(regionsDesc, prometheus.GaugeValue, float64(n), state)
+	}
+}
@@ -98,7 +98,7 @@ func (r *Registry) Register(ctx context.Context, region *RegionInfo) error {
 
 	r.regions[region.ID] = region
 
-	r.logger.Info("region registered",
+	logger.FromContext(ctx, r.logger).Info("region registered",
 		zap.String("region_id", region.ID),
 		zap.String("name", region.Name),
 		zap.String("endpoint", region.Endpoint),
@@ -118,7 +118,7 @@ func (r *Registry) Deregister(ctx context.Context, regionID string) error {
 
 	delete(r.regions, regionID)
 
-	r.logger.Info("region deregistered", zap.String("region_id", regionID))
+	logger.FromContext(ctx, r.logger).Info("region deregistered", zap.String("region_id", regionID))
 	return nil
 }
 
@@ -7,7 +7,10 @@ import (
 	"time"
 
 	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/common/tracing"
 	"asisaid.cn/JzSE/internal/coordinator/metadata"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -20,6 +23,14 @@ type ChangeEvent struct {
 	VectorClock map[string]uint64            `json:"vector_clock"`
 	Timestamp   time.Time                    `json:"timestamp"`
 	RegionID    string                       `json:"region_id"`
+
+	// TraceContext carries the trace of the change, continued by
+	// HandleChange and by the regions pulling the change.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+
+	// RequestID is the ID of the request that made the change in its
+	// region, logged with the event.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // EngineConfig holds sync engine configuration.
@@ -79,9 +90,26 @@ func (e *Engine) Stop() {
 	e.wg.Wait()
 }
 
-// HandleChange processes a change event from a region.
-func (e *Engine) HandleChange(ctx context.Context, event *ChangeEvent) error {
-	e.logger.Debug("handling change",
+// HandleChange processes a change event from a region, continuing the
+// trace and logging the request ID the event carries.
+func (e *Engine) HandleChange(ctx context.Context, event *ChangeEvent) (err error) {
+	ctx, span := tracing.Continue(ctx, event.TraceContext, "Engine.HandleChange",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("sync.event_id", event.ID),
+			attribute.String("sync.change_type", event.Type),
+			attribute.String("sync.region_id", event.RegionID),
+			attribute.String("file.id", event.FileID),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// Lines logged while handling the change carry the ID of the request
+	// that made it
+	if event.RequestID != "" {
+		ctx = logger.ContextWith(ctx, logger.RequestID(event.RequestID))
+	}
+	logger.FromContext(ctx, e.logger).Debug("handling change",
 		zap.String("event_id", event.ID),
 		zap.String("file_id", event.FileID),
 		zap.String("region_id", event.RegionID),
@@ -104,8 +132,9 @@ func (e *Engine) HandleChange(ctx context.Context, event *ChangeEvent) error {
 		}
 	}
 
-	// Broadcast to other regions
+	// Broadcast to other regions, which continue the trace from here
 	if e.config.DefaultStrategy == "eager" {
+		event.TraceContext = tracing.Inject(ctx)
 		e.broadcastChange(ctx, event)
 	}
 
@@ -124,7 +153,7 @@ func (e *Engine) broadcastChange(ctx context.Context, event *ChangeEvent) {
 
 		// Add to pending events for the region
 		state.PendingEvents = append(state.PendingEvents, event)
-		e.logger.Debug("queued event for region",
+		logger.FromContext(ctx, e.logger).Debug("queued event for region",
 			zap.String("region_id", regionID),
 			zap.String("event_id", event.ID),
 		)
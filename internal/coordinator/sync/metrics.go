@@ -0,0 +1,38 @@
+// Package sync provides the Prometheus metrics of the sync engine.
+package sync
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"asisaid.cn/JzSE/internal/common/metrics"
+)
+
+var pendingEventsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(metrics.Namespace, "coordinator", "pending_events"),
+	"Change events queued for a region to pull.",
+	[]string{"region"}, nil,
+)
+
+// RegisterMetrics registers the metrics of the engine: the events pending
+// for each region.
+func (e *Engine) RegisterMetrics(reg prometheus.Registerer) error {
+	return reg.Register(engineCollector{e})
+}
+
+// engineCollector reads the pending events of the engine at scrape time.
+type engineCollector struct {
+	engine *Engine
+}
+
+func (c engineCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingEventsDesc
+}
+
+func (c engineCollector) Collect(ch chan<- prometheus.Metric) {
+	c.engine.mu.RLock()
+	defer c.engine.mu.RUnlock()
+
+	for regionID, state := range c.engine.regions {
+		ch <- prometheus.MustNewConstMetric(pendingEventsDesc, prometheus.GaugeValue, float64(len(state.PendingEvents)), regionID)
+	}
+}
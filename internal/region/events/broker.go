@@ -0,0 +1,323 @@
+// Package events provides real-time change notifications for subscribed
+// paths. The broker receives the same change events as the sync agent and
+// keeps a bounded history, so subscribers can resume from the last event
+// they saw after a disconnect.
+package events
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+)
+
+// Type is the kind of a change notification.
+type Type string
+
+const (
+	TypeCreate   Type = "create"
+	TypeUpdate   Type = "update"
+	TypeDelete   Type = "delete"
+	TypeRename   Type = "rename"   // File moved to Path from OldPath
+	TypeConflict Type = "conflict" // Concurrent updates of the file were detected
+	TypeReset    Type = "reset"    // Events were missed; list the subscribed paths again
+)
+
+// Default limits of a broker.
+const (
+	DefaultHistorySize = 1000
+	DefaultBufferSize  = 256
+)
+
+// Event is a change notification. IDs are opaque and only valid for the
+// broker that issued them.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      Type      `json:"type"`
+	Path      string    `json:"path,omitempty"`
+	Dir       string    `json:"dir,omitempty"`      // Directory containing Path
+	OldPath   string    `json:"old_path,omitempty"` // For renames
+	FileID    string    `json:"file_id,omitempty"`
+	OwnerID   string    `json:"owner_id,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Version   int64     `json:"version,omitempty"`
+	ETag      string    `json:"etag,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	seq uint64
+}
+
+// BrokerConfig holds configuration for the event broker.
+type BrokerConfig struct {
+	HistorySize int // Events kept for resuming subscriptions
+	BufferSize  int // Events queued per subscriber before it is dropped
+}
+
+// Broker fans change events out to subscribers.
+//
+// Publishing never blocks: a subscriber whose buffer is full is dropped,
+// and resumes from its last event by subscribing again.
+type Broker struct {
+	config BrokerConfig
+	epoch  string // Distinguishes event IDs of different broker instances
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	seq     uint64
+	history []*Event
+	files   map[string]fileState // Files with events in the history
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+// fileState is the last known path of a file.
+type fileState struct {
+	path string
+	seq  uint64 // Latest event of the file
+}
+
+// NewBroker creates a new event broker.
+func NewBroker(cfg BrokerConfig) *Broker {
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = DefaultHistorySize
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultBufferSize
+	}
+	return &Broker{
+		config: cfg,
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		logger: logger.WithComponent("EventBroker"),
+		files:  make(map[string]fileState),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// QueueChange publishes a file change. Updates that move a file are
+// reported as renames, and changes of conflicting files as conflicts.
+func (b *Broker) QueueChange(ctx context.Context, changeType regionsync.ChangeType, meta *metadata.FileMetadata) {
+	eventType, ok := TypeOf(changeType, meta)
+	if !ok {
+		return
+	}
+	event := &Event{
+		Type:      eventType,
+		Path:      meta.Path,
+		Dir:       path.Dir(meta.Path),
+		FileID:    meta.ID,
+		OwnerID:   meta.OwnerID,
+		Size:      meta.Size,
+		Version:   meta.Version,
+		ETag:      meta.ETag(),
+		Timestamp: time.Now(),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if last, ok := b.files[meta.ID]; ok && event.Type == TypeUpdate && last.path != meta.Path {
+		event.Type = TypeRename
+		event.OldPath = last.path
+	}
+	b.publish(event)
+}
+
+// TypeOf returns the notification type of a file change, and false for
+// changes that are not file changes. Any change of a conflicting file is a
+// conflict.
+func TypeOf(changeType regionsync.ChangeType, meta *metadata.FileMetadata) (Type, bool) {
+	if meta.SyncState == metadata.SyncStateConflict {
+		return TypeConflict, true
+	}
+	switch changeType {
+	case regionsync.ChangeTypeCreate:
+		return TypeCreate, true
+	case regionsync.ChangeTypeUpdate:
+		return TypeUpdate, true
+	case regionsync.ChangeTypeDelete:
+		return TypeDelete, true
+	default:
+		return "", false
+	}
+}
+
+// QueueACLChange ignores ACL changes; they are not file changes, and
+// subscribers see their effect on the permission checks of later events.
+func (b *Broker) QueueACLChange(ctx context.Context, acl *metadata.ACL) {}
+
+// publish numbers an event, records it in the history and delivers it to
+// matching subscribers. b.mu must be held.
+func (b *Broker) publish(event *Event) {
+	if b.closed {
+		return
+	}
+
+	b.seq++
+	event.seq = b.seq
+	event.ID = b.eventID(b.seq)
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.config.HistorySize {
+		evicted := b.history[0]
+		b.history[0] = nil
+		b.history = b.history[1:]
+		if state, ok := b.files[evicted.FileID]; ok && state.seq == evicted.seq {
+			delete(b.files, evicted.FileID)
+		}
+	}
+	if event.FileID != "" {
+		b.files[event.FileID] = fileState{path: event.Path, seq: event.seq}
+	}
+
+	for sub := range b.subs {
+		if !sub.matches(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			b.logger.Warn("dropping slow subscriber", zap.String("last_event_id", event.ID))
+			sub.dropped = true
+			b.remove(sub)
+		}
+	}
+}
+
+// eventID formats the ID of the event with the given sequence number.
+func (b *Broker) eventID(seq uint64) string {
+	return b.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// Subscribe subscribes to changes of files at or below paths. With a
+// lastEventID, the events after it are replayed first; if they are no
+// longer known, a reset event is sent instead.
+func (b *Broker) Subscribe(paths []string, lastEventID string) *Subscription {
+	sub := &Subscription{
+		broker: b,
+		ch:     make(chan *Event, b.config.BufferSize),
+	}
+	for _, p := range paths {
+		sub.paths = append(sub.paths, path.Clean("/"+p))
+	}
+	if len(sub.paths) == 0 {
+		sub.paths = []string{"/"}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(sub.ch)
+		return sub
+	}
+
+	if lastEventID != "" {
+		missed, ok := b.since(lastEventID)
+		var replay []*Event
+		for _, event := range missed {
+			if sub.matches(event) {
+				replay = append(replay, event)
+			}
+		}
+		if !ok || len(replay) > b.config.BufferSize {
+			replay = []*Event{{ID: b.eventID(b.seq), Type: TypeReset, Timestamp: time.Now()}}
+		}
+		for _, event := range replay {
+			sub.ch <- event
+		}
+	}
+
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// since returns the events after lastEventID, and false if some of them
+// are no longer in the history or the ID was not issued by b.
+func (b *Broker) since(lastEventID string) ([]*Event, bool) {
+	epoch, value, found := strings.Cut(lastEventID, "-")
+	if !found || epoch != b.epoch {
+		return nil, false
+	}
+	seq, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || seq > b.seq {
+		return nil, false
+	}
+	if len(b.history) == 0 || seq >= b.seq {
+		return nil, seq == b.seq
+	}
+	if seq+1 < b.history[0].seq {
+		return nil, false
+	}
+	return b.history[len(b.history)-int(b.seq-seq):], true
+}
+
+// Close ends all subscriptions and stops publishing, for server shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+// remove ends a subscription. b.mu must be held.
+func (b *Broker) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Subscription receives the events of subscribed paths.
+type Subscription struct {
+	broker  *Broker
+	paths   []string
+	ch      chan *Event
+	dropped bool // Guarded by broker.mu
+}
+
+// Events returns the channel events are delivered on. It is closed when
+// the subscription ends.
+func (s *Subscription) Events() <-chan *Event {
+	return s.ch
+}
+
+// Dropped reports whether the subscription was ended because it did not
+// keep up with the events.
+func (s *Subscription) Dropped() bool {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.dropped
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s)
+}
+
+// matches reports whether event concerns a subscribed path.
+func (s *Subscription) matches(event *Event) bool {
+	for _, p := range s.paths {
+		if within(event.Path, p) || (event.OldPath != "" && within(event.OldPath, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether p is dir or below it.
+func within(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
@@ -0,0 +1,157 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"asisaid.cn/JzSE/internal/region/metadata"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+)
+
+// received drains the events queued on a subscription.
+func received(sub *Subscription) []*Event {
+	var events []*Event
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func types(events []*Event) []Type {
+	result := make([]Type, len(events))
+	for i, event := range events {
+		result[i] = event.Type
+	}
+	return result
+}
+
+func equalTypes(got []*Event, want ...Type) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i, event := range got {
+		if event.Type != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func file(id, path string) *metadata.FileMetadata {
+	return &metadata.FileMetadata{ID: id, Path: path, Version: 1}
+}
+
+func TestBroker_PathFiltering(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(BrokerConfig{})
+	docs := b.Subscribe([]string{"/docs"}, "")
+	all := b.Subscribe(nil, "")
+	defer docs.Close()
+	defer all.Close()
+
+	b.QueueChange(ctx, regionsync.ChangeTypeCreate, file("1", "/docs/a.txt"))
+	b.QueueChange(ctx, regionsync.ChangeTypeCreate, file("2", "/docsx/b.txt"))
+	b.QueueChange(ctx, regionsync.ChangeTypeDelete, file("1", "/docs/a.txt"))
+	b.QueueACLChange(ctx, &metadata.ACL{Path: "/docs"})
+
+	got := received(docs)
+	if !equalTypes(got, TypeCreate, TypeDelete) || got[0].Path != "/docs/a.txt" || got[0].Dir != "/docs" {
+		t.Errorf("/docs events = %v", types(got))
+	}
+	if got := received(all); len(got) != 3 {
+		t.Errorf("/ events = %v, want 3", types(got))
+	}
+}
+
+func TestBroker_RenameAndConflict(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(BrokerConfig{})
+	sub := b.Subscribe([]string{"/old"}, "")
+	defer sub.Close()
+
+	b.QueueChange(ctx, regionsync.ChangeTypeCreate, file("1", "/old/a.txt"))
+	b.QueueChange(ctx, regionsync.ChangeTypeUpdate, file("1", "/new/a.txt"))
+	conflicted := file("1", "/old/a.txt")
+	conflicted.SyncState = metadata.SyncStateConflict
+	b.QueueChange(ctx, regionsync.ChangeTypeUpdate, conflicted)
+
+	got := received(sub)
+	if !equalTypes(got, TypeCreate, TypeRename, TypeConflict) {
+		t.Fatalf("events = %v", types(got))
+	}
+	if got[1].OldPath != "/old/a.txt" || got[1].Path != "/new/a.txt" {
+		t.Errorf("rename = %+v", got[1])
+	}
+}
+
+func TestBroker_Resume(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(BrokerConfig{HistorySize: 3})
+	for _, id := range []string{"1", "2", "3"} {
+		b.QueueChange(ctx, regionsync.ChangeTypeCreate, file(id, "/"+id))
+	}
+	first := b.history[0].ID
+
+	sub := b.Subscribe(nil, first)
+	got := received(sub)
+	if len(got) != 2 || got[0].FileID != "2" || got[1].FileID != "3" {
+		t.Errorf("replay after %s = %v", first, got)
+	}
+	sub.Close()
+
+	sub = b.Subscribe(nil, got[1].ID)
+	if got := received(sub); len(got) != 0 {
+		t.Errorf("replay after the latest event = %v", types(got))
+	}
+	sub.Close()
+
+	// The event after first is evicted
+	b.QueueChange(ctx, regionsync.ChangeTypeCreate, file("4", "/4"))
+	b.QueueChange(ctx, regionsync.ChangeTypeCreate, file("5", "/5"))
+	for _, lastID := range []string{first, "other-1", "garbage"} {
+		sub := b.Subscribe(nil, lastID)
+		got := received(sub)
+		if !equalTypes(got, TypeReset) || got[0].ID != b.history[len(b.history)-1].ID {
+			t.Errorf("replay after %s = %v, want a reset", lastID, types(got))
+		}
+		sub.Close()
+	}
+}
+
+func TestBroker_DropsSlowSubscriber(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroker(BrokerConfig{BufferSize: 2})
+	slow := b.Subscribe(nil, "")
+
+	for _, id := range []string{"1", "2", "3"} {
+		b.QueueChange(ctx, regionsync.ChangeTypeCreate, file(id, "/"+id))
+	}
+
+	if got := received(slow); len(got) != 2 {
+		t.Errorf("events = %v, want the 2 buffered", types(got))
+	}
+	if _, ok := <-slow.Events(); ok || !slow.Dropped() {
+		t.Error("slow subscriber not dropped")
+	}
+	slow.Close()
+}
+
+func TestBroker_Close(t *testing.T) {
+	b := NewBroker(BrokerConfig{})
+	sub := b.Subscribe(nil, "")
+	b.Close()
+
+	if _, ok := <-sub.Events(); ok || sub.Dropped() {
+		t.Error("subscription not ended by Close")
+	}
+	if _, ok := <-b.Subscribe(nil, "").Events(); ok {
+		t.Error("subscription after Close not ended")
+	}
+}
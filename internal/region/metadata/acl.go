@@ -0,0 +1,154 @@
+// Package metadata defines access control lists for paths.
+package metadata
+
+import (
+	"strings"
+	"time"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// Permission is an operation an ACL grants.
+type Permission string
+
+const (
+	PermRead   Permission = "read"   // Download, stat and list
+	PermWrite  Permission = "write"  // Create, replace and update metadata
+	PermDelete Permission = "delete" // Delete files and directories
+	PermShare  Permission = "share"  // Change ACL entries
+	PermOwner  Permission = "owner"  // All of the above, and change the ACL owner
+)
+
+// ManagesACL reports whether perm is about changing ACLs rather than files.
+func (p Permission) ManagesACL() bool {
+	return p == PermShare || p == PermOwner
+}
+
+// Principal forms used in ACL entries.
+const (
+	PrincipalEveryone    = "*"
+	PrincipalUserPrefix  = "user:"
+	PrincipalGroupPrefix = "group:"
+)
+
+// ACLEntry grants permissions to a principal: "user:<id>", "group:<name>"
+// or "*" for everyone, including anonymous callers.
+type ACLEntry struct {
+	Principal   string       `json:"principal"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// ACL is the access control list of a file or directory path. ACLs are
+// attached to paths rather than file IDs, so an ACL on a file path also
+// applies to a file later created at the same path.
+//
+// ACLs are inherited down the directory tree: the permissions at a path
+// are the union of its own ACL and those of its ancestors, up to and
+// including the first ACL with NoInherit set.
+type ACL struct {
+	Path      string     `json:"path"`
+	Owner     string     `json:"owner,omitempty"` // User with all permissions
+	Entries   []ACLEntry `json:"entries"`
+	NoInherit bool       `json:"no_inherit,omitempty"` // Ignore ACLs of ancestors
+
+	// Versioning and sync, as for files
+	Version     int64             `json:"version"`
+	VectorClock map[string]uint64 `json:"vector_clock"`
+	SyncState   SyncState         `json:"sync_state"`
+	Deleted     bool              `json:"deleted,omitempty"` // Set on change events of removed ACLs
+	UpdatedAt   time.Time         `json:"updated_at"`
+	UpdatedBy   string            `json:"updated_by"`
+}
+
+// IncrementClock increments the vector clock for the given region.
+func (a *ACL) IncrementClock(regionID string) {
+	if a.VectorClock == nil {
+		a.VectorClock = make(map[string]uint64)
+	}
+	a.VectorClock[regionID]++
+	a.Version++
+	a.UpdatedAt = time.Now()
+}
+
+// Validate checks the principals and permissions of the entries.
+func (a *ACL) Validate() error {
+	for _, entry := range a.Entries {
+		p := entry.Principal
+		valid := p == PrincipalEveryone ||
+			(strings.HasPrefix(p, PrincipalUserPrefix) && len(p) > len(PrincipalUserPrefix)) ||
+			(strings.HasPrefix(p, PrincipalGroupPrefix) && len(p) > len(PrincipalGroupPrefix))
+		if !valid {
+			return errors.E("ACL.Validate", errors.ErrInvalidInput, nil, "invalid principal "+p)
+		}
+
+		for _, perm := range entry.Permissions {
+			switch perm {
+			case PermRead, PermWrite, PermDelete, PermShare, PermOwner:
+			default:
+				return errors.E("ACL.Validate", errors.ErrInvalidInput, nil, "invalid permission "+string(perm))
+			}
+		}
+	}
+	return nil
+}
+
+// Principal is a caller that ACL entries are matched against.
+type Principal struct {
+	UserID    string
+	Groups    []string
+	Anonymous bool // Only matched by "*" entries
+}
+
+// matches reports whether an entry applies to p.
+func (e *ACLEntry) matches(p *Principal) bool {
+	switch {
+	case e.Principal == PrincipalEveryone:
+		return true
+	case p.Anonymous:
+		return false
+	case strings.HasPrefix(e.Principal, PrincipalUserPrefix):
+		return strings.TrimPrefix(e.Principal, PrincipalUserPrefix) == p.UserID
+	case strings.HasPrefix(e.Principal, PrincipalGroupPrefix):
+		group := strings.TrimPrefix(e.Principal, PrincipalGroupPrefix)
+		for _, g := range p.Groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// grants reports whether the ACL itself grants perm to p.
+func (a *ACL) grants(p *Principal, perm Permission) bool {
+	if a.Owner != "" && !p.Anonymous && a.Owner == p.UserID {
+		return true
+	}
+	for i := range a.Entries {
+		entry := &a.Entries[i]
+		if !entry.matches(p) {
+			continue
+		}
+		for _, granted := range entry.Permissions {
+			if granted == perm || granted == PermOwner {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allowed reports whether an ACL chain, as returned by Store.ACLChain,
+// grants perm to p. Anonymous callers never manage ACLs, whatever the
+// "*" entries grant.
+func Allowed(chain []*ACL, p *Principal, perm Permission) bool {
+	if p.Anonymous && perm.ManagesACL() {
+		return false
+	}
+	for _, acl := range chain {
+		if acl.grants(p, perm) {
+			return true
+		}
+	}
+	return false
+}
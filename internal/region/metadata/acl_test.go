@@ -0,0 +1,77 @@
+package metadata
+
+import (
+	"testing"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+func TestACL_Validate(t *testing.T) {
+	valid := &ACL{Entries: []ACLEntry{
+		{Principal: "user:alice", Permissions: []Permission{PermRead, PermWrite}},
+		{Principal: "group:eng", Permissions: []Permission{PermShare}},
+		{Principal: PrincipalEveryone, Permissions: []Permission{PermRead}},
+	}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+
+	invalid := []ACLEntry{
+		{Principal: "alice", Permissions: []Permission{PermRead}},
+		{Principal: "user:", Permissions: []Permission{PermRead}},
+		{Principal: "group:eng", Permissions: []Permission{"execute"}},
+	}
+	for _, entry := range invalid {
+		acl := &ACL{Entries: []ACLEntry{entry}}
+		if err := acl.Validate(); !errors.IsInvalidInput(err) {
+			t.Errorf("Validate(%+v) error = %v, want invalid input", entry, err)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	chain := []*ACL{
+		{Path: "/eng/specs", Entries: []ACLEntry{
+			{Principal: "user:bob", Permissions: []Permission{PermWrite}},
+			{Principal: PrincipalEveryone, Permissions: []Permission{PermDelete}},
+		}},
+		{Path: "/eng", Owner: "carol", Entries: []ACLEntry{
+			{Principal: "group:eng", Permissions: []Permission{PermRead}},
+			{Principal: "user:dave", Permissions: []Permission{PermOwner}},
+			{Principal: PrincipalEveryone, Permissions: []Permission{PermShare}},
+		}},
+	}
+
+	alice := &Principal{UserID: "alice", Groups: []string{"eng"}}
+	bob := &Principal{UserID: "bob"}
+	carol := &Principal{UserID: "carol"}
+	dave := &Principal{UserID: "dave"}
+	anonymous := &Principal{UserID: "carol", Anonymous: true}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		perm      Permission
+		want      bool
+	}{
+		{"group grant", alice, PermRead, true},
+		{"group lacks write", alice, PermWrite, false},
+		{"user grant on nearer ACL", bob, PermWrite, true},
+		{"user lacks read", bob, PermRead, false},
+		{"ACL owner", carol, PermDelete, true},
+		{"owner permission", dave, PermDelete, true},
+		{"everyone entry", bob, PermShare, true},
+		{"anonymous not matched by user", anonymous, PermWrite, false},
+		{"anonymous matched by everyone", anonymous, PermDelete, true},
+		{"anonymous never shares", anonymous, PermShare, false},
+		{"anonymous never owns", anonymous, PermOwner, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Allowed(chain, tt.principal, tt.perm); got != tt.want {
+				t.Errorf("Allowed(%s, %s) = %v, want %v", tt.principal.UserID, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
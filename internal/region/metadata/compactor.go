@@ -0,0 +1,119 @@
+// Package metadata provides tombstone compaction for the local metadata store.
+package metadata
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"asisaid.cn/JzSE/internal/common/logger"
+	"go.uber.org/zap"
+)
+
+// CompactorConfig holds configuration for tombstone compaction.
+type CompactorConfig struct {
+	TombstoneTTL time.Duration // Minimum age of a tombstone before it may be purged
+	Interval     time.Duration // How often compaction runs
+}
+
+// Compactor periodically purges tombstones of deleted files.
+//
+// A tombstone is only purged once it is older than the TTL and its deletion
+// has been acknowledged as synced, so a lagging region cannot resurrect the
+// file by replaying an older version.
+type Compactor struct {
+	config CompactorConfig
+	store  Store
+	logger *zap.Logger
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewCompactor creates a new tombstone compactor.
+func NewCompactor(cfg CompactorConfig, store Store) *Compactor {
+	return &Compactor{
+		config: cfg,
+		store:  store,
+		logger: logger.WithComponent("Compactor"),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start starts periodic compaction.
+func (c *Compactor) Start(ctx context.Context) error {
+	c.logger.Info("starting tombstone compactor",
+		zap.Duration("tombstone_ttl", c.config.TombstoneTTL),
+		zap.Duration("interval", c.config.Interval),
+	)
+
+	if c.config.Interval <= 0 {
+		return nil
+	}
+
+	c.wg.Add(1)
+	go c.run(ctx)
+
+	return nil
+}
+
+// Stop stops the compactor.
+func (c *Compactor) Stop() {
+	c.logger.Info("stopping tombstone compactor")
+	close(c.stopCh)
+	c.wg.Wait()
+}
+
+// Compact purges all eligible tombstones and returns how many were purged.
+func (c *Compactor) Compact(ctx context.Context) (int, error) {
+	tombstones, err := c.store.ListTombstones(ctx, time.Now().Add(-c.config.TombstoneTTL), 0)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, meta := range tombstones {
+		if meta.SyncState != SyncStateSynced {
+			continue // Deletion not acknowledged yet
+		}
+
+		if err := c.store.Delete(ctx, meta.ID); err != nil {
+			c.logger.Warn("failed to purge tombstone",
+				zap.String("file_id", meta.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+		purged++
+	}
+
+	if purged > 0 {
+		c.logger.Info("tombstones purged",
+			zap.Int("purged", purged),
+			zap.Int("pending", len(tombstones)-purged),
+		)
+	}
+
+	return purged, nil
+}
+
+// run runs compaction on every interval tick.
+func (c *Compactor) run(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.Compact(ctx); err != nil {
+				c.logger.Error("compaction failed", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Package metadata provides the Prometheus instrumentation of metadata
+// stores.
+package metadata
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"asisaid.cn/JzSE/internal/common/metrics"
+)
+
+// InstrumentedStore records the latency and errors of the operations of a
+// Store.
+type InstrumentedStore struct {
+	store      Store
+	operations *metrics.Operations
+}
+
+var _ Store = (*InstrumentedStore)(nil)
+
+// NewInstrumentedStore wraps a store and registers its metrics. The sizes
+// of a BadgerStore are reported as well.
+func NewInstrumentedStore(store Store, reg prometheus.Registerer) (*InstrumentedStore, error) {
+	operations, err := metrics.NewOperations(reg, "metadata", "metadata store")
+	if err != nil {
+		return nil, err
+	}
+
+	if statter, ok := store.(interface{ Stats() StoreStats }); ok {
+		err := metrics.Register(reg,
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace: metrics.Namespace,
+				Subsystem: "metadata",
+				Name:      "lsm_size_bytes",
+				Help:      "Size of the LSM tree of the metadata store.",
+			}, func() float64 { return float64(statter.Stats().LSMSize) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace: metrics.Namespace,
+				Subsystem: "metadata",
+				Name:      "vlog_size_bytes",
+				Help:      "Size of the value log of the metadata store.",
+			}, func() float64 { return float64(statter.Stats().VLogSize) }),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &InstrumentedStore{store: store, operations: operations}, nil
+}
+
+// Get retrieves file metadata by ID.
+func (s *InstrumentedStore) Get(ctx context.Context, fileID string) (meta *FileMetadata, err error) {
+	defer s.observe("get", time.Now(), &err)
+	return s.store.Get(ctx, fileID)
+}
+
+// GetByPath retrieves file metadata by path.
+func (s *InstrumentedStore) GetByPath(ctx context.Context, path string) (meta *FileMetadata, err error) {
+	defer s.observe("get_by_path", time.Now(), &err)
+	return s.store.GetByPath(ctx, path)
+}
+
+// GetBatch retrieves several files in a single transaction.
+func (s *InstrumentedStore) GetBatch(ctx context.Context, keys []FileKey) (metas []*FileMetadata, err error) {
+	defer s.observe("get_batch", time.Now(), &err)
+	return s.store.GetBatch(ctx, keys)
+}
+
+// Save saves or updates file metadata.
+func (s *InstrumentedStore) Save(ctx context.Context, meta *FileMetadata) (err error) {
+	defer s.observe("save", time.Now(), &err)
+	return s.store.Save(ctx, meta)
+}
+
+// SaveBatch saves or updates several files in a single transaction.
+func (s *InstrumentedStore) SaveBatch(ctx context.Context, metas []*FileMetadata) (err error) {
+	defer s.observe("save_batch", time.Now(), &err)
+	return s.store.SaveBatch(ctx, metas)
+}
+
+// Delete removes file metadata.
+func (s *InstrumentedStore) Delete(ctx context.Context, fileID string) (err error) {
+	defer s.observe("delete", time.Now(), &err)
+	return s.store.Delete(ctx, fileID)
+}
+
+// List lists files and subdirectories in a directory.
+func (s *InstrumentedStore) List(ctx context.Context, dirPath string) (entries []*DirectoryEntry, err error) {
+	defer s.observe("list", time.Now(), &err)
+	return s.store.List(ctx, dirPath)
+}
+
+// IsDir reports whether a directory exists at the given path.
+func (s *InstrumentedStore) IsDir(ctx context.Context, dirPath string) (isDir bool, err error) {
+	defer s.observe("is_dir", time.Now(), &err)
+	return s.store.IsDir(ctx, dirPath)
+}
+
+// MkdirAll creates a directory and all of its ancestors.
+func (s *InstrumentedStore) MkdirAll(ctx context.Context, dirPath string) (err error) {
+	defer s.observe("mkdir_all", time.Now(), &err)
+	return s.store.MkdirAll(ctx, dirPath)
+}
+
+// DeleteDir removes an empty directory.
+func (s *InstrumentedStore) DeleteDir(ctx context.Context, dirPath string) (err error) {
+	defer s.observe("delete_dir", time.Now(), &err)
+	return s.store.DeleteDir(ctx, dirPath)
+}
+
+// GetACL retrieves the ACL attached to a path.
+func (s *InstrumentedStore) GetACL(ctx context.Context, path string) (acl *ACL, err error) {
+	defer s.observe("get_acl", time.Now(), &err)
+	return s.store.GetACL(ctx, path)
+}
+
+// ACLChain returns the ACLs that apply to a path, nearest first.
+func (s *InstrumentedStore) ACLChain(ctx context.Context, path string) (acls []*ACL, err error) {
+	defer s.observe("acl_chain", time.Now(), &err)
+	return s.store.ACLChain(ctx, path)
+}
+
+// SaveACL saves or replaces the ACL of a path.
+func (s *InstrumentedStore) SaveACL(ctx context.Context, acl *ACL) (err error) {
+	defer s.observe("save_acl", time.Now(), &err)
+	return s.store.SaveACL(ctx, acl)
+}
+
+// DeleteACL removes the ACL of a path.
+func (s *InstrumentedStore) DeleteACL(ctx context.Context, path string) (err error) {
+	defer s.observe("delete_acl", time.Now(), &err)
+	return s.store.DeleteACL(ctx, path)
+}
+
+// ListByState lists files by sync state.
+func (s *InstrumentedStore) ListByState(ctx context.Context, state SyncState, limit int) (metas []*FileMetadata, err error) {
+	defer s.observe("list_by_state", time.Now(), &err)
+	return s.store.ListByState(ctx, state, limit)
+}
+
+// CountByState counts files by sync state.
+func (s *InstrumentedStore) CountByState(ctx context.Context) (counts map[SyncState]int, err error) {
+	defer s.observe("count_by_state", time.Now(), &err)
+	return s.store.CountByState(ctx)
+}
+
+// ListTombstones lists deleted files whose deletion happened before the given time.
+func (s *InstrumentedStore) ListTombstones(ctx context.Context, before time.Time, limit int) (metas []*FileMetadata, err error) {
+	defer s.observe("list_tombstones", time.Now(), &err)
+	return s.store.ListTombstones(ctx, before, limit)
+}
+
+// Close closes the store.
+func (s *InstrumentedStore) Close() error {
+	return s.store.Close()
+}
+
+func (s *InstrumentedStore) observe(operation string, start time.Time, err *error) {
+	s.operations.Observe(operation, start, *err)
+}
@@ -2,6 +2,7 @@
 package metadata
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -15,6 +16,9 @@ type FileMetadata struct {
 	ContentHash string `json:"content_hash"` // SHA-256 hash of content
 	MimeType    string `json:"mime_type"`    // MIME type
 
+	// Client-supplied digests verified on upload, hex encoded by algorithm
+	Digests map[string]string `json:"digests,omitempty"`
+
 	// Versioning
 	Version     int64             `json:"version"`      // Version number
 	VectorClock map[string]uint64 `json:"vector_clock"` // Vector clock for conflict detection
@@ -33,6 +37,9 @@ type FileMetadata struct {
 
 	// Custom metadata
 	CustomMeta map[string]string `json:"custom_meta,omitempty"`
+
+	// Outcome of the pre-commit hooks on the current content, if any ran
+	Verdict *Verdict `json:"verdict,omitempty"`
 }
 
 // LocalState represents the local storage state of a file.
@@ -42,6 +49,9 @@ const (
 	LocalStatePresent LocalState = "present" // File exists locally
 	LocalStatePending LocalState = "pending" // Waiting to be downloaded
 	LocalStateDeleted LocalState = "deleted" // Deleted (tombstone)
+
+	// Withheld by a pre-commit hook: stored, but neither served nor synced
+	LocalStateQuarantined LocalState = "quarantined"
 )
 
 // SyncState represents the synchronization state of a file.
@@ -53,6 +63,23 @@ const (
 	SyncStateConflict SyncState = "conflict" // Conflict detected
 )
 
+// VerdictAction is the decision of a pre-commit hook on uploaded content.
+type VerdictAction string
+
+const (
+	VerdictAccept     VerdictAction = "accept"
+	VerdictReject     VerdictAction = "reject"     // Not committed
+	VerdictQuarantine VerdictAction = "quarantine" // Committed as quarantined
+)
+
+// Verdict records the pre-commit hook decision on a version of a file.
+type Verdict struct {
+	Action    VerdictAction `json:"action"`
+	Hook      string        `json:"hook,omitempty"` // Hook that decided; empty if all accepted
+	Reason    string        `json:"reason,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
 // NewFileMetadata creates a new FileMetadata with default values.
 func NewFileMetadata(id, name, path string) *FileMetadata {
 	now := time.Now()
@@ -70,6 +97,11 @@ func NewFileMetadata(id, name, path string) *FileMetadata {
 	}
 }
 
+// ETag returns a strong entity tag identifying this version of the file content.
+func (m *FileMetadata) ETag() string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%s-%d", m.ContentHash, m.Version))
+}
+
 // IncrementClock increments the vector clock for the given region.
 func (m *FileMetadata) IncrementClock(regionID string) {
 	if m.VectorClock == nil {
@@ -150,6 +182,7 @@ func (m *FileMetadata) CompareClock(other map[string]uint64) ClockRelation {
 
 // DirectoryEntry represents an entry in a directory listing.
 type DirectoryEntry struct {
+	ID        string    `json:"id,omitempty"` // File ID, empty for directories
 	Name      string    `json:"name"`
 	Path      string    `json:"path"`
 	IsDir     bool      `json:"is_dir"`
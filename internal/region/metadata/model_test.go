@@ -53,6 +53,21 @@ func TestFileMetadata_IncrementClock(t *testing.T) {
 	}
 }
 
+func TestFileMetadata_ETag(t *testing.T) {
+	meta := NewFileMetadata("id", "name", "/path")
+	meta.ContentHash = "abc"
+
+	if got := meta.ETag(); got != `"abc-1"` {
+		t.Errorf("ETag() = %v, want %v", got, `"abc-1"`)
+	}
+
+	before := meta.ETag()
+	meta.IncrementClock("region-a")
+	if meta.ETag() == before {
+		t.Error("ETag should change with the version")
+	}
+}
+
 func TestFileMetadata_MergeClock(t *testing.T) {
 	meta := NewFileMetadata("id", "name", "/path")
 	meta.VectorClock["region-a"] = 2
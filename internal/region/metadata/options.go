@@ -0,0 +1,98 @@
+// Package metadata provides BadgerDB tuning for the local metadata store.
+package metadata
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/options"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/logger"
+)
+
+// StoreConfig holds configuration for the BadgerDB metadata store.
+// Zero values keep Badger's defaults.
+type StoreConfig struct {
+	Path           string
+	BlockCacheSize int64
+	IndexCacheSize int64
+	MemTableSize   int64
+	ValueThreshold int64
+	SyncWrites     bool
+	Compression    string // none, snappy, zstd
+
+	// Value log garbage collection
+	GCInterval     time.Duration // Disabled when zero
+	GCDiscardRatio float64
+}
+
+// Default value log GC discard ratio, as recommended by Badger.
+const defaultGCDiscardRatio = 0.5
+
+// badgerOptions converts a StoreConfig into Badger options.
+func badgerOptions(cfg StoreConfig) (badger.Options, error) {
+	opts := badger.DefaultOptions(cfg.Path).
+		WithLogger(newBadgerLogger()).
+		WithSyncWrites(cfg.SyncWrites)
+
+	if cfg.BlockCacheSize > 0 {
+		opts = opts.WithBlockCacheSize(cfg.BlockCacheSize)
+	}
+	if cfg.IndexCacheSize > 0 {
+		opts = opts.WithIndexCacheSize(cfg.IndexCacheSize)
+	}
+	if cfg.MemTableSize > 0 {
+		opts = opts.WithMemTableSize(cfg.MemTableSize)
+	}
+	if cfg.ValueThreshold > 0 {
+		opts = opts.WithValueThreshold(cfg.ValueThreshold)
+	}
+
+	switch strings.ToLower(cfg.Compression) {
+	case "":
+		// Keep default
+	case "none":
+		opts = opts.WithCompression(options.None)
+	case "snappy":
+		opts = opts.WithCompression(options.Snappy)
+	case "zstd":
+		opts = opts.WithCompression(options.ZSTD)
+	default:
+		return opts, fmt.Errorf("unknown compression %q", cfg.Compression)
+	}
+
+	return opts, nil
+}
+
+// badgerLogger routes Badger's log output into zap.
+type badgerLogger struct {
+	logger *zap.SugaredLogger
+}
+
+func newBadgerLogger() *badgerLogger {
+	return &badgerLogger{
+		logger: logger.WithComponent("BadgerDB").WithOptions(zap.AddCallerSkip(1)).Sugar(),
+	}
+}
+
+func (l *badgerLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Errorf(strings.TrimSuffix(format, "\n"), args...)
+}
+
+func (l *badgerLogger) Warningf(format string, args ...interface{}) {
+	l.logger.Warnf(strings.TrimSuffix(format, "\n"), args...)
+}
+
+func (l *badgerLogger) Infof(format string, args ...interface{}) {
+	l.logger.Infof(strings.TrimSuffix(format, "\n"), args...)
+}
+
+func (l *badgerLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Debugf(strings.TrimSuffix(format, "\n"), args...)
+}
+
+// Ensure badgerLogger implements badger.Logger
+var _ badger.Logger = (*badgerLogger)(nil)
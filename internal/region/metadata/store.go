@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"go.uber.org/zap"
 
 	"asisaid.cn/JzSE/internal/common/errors"
 	"asisaid.cn/JzSE/internal/common/logger"
@@ -22,67 +25,123 @@ type Store interface {
 	// GetByPath retrieves file metadata by path.
 	GetByPath(ctx context.Context, path string) (*FileMetadata, error)
 
+	// GetBatch retrieves several files in a single transaction.
+	GetBatch(ctx context.Context, keys []FileKey) ([]*FileMetadata, error)
+
 	// Save saves or updates file metadata.
 	Save(ctx context.Context, meta *FileMetadata) error
 
+	// SaveBatch saves or updates several files in a single transaction.
+	SaveBatch(ctx context.Context, metas []*FileMetadata) error
+
 	// Delete removes file metadata.
 	Delete(ctx context.Context, fileID string) error
 
-	// List lists files in a directory.
+	// List lists files and subdirectories in a directory.
 	List(ctx context.Context, dirPath string) ([]*DirectoryEntry, error)
 
+	// IsDir reports whether a directory exists at the given path.
+	IsDir(ctx context.Context, dirPath string) (bool, error)
+
+	// MkdirAll creates a directory and all of its ancestors.
+	MkdirAll(ctx context.Context, dirPath string) error
+
+	// DeleteDir removes an empty directory.
+	DeleteDir(ctx context.Context, dirPath string) error
+
+	// GetACL retrieves the ACL attached to a path.
+	GetACL(ctx context.Context, path string) (*ACL, error)
+
+	// ACLChain returns the ACLs that apply to a path, nearest first.
+	ACLChain(ctx context.Context, path string) ([]*ACL, error)
+
+	// SaveACL saves or replaces the ACL of a path.
+	SaveACL(ctx context.Context, acl *ACL) error
+
+	// DeleteACL removes the ACL of a path.
+	DeleteACL(ctx context.Context, path string) error
+
 	// ListByState lists files by sync state.
 	ListByState(ctx context.Context, state SyncState, limit int) ([]*FileMetadata, error)
 
+	// CountByState counts files, tombstones included, by sync state.
+	CountByState(ctx context.Context) (map[SyncState]int, error)
+
+	// ListTombstones lists deleted files whose deletion happened before the given time.
+	ListTombstones(ctx context.Context, before time.Time, limit int) ([]*FileMetadata, error)
+
 	// Close closes the store.
 	Close() error
 }
 
 // BadgerStore implements Store using BadgerDB.
 type BadgerStore struct {
-	db *badger.DB
+	db     *badger.DB
+	config StoreConfig
+
+	gcMu    sync.Mutex // Serializes value log GC runs
+	statsMu sync.Mutex
+	gcStats GCStats
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
 }
 
 // Key prefixes for different indexes.
 const (
-	prefixFile      = "files:"     // files:<file_id> -> metadata
-	prefixPath      = "paths:"     // paths:<path_hash> -> file_id
-	prefixDir       = "dirs:"      // dirs:<parent_hash>:<name> -> file_id
-	prefixSyncState = "syncstate:" // syncstate:<state>:<updated_at>:<file_id> -> ""
+	prefixFile      = "files:"      // files:<file_id> -> metadata
+	prefixPath      = "paths:"      // paths:<path_hash> -> file_id
+	prefixDir       = "dirs:"       // dirs:<parent_hash>:<name> -> file_id
+	prefixSubdir    = "subdirs:"    // subdirs:<parent_hash>:<name> -> created_at
+	prefixSyncState = "syncstate:"  // syncstate:<state>:<updated_at>:<file_id> -> ""
+	prefixTombstone = "tombstones:" // tombstones:<deleted_at>:<file_id> -> ""
+	prefixACL       = "acls:"       // acls:<path_hash> -> acl
 )
 
 // NewBadgerStore creates a new BadgerStore.
-func NewBadgerStore(dbPath string) (*BadgerStore, error) {
-	opts := badger.DefaultOptions(dbPath)
-	opts.Logger = nil // Disable badger's default logger
+func NewBadgerStore(cfg StoreConfig) (*BadgerStore, error) {
+	opts, err := badgerOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid badger options: %w", err)
+	}
 
 	db, err := badger.Open(opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open badger db: %w", err)
 	}
 
-	logger.L().Info("BadgerDB opened")
+	logger.L().Info("BadgerDB opened",
+		zap.String("path", cfg.Path),
+		zap.Int64("block_cache_size", opts.BlockCacheSize),
+		zap.Int64("index_cache_size", opts.IndexCacheSize),
+		zap.Int64("memtable_size", opts.MemTableSize),
+		zap.Int64("value_threshold", opts.ValueThreshold),
+		zap.Bool("sync_writes", opts.SyncWrites),
+		zap.Duration("gc_interval", cfg.GCInterval),
+	)
+
+	s := &BadgerStore{
+		db:     db,
+		config: cfg,
+		stopCh: make(chan struct{}),
+	}
+
+	if cfg.GCInterval > 0 {
+		s.wg.Add(1)
+		go s.runGC()
+	}
 
-	return &BadgerStore{db: db}, nil
+	return s, nil
 }
 
 // Get retrieves file metadata by ID.
 func (s *BadgerStore) Get(ctx context.Context, fileID string) (*FileMetadata, error) {
-	var meta FileMetadata
+	var meta *FileMetadata
 
 	err := s.db.View(func(txn *badger.Txn) error {
-		key := []byte(prefixFile + fileID)
-		item, err := txn.Get(key)
-		if err == badger.ErrKeyNotFound {
-			return errors.ErrNotFound
-		}
-		if err != nil {
-			return err
-		}
-
-		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &meta)
-		})
+		var err error
+		meta, err = getMeta(txn, fileID)
+		return err
 	})
 
 	if err != nil {
@@ -92,10 +151,11 @@ func (s *BadgerStore) Get(ctx context.Context, fileID string) (*FileMetadata, er
 		return nil, errors.E("BadgerStore.Get", errors.ErrNotFound, err)
 	}
 
-	return &meta, nil
+	return meta, nil
 }
 
 // GetByPath retrieves file metadata by path.
+// Deleted files (tombstones) are not visible by path.
 func (s *BadgerStore) GetByPath(ctx context.Context, path string) (*FileMetadata, error) {
 	var fileID string
 
@@ -119,89 +179,145 @@ func (s *BadgerStore) GetByPath(ctx context.Context, path string) (*FileMetadata
 		return nil, err
 	}
 
-	return s.Get(ctx, fileID)
-}
-
-// Save saves or updates file metadata.
-func (s *BadgerStore) Save(ctx context.Context, meta *FileMetadata) error {
-	data, err := json.Marshal(meta)
+	meta, err := s.Get(ctx, fileID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
+		return nil, err
+	}
+	if meta.LocalState == LocalStateDeleted {
+		return nil, errors.ErrNotFound
 	}
 
-	return s.db.Update(func(txn *badger.Txn) error {
-		// Save main record
-		fileKey := []byte(prefixFile + meta.ID)
-		if err := txn.Set(fileKey, data); err != nil {
-			return err
-		}
+	return meta, nil
+}
 
-		// Save path index
-		pathKey := []byte(prefixPath + hashPath(meta.Path))
-		if err := txn.Set(pathKey, []byte(meta.ID)); err != nil {
-			return err
-		}
+// FileKey identifies a file by ID, or by path if the ID is empty.
+type FileKey struct {
+	ID   string
+	Path string
+}
 
-		// Save directory index
-		parentPath := filepath.Dir(meta.Path)
-		dirKey := []byte(prefixDir + hashPath(parentPath) + ":" + meta.Name)
-		if err := txn.Set(dirKey, []byte(meta.ID)); err != nil {
-			return err
-		}
+// GetBatch retrieves several files in a single transaction. The result
+// has one element per key, nil where no file exists. As with Get and
+// GetByPath, deleted files are returned by ID but not by path.
+func (s *BadgerStore) GetBatch(ctx context.Context, keys []FileKey) ([]*FileMetadata, error) {
+	metas := make([]*FileMetadata, len(keys))
 
-		// Save sync state index
-		syncKey := []byte(fmt.Sprintf("%s%s:%s:%s",
-			prefixSyncState,
-			meta.SyncState,
-			meta.UpdatedAt.Format("20060102150405"),
-			meta.ID,
-		))
-		if err := txn.Set(syncKey, nil); err != nil {
-			return err
-		}
+	err := s.db.View(func(txn *badger.Txn) error {
+		for i, key := range keys {
+			fileID := key.ID
+			if fileID == "" {
+				item, err := txn.Get([]byte(prefixPath + hashPath(key.Path)))
+				if err == badger.ErrKeyNotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				fileID = string(value)
+			}
 
+			meta, err := getMeta(txn, fileID)
+			if errors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if key.ID == "" && meta.LocalState == LocalStateDeleted {
+				continue
+			}
+			metas[i] = meta
+		}
 		return nil
 	})
-}
-
-// Delete removes file metadata.
-func (s *BadgerStore) Delete(ctx context.Context, fileID string) error {
-	// First get the metadata to remove indexes
-	meta, err := s.Get(ctx, fileID)
 	if err != nil {
-		return err
+		return nil, errors.E("BadgerStore.GetBatch", errors.ErrInvalidMetadata, err)
 	}
 
+	return metas, nil
+}
+
+// Save saves or updates file metadata.
+func (s *BadgerStore) Save(ctx context.Context, meta *FileMetadata) error {
 	return s.db.Update(func(txn *badger.Txn) error {
-		// Delete main record
-		fileKey := []byte(prefixFile + fileID)
-		if err := txn.Delete(fileKey); err != nil {
-			return err
+		return saveMeta(txn, meta)
+	})
+}
+
+// SaveBatch saves or updates several files in a single transaction,
+// so either all of them become visible or none does.
+func (s *BadgerStore) SaveBatch(ctx context.Context, metas []*FileMetadata) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		for _, meta := range metas {
+			if err := saveMeta(txn, meta); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err == badger.ErrTxnTooBig {
+		return errors.E("BadgerStore.SaveBatch", errors.ErrTooLarge, err, fmt.Sprintf("%d files", len(metas)))
+	}
+	return err
+}
 
-		// Delete path index
-		pathKey := []byte(prefixPath + hashPath(meta.Path))
-		if err := txn.Delete(pathKey); err != nil && err != badger.ErrKeyNotFound {
+// Delete removes file metadata.
+func (s *BadgerStore) Delete(ctx context.Context, fileID string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		meta, err := getMeta(txn, fileID)
+		if err != nil {
 			return err
 		}
 
-		// Delete directory index
-		parentPath := filepath.Dir(meta.Path)
-		dirKey := []byte(prefixDir + hashPath(parentPath) + ":" + meta.Name)
-		if err := txn.Delete(dirKey); err != nil && err != badger.ErrKeyNotFound {
+		// Delete main record
+		fileKey := []byte(prefixFile + fileID)
+		if err := txn.Delete(fileKey); err != nil {
 			return err
 		}
 
-		return nil
+		return deleteIndexes(txn, meta)
 	})
 }
 
-// List lists files in a directory.
+// List lists files and subdirectories in a directory. Quarantined files
+// are left out.
 func (s *BadgerStore) List(ctx context.Context, dirPath string) ([]*DirectoryEntry, error) {
 	var entries []*DirectoryEntry
 	prefix := []byte(prefixDir + hashPath(dirPath) + ":")
+	subdirPrefix := []byte(prefixSubdir + hashPath(dirPath) + ":")
 
 	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = subdirPrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(subdirPrefix); it.ValidForPrefix(subdirPrefix); it.Next() {
+			name := string(it.Item().Key()[len(subdirPrefix):])
+			var createdAt time.Time
+			_ = it.Item().Value(func(val []byte) error {
+				return createdAt.UnmarshalText(val)
+			})
+
+			entries = append(entries, &DirectoryEntry{
+				Name:      name,
+				Path:      filepath.Join(dirPath, name),
+				IsDir:     true,
+				UpdatedAt: createdAt,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.Prefix = prefix
 		it := txn.NewIterator(opts)
@@ -220,11 +336,12 @@ func (s *BadgerStore) List(ctx context.Context, dirPath string) ([]*DirectoryEnt
 
 			// Get file metadata
 			meta, err := s.Get(ctx, fileID)
-			if err != nil {
+			if err != nil || meta.LocalState == LocalStateDeleted || meta.LocalState == LocalStateQuarantined {
 				continue
 			}
 
 			entries = append(entries, &DirectoryEntry{
+				ID:        meta.ID,
 				Name:      meta.Name,
 				Path:      meta.Path,
 				IsDir:     false,
@@ -243,6 +360,131 @@ func (s *BadgerStore) List(ctx context.Context, dirPath string) ([]*DirectoryEnt
 	return entries, nil
 }
 
+// IsDir reports whether a directory exists at the given path.
+// The root directory always exists.
+func (s *BadgerStore) IsDir(ctx context.Context, dirPath string) (bool, error) {
+	if dirPath == "/" {
+		return true, nil
+	}
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(subdirKey(dirPath))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// MkdirAll creates a directory and all of its ancestors.
+func (s *BadgerStore) MkdirAll(ctx context.Context, dirPath string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return ensureDirs(txn, dirPath, time.Now())
+	})
+}
+
+// DeleteDir removes an empty directory.
+func (s *BadgerStore) DeleteDir(ctx context.Context, dirPath string) error {
+	if dirPath == "/" {
+		return errors.E("BadgerStore.DeleteDir", errors.ErrInvalidInput, nil, "cannot delete root directory")
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := subdirKey(dirPath)
+		if _, err := txn.Get(key); err == badger.ErrKeyNotFound {
+			return errors.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		for _, prefix := range []string{prefixDir, prefixSubdir} {
+			if hasPrefix(txn, []byte(prefix+hashPath(dirPath)+":")) {
+				return errors.E("BadgerStore.DeleteDir", errors.ErrConflict, nil, "directory not empty")
+			}
+		}
+
+		return txn.Delete(key)
+	})
+}
+
+// GetACL retrieves the ACL attached to a path.
+func (s *BadgerStore) GetACL(ctx context.Context, path string) (*ACL, error) {
+	var acl *ACL
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		acl, err = getACL(txn, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if acl == nil {
+		return nil, errors.E("BadgerStore.GetACL", errors.ErrNotFound, nil, "no ACL on "+path)
+	}
+
+	return acl, nil
+}
+
+// ACLChain returns the ACLs of a path and its ancestors, nearest first,
+// up to and including the first ACL that does not inherit.
+func (s *BadgerStore) ACLChain(ctx context.Context, path string) ([]*ACL, error) {
+	var chain []*ACL
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		for dir := path; ; dir = filepath.Dir(dir) {
+			acl, err := getACL(txn, dir)
+			if err != nil {
+				return err
+			}
+			if acl != nil {
+				chain = append(chain, acl)
+				if acl.NoInherit {
+					return nil
+				}
+			}
+			if dir == "/" || dir == "." {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, errors.E("BadgerStore.ACLChain", errors.ErrInvalidMetadata, err)
+	}
+
+	return chain, nil
+}
+
+// SaveACL saves or replaces the ACL of a path.
+func (s *BadgerStore) SaveACL(ctx context.Context, acl *ACL) error {
+	data, err := json.Marshal(acl)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ACL: %w", err)
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(prefixACL+hashPath(acl.Path)), data)
+	})
+}
+
+// DeleteACL removes the ACL of a path.
+func (s *BadgerStore) DeleteACL(ctx context.Context, path string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := []byte(prefixACL + hashPath(path))
+		if _, err := txn.Get(key); err == badger.ErrKeyNotFound {
+			return errors.E("BadgerStore.DeleteACL", errors.ErrNotFound, nil, "no ACL on "+path)
+		} else if err != nil {
+			return err
+		}
+		return txn.Delete(key)
+	})
+}
+
 // ListByState lists files by sync state.
 func (s *BadgerStore) ListByState(ctx context.Context, state SyncState, limit int) ([]*FileMetadata, error) {
 	var result []*FileMetadata
@@ -282,11 +524,291 @@ func (s *BadgerStore) ListByState(ctx context.Context, state SyncState, limit in
 	return result, nil
 }
 
+// CountByState counts files, tombstones included, by sync state. Only the
+// sync state index is read.
+func (s *BadgerStore) CountByState(ctx context.Context) (map[SyncState]int, error) {
+	counts := make(map[SyncState]int)
+	prefix := []byte(prefixSyncState)
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			state, _, ok := strings.Cut(strings.TrimPrefix(string(it.Item().Key()), prefixSyncState), ":")
+			if ok {
+				counts[SyncState(state)]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap("BadgerStore.CountByState", err)
+	}
+
+	return counts, nil
+}
+
+// ListTombstones lists deleted files whose deletion happened before the given time.
+// Results are ordered from the oldest deletion.
+func (s *BadgerStore) ListTombstones(ctx context.Context, before time.Time, limit int) ([]*FileMetadata, error) {
+	var result []*FileMetadata
+	prefix := []byte(prefixTombstone)
+	cutoff := tombstoneTime(before)
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix) && (limit <= 0 || len(result) < limit); it.Next() {
+			key := strings.TrimPrefix(string(it.Item().Key()), prefixTombstone)
+			parts := strings.SplitN(key, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if parts[0] >= cutoff {
+				break
+			}
+
+			meta, err := getMeta(txn, parts[1])
+			if err != nil {
+				continue
+			}
+
+			result = append(result, meta)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // Close closes the store.
 func (s *BadgerStore) Close() error {
+	close(s.stopCh)
+	s.wg.Wait()
 	return s.db.Close()
 }
 
+// getMeta reads the main record of a file within a transaction.
+func getMeta(txn *badger.Txn, fileID string) (*FileMetadata, error) {
+	item, err := txn.Get([]byte(prefixFile + fileID))
+	if err == badger.ErrKeyNotFound {
+		return nil, errors.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta FileMetadata
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &meta)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// getACL reads the ACL of a path within a transaction, nil if there is none.
+func getACL(txn *badger.Txn, path string) (*ACL, error) {
+	item, err := txn.Get([]byte(prefixACL + hashPath(path)))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var acl ACL
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &acl)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &acl, nil
+}
+
+// saveMeta writes the main record of a file and replaces its indexes.
+func saveMeta(txn *badger.Txn, meta *FileMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	// Drop the indexes of the previous revision
+	prev, err := getMeta(txn, meta.ID)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if prev != nil {
+		if err := deleteIndexes(txn, prev); err != nil {
+			return err
+		}
+	}
+
+	// Save main record
+	fileKey := []byte(prefixFile + meta.ID)
+	if err := txn.Set(fileKey, data); err != nil {
+		return err
+	}
+
+	return setIndexes(txn, meta)
+}
+
+// setIndexes writes the secondary indexes of a file.
+// Tombstones are kept out of the path and directory indexes so the path
+// can be reused, and are tracked in the tombstone index instead.
+func setIndexes(txn *badger.Txn, meta *FileMetadata) error {
+	if meta.LocalState == LocalStateDeleted {
+		if err := txn.Set(tombstoneKey(meta), nil); err != nil {
+			return err
+		}
+	} else {
+		// Save path index
+		if err := txn.Set(pathKey(meta), []byte(meta.ID)); err != nil {
+			return err
+		}
+
+		// Save directory index
+		if err := txn.Set(dirKey(meta), []byte(meta.ID)); err != nil {
+			return err
+		}
+
+		// Make sure all parent directories exist
+		if err := ensureDirs(txn, filepath.Dir(meta.Path), meta.UpdatedAt); err != nil {
+			return err
+		}
+	}
+
+	// Save sync state index
+	return txn.Set(syncStateKey(meta), nil)
+}
+
+// deleteIndexes removes the secondary indexes of a file.
+// Path and directory entries are only removed while they still point at
+// this file, since another file may have taken over the path meanwhile.
+func deleteIndexes(txn *badger.Txn, meta *FileMetadata) error {
+	for _, key := range [][]byte{pathKey(meta), dirKey(meta)} {
+		if err := deleteIfOwned(txn, key, meta.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range [][]byte{syncStateKey(meta), tombstoneKey(meta)} {
+		if err := txn.Delete(key); err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteIfOwned deletes an index key if its value is the given file ID.
+func deleteIfOwned(txn *badger.Txn, key []byte, fileID string) error {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	owned := false
+	err = item.Value(func(val []byte) error {
+		owned = string(val) == fileID
+		return nil
+	})
+	if err != nil || !owned {
+		return err
+	}
+
+	return txn.Delete(key)
+}
+
+// ensureDirs creates directory records for a path and all of its ancestors.
+func ensureDirs(txn *badger.Txn, dirPath string, createdAt time.Time) error {
+	for dir := dirPath; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		key := subdirKey(dir)
+		_, err := txn.Get(key)
+		if err == nil {
+			return nil // Ancestors exist as well
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		value, err := createdAt.MarshalText()
+		if err != nil {
+			return err
+		}
+		if err := txn.Set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasPrefix reports whether any key starts with the given prefix.
+func hasPrefix(txn *badger.Txn, prefix []byte) bool {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	it.Seek(prefix)
+	return it.ValidForPrefix(prefix)
+}
+
+func subdirKey(dirPath string) []byte {
+	return []byte(prefixSubdir + hashPath(filepath.Dir(dirPath)) + ":" + filepath.Base(dirPath))
+}
+
+func pathKey(meta *FileMetadata) []byte {
+	return []byte(prefixPath + hashPath(meta.Path))
+}
+
+func dirKey(meta *FileMetadata) []byte {
+	return []byte(prefixDir + hashPath(filepath.Dir(meta.Path)) + ":" + meta.Name)
+}
+
+func syncStateKey(meta *FileMetadata) []byte {
+	return []byte(fmt.Sprintf("%s%s:%s:%s",
+		prefixSyncState,
+		meta.SyncState,
+		meta.UpdatedAt.Format("20060102150405"),
+		meta.ID,
+	))
+}
+
+func tombstoneKey(meta *FileMetadata) []byte {
+	return []byte(prefixTombstone + tombstoneTime(meta.UpdatedAt) + ":" + meta.ID)
+}
+
+// tombstoneTime formats a time so that tombstone keys sort chronologically.
+func tombstoneTime(t time.Time) string {
+	nanos := t.UnixNano()
+	if nanos < 0 {
+		nanos = 0
+	}
+	return fmt.Sprintf("%020d", nanos)
+}
+
 // hashPath creates a simple hash of a path for indexing.
 func hashPath(path string) string {
 	// Simple implementation - in production, use a proper hash
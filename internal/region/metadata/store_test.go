@@ -0,0 +1,430 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+func newTestStore(t *testing.T) *BadgerStore {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "jzse-metadata-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	store, err := NewBadgerStore(StoreConfig{Path: tmpDir})
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	t.Cleanup(func() {
+		store.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	return store
+}
+
+func TestBadgerStore_Tombstones(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	meta := NewFileMetadata("file-1", "report.docx", "/docs/report.docx")
+	if err := store.Save(ctx, meta); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	meta.LocalState = LocalStateDeleted
+	meta.IncrementClock("region-a")
+	if err := store.Save(ctx, meta); err != nil {
+		t.Fatalf("Save tombstone failed: %v", err)
+	}
+
+	t.Run("Get still returns tombstone", func(t *testing.T) {
+		got, err := store.Get(ctx, "file-1")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got.LocalState != LocalStateDeleted {
+			t.Errorf("LocalState = %v, want deleted", got.LocalState)
+		}
+	})
+
+	t.Run("GetByPath hides tombstone", func(t *testing.T) {
+		_, err := store.GetByPath(ctx, "/docs/report.docx")
+		if !errors.IsNotFound(err) {
+			t.Errorf("GetByPath error = %v, want not found", err)
+		}
+	})
+
+	t.Run("List hides tombstone", func(t *testing.T) {
+		entries, err := store.List(ctx, "/docs")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("List returned %v entries, want 0", len(entries))
+		}
+	})
+
+	t.Run("path can be reused", func(t *testing.T) {
+		next := NewFileMetadata("file-2", "report.docx", "/docs/report.docx")
+		if err := store.Save(ctx, next); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+
+		// Re-saving the tombstone must not steal the path back
+		meta.SyncState = SyncStateSynced
+		if err := store.Save(ctx, meta); err != nil {
+			t.Fatalf("Save tombstone failed: %v", err)
+		}
+
+		got, err := store.GetByPath(ctx, "/docs/report.docx")
+		if err != nil {
+			t.Fatalf("GetByPath failed: %v", err)
+		}
+		if got.ID != "file-2" {
+			t.Errorf("ID = %v, want file-2", got.ID)
+		}
+
+		entries, _ := store.List(ctx, "/docs")
+		if len(entries) != 1 {
+			t.Errorf("List returned %v entries, want 1", len(entries))
+		}
+	})
+
+	t.Run("ListTombstones", func(t *testing.T) {
+		tombstones, err := store.ListTombstones(ctx, time.Now().Add(time.Second), 0)
+		if err != nil {
+			t.Fatalf("ListTombstones failed: %v", err)
+		}
+		if len(tombstones) != 1 || tombstones[0].ID != "file-1" {
+			t.Errorf("ListTombstones = %v, want [file-1]", tombstones)
+		}
+
+		tombstones, _ = store.ListTombstones(ctx, meta.UpdatedAt.Add(-time.Second), 0)
+		if len(tombstones) != 0 {
+			t.Errorf("ListTombstones before deletion returned %v, want 0", len(tombstones))
+		}
+	})
+}
+
+func TestBadgerStore_ListByStateAfterUpdate(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	meta := NewFileMetadata("file-1", "a.txt", "/a.txt")
+	store.Save(ctx, meta)
+
+	meta.SyncState = SyncStateSynced
+	store.Save(ctx, meta)
+
+	pending, err := store.ListByState(ctx, SyncStatePending, 0)
+	if err != nil {
+		t.Fatalf("ListByState failed: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("ListByState(pending) returned %v files, want 0", len(pending))
+	}
+}
+
+func TestBadgerStore_CountByState(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	for i, state := range []SyncState{SyncStatePending, SyncStateSynced, SyncStateSynced, SyncStateConflict} {
+		meta := NewFileMetadata(fmt.Sprintf("file-%d", i), fmt.Sprintf("%d.txt", i), fmt.Sprintf("/%d.txt", i))
+		meta.SyncState = state
+		if err := store.Save(ctx, meta); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	counts, err := store.CountByState(ctx)
+	if err != nil {
+		t.Fatalf("CountByState failed: %v", err)
+	}
+	want := map[SyncState]int{SyncStatePending: 1, SyncStateSynced: 2, SyncStateConflict: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("CountByState() = %v, want %v", counts, want)
+	}
+}
+
+func TestCompactor(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	old := time.Now().Add(-2 * time.Hour)
+
+	unsynced := NewFileMetadata("unsynced", "a.txt", "/a.txt")
+	unsynced.LocalState = LocalStateDeleted
+	unsynced.UpdatedAt = old
+	store.Save(ctx, unsynced)
+
+	synced := NewFileMetadata("synced", "b.txt", "/b.txt")
+	synced.LocalState = LocalStateDeleted
+	synced.SyncState = SyncStateSynced
+	synced.UpdatedAt = old
+	store.Save(ctx, synced)
+
+	recent := NewFileMetadata("recent", "c.txt", "/c.txt")
+	recent.LocalState = LocalStateDeleted
+	recent.SyncState = SyncStateSynced
+	store.Save(ctx, recent)
+
+	compactor := NewCompactor(CompactorConfig{TombstoneTTL: time.Hour}, store)
+
+	purged, err := compactor.Compact(ctx)
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("purged = %v, want 1", purged)
+	}
+
+	if _, err := store.Get(ctx, "synced"); !errors.IsNotFound(err) {
+		t.Errorf("synced tombstone should be purged, got err = %v", err)
+	}
+	if _, err := store.Get(ctx, "unsynced"); err != nil {
+		t.Errorf("unsynced tombstone should be kept: %v", err)
+	}
+	if _, err := store.Get(ctx, "recent"); err != nil {
+		t.Errorf("recent tombstone should be kept: %v", err)
+	}
+}
+
+func TestNewBadgerStore_InvalidCompression(t *testing.T) {
+	_, err := NewBadgerStore(StoreConfig{Path: t.TempDir(), Compression: "lz4"})
+	if err == nil {
+		t.Error("NewBadgerStore should reject unknown compression")
+	}
+}
+
+func TestBadgerStore_RunValueLogGC(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	result, err := store.RunValueLogGC(ctx)
+	if err != nil {
+		t.Fatalf("RunValueLogGC failed: %v", err)
+	}
+	if result.Rewrites != 0 {
+		t.Errorf("Rewrites = %v, want 0 on an empty store", result.Rewrites)
+	}
+
+	stats := store.Stats()
+	if stats.GC.Runs != 1 {
+		t.Errorf("GC.Runs = %v, want 1", stats.GC.Runs)
+	}
+	if stats.GC.LastRunAt.IsZero() {
+		t.Error("GC.LastRunAt should be set")
+	}
+}
+
+func TestBadgerStore_Directories(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	store.Save(ctx, NewFileMetadata("file-1", "c.txt", "/a/b/c.txt"))
+
+	t.Run("ancestors exist", func(t *testing.T) {
+		for _, dir := range []string{"/", "/a", "/a/b"} {
+			isDir, err := store.IsDir(ctx, dir)
+			if err != nil {
+				t.Fatalf("IsDir(%v) failed: %v", dir, err)
+			}
+			if !isDir {
+				t.Errorf("IsDir(%v) = false, want true", dir)
+			}
+		}
+
+		if isDir, _ := store.IsDir(ctx, "/a/b/c.txt"); isDir {
+			t.Error("IsDir of a file should be false")
+		}
+	})
+
+	t.Run("List includes subdirectories", func(t *testing.T) {
+		entries, err := store.List(ctx, "/a")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(entries) != 1 || !entries[0].IsDir || entries[0].Path != "/a/b" {
+			t.Errorf("List(/a) = %+v, want directory /a/b", entries)
+		}
+	})
+
+	t.Run("DeleteDir", func(t *testing.T) {
+		if err := store.DeleteDir(ctx, "/a"); !errors.IsConflict(err) {
+			t.Errorf("DeleteDir of non-empty directory error = %v, want conflict", err)
+		}
+
+		store.Delete(ctx, "file-1")
+		if err := store.DeleteDir(ctx, "/a/b"); err != nil {
+			t.Fatalf("DeleteDir failed: %v", err)
+		}
+		if err := store.DeleteDir(ctx, "/a"); err != nil {
+			t.Fatalf("DeleteDir failed: %v", err)
+		}
+		if isDir, _ := store.IsDir(ctx, "/a"); isDir {
+			t.Error("directory should not exist after DeleteDir")
+		}
+		if err := store.DeleteDir(ctx, "/a"); !errors.IsNotFound(err) {
+			t.Errorf("DeleteDir of missing directory error = %v, want not found", err)
+		}
+	})
+}
+
+func TestBadgerStore_SaveBatch(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	metas := []*FileMetadata{
+		NewFileMetadata("file-1", "a.txt", "/batch/a.txt"),
+		NewFileMetadata("file-2", "b.txt", "/batch/sub/b.txt"),
+	}
+	if err := store.SaveBatch(ctx, metas); err != nil {
+		t.Fatalf("SaveBatch failed: %v", err)
+	}
+
+	for _, meta := range metas {
+		got, err := store.GetByPath(ctx, meta.Path)
+		if err != nil {
+			t.Fatalf("GetByPath(%v) failed: %v", meta.Path, err)
+		}
+		if got.ID != meta.ID {
+			t.Errorf("GetByPath(%v).ID = %v, want %v", meta.Path, got.ID, meta.ID)
+		}
+	}
+	if isDir, _ := store.IsDir(ctx, "/batch/sub"); !isDir {
+		t.Error("SaveBatch should create parent directories")
+	}
+
+	// Updates in a batch replace the previous indexes
+	metas[0].Path = "/batch/renamed.txt"
+	metas[0].Name = "renamed.txt"
+	if err := store.SaveBatch(ctx, metas[:1]); err != nil {
+		t.Fatalf("SaveBatch failed: %v", err)
+	}
+	if _, err := store.GetByPath(ctx, "/batch/a.txt"); !errors.IsNotFound(err) {
+		t.Errorf("old path should be gone, err = %v", err)
+	}
+}
+
+func TestBadgerStore_GetBatch(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	live := NewFileMetadata("file-1", "a.txt", "/a.txt")
+	deleted := NewFileMetadata("file-2", "b.txt", "/b.txt")
+	deleted.LocalState = LocalStateDeleted
+	if err := store.SaveBatch(ctx, []*FileMetadata{live, deleted}); err != nil {
+		t.Fatalf("SaveBatch failed: %v", err)
+	}
+
+	metas, err := store.GetBatch(ctx, []FileKey{
+		{ID: "file-1"},
+		{Path: "/a.txt"},
+		{ID: "file-2"},
+		{Path: "/b.txt"},
+		{ID: "missing"},
+		{Path: "/missing.txt"},
+	})
+	if err != nil {
+		t.Fatalf("GetBatch failed: %v", err)
+	}
+
+	wantIDs := []string{"file-1", "file-1", "file-2", "", "", ""}
+	for i, want := range wantIDs {
+		got := ""
+		if metas[i] != nil {
+			got = metas[i].ID
+		}
+		if got != want {
+			t.Errorf("GetBatch()[%d] = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestBadgerStore_MkdirAll(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	if err := store.MkdirAll(ctx, "/x/y/z"); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	for _, dir := range []string{"/x", "/x/y", "/x/y/z"} {
+		if isDir, _ := store.IsDir(ctx, dir); !isDir {
+			t.Errorf("IsDir(%v) = false, want true", dir)
+		}
+	}
+
+	entries, _ := store.List(ctx, "/x/y")
+	if len(entries) != 1 || entries[0].Name != "z" {
+		t.Errorf("List(/x/y) = %+v, want [z]", entries)
+	}
+}
+
+func TestBadgerStore_ACLChain(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	for _, acl := range []*ACL{
+		{Path: "/", Owner: "admin"},
+		{Path: "/eng", Entries: []ACLEntry{{Principal: "group:eng", Permissions: []Permission{PermRead}}}},
+		{Path: "/eng/secret", NoInherit: true},
+		{Path: "/eng/secret/plan.txt", Owner: "alice"},
+	} {
+		if err := store.SaveACL(ctx, acl); err != nil {
+			t.Fatalf("SaveACL(%s) failed: %v", acl.Path, err)
+		}
+	}
+
+	paths := func(chain []*ACL) []string {
+		var p []string
+		for _, acl := range chain {
+			p = append(p, acl.Path)
+		}
+		return p
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/eng/docs/a.txt", []string{"/eng", "/"}},
+		{"/eng", []string{"/eng", "/"}},
+		{"/eng/secret/plan.txt", []string{"/eng/secret/plan.txt", "/eng/secret"}},
+		{"/other", []string{"/"}},
+	}
+	for _, tt := range tests {
+		chain, err := store.ACLChain(ctx, tt.path)
+		if err != nil {
+			t.Fatalf("ACLChain(%s) failed: %v", tt.path, err)
+		}
+		if got := paths(chain); !slices.Equal(got, tt.want) {
+			t.Errorf("ACLChain(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if err := store.DeleteACL(ctx, "/"); err != nil {
+		t.Fatalf("DeleteACL failed: %v", err)
+	}
+	if _, err := store.GetACL(ctx, "/"); !errors.IsNotFound(err) {
+		t.Errorf("GetACL after delete error = %v, want not found", err)
+	}
+	if err := store.DeleteACL(ctx, "/"); !errors.IsNotFound(err) {
+		t.Errorf("DeleteACL of missing ACL error = %v, want not found", err)
+	}
+	if chain, _ := store.ACLChain(ctx, "/other"); len(chain) != 0 {
+		t.Errorf("ACLChain(/other) = %v, want empty", paths(chain))
+	}
+}
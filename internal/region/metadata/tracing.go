@@ -0,0 +1,152 @@
+// Package metadata provides the tracing of metadata stores.
+package metadata
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"asisaid.cn/JzSE/internal/common/tracing"
+)
+
+// TracedStore starts a span around each operation of a Store.
+type TracedStore struct {
+	store Store
+}
+
+var _ Store = (*TracedStore)(nil)
+
+// NewTracedStore wraps a store.
+func NewTracedStore(store Store) *TracedStore {
+	return &TracedStore{store: store}
+}
+
+// Get retrieves file metadata by ID.
+func (s *TracedStore) Get(ctx context.Context, fileID string) (meta *FileMetadata, err error) {
+	ctx, span := start(ctx, "metadata.Get", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+	return s.store.Get(ctx, fileID)
+}
+
+// GetByPath retrieves file metadata by path.
+func (s *TracedStore) GetByPath(ctx context.Context, path string) (meta *FileMetadata, err error) {
+	ctx, span := start(ctx, "metadata.GetByPath", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+	return s.store.GetByPath(ctx, path)
+}
+
+// GetBatch retrieves several files in a single transaction.
+func (s *TracedStore) GetBatch(ctx context.Context, keys []FileKey) (metas []*FileMetadata, err error) {
+	ctx, span := start(ctx, "metadata.GetBatch", attribute.Int("batch.size", len(keys)))
+	defer func() { tracing.End(span, err) }()
+	return s.store.GetBatch(ctx, keys)
+}
+
+// Save saves or updates file metadata.
+func (s *TracedStore) Save(ctx context.Context, meta *FileMetadata) (err error) {
+	ctx, span := start(ctx, "metadata.Save", attribute.String("file.id", meta.ID), attribute.String("file.path", meta.Path))
+	defer func() { tracing.End(span, err) }()
+	return s.store.Save(ctx, meta)
+}
+
+// SaveBatch saves or updates several files in a single transaction.
+func (s *TracedStore) SaveBatch(ctx context.Context, metas []*FileMetadata) (err error) {
+	ctx, span := start(ctx, "metadata.SaveBatch", attribute.Int("batch.size", len(metas)))
+	defer func() { tracing.End(span, err) }()
+	return s.store.SaveBatch(ctx, metas)
+}
+
+// Delete removes file metadata.
+func (s *TracedStore) Delete(ctx context.Context, fileID string) (err error) {
+	ctx, span := start(ctx, "metadata.Delete", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+	return s.store.Delete(ctx, fileID)
+}
+
+// List lists files and subdirectories in a directory.
+func (s *TracedStore) List(ctx context.Context, dirPath string) (entries []*DirectoryEntry, err error) {
+	ctx, span := start(ctx, "metadata.List", attribute.String("file.path", dirPath))
+	defer func() { tracing.End(span, err) }()
+	return s.store.List(ctx, dirPath)
+}
+
+// IsDir reports whether a directory exists at the given path.
+func (s *TracedStore) IsDir(ctx context.Context, dirPath string) (isDir bool, err error) {
+	ctx, span := start(ctx, "metadata.IsDir", attribute.String("file.path", dirPath))
+	defer func() { tracing.End(span, err) }()
+	return s.store.IsDir(ctx, dirPath)
+}
+
+// MkdirAll creates a directory and all of its ancestors.
+func (s *TracedStore) MkdirAll(ctx context.Context, dirPath string) (err error) {
+	ctx, span := start(ctx, "metadata.MkdirAll", attribute.String("file.path", dirPath))
+	defer func() { tracing.End(span, err) }()
+	return s.store.MkdirAll(ctx, dirPath)
+}
+
+// DeleteDir removes an empty directory.
+func (s *TracedStore) DeleteDir(ctx context.Context, dirPath string) (err error) {
+	ctx, span := start(ctx, "metadata.DeleteDir", attribute.String("file.path", dirPath))
+	defer func() { tracing.End(span, err) }()
+	return s.store.DeleteDir(ctx, dirPath)
+}
+
+// GetACL retrieves the ACL attached to a path.
+func (s *TracedStore) GetACL(ctx context.Context, path string) (acl *ACL, err error) {
+	ctx, span := start(ctx, "metadata.GetACL", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+	return s.store.GetACL(ctx, path)
+}
+
+// ACLChain returns the ACLs that apply to a path, nearest first.
+func (s *TracedStore) ACLChain(ctx context.Context, path string) (acls []*ACL, err error) {
+	ctx, span := start(ctx, "metadata.ACLChain", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+	return s.store.ACLChain(ctx, path)
+}
+
+// SaveACL saves or replaces the ACL of a path.
+func (s *TracedStore) SaveACL(ctx context.Context, acl *ACL) (err error) {
+	ctx, span := start(ctx, "metadata.SaveACL", attribute.String("file.path", acl.Path))
+	defer func() { tracing.End(span, err) }()
+	return s.store.SaveACL(ctx, acl)
+}
+
+// DeleteACL removes the ACL of a path.
+func (s *TracedStore) DeleteACL(ctx context.Context, path string) (err error) {
+	ctx, span := start(ctx, "metadata.DeleteACL", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+	return s.store.DeleteACL(ctx, path)
+}
+
+// ListByState lists files by sync state.
+func (s *TracedStore) ListByState(ctx context.Context, state SyncState, limit int) (metas []*FileMetadata, err error) {
+	ctx, span := start(ctx, "metadata.ListByState", attribute.String("file.sync_state", string(state)))
+	defer func() { tracing.End(span, err) }()
+	return s.store.ListByState(ctx, state, limit)
+}
+
+// CountByState counts files by sync state.
+func (s *TracedStore) CountByState(ctx context.Context) (counts map[SyncState]int, err error) {
+	ctx, span := start(ctx, "metadata.CountByState")
+	defer func() { tracing.End(span, err) }()
+	return s.store.CountByState(ctx)
+}
+
+// ListTombstones lists deleted files whose deletion happened before the given time.
+func (s *TracedStore) ListTombstones(ctx context.Context, before time.Time, limit int) (metas []*FileMetadata, err error) {
+	ctx, span := start(ctx, "metadata.ListTombstones")
+	defer func() { tracing.End(span, err) }()
+	return s.store.ListTombstones(ctx, before, limit)
+}
+
+// Close closes the store.
+func (s *TracedStore) Close() error {
+	return s.store.Close()
+}
+
+func start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Start(ctx, name, trace.WithAttributes(attrs...))
+}
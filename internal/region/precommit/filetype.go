@@ -0,0 +1,86 @@
+// Package precommit provides a hook rejecting disallowed file types.
+package precommit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"asisaid.cn/JzSE/internal/region/metadata"
+)
+
+// FileTypeHook rejects files by extension or MIME type. The MIME type is
+// checked as declared and as sniffed from the content, so renaming a file
+// does not get it past the hook.
+type FileTypeHook struct {
+	extensions map[string]bool
+	mimeTypes  []string
+}
+
+// NewFileTypeHook creates a hook rejecting the given extensions (with or
+// without the dot, case-insensitive) and MIME types. A MIME type ending
+// in "/*" matches the whole type, e.g. "video/*".
+func NewFileTypeHook(extensions, mimeTypes []string) *FileTypeHook {
+	h := &FileTypeHook{extensions: make(map[string]bool)}
+	for _, ext := range extensions {
+		h.extensions["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	for _, mimeType := range mimeTypes {
+		h.mimeTypes = append(h.mimeTypes, strings.ToLower(mimeType))
+	}
+	return h
+}
+
+// Name implements Hook.
+func (h *FileTypeHook) Name() string {
+	return "file-type"
+}
+
+// Check implements Hook.
+func (h *FileTypeHook) Check(ctx context.Context, upload *Upload) (Decision, error) {
+	if ext := strings.ToLower(filepath.Ext(upload.Meta.Name)); h.extensions[ext] {
+		return Decision{Action: metadata.VerdictReject, Reason: "file type " + ext + " not allowed"}, nil
+	}
+	if len(h.mimeTypes) == 0 {
+		return Decision{Action: metadata.VerdictAccept}, nil
+	}
+	if h.blocked(upload.Meta.MimeType) {
+		return Decision{Action: metadata.VerdictReject, Reason: "content type " + upload.Meta.MimeType + " not allowed"}, nil
+	}
+
+	content, err := upload.Open(ctx)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer content.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return Decision{}, err
+	}
+	if sniffed := http.DetectContentType(head[:n]); h.blocked(sniffed) {
+		return Decision{Action: metadata.VerdictReject, Reason: "content type " + sniffed + " not allowed"}, nil
+	}
+	return Decision{Action: metadata.VerdictAccept}, nil
+}
+
+// blocked reports whether a MIME type, possibly with parameters, matches
+// a blocked type.
+func (h *FileTypeHook) blocked(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(strings.ToLower(mimeType), ";")
+	mimeType = strings.TrimSpace(mimeType)
+	if mimeType == "" {
+		return false
+	}
+	for _, blocked := range h.mimeTypes {
+		if blocked == mimeType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(blocked, "/*"); ok && strings.HasPrefix(mimeType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
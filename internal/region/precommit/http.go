@@ -0,0 +1,110 @@
+// Package precommit provides a hook calling out to an HTTP scanner.
+package precommit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/metadata"
+)
+
+// HTTPHook asks an external service for a decision. It POSTs a
+// multipart/form-data request with a "metadata" part holding the proposed
+// FileMetadata as JSON and a "content" part streaming the staged blob, and
+// expects a 200 response with a JSON Decision:
+//
+//	{"action": "accept" | "reject" | "quarantine", "reason": "..."}
+//
+// Any other response is a failure of the hook.
+type HTTPHook struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// NewHTTPHook creates a hook calling url. Calls are bounded by the
+// pipeline timeout.
+func NewHTTPHook(name, url string) *HTTPHook {
+	return &HTTPHook{name: name, url: url, client: &http.Client{}}
+}
+
+// Name implements Hook.
+func (h *HTTPHook) Name() string {
+	return h.name
+}
+
+// Check implements Hook.
+func (h *HTTPHook) Check(ctx context.Context, upload *Upload) (Decision, error) {
+	const op = "HTTPHook.Check"
+
+	meta, err := json.Marshal(upload.Meta)
+	if err != nil {
+		return Decision{}, errors.Wrap(op, err)
+	}
+	content, err := upload.Open(ctx)
+	if err != nil {
+		return Decision{}, errors.Wrap(op, err)
+	}
+	defer content.Close()
+
+	// Stream the body instead of buffering the blob
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeForm(form, meta, upload.Meta, content))
+	}()
+	defer body.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, body)
+	if err != nil {
+		return Decision{}, errors.E(op, errors.ErrInvalidInput, err, h.url)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return Decision{}, errors.Wrap(op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Decision{}, errors.E(op, errors.ErrRegionUnavailable, nil, fmt.Sprintf("%s: %s", h.url, resp.Status))
+	}
+	var decision Decision
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&decision); err != nil {
+		return Decision{}, errors.E(op, errors.ErrInvalidInput, err, "malformed decision from "+h.url)
+	}
+	return decision, nil
+}
+
+// writeForm writes the metadata and content parts of a hook request.
+func writeForm(form *multipart.Writer, metaJSON []byte, meta *metadata.FileMetadata, content io.Reader) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="metadata"`)
+	header.Set("Content-Type", "application/json")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(metaJSON); err != nil {
+		return err
+	}
+
+	header = make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", multipart.FileContentDisposition("content", meta.Name))
+	header.Set("Content-Type", meta.MimeType)
+	if part, err = form.CreatePart(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return err
+	}
+	return form.Close()
+}
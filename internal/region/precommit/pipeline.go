@@ -0,0 +1,131 @@
+// Package precommit provides hooks that inspect uploaded content before it
+// is committed. Each hook sees the staged blob and the proposed metadata
+// and accepts it, rejects it with a reason, or quarantines it: the file is
+// stored but neither served nor synced until it is replaced.
+package precommit
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/metadata"
+)
+
+// DefaultTimeout bounds a single hook call.
+const DefaultTimeout = 30 * time.Second
+
+// Upload is staged content waiting to be committed.
+type Upload struct {
+	// Meta is the proposed metadata of the file. Its ID is only set when
+	// a file is updated by ID. Hooks must not modify it.
+	Meta *metadata.FileMetadata
+
+	// Open reads the staged content; callers close the reader.
+	Open func(ctx context.Context) (io.ReadCloser, error)
+}
+
+// Decision is the outcome of a single hook.
+type Decision struct {
+	Action metadata.VerdictAction `json:"action"`
+	Reason string                 `json:"reason,omitempty"`
+}
+
+// Hook inspects uploads before they are committed.
+type Hook interface {
+	// Name identifies the hook in verdicts and logs.
+	Name() string
+
+	// Check decides on an upload. An error means the hook could not
+	// decide.
+	Check(ctx context.Context, upload *Upload) (Decision, error)
+}
+
+// PipelineConfig holds configuration for a hook pipeline.
+type PipelineConfig struct {
+	Timeout  time.Duration // Per hook call
+	FailOpen bool          // Ignore hooks that fail instead of refusing the upload
+}
+
+// Pipeline runs hooks in order. A rejection ends the pipeline; a
+// quarantine is kept unless a later hook rejects.
+type Pipeline struct {
+	config PipelineConfig
+	hooks  []Hook
+	logger *zap.Logger
+}
+
+// NewPipeline creates a pipeline running hooks in the given order.
+func NewPipeline(cfg PipelineConfig, hooks ...Hook) *Pipeline {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	return &Pipeline{
+		config: cfg,
+		hooks:  hooks,
+		logger: logger.WithComponent("PreCommitHooks"),
+	}
+}
+
+// Check runs the hooks on an upload and returns the verdict to record on
+// the file. A hook that fails refuses the upload as unavailable, unless
+// the pipeline fails open.
+func (p *Pipeline) Check(ctx context.Context, upload *Upload) (*metadata.Verdict, error) {
+	verdict := &metadata.Verdict{Action: metadata.VerdictAccept}
+
+	for _, hook := range p.hooks {
+		decision, err := p.run(ctx, hook, upload)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if p.config.FailOpen {
+				p.logger.Warn("ignoring failed pre-commit hook",
+					zap.String("hook", hook.Name()),
+					zap.String("path", upload.Meta.Path),
+					zap.Error(err))
+				continue
+			}
+			return nil, errors.E("Pipeline.Check", errors.ErrRegionUnavailable, err, "pre-commit hook "+hook.Name()+" failed")
+		}
+
+		switch decision.Action {
+		case metadata.VerdictAccept:
+		case metadata.VerdictQuarantine:
+			if verdict.Action == metadata.VerdictAccept {
+				verdict = &metadata.Verdict{Action: decision.Action, Hook: hook.Name(), Reason: decision.Reason}
+			}
+		case metadata.VerdictReject:
+			return p.decided(upload, &metadata.Verdict{Action: decision.Action, Hook: hook.Name(), Reason: decision.Reason}), nil
+		default:
+			return nil, errors.E("Pipeline.Check", errors.ErrRegionUnavailable, nil,
+				"pre-commit hook "+hook.Name()+" returned unknown action "+string(decision.Action))
+		}
+	}
+
+	return p.decided(upload, verdict), nil
+}
+
+// decided timestamps a verdict and logs those that are not acceptances.
+func (p *Pipeline) decided(upload *Upload, verdict *metadata.Verdict) *metadata.Verdict {
+	verdict.CheckedAt = time.Now()
+	if verdict.Action != metadata.VerdictAccept {
+		p.logger.Info("pre-commit hook verdict",
+			zap.String("path", upload.Meta.Path),
+			zap.String("action", string(verdict.Action)),
+			zap.String("hook", verdict.Hook),
+			zap.String("reason", verdict.Reason))
+	}
+	return verdict
+}
+
+// run calls a hook with the per-hook timeout.
+func (p *Pipeline) run(ctx context.Context, hook Hook, upload *Upload) (Decision, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	return hook.Check(ctx, upload)
+}
@@ -0,0 +1,185 @@
+package precommit
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/metadata"
+)
+
+// stubHook returns a fixed decision and records whether it ran.
+type stubHook struct {
+	name     string
+	decision Decision
+	err      error
+	ran      bool
+}
+
+func (h *stubHook) Name() string { return h.name }
+
+func (h *stubHook) Check(ctx context.Context, upload *Upload) (Decision, error) {
+	h.ran = true
+	return h.decision, h.err
+}
+
+func upload(name, mimeType, content string) *Upload {
+	meta := metadata.NewFileMetadata("", name, "/docs/"+name)
+	meta.MimeType = mimeType
+	return &Upload{
+		Meta: meta,
+		Open: func(ctx context.Context) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(content)), nil
+		},
+	}
+}
+
+func TestPipeline_Verdicts(t *testing.T) {
+	accept := Decision{Action: metadata.VerdictAccept}
+	quarantine := Decision{Action: metadata.VerdictQuarantine, Reason: "suspicious"}
+	reject := Decision{Action: metadata.VerdictReject, Reason: "malware"}
+
+	tests := []struct {
+		name      string
+		decisions []Decision
+		want      metadata.VerdictAction
+		wantHook  string
+		ran       int
+	}{
+		{"none", nil, metadata.VerdictAccept, "", 0},
+		{"all accept", []Decision{accept, accept}, metadata.VerdictAccept, "", 2},
+		{"quarantine", []Decision{accept, quarantine, accept}, metadata.VerdictQuarantine, "h1", 3},
+		{"reject wins", []Decision{quarantine, reject, accept}, metadata.VerdictReject, "h1", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hooks []Hook
+			var stubs []*stubHook
+			for i, d := range tt.decisions {
+				stub := &stubHook{name: "h" + string(rune('0'+i)), decision: d}
+				stubs = append(stubs, stub)
+				hooks = append(hooks, stub)
+			}
+			verdict, err := NewPipeline(PipelineConfig{}, hooks...).Check(context.Background(), upload("a.txt", "text/plain", "a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Action != tt.want || verdict.Hook != tt.wantHook || verdict.CheckedAt.IsZero() {
+				t.Errorf("verdict = %+v, want %s by %q", verdict, tt.want, tt.wantHook)
+			}
+			ran := 0
+			for _, stub := range stubs {
+				if stub.ran {
+					ran++
+				}
+			}
+			if ran != tt.ran {
+				t.Errorf("%d hooks ran, want %d", ran, tt.ran)
+			}
+		})
+	}
+}
+
+func TestPipeline_FailingHook(t *testing.T) {
+	failing := &stubHook{name: "scanner", err: io.ErrUnexpectedEOF}
+	quarantine := &stubHook{name: "q", decision: Decision{Action: metadata.VerdictQuarantine}}
+
+	_, err := NewPipeline(PipelineConfig{}, failing, quarantine).Check(context.Background(), upload("a.txt", "", ""))
+	if !stderrors.Is(err, errors.ErrRegionUnavailable) || quarantine.ran {
+		t.Errorf("fail closed: err = %v, later hook ran = %v", err, quarantine.ran)
+	}
+
+	verdict, err := NewPipeline(PipelineConfig{FailOpen: true}, failing, quarantine).Check(context.Background(), upload("a.txt", "", ""))
+	if err != nil || verdict.Action != metadata.VerdictQuarantine {
+		t.Errorf("fail open: verdict = %+v, err = %v", verdict, err)
+	}
+
+	unknown := &stubHook{name: "u", decision: Decision{Action: "maybe"}}
+	if _, err := NewPipeline(PipelineConfig{}, unknown).Check(context.Background(), upload("a.txt", "", "")); err == nil {
+		t.Error("unknown action accepted")
+	}
+}
+
+func TestFileTypeHook(t *testing.T) {
+	hook := NewFileTypeHook([]string{"exe", ".BAT"}, []string{"application/pdf", "video/*"})
+
+	tests := []struct {
+		name, mimeType, content string
+		want                    metadata.VerdictAction
+	}{
+		{"notes.txt", "text/plain", "hello", metadata.VerdictAccept},
+		{"setup.EXE", "", "MZ", metadata.VerdictReject},
+		{"run.bat", "", "echo", metadata.VerdictReject},
+		{"clip.mp4", "video/mp4; codecs=avc1", "", metadata.VerdictReject},
+		{"renamed.txt", "text/plain", "%PDF-1.7\n", metadata.VerdictReject}, // Sniffed
+	}
+	for _, tt := range tests {
+		decision, err := hook.Check(context.Background(), upload(tt.name, tt.mimeType, tt.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Action != tt.want {
+			t.Errorf("%s: %+v, want %s", tt.name, decision, tt.want)
+		}
+	}
+}
+
+func TestHTTPHook(t *testing.T) {
+	var gotMeta metadata.FileMetadata
+	var gotContent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.Unmarshal([]byte(r.FormValue("metadata")), &gotMeta); err != nil {
+			t.Errorf("metadata part: %v", err)
+		}
+		file, _, err := r.FormFile("content")
+		if err != nil {
+			t.Errorf("content part: %v", err)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		gotContent = string(content)
+
+		if strings.Contains(gotContent, "EICAR") {
+			json.NewEncoder(w).Encode(Decision{Action: metadata.VerdictReject, Reason: "EICAR test file"})
+			return
+		}
+		if gotMeta.Name == "broken.txt" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(Decision{Action: metadata.VerdictAccept})
+	}))
+	defer server.Close()
+
+	hook := NewHTTPHook("scanner", server.URL)
+	decision, err := hook.Check(context.Background(), upload("a.txt", "text/plain", "clean"))
+	if err != nil || decision.Action != metadata.VerdictAccept {
+		t.Fatalf("clean file: %+v, %v", decision, err)
+	}
+	if gotMeta.Path != "/docs/a.txt" || gotContent != "clean" {
+		t.Errorf("hook received %+v with %q", gotMeta, gotContent)
+	}
+
+	decision, err = hook.Check(context.Background(), upload("b.txt", "text/plain", "X5O!P%@AP EICAR"))
+	if err != nil || decision.Action != metadata.VerdictReject || decision.Reason != "EICAR test file" {
+		t.Errorf("infected file: %+v, %v", decision, err)
+	}
+
+	if _, err := hook.Check(context.Background(), upload("broken.txt", "text/plain", "x")); err == nil {
+		t.Error("server error not reported")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+	if _, err := hook.Check(ctx, upload("a.txt", "text/plain", "x")); err == nil {
+		t.Error("expired context not reported")
+	}
+}
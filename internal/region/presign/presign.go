@@ -0,0 +1,176 @@
+// Package presign provides HMAC-signed, expiring URLs that grant access to
+// a single region API resource without credentials.
+package presign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// Query parameters of a presigned URL.
+const (
+	ParamMethod      = "X-JzSE-Method"
+	ParamExpires     = "X-JzSE-Expires" // Unix seconds
+	ParamMaxSize     = "X-JzSE-Max-Size"
+	ParamContentType = "X-JzSE-Content-Type"
+	ParamUser        = "X-JzSE-User"
+	ParamGroups      = "X-JzSE-Groups" // Comma-separated
+	ParamSignature   = "X-JzSE-Signature"
+)
+
+// Reasons a presigned request is refused. They are wrapped in
+// errors.ErrForbidden.
+var (
+	ErrMissingParams     = stderrors.New("presigned URL is missing parameters")
+	ErrMalformed         = stderrors.New("presigned URL parameters are malformed")
+	ErrSignatureMismatch = stderrors.New("presigned URL signature does not match")
+	ErrExpired           = stderrors.New("presigned URL has expired")
+	ErrMethodNotAllowed  = stderrors.New("method not allowed by presigned URL")
+	ErrContentType       = stderrors.New("content type not allowed by presigned URL")
+	ErrSizeExceeded      = stderrors.New("upload exceeds the size allowed by presigned URL")
+)
+
+// Policy describes what a presigned URL allows.
+type Policy struct {
+	Method      string    // GET (which also allows HEAD) or PUT
+	Path        string    // URL path of the resource
+	Expires     time.Time // Truncated to seconds when signed
+	MaxSize     int64     // Upload size limit, zero for none
+	ContentType string    // Required upload media type, empty for any
+	UserID      string    // Identity the request acts as, empty for anonymous
+	Groups      []string  // Groups of that identity
+}
+
+// Signer signs and verifies presigned URLs with a region secret.
+type Signer struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// NewSigner creates a Signer. The secret must not be empty.
+func NewSigner(secret []byte) (*Signer, error) {
+	if len(secret) == 0 {
+		return nil, errors.E("presign.NewSigner", errors.ErrInvalidInput, nil, "empty secret")
+	}
+	return &Signer{secret: secret, now: time.Now}, nil
+}
+
+// IsPresigned reports whether a query carries a presigned URL signature.
+func IsPresigned(query url.Values) bool {
+	return query.Has(ParamSignature)
+}
+
+// Sign returns the query parameters that make a request to p.Path
+// satisfy the policy.
+func (s *Signer) Sign(p *Policy) url.Values {
+	query := url.Values{}
+	query.Set(ParamMethod, p.Method)
+	query.Set(ParamExpires, strconv.FormatInt(p.Expires.Unix(), 10))
+	if p.MaxSize > 0 {
+		query.Set(ParamMaxSize, strconv.FormatInt(p.MaxSize, 10))
+	}
+	if p.ContentType != "" {
+		query.Set(ParamContentType, p.ContentType)
+	}
+	if p.UserID != "" {
+		query.Set(ParamUser, p.UserID)
+	}
+	if len(p.Groups) > 0 {
+		query.Set(ParamGroups, strings.Join(p.Groups, ","))
+	}
+	query.Set(ParamSignature, s.signature(p.Path, query))
+	return query
+}
+
+// Verify checks the presigned parameters of a request for the given
+// method and URL path, returning the policy they grant.
+func (s *Signer) Verify(method, path string, query url.Values) (*Policy, error) {
+	signature := query.Get(ParamSignature)
+	if signature == "" || query.Get(ParamMethod) == "" || query.Get(ParamExpires) == "" {
+		return nil, errors.E("presign.Verify", errors.ErrForbidden, ErrMissingParams)
+	}
+
+	expected := s.signature(path, query)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, errors.E("presign.Verify", errors.ErrForbidden, ErrSignatureMismatch)
+	}
+
+	policy := &Policy{
+		Method:      query.Get(ParamMethod),
+		Path:        path,
+		ContentType: query.Get(ParamContentType),
+		UserID:      query.Get(ParamUser),
+	}
+	if groups := query.Get(ParamGroups); groups != "" {
+		policy.Groups = strings.Split(groups, ",")
+	}
+
+	expires, err := strconv.ParseInt(query.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return nil, errors.E("presign.Verify", errors.ErrForbidden, ErrMalformed, "expiry")
+	}
+	policy.Expires = time.Unix(expires, 0)
+	if s.now().After(policy.Expires) {
+		return nil, errors.E("presign.Verify", errors.ErrForbidden, ErrExpired)
+	}
+
+	if size := query.Get(ParamMaxSize); size != "" {
+		policy.MaxSize, err = strconv.ParseInt(size, 10, 64)
+		if err != nil || policy.MaxSize < 0 {
+			return nil, errors.E("presign.Verify", errors.ErrForbidden, ErrMalformed, "max size")
+		}
+	}
+
+	if !policy.Allows(method) {
+		return nil, errors.E("presign.Verify", errors.ErrForbidden, ErrMethodNotAllowed, method)
+	}
+
+	return policy, nil
+}
+
+// Allows reports whether the policy permits the given request method.
+func (p *Policy) Allows(method string) bool {
+	if method == p.Method {
+		return true
+	}
+	return method == http.MethodHead && p.Method == http.MethodGet
+}
+
+// CheckUpload verifies the media type and declared length of an upload
+// against the policy. A negative length is unknown and only limited
+// while the body is read.
+func (p *Policy) CheckUpload(mediaType string, length int64) error {
+	if p.ContentType != "" && !strings.EqualFold(mediaType, p.ContentType) {
+		return errors.E("presign.CheckUpload", errors.ErrForbidden, ErrContentType, mediaType)
+	}
+	if p.MaxSize > 0 && length > p.MaxSize {
+		return errors.E("presign.CheckUpload", errors.ErrForbidden, ErrSizeExceeded)
+	}
+	return nil
+}
+
+// signature computes the hex HMAC-SHA256 of the canonical request.
+func (s *Signer) signature(path string, query url.Values) string {
+	canonical := strings.Join([]string{
+		query.Get(ParamMethod),
+		path,
+		query.Get(ParamExpires),
+		query.Get(ParamMaxSize),
+		query.Get(ParamContentType),
+		query.Get(ParamUser),
+		query.Get(ParamGroups),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,130 @@
+package presign
+
+import (
+	stderrors "errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+func newTestSigner(t *testing.T, now time.Time) *Signer {
+	t.Helper()
+	signer, err := NewSigner([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("NewSigner failed: %v", err)
+	}
+	signer.now = func() time.Time { return now }
+	return signer
+}
+
+func TestNewSigner_EmptySecret(t *testing.T) {
+	if _, err := NewSigner(nil); !errors.IsInvalidInput(err) {
+		t.Errorf("NewSigner(nil) error = %v, want invalid input", err)
+	}
+}
+
+func TestSigner_SignVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := newTestSigner(t, now)
+
+	query := signer.Sign(&Policy{
+		Method:      http.MethodPut,
+		Path:        "/api/v1/fs/docs/a.txt",
+		Expires:     now.Add(15 * time.Minute),
+		MaxSize:     1024,
+		ContentType: "text/plain",
+		UserID:      "alice",
+		Groups:      []string{"eng", "ops"},
+	})
+	if !IsPresigned(query) {
+		t.Fatal("signed query should be presigned")
+	}
+
+	policy, err := signer.Verify(http.MethodPut, "/api/v1/fs/docs/a.txt", query)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if policy.MaxSize != 1024 || policy.ContentType != "text/plain" || policy.UserID != "alice" ||
+		!reflect.DeepEqual(policy.Groups, []string{"eng", "ops"}) {
+		t.Errorf("Verify() = %+v", policy)
+	}
+}
+
+func TestSigner_VerifyRejects(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := newTestSigner(t, now)
+	path := "/api/v1/files/file-1"
+
+	sign := func() url.Values {
+		return signer.Sign(&Policy{Method: http.MethodGet, Path: path, Expires: now.Add(time.Minute)})
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		modify func(q url.Values)
+		want   error
+	}{
+		{"other path", http.MethodGet, "/api/v1/files/file-2", nil, ErrSignatureMismatch},
+		{"tampered expiry", http.MethodGet, path, func(q url.Values) {
+			q[ParamExpires] = []string{"1800000000"}
+		}, ErrSignatureMismatch},
+		{"added size", http.MethodGet, path, func(q url.Values) {
+			q[ParamMaxSize] = []string{"10"}
+		}, ErrSignatureMismatch},
+		{"added groups", http.MethodGet, path, func(q url.Values) {
+			q[ParamGroups] = []string{"admins"}
+		}, ErrSignatureMismatch},
+		{"missing signature", http.MethodGet, path, func(q url.Values) {
+			q[ParamSignature] = []string{""}
+		}, ErrMissingParams},
+		{"wrong method", http.MethodPut, path, nil, ErrMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := sign()
+			if tt.modify != nil {
+				tt.modify(query)
+			}
+			_, err := signer.Verify(tt.method, tt.path, query)
+			if !errors.IsForbidden(err) || !stderrors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// HEAD is allowed by a GET URL
+	if _, err := signer.Verify(http.MethodHead, path, sign()); err != nil {
+		t.Errorf("Verify(HEAD) failed: %v", err)
+	}
+
+	// Expired
+	query := sign()
+	signer.now = func() time.Time { return now.Add(2 * time.Minute) }
+	if _, err := signer.Verify(http.MethodGet, path, query); !stderrors.Is(err, ErrExpired) {
+		t.Errorf("Verify() after expiry error = %v, want %v", err, ErrExpired)
+	}
+}
+
+func TestPolicy_CheckUpload(t *testing.T) {
+	policy := &Policy{Method: http.MethodPut, MaxSize: 100, ContentType: "image/png"}
+
+	if err := policy.CheckUpload("image/png", 100); err != nil {
+		t.Errorf("CheckUpload(allowed) failed: %v", err)
+	}
+	if err := policy.CheckUpload("image/png", -1); err != nil {
+		t.Errorf("CheckUpload(unknown length) failed: %v", err)
+	}
+	if err := policy.CheckUpload("text/html", 10); !stderrors.Is(err, ErrContentType) {
+		t.Errorf("CheckUpload(wrong type) error = %v, want %v", err, ErrContentType)
+	}
+	if err := policy.CheckUpload("image/png", 101); !stderrors.Is(err, ErrSizeExceeded) {
+		t.Errorf("CheckUpload(too large) error = %v, want %v", err, ErrSizeExceeded)
+	}
+}
@@ -0,0 +1,235 @@
+// Package service provides access control for file operations.
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/tracing"
+	"asisaid.cn/JzSE/internal/region/metadata"
+)
+
+// callerKey is the context key of the operation's caller.
+type callerKey struct{}
+
+// WithCaller returns a context whose FileService operations are
+// authorized for the given caller.
+func WithCaller(ctx context.Context, caller *metadata.Principal) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFrom returns the caller of ctx, or nil for internal operations.
+func CallerFrom(ctx context.Context) *metadata.Principal {
+	caller, _ := ctx.Value(callerKey{}).(*metadata.Principal)
+	return caller
+}
+
+// CheckAccess verifies that the caller of ctx holds perm on a path.
+//
+// Operations without a caller are internal and always allowed. Paths that
+// no ACL applies to are open to file operations, which keeps a region open
+// until ACLs are set; an ACL on "/" protects the whole tree. Attaching the
+// first ACL to such a path takes the owner of the file or a member of the
+// admin group. The owner of a file holds every permission on it, and
+// admins may manage every ACL. Anonymous callers never manage ACLs.
+func (s *FileService) CheckAccess(ctx context.Context, path string, perm metadata.Permission) error {
+	ownerID := ""
+	if meta, err := s.metadata.GetByPath(ctx, CleanPath(path)); err == nil {
+		ownerID = meta.OwnerID
+	}
+	return s.authorize(ctx, "FileService.CheckAccess", CleanPath(path), ownerID, perm)
+}
+
+// authorizeFile checks perm on an existing file.
+func (s *FileService) authorizeFile(ctx context.Context, op string, meta *metadata.FileMetadata, perm metadata.Permission) error {
+	return s.authorize(ctx, op, meta.Path, meta.OwnerID, perm)
+}
+
+// CanRead reports whether the caller of ctx may read the file at path
+// owned by ownerID. Unlike CheckAccess it does not look the file up, so it
+// also answers for deleted files, and denials are not logged.
+func (s *FileService) CanRead(ctx context.Context, path, ownerID string) bool {
+	allowed, err := s.allowed(ctx, CleanPath(path), ownerID, metadata.PermRead)
+	return err == nil && allowed
+}
+
+// authorize checks perm on path for the caller of ctx. ownerID is the
+// owner of the file at path, if any.
+func (s *FileService) authorize(ctx context.Context, op, path, ownerID string, perm metadata.Permission) error {
+	allowed, err := s.allowed(ctx, path, ownerID, perm)
+	if err != nil {
+		return errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	if allowed {
+		return nil
+	}
+
+	s.log(ctx).Info("access denied",
+		zap.String("user_id", CallerFrom(ctx).UserID),
+		zap.String("path", path),
+		zap.String("permission", string(perm)),
+	)
+	return errors.E(op, errors.ErrForbidden, nil, fmt.Sprintf("%s access to %s denied", perm, path))
+}
+
+// allowed reports whether the caller of ctx holds perm on path.
+func (s *FileService) allowed(ctx context.Context, path, ownerID string, perm metadata.Permission) (bool, error) {
+	caller := CallerFrom(ctx)
+	if caller == nil {
+		return true, nil
+	}
+	if caller.Anonymous && perm.ManagesACL() {
+		return false, nil
+	}
+	if ownerID != "" && !caller.Anonymous && caller.UserID == ownerID {
+		return true, nil
+	}
+	if perm.ManagesACL() && s.isAdmin(caller) {
+		return true, nil
+	}
+
+	chain, err := s.metadata.ACLChain(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	if len(chain) == 0 {
+		return !perm.ManagesACL(), nil
+	}
+	return metadata.Allowed(chain, caller, perm), nil
+}
+
+// isAdmin reports whether the caller is a member of the admin group.
+func (s *FileService) isAdmin(caller *metadata.Principal) bool {
+	return s.admins != "" && !caller.Anonymous && slices.Contains(caller.Groups, s.admins)
+}
+
+// GetACL returns the ACL attached to a path. The caller needs read access.
+func (s *FileService) GetACL(ctx context.Context, path string) (_ *metadata.ACL, err error) {
+	ctx, span := startSpan(ctx, "GetACL", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+
+	path = CleanPath(path)
+	if err := s.CheckAccess(ctx, path, metadata.PermRead); err != nil {
+		return nil, err
+	}
+	return s.metadata.GetACL(ctx, path)
+}
+
+// SetACL attaches an ACL to the file or directory at acl.Path, replacing
+// any previous one. The caller needs share permission, and owner
+// permission to change the ACL owner; see CheckAccess for paths without
+// ACLs. A new ACL without an owner is owned by the caller.
+func (s *FileService) SetACL(ctx context.Context, acl *metadata.ACL) (_ *metadata.ACL, err error) {
+	ctx, span := startSpan(ctx, "SetACL", attribute.String("file.path", acl.Path))
+	defer func() { tracing.End(span, err) }()
+
+	acl.Path = CleanPath(acl.Path)
+	if err := acl.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	info, err := s.stat(ctx, acl.Path)
+	if err != nil {
+		return nil, err
+	}
+	ownerID := ""
+	if info.File != nil {
+		ownerID = info.File.OwnerID
+	}
+
+	if err := s.authorize(ctx, "FileService.SetACL", acl.Path, ownerID, metadata.PermShare); err != nil {
+		return nil, err
+	}
+
+	caller := CallerFrom(ctx)
+	existing, err := s.metadata.GetACL(ctx, acl.Path)
+	switch {
+	case errors.IsNotFound(err):
+		if acl.Owner == "" && caller != nil && !caller.Anonymous {
+			acl.Owner = caller.UserID
+		}
+		acl.Version = 0
+		acl.VectorClock = nil
+	case err != nil:
+		return nil, errors.E("FileService.SetACL", errors.ErrInvalidMetadata, err)
+	default:
+		if acl.Owner != existing.Owner {
+			if err := s.authorize(ctx, "FileService.SetACL", acl.Path, ownerID, metadata.PermOwner); err != nil {
+				return nil, err
+			}
+		}
+		acl.Version = existing.Version
+		acl.VectorClock = existing.VectorClock
+	}
+
+	if caller != nil {
+		acl.UpdatedBy = caller.UserID
+	}
+	acl.Deleted = false
+	acl.SyncState = metadata.SyncStatePending
+	acl.IncrementClock(s.regionID)
+
+	if err := s.metadata.SaveACL(ctx, acl); err != nil {
+		return nil, errors.E("FileService.SetACL", errors.ErrInvalidMetadata, err)
+	}
+
+	s.notifyACL(ctx, acl)
+
+	s.log(ctx).Info("ACL updated",
+		zap.String("path", acl.Path),
+		zap.Int("entries", len(acl.Entries)),
+	)
+
+	return acl, nil
+}
+
+// DeleteACL removes the ACL of a path. The caller needs share permission.
+func (s *FileService) DeleteACL(ctx context.Context, path string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteACL", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+
+	path = CleanPath(path)
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	acl, err := s.metadata.GetACL(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err := s.CheckAccess(ctx, path, metadata.PermShare); err != nil {
+		return err
+	}
+
+	if err := s.metadata.DeleteACL(ctx, path); err != nil {
+		return errors.E("FileService.DeleteACL", errors.ErrInvalidMetadata, err)
+	}
+
+	// Removals are synced as a deleted ACL
+	acl.Deleted = true
+	acl.Entries = nil
+	if caller := CallerFrom(ctx); caller != nil {
+		acl.UpdatedBy = caller.UserID
+	}
+	acl.IncrementClock(s.regionID)
+	s.notifyACL(ctx, acl)
+
+	s.log(ctx).Info("ACL removed", zap.String("path", path))
+
+	return nil
+}
+
+// notifyACL forwards an ACL change to the notifier, if any.
+func (s *FileService) notifyACL(ctx context.Context, acl *metadata.ACL) {
+	if s.notifier != nil {
+		s.notifier.QueueACLChange(ctx, acl)
+	}
+}
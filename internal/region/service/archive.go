@@ -0,0 +1,334 @@
+// Package service provides streaming directory archives.
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/tracing"
+	"asisaid.cn/JzSE/internal/region/metadata"
+)
+
+// ArchiveFormat is the container format of a directory archive.
+type ArchiveFormat string
+
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTar   ArchiveFormat = "tar"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+// ParseArchiveFormat parses an archive format name.
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+	switch s {
+	case "zip":
+		return ArchiveZip, nil
+	case "tar":
+		return ArchiveTar, nil
+	case "tar.gz", "tgz":
+		return ArchiveTarGz, nil
+	default:
+		return "", errors.E("ParseArchiveFormat", errors.ErrInvalidInput, nil, "unknown archive format "+s)
+	}
+}
+
+// ArchiveManifestName is the archive entry listing skipped files.
+// It is only added when something was skipped.
+const ArchiveManifestName = ".jzse-manifest.json"
+
+// ArchiveManifest describes the content of a directory archive.
+type ArchiveManifest struct {
+	Root    string          `json:"root"`
+	Files   int             `json:"files"`
+	Bytes   int64           `json:"bytes"`
+	Skipped []*SkippedEntry `json:"skipped,omitempty"`
+}
+
+// SkippedEntry is a file left out of an archive.
+type SkippedEntry struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// archiveEntry is a planned archive entry.
+type archiveEntry struct {
+	name string // Relative, slash separated; directories end in "/"
+	meta *metadata.FileMetadata
+	mod  time.Time
+}
+
+// ArchivePlan is the resolved content of a directory archive.
+type ArchivePlan struct {
+	entries  []*archiveEntry
+	manifest *ArchiveManifest
+}
+
+// Manifest returns the planned manifest. After WriteArchive it also lists
+// files whose content could not be read.
+func (p *ArchivePlan) Manifest() *ArchiveManifest {
+	return p.manifest
+}
+
+func (p *ArchivePlan) skip(name, reason string) {
+	p.manifest.Skipped = append(p.manifest.Skipped, &SkippedEntry{Path: name, Reason: reason})
+}
+
+// PlanArchive resolves the files below dir that an archive would contain,
+// without reading any content. It fails with ErrTooLarge if the files add
+// up to more than maxSize bytes (zero for no limit).
+func (s *FileService) PlanArchive(ctx context.Context, dir string, maxSize int64) (_ *ArchivePlan, err error) {
+	ctx, span := startSpan(ctx, "PlanArchive", attribute.String("file.path", dir))
+	defer func() { tracing.End(span, err) }()
+
+	dir = CleanPath(dir)
+
+	isDir, err := s.metadata.IsDir(ctx, dir)
+	if err != nil {
+		return nil, errors.E("FileService.PlanArchive", errors.ErrInvalidMetadata, err)
+	}
+	if !isDir {
+		return nil, errors.E("FileService.PlanArchive", errors.ErrNotFound, nil, "directory "+dir)
+	}
+	if err := s.authorize(ctx, "FileService.PlanArchive", dir, "", metadata.PermRead); err != nil {
+		return nil, err
+	}
+
+	plan := &ArchivePlan{
+		manifest: &ArchiveManifest{Root: dir},
+	}
+	if err := s.planDir(ctx, plan, dir, ""); err != nil {
+		return nil, err
+	}
+
+	if maxSize > 0 && plan.manifest.Bytes > maxSize {
+		return nil, errors.E("FileService.PlanArchive", errors.ErrTooLarge, nil,
+			"archive of "+dir+" exceeds the size limit")
+	}
+
+	return plan, nil
+}
+
+// planDir adds the entries below dir to the plan, depth first.
+func (s *FileService) planDir(ctx context.Context, plan *ArchivePlan, dir, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	entries, err := s.metadata.List(ctx, dir)
+	if err != nil {
+		return errors.E("FileService.PlanArchive", errors.ErrInvalidMetadata, err)
+	}
+
+	for _, entry := range entries {
+		name := prefix + entry.Name
+
+		if entry.IsDir {
+			plan.entries = append(plan.entries, &archiveEntry{name: name + "/", mod: entry.UpdatedAt})
+			if err := s.planDir(ctx, plan, entry.Path, name+"/"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		meta, err := s.metadata.Get(ctx, entry.ID)
+		if err != nil {
+			plan.skip(name, "metadata unavailable")
+			continue
+		}
+		if meta.LocalState != metadata.LocalStatePresent {
+			plan.skip(name, "not available locally")
+			continue
+		}
+		if err := s.authorizeFile(ctx, "FileService.PlanArchive", meta, metadata.PermRead); err != nil {
+			plan.skip(name, "access denied")
+			continue
+		}
+
+		plan.entries = append(plan.entries, &archiveEntry{name: name, meta: meta, mod: meta.UpdatedAt})
+		plan.manifest.Files++
+		plan.manifest.Bytes += meta.Size
+	}
+
+	return nil
+}
+
+// WriteArchive streams the planned files to w in the given format,
+// reading content straight from storage. Files whose content cannot be
+// opened are skipped and listed in the manifest entry.
+func (s *FileService) WriteArchive(ctx context.Context, w io.Writer, plan *ArchivePlan, format ArchiveFormat) (err error) {
+	ctx, span := startSpan(ctx, "WriteArchive", attribute.String("archive.format", string(format)))
+	defer func() { tracing.End(span, err) }()
+
+	var archive archiveWriter
+	switch format {
+	case ArchiveZip:
+		archive = newZipArchive(w)
+	case ArchiveTar:
+		archive = newTarArchive(w, false)
+	case ArchiveTarGz:
+		archive = newTarArchive(w, true)
+	default:
+		return errors.E("FileService.WriteArchive", errors.ErrInvalidInput, nil, "unknown archive format "+string(format))
+	}
+
+	for _, entry := range plan.entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.meta == nil {
+			if err := archive.WriteDir(entry.name, entry.mod); err != nil {
+				return errors.Wrap("FileService.WriteArchive", err)
+			}
+			continue
+		}
+
+		content, err := s.storage.Get(ctx, entry.meta.ID)
+		if err != nil {
+			s.log(ctx).Warn("skipping unreadable file in archive",
+				zap.String("file_id", entry.meta.ID),
+				zap.Error(err),
+			)
+			plan.skip(entry.name, "content unavailable")
+			plan.manifest.Files--
+			plan.manifest.Bytes -= entry.meta.Size
+			continue
+		}
+
+		err = archive.WriteFile(entry.name, entry.meta, content)
+		content.Close()
+		if err != nil {
+			return errors.Wrap("FileService.WriteArchive", err)
+		}
+	}
+
+	if len(plan.manifest.Skipped) > 0 {
+		data, err := json.MarshalIndent(plan.manifest, "", "  ")
+		if err != nil {
+			return errors.Wrap("FileService.WriteArchive", err)
+		}
+		manifest := &metadata.FileMetadata{Size: int64(len(data)), UpdatedAt: time.Now()}
+		if err := archive.WriteFile(ArchiveManifestName, manifest, bytes.NewReader(data)); err != nil {
+			return errors.Wrap("FileService.WriteArchive", err)
+		}
+	}
+
+	return archive.Close()
+}
+
+// archiveWriter writes entries of one archive format.
+type archiveWriter interface {
+	WriteDir(name string, modTime time.Time) error
+	WriteFile(name string, meta *metadata.FileMetadata, content io.Reader) error
+	Close() error
+}
+
+// zipArchive writes a zip stream. Entry sizes go into data descriptors,
+// so the output never needs to be seeked.
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func newZipArchive(w io.Writer) *zipArchive {
+	return &zipArchive{zw: zip.NewWriter(w)}
+}
+
+func (a *zipArchive) WriteDir(name string, modTime time.Time) error {
+	_, err := a.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Modified: modTime,
+	})
+	return err
+}
+
+func (a *zipArchive) WriteFile(name string, meta *metadata.FileMetadata, content io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: meta.UpdatedAt,
+	}
+	header.SetMode(0644)
+
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+// tarArchive writes a tar stream, optionally gzip compressed.
+type tarArchive struct {
+	gw *gzip.Writer // Nil when uncompressed
+	tw *tar.Writer
+}
+
+func newTarArchive(w io.Writer, compress bool) *tarArchive {
+	if !compress {
+		return &tarArchive{tw: tar.NewWriter(w)}
+	}
+	gw := gzip.NewWriter(w)
+	return &tarArchive{gw: gw, tw: tar.NewWriter(gw)}
+}
+
+func (a *tarArchive) WriteDir(name string, modTime time.Time) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     0755,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+func (a *tarArchive) WriteFile(name string, meta *metadata.FileMetadata, content io.Reader) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     meta.Size,
+		Mode:     0644,
+		ModTime:  meta.UpdatedAt,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The header fixed the size, so exactly that many bytes must follow
+	_, err = io.CopyN(a.tw, content, meta.Size)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gw != nil {
+		return a.gw.Close()
+	}
+	return nil
+}
+
+// ArchiveName returns the download file name for an archive of dir.
+func ArchiveName(dir string, format ArchiveFormat) string {
+	name := path.Base(CleanPath(dir))
+	if name == "/" {
+		name = "root"
+	}
+	return name + "." + string(format)
+}
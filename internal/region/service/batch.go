@@ -0,0 +1,264 @@
+// Package service provides batch operations on file metadata.
+package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/common/tracing"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+)
+
+// BatchItem selects one file of a batch request, by ID or by path.
+type BatchItem struct {
+	ID      string `json:"id,omitempty"`
+	Path    string `json:"path,omitempty"`
+	IfMatch string `json:"if_match,omitempty"` // ETags in If-Match syntax
+
+	// CustomMeta is the patch applied by PatchCustomMetaBatch.
+	// A null value removes the key.
+	CustomMeta map[string]*string `json:"custom_meta,omitempty"`
+}
+
+// BatchResult is the outcome of one batch item. Err is nil on success.
+type BatchResult struct {
+	ID       string
+	Path     string
+	Metadata *metadata.FileMetadata
+	Err      error
+}
+
+// GetMetadataBatch retrieves the metadata of several files in a single
+// store transaction. Like GetMetadata, deleted files are found by ID.
+func (s *FileService) GetMetadataBatch(ctx context.Context, items []*BatchItem) (_ []*BatchResult, err error) {
+	ctx, span := startSpan(ctx, "GetMetadataBatch", attribute.Int("batch.size", len(items)))
+	defer func() { tracing.End(span, err) }()
+
+	results, metas, err := s.lookupBatch(ctx, "FileService.GetMetadataBatch", items, metadata.PermRead)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, meta := range metas {
+		results[i].Metadata = meta
+	}
+
+	return results, nil
+}
+
+// DeleteBatch deletes several files. The files are looked up and their
+// tombstones saved in one store transaction each; content is removed file
+// by file once the tombstones are committed, so that a failed commit leaves
+// every file readable. A file whose precondition fails is left untouched
+// and reported, without affecting the other items.
+func (s *FileService) DeleteBatch(ctx context.Context, items []*BatchItem) (_ []*BatchResult, err error) {
+	ctx, span := startSpan(ctx, "DeleteBatch", attribute.Int("batch.size", len(items)))
+	defer func() { tracing.End(span, err) }()
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	results, metas, err := s.lookupBatch(ctx, "FileService.DeleteBatch", items, metadata.PermDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []*BatchResult
+	for i, meta := range metas {
+		result := results[i]
+		if meta == nil {
+			continue
+		}
+		if meta.LocalState == metadata.LocalStateDeleted {
+			result.Err = errors.E("FileService.DeleteBatch", errors.ErrNotFound, nil, "file already deleted")
+			continue
+		}
+		if err := checkPreconditions("FileService.DeleteBatch", meta, items[i].IfMatch, ""); err != nil {
+			result.Err = err
+			continue
+		}
+
+		meta.LocalState = metadata.LocalStateDeleted
+		meta.SyncState = metadata.SyncStatePending
+		meta.IncrementClock(s.regionID)
+		result.Metadata = meta
+		deleted = append(deleted, result)
+	}
+
+	if err := s.commitBatch(ctx, "FileService.DeleteBatch", regionsync.ChangeTypeDelete, deleted); err != nil {
+		return nil, err
+	}
+
+	// The files are deleted; content that cannot be removed only takes space.
+	for _, result := range deleted {
+		if err := s.storage.Delete(ctx, result.ID); err != nil && !errors.IsNotFound(err) {
+			s.log(ctx).Warn("failed to remove content of deleted file",
+				logger.FileID(result.ID),
+				zap.Error(err),
+			)
+		}
+	}
+
+	s.log(ctx).Info("files deleted",
+		zap.Int("requested", len(items)),
+		zap.Int("deleted", len(deleted)),
+	)
+
+	return results, nil
+}
+
+// PatchCustomMetaBatch merges a patch into the custom metadata of several
+// files, committing all changed files in a single store transaction.
+func (s *FileService) PatchCustomMetaBatch(ctx context.Context, items []*BatchItem, userID string) (_ []*BatchResult, err error) {
+	ctx, span := startSpan(ctx, "PatchCustomMetaBatch", attribute.Int("batch.size", len(items)))
+	defer func() { tracing.End(span, err) }()
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	results, metas, err := s.lookupBatch(ctx, "FileService.PatchCustomMetaBatch", items, metadata.PermWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []*BatchResult
+	for i, meta := range metas {
+		result := results[i]
+		if meta == nil {
+			continue
+		}
+		if meta.LocalState == metadata.LocalStateDeleted {
+			result.Err = errors.E("FileService.PatchCustomMetaBatch", errors.ErrNotFound, nil, "file deleted")
+			continue
+		}
+		if err := checkPreconditions("FileService.PatchCustomMetaBatch", meta, items[i].IfMatch, ""); err != nil {
+			result.Err = err
+			continue
+		}
+		if err := patchCustomMeta(meta, items[i].CustomMeta); err != nil {
+			result.Err = err
+			continue
+		}
+
+		meta.UpdatedBy = userID
+		meta.SyncState = metadata.SyncStatePending
+		meta.IncrementClock(s.regionID)
+		result.Metadata = meta
+		patched = append(patched, result)
+	}
+
+	if err := s.commitBatch(ctx, "FileService.PatchCustomMetaBatch", regionsync.ChangeTypeUpdate, patched); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// lookupBatch resolves the items of a batch in one store transaction.
+// Items that are invalid, missing, repeat an earlier file or whose file
+// the caller lacks perm on get an error in their result and a nil
+// metadata entry.
+func (s *FileService) lookupBatch(ctx context.Context, op string, items []*BatchItem, perm metadata.Permission) ([]*BatchResult, []*metadata.FileMetadata, error) {
+	results := make([]*BatchResult, len(items))
+	keys := make([]metadata.FileKey, len(items))
+	for i, item := range items {
+		results[i] = &BatchResult{ID: item.ID, Path: item.Path}
+		switch {
+		case item.ID != "" && item.Path != "":
+			results[i].Err = errors.E(op, errors.ErrInvalidInput, nil, "either id or path must be given, not both")
+		case item.ID != "":
+			keys[i].ID = item.ID
+		case item.Path != "":
+			keys[i].Path = CleanPath(item.Path)
+			results[i].Path = keys[i].Path
+		default:
+			results[i].Err = errors.E(op, errors.ErrInvalidInput, nil, "id or path is required")
+		}
+	}
+
+	metas, err := s.metadata.GetBatch(ctx, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i, meta := range metas {
+		result := results[i]
+		switch {
+		case result.Err != nil:
+			metas[i] = nil
+		case meta == nil:
+			result.Err = errors.E(op, errors.ErrNotFound, nil, "file "+result.ID+result.Path)
+		case seen[meta.ID]:
+			result.Err = errors.E(op, errors.ErrConflict, nil, "file "+meta.ID+" appears more than once")
+			metas[i] = nil
+		default:
+			seen[meta.ID] = true
+			result.ID = meta.ID
+			result.Path = meta.Path
+			if err := s.authorizeFile(ctx, op, meta, perm); err != nil {
+				result.Err = err
+				metas[i] = nil
+			}
+		}
+	}
+
+	return results, metas, nil
+}
+
+// commitBatch saves the metadata of the given results in one transaction
+// and queues their changes for sync. Must be called with commitMu held.
+func (s *FileService) commitBatch(ctx context.Context, op string, changeType regionsync.ChangeType, results []*BatchResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	metas := make([]*metadata.FileMetadata, len(results))
+	for i, result := range results {
+		metas[i] = result.Metadata
+	}
+
+	if err := s.metadata.SaveBatch(ctx, metas); err != nil {
+		s.log(ctx).Error("failed to save batch metadata", zap.Error(err))
+		if errors.IsTooLarge(err) {
+			return err
+		}
+		return errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+
+	for _, meta := range metas {
+		s.notify(ctx, changeType, meta)
+	}
+
+	return nil
+}
+
+// patchCustomMeta applies a merge patch to the custom metadata of a file.
+func patchCustomMeta(meta *metadata.FileMetadata, patch map[string]*string) error {
+	if len(patch) == 0 {
+		return errors.E("FileService.PatchCustomMetaBatch", errors.ErrInvalidInput, nil, "custom_meta patch is empty")
+	}
+
+	if meta.CustomMeta == nil {
+		meta.CustomMeta = make(map[string]string)
+	}
+	for key := range patch {
+		if key == "" {
+			return errors.E("FileService.PatchCustomMetaBatch", errors.ErrInvalidInput, nil, "empty custom_meta key")
+		}
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(meta.CustomMeta, key)
+		} else {
+			meta.CustomMeta[key] = *value
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+// Package service provides verification of client-supplied content digests.
+package service
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// DigestAlgorithm names a digest algorithm, using the IANA
+// HTTP digest algorithm names.
+type DigestAlgorithm string
+
+const (
+	DigestMD5    DigestAlgorithm = "md5"
+	DigestSHA256 DigestAlgorithm = "sha-256"
+)
+
+// Digests holds the digests a client claims for uploaded content, as raw bytes.
+//
+// Digests may still be added while the content is read, for example from
+// HTTP trailers; they are verified once the content has been fully staged.
+type Digests map[DigestAlgorithm][]byte
+
+// Add records an expected digest. Conflicting values for the same
+// algorithm are rejected.
+func (d Digests) Add(algorithm DigestAlgorithm, value []byte) error {
+	switch algorithm {
+	case DigestMD5:
+		if len(value) != md5.Size {
+			return errors.E("Digests.Add", errors.ErrInvalidInput, nil, "malformed md5 digest")
+		}
+	case DigestSHA256:
+		if len(value) != 32 {
+			return errors.E("Digests.Add", errors.ErrInvalidInput, nil, "malformed sha-256 digest")
+		}
+	default:
+		return errors.E("Digests.Add", errors.ErrInvalidInput, nil, "unsupported digest algorithm "+string(algorithm))
+	}
+
+	if existing, ok := d[algorithm]; ok && !bytes.Equal(existing, value) {
+		return errors.E("Digests.Add", errors.ErrInvalidInput, nil, "conflicting "+string(algorithm)+" digests")
+	}
+	d[algorithm] = value
+	return nil
+}
+
+// verifyDigests compares the expected digests with those computed while
+// staging, returning the verified digests hex encoded by algorithm.
+func verifyDigests(expected Digests, computed *hashingReader) (map[string]string, error) {
+	if len(expected) == 0 {
+		return nil, nil
+	}
+
+	verified := make(map[string]string, len(expected))
+	for algorithm, want := range expected {
+		got := computed.Sum(algorithm)
+		if got == nil {
+			return nil, errors.E("FileService.verifyDigests", errors.ErrInvalidInput, nil, "unsupported digest algorithm "+string(algorithm))
+		}
+		if !bytes.Equal(got, want) {
+			return nil, errors.E("FileService.verifyDigests", errors.ErrInvalidInput, nil,
+				string(algorithm)+" checksum mismatch: expected "+hex.EncodeToString(want)+", got "+hex.EncodeToString(got))
+		}
+		verified[string(algorithm)] = hex.EncodeToString(got)
+	}
+
+	return verified, nil
+}
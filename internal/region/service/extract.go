@@ -0,0 +1,598 @@
+// Package service provides server-side extraction of uploaded archives.
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/tracing"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+)
+
+// ExtractRequest represents a request to extract an archive into a directory.
+type ExtractRequest struct {
+	Path       string // Target directory
+	Format     ArchiveFormat
+	Content    io.Reader
+	OwnerID    string
+	OnConflict ConflictPolicy // Applies to files that already exist, defaults to overwrite
+
+	// Quotas, zero for no limit
+	MaxEntries int   // Number of entries in the archive
+	MaxBytes   int64 // Total uncompressed size of the files
+}
+
+// Extract entry statuses.
+const (
+	ExtractCreated     = "created"
+	ExtractReplaced    = "replaced"
+	ExtractDirectory   = "directory"
+	ExtractSkipped     = "skipped"     // Entry type not supported, e.g. symlinks
+	ExtractRejected    = "rejected"    // Unsafe name, conflict or pre-commit hook
+	ExtractQuarantined = "quarantined" // Stored, but withheld by a pre-commit hook
+)
+
+// ExtractEntry reports what happened to one archive entry.
+type ExtractEntry struct {
+	Name   string `json:"name"` // Name as found in the archive
+	Path   string `json:"path,omitempty"`
+	Status string `json:"status"`
+	FileID string `json:"file_id,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ExtractResponse is the per-entry report of an extraction.
+type ExtractResponse struct {
+	Path        string          `json:"path"`
+	Created     int             `json:"created"`
+	Replaced    int             `json:"replaced"`
+	Rejected    int             `json:"rejected"`
+	Quarantined int             `json:"quarantined"`
+	Skipped     int             `json:"skipped"`
+	Entries     []*ExtractEntry `json:"entries"`
+}
+
+// extractItem is an archive entry being extracted.
+type extractItem struct {
+	report   *ExtractEntry
+	fullPath string
+	isDir    bool
+	mimeType string
+	staged   *stagedContent
+	verdict  *metadata.Verdict
+	backup   string // Previous content of an overwritten file, see swapContent
+}
+
+// Extract unpacks a zip, tar or tar.gz archive below req.Path.
+//
+// All file contents are staged first. The metadata of every accepted file
+// is then committed in a single transaction, so the extracted tree appears
+// as a whole. Entries with unsafe names (absolute paths or ".." segments),
+// unsupported types, conflicting paths or content refused by a pre-commit
+// hook are rejected individually and reported. Exceeding a quota aborts
+// the whole extraction.
+func (s *FileService) Extract(ctx context.Context, req *ExtractRequest) (_ *ExtractResponse, err error) {
+	ctx, span := startSpan(ctx, "Extract", attribute.String("file.path", req.Path), attribute.String("archive.format", string(req.Format)))
+	defer func() { tracing.End(span, err) }()
+
+	policy, err := ParseConflictPolicy(string(req.OnConflict))
+	if err != nil {
+		return nil, err
+	}
+
+	target := CleanPath(req.Path)
+	if err := s.checkDir(ctx, target); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, "FileService.Extract", target, "", metadata.PermWrite); err != nil {
+		return nil, err
+	}
+
+	s.log(ctx).Info("extracting archive",
+		zap.String("path", target),
+		zap.String("format", string(req.Format)),
+		zap.String("on_conflict", string(policy)),
+	)
+
+	items, err := s.stageArchive(ctx, req, target)
+	if err != nil {
+		return nil, err
+	}
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	resp, err := s.commitExtract(ctx, items, policy, req.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	resp.Path = target
+
+	s.log(ctx).Info("archive extracted",
+		zap.String("path", target),
+		zap.Int("created", resp.Created),
+		zap.Int("replaced", resp.Replaced),
+		zap.Int("rejected", resp.Rejected),
+		zap.Int("skipped", resp.Skipped),
+	)
+
+	return resp, nil
+}
+
+// stageArchive reads all archive entries, staging the content of regular files.
+// On error, everything staged so far is discarded.
+func (s *FileService) stageArchive(ctx context.Context, req *ExtractRequest, target string) (items []*extractItem, err error) {
+	defer func() {
+		if err != nil {
+			s.discardItems(ctx, items)
+		}
+	}()
+
+	reader, closeFn, err := openArchive(req.Format, req.Content, s.tempDir)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	var totalBytes int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return items, err
+		}
+
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return items, errors.E("FileService.Extract", errors.ErrInvalidInput, err, "malformed archive")
+		}
+
+		if req.MaxEntries > 0 && len(items) >= req.MaxEntries {
+			return items, errors.E("FileService.Extract", errors.ErrTooLarge, nil,
+				fmt.Sprintf("archive has more than %d entries", req.MaxEntries))
+		}
+
+		item := &extractItem{
+			report: &ExtractEntry{Name: entry.name},
+			isDir:  entry.isDir,
+		}
+		items = append(items, item)
+
+		rel, ok := sanitizeEntryName(entry.name)
+		if !ok || rel == "." && !entry.isDir {
+			item.reject("unsafe path")
+			continue
+		}
+		item.fullPath = filepath.Join(target, rel)
+		item.report.Path = item.fullPath
+
+		if entry.isDir {
+			continue
+		}
+		if !entry.isRegular {
+			item.report.Status = ExtractSkipped
+			item.report.Error = "unsupported entry type"
+			continue
+		}
+
+		totalBytes += entry.size
+		if req.MaxBytes > 0 && totalBytes > req.MaxBytes {
+			return items, errors.E("FileService.Extract", errors.ErrTooLarge, nil,
+				fmt.Sprintf("archive content exceeds %d bytes", req.MaxBytes))
+		}
+
+		content, err := entry.open()
+		if err != nil {
+			return items, errors.E("FileService.Extract", errors.ErrInvalidInput, err, "malformed entry "+entry.name)
+		}
+		staged, err := s.stage(ctx, content, entry.size, nil)
+		content.Close()
+		if err != nil {
+			return items, stageError("FileService.Extract", err)
+		}
+		item.staged = staged
+		item.mimeType = detectMimeType("", rel)
+		item.report.Size = staged.size
+
+		proposed := metadata.NewFileMetadata("", filepath.Base(item.fullPath), item.fullPath)
+		proposed.MimeType = item.mimeType
+		proposed.OwnerID = req.OwnerID
+		proposed.OriginRegion = s.regionID
+		item.verdict, err = s.checkContent(ctx, "FileService.Extract", staged, proposed)
+		if errors.IsRejected(err) {
+			s.discard(ctx, staged)
+			item.staged = nil
+			item.reject(err.Error())
+		} else if err != nil {
+			return items, err
+		}
+	}
+}
+
+// commitExtract resolves conflicts and commits the staged items.
+// Must be called with commitMu held.
+func (s *FileService) commitExtract(ctx context.Context, items []*extractItem, policy ConflictPolicy, userID string) (*ExtractResponse, error) {
+	resp := &ExtractResponse{}
+
+	// Paths claimed by the archive itself, to detect conflicts between entries
+	claimedFiles := make(map[string]bool)
+	claimedDirs := make(map[string]bool)
+	for _, item := range items {
+		if item.report.Status != "" {
+			continue // Rejected or skipped while reading
+		}
+		for dir := filepath.Dir(item.fullPath); dir != "/"; dir = filepath.Dir(dir) {
+			claimedDirs[dir] = true
+		}
+		if item.isDir {
+			claimedDirs[item.fullPath] = true
+		}
+	}
+
+	var (
+		metas    []*metadata.FileMetadata
+		changes  []regionsync.ChangeType
+		newBlobs []string
+		replaced []*extractItem
+		dirs     []string
+	)
+	for _, item := range items {
+		switch {
+		case item.report.Status != "":
+			// Rejected or skipped while reading
+		case item.isDir:
+			if claimedFiles[item.fullPath] {
+				item.reject("conflicts with a file in the archive")
+			} else if err := s.checkDir(ctx, item.fullPath); err != nil {
+				item.reject(err.Error())
+			} else if err := s.authorize(ctx, "FileService.Extract", item.fullPath, "", metadata.PermWrite); err != nil {
+				item.reject(err.Error())
+			} else {
+				item.report.Status = ExtractDirectory
+				dirs = append(dirs, item.fullPath)
+			}
+		case claimedFiles[item.fullPath]:
+			item.reject("duplicate entry")
+		case claimedDirs[item.fullPath] || hasClaimedAncestor(claimedFiles, item.fullPath):
+			item.reject("conflicts with a directory in the archive")
+		default:
+			meta, changeType, err := s.resolveExtracted(ctx, item, policy, userID, claimedFiles)
+			if err != nil {
+				item.reject(err.Error())
+				break
+			}
+			claimedFiles[meta.Path] = true
+			metas = append(metas, meta)
+			changes = append(changes, changeType)
+			if changeType == regionsync.ChangeTypeCreate {
+				newBlobs = append(newBlobs, meta.ID)
+			} else {
+				replaced = append(replaced, item)
+			}
+		}
+
+		if item.report.Status == ExtractRejected && item.staged != nil {
+			s.discard(ctx, item.staged)
+			item.staged = nil
+		}
+	}
+
+	rollback := func(err error) error {
+		for _, key := range newBlobs {
+			_ = s.storage.Delete(ctx, key)
+		}
+		for _, item := range replaced {
+			s.restoreContent(ctx, item.report.FileID, item.backup)
+		}
+		s.log(ctx).Error("failed to save extracted metadata", zap.Error(err))
+		return errors.E("FileService.Extract", errors.ErrInvalidMetadata, err)
+	}
+
+	// Directories are created first, so that a failure leaves at most
+	// some empty directories behind and no file is committed
+	for _, dir := range dirs {
+		if err := s.metadata.MkdirAll(ctx, dir); err != nil {
+			return nil, rollback(err)
+		}
+	}
+	if len(metas) > 0 {
+		if err := s.metadata.SaveBatch(ctx, metas); err != nil {
+			return nil, rollback(err)
+		}
+	}
+	for _, item := range replaced {
+		s.dropContent(ctx, item.backup)
+	}
+
+	for i, meta := range metas {
+		s.notify(ctx, changes[i], meta)
+	}
+
+	for _, item := range items {
+		resp.Entries = append(resp.Entries, item.report)
+		switch item.report.Status {
+		case ExtractCreated:
+			resp.Created++
+		case ExtractReplaced:
+			resp.Replaced++
+		case ExtractRejected:
+			resp.Rejected++
+		case ExtractQuarantined:
+			resp.Quarantined++
+		case ExtractSkipped:
+			resp.Skipped++
+		}
+	}
+
+	return resp, nil
+}
+
+// resolveExtracted applies the conflict policy to an extracted file and
+// moves its staged content into place, returning the metadata to commit.
+// The previous content of an overwritten file is kept in item.backup until
+// the commit settles.
+func (s *FileService) resolveExtracted(ctx context.Context, item *extractItem, policy ConflictPolicy, userID string, claimed map[string]bool) (*metadata.FileMetadata, regionsync.ChangeType, error) {
+	if err := s.checkTarget(ctx, item.fullPath); err != nil {
+		return nil, "", err
+	}
+
+	existing, err := s.metadata.GetByPath(ctx, item.fullPath)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, "", err
+	}
+
+	fullPath := item.fullPath
+	if existing != nil {
+		switch policy {
+		case ConflictFail:
+			return nil, "", errors.E("FileService.Extract", errors.ErrAlreadyExists, nil, "file already exists")
+		case ConflictRename:
+			dir, name := filepath.Dir(fullPath), filepath.Base(fullPath)
+			for {
+				if name, err = s.freeName(ctx, dir, name); err != nil {
+					return nil, "", err
+				}
+				if !claimed[filepath.Join(dir, name)] {
+					break
+				}
+			}
+			fullPath = filepath.Join(dir, name)
+			existing = nil
+		default:
+			if err := s.authorizeFile(ctx, "FileService.Extract", existing, metadata.PermWrite); err != nil {
+				return nil, "", err
+			}
+			backup, err := s.swapContent(ctx, item.staged, existing.ID)
+			if err != nil {
+				return nil, "", err
+			}
+			item.backup = backup
+			existing.Size = item.staged.size
+			existing.ContentHash = item.staged.hash
+			existing.Digests = nil
+			existing.MimeType = item.mimeType
+			existing.UpdatedBy = userID
+			existing.LocalState = metadata.LocalStatePresent
+			existing.SyncState = metadata.SyncStatePending
+			existing.IncrementClock(s.regionID)
+			applyVerdict(existing, item.verdict)
+
+			item.report.Status = ExtractReplaced
+			item.report.FileID = existing.ID
+			item.quarantined(existing)
+			return existing, regionsync.ChangeTypeUpdate, nil
+		}
+	}
+
+	if err := s.authorize(ctx, "FileService.Extract", fullPath, "", metadata.PermWrite); err != nil {
+		return nil, "", err
+	}
+
+	fileID := uuid.New().String()
+	if err := s.storage.Rename(ctx, item.staged.key, fileID); err != nil {
+		return nil, "", err
+	}
+
+	meta := metadata.NewFileMetadata(fileID, filepath.Base(fullPath), fullPath)
+	meta.Size = item.staged.size
+	meta.ContentHash = item.staged.hash
+	meta.MimeType = item.mimeType
+	meta.OwnerID = userID
+	meta.OriginRegion = s.regionID
+	meta.CreatedBy = userID
+	meta.UpdatedBy = userID
+	meta.IncrementClock(s.regionID)
+	applyVerdict(meta, item.verdict)
+
+	item.report.Status = ExtractCreated
+	item.report.Path = fullPath
+	item.report.FileID = fileID
+	item.quarantined(meta)
+	return meta, regionsync.ChangeTypeCreate, nil
+}
+
+// checkDir verifies that a directory may be created: neither it nor any
+// of its ancestors may be a file.
+func (s *FileService) checkDir(ctx context.Context, dirPath string) error {
+	for dir := dirPath; dir != "/"; dir = filepath.Dir(dir) {
+		_, err := s.metadata.GetByPath(ctx, dir)
+		if err == nil {
+			return errors.E("FileService.checkDir", errors.ErrConflict, nil, dir+" is a file")
+		}
+		if !errors.IsNotFound(err) {
+			return errors.E("FileService.checkDir", errors.ErrInvalidMetadata, err)
+		}
+	}
+	return nil
+}
+
+// discardItems removes the staged content of all items.
+func (s *FileService) discardItems(ctx context.Context, items []*extractItem) {
+	for _, item := range items {
+		if item.staged != nil {
+			s.discard(ctx, item.staged)
+		}
+	}
+}
+
+func (item *extractItem) reject(reason string) {
+	item.report.Status = ExtractRejected
+	item.report.Error = reason
+}
+
+// quarantined reports a committed file withheld by a pre-commit hook.
+func (item *extractItem) quarantined(meta *metadata.FileMetadata) {
+	if meta.LocalState == metadata.LocalStateQuarantined {
+		item.report.Status = ExtractQuarantined
+		item.report.Error = meta.Verdict.Hook + ": " + meta.Verdict.Reason
+	}
+}
+
+// hasClaimedAncestor reports whether an ancestor of p is a claimed file.
+func hasClaimedAncestor(files map[string]bool, p string) bool {
+	for dir := filepath.Dir(p); dir != "/"; dir = filepath.Dir(dir) {
+		if files[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeEntryName turns an archive entry name into a safe relative path,
+// "." for the archive root. Absolute names, drive letters and ".." segments
+// are refused so that no entry can escape the target directory.
+func sanitizeEntryName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsRune(name, 0) {
+		return "", false
+	}
+	if len(name) >= 2 && name[1] == ':' {
+		return "", false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+
+	return path.Clean(name), true
+}
+
+// archiveEntryReader iterates over the entries of an archive.
+type archiveEntryReader interface {
+	Next() (*archiveItem, error)
+}
+
+// archiveItem is an entry read from an archive.
+type archiveItem struct {
+	name      string
+	isDir     bool
+	isRegular bool
+	size      int64
+	open      func() (io.ReadCloser, error)
+}
+
+// openArchive returns an entry reader for the archive content. Zip archives
+// keep their directory at the end, so they are spooled to a temporary file
+// in tempDir.
+func openArchive(format ArchiveFormat, content io.Reader, tempDir string) (archiveEntryReader, func(), error) {
+	switch format {
+	case ArchiveTar:
+		return &tarEntryReader{tr: tar.NewReader(content)}, func() {}, nil
+	case ArchiveTarGz:
+		gr, err := gzip.NewReader(content)
+		if err != nil {
+			return nil, nil, errors.E("FileService.Extract", errors.ErrInvalidInput, err, "malformed gzip stream")
+		}
+		return &tarEntryReader{tr: tar.NewReader(gr)}, func() { gr.Close() }, nil
+	case ArchiveZip:
+		return openZip(content, tempDir)
+	default:
+		return nil, nil, errors.E("FileService.Extract", errors.ErrInvalidInput, nil, "unknown archive format "+string(format))
+	}
+}
+
+func openZip(content io.Reader, tempDir string) (archiveEntryReader, func(), error) {
+	spool, err := os.CreateTemp(tempDir, "jzse-extract-*.zip")
+	if err != nil {
+		return nil, nil, errors.E("FileService.Extract", errors.ErrStorageFull, err)
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	size, err := io.Copy(spool, content)
+	if err != nil {
+		cleanup()
+		return nil, nil, errors.E("FileService.Extract", errors.ErrStorageFull, err)
+	}
+
+	zr, err := zip.NewReader(spool, size)
+	if err != nil {
+		cleanup()
+		return nil, nil, errors.E("FileService.Extract", errors.ErrInvalidInput, err, "malformed zip archive")
+	}
+
+	return &zipEntryReader{files: zr.File}, cleanup, nil
+}
+
+// tarEntryReader reads entries of a tar stream in order.
+type tarEntryReader struct {
+	tr *tar.Reader
+}
+
+func (r *tarEntryReader) Next() (*archiveItem, error) {
+	header, err := r.tr.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return &archiveItem{
+		name:      header.Name,
+		isDir:     header.Typeflag == tar.TypeDir,
+		isRegular: header.Typeflag == tar.TypeReg,
+		size:      header.Size,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(r.tr), nil
+		},
+	}, nil
+}
+
+// zipEntryReader reads entries of a zip archive in directory order.
+type zipEntryReader struct {
+	files []*zip.File
+}
+
+func (r *zipEntryReader) Next() (*archiveItem, error) {
+	if len(r.files) == 0 {
+		return nil, io.EOF
+	}
+	f := r.files[0]
+	r.files = r.files[1:]
+
+	mode := f.Mode()
+	return &archiveItem{
+		name:      f.Name,
+		isDir:     mode.IsDir(),
+		isRegular: mode.IsRegular(),
+		size:      int64(f.UncompressedSize64),
+		open:      f.Open,
+	}, nil
+}
@@ -3,29 +3,69 @@ package service
 
 import (
 	"context"
+	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"hash"
 	"io"
 	"mime"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
 	"asisaid.cn/JzSE/internal/common/errors"
 	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/common/tracing"
 	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/precommit"
 	"asisaid.cn/JzSE/internal/region/storage"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// ChangeNotifier receives the change events produced by file and ACL operations.
+type ChangeNotifier interface {
+	QueueChange(ctx context.Context, changeType regionsync.ChangeType, meta *metadata.FileMetadata)
+	QueueACLChange(ctx context.Context, acl *metadata.ACL)
+}
+
+// Notifiers forwards change events to several notifiers, in order.
+type Notifiers []ChangeNotifier
+
+// QueueChange forwards a file change to every notifier.
+func (n Notifiers) QueueChange(ctx context.Context, changeType regionsync.ChangeType, meta *metadata.FileMetadata) {
+	for _, notifier := range n {
+		notifier.QueueChange(ctx, changeType, meta)
+	}
+}
+
+// QueueACLChange forwards an ACL change to every notifier.
+func (n Notifiers) QueueACLChange(ctx context.Context, acl *metadata.ACL) {
+	for _, notifier := range n {
+		notifier.QueueACLChange(ctx, acl)
+	}
+}
+
 // FileService handles file operations.
 type FileService struct {
 	regionID string
 	storage  storage.Backend
 	metadata metadata.Store
+	notifier ChangeNotifier
+	hooks    *precommit.Pipeline // Nil when no pre-commit hooks are configured
+	tempDir  string              // Spools archives, empty for the system default
+	admins   string              // Group whose members may manage every ACL
 	logger   *zap.Logger
+
+	// commitMu serializes path resolution and metadata commits so that
+	// concurrent uploads cannot claim the same path twice.
+	commitMu sync.Mutex
 }
 
 // NewFileService creates a new FileService.
@@ -38,14 +78,68 @@ func NewFileService(regionID string, storageBackend storage.Backend, metaStore m
 	}
 }
 
+// SetChangeNotifier sets the receiver of file change events, usually the sync
+// agent, or Notifiers combining it with other receivers.
+func (s *FileService) SetChangeNotifier(notifier ChangeNotifier) {
+	s.notifier = notifier
+}
+
+// SetPreCommitHooks sets the hooks that inspect uploaded content before it
+// is committed.
+func (s *FileService) SetPreCommitHooks(hooks *precommit.Pipeline) {
+	s.hooks = hooks
+}
+
+// SetAdminGroup sets the group whose members may manage every ACL, including
+// the first ACL of paths no ACL applies to yet.
+func (s *FileService) SetAdminGroup(group string) {
+	s.admins = group
+}
+
+// SetTempDir sets the directory that archives are spooled to while they are
+// extracted, usually storage.temp_path.
+func (s *FileService) SetTempDir(dir string) {
+	s.tempDir = dir
+}
+
+// ConflictPolicy decides what an upload does when its path already holds a file.
+type ConflictPolicy string
+
+const (
+	ConflictOverwrite ConflictPolicy = "overwrite" // Replace the content of the existing file
+	ConflictFail      ConflictPolicy = "fail"      // Reject the upload
+	ConflictRename    ConflictPolicy = "rename"    // Store under a free name such as "a (1).txt"
+)
+
+// ParseConflictPolicy parses a conflict policy, defaulting to overwrite.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(s); policy {
+	case "":
+		return ConflictOverwrite, nil
+	case ConflictOverwrite, ConflictFail, ConflictRename:
+		return policy, nil
+	default:
+		return "", errors.E("ParseConflictPolicy", errors.ErrInvalidInput, nil, "unknown conflict policy "+s)
+	}
+}
+
 // UploadRequest represents a file upload request.
 type UploadRequest struct {
-	Path     string
-	Name     string
-	Size     int64
-	Content  io.Reader
-	MimeType string
-	OwnerID  string
+	Path       string
+	Name       string
+	Size       int64 // Declared content length, or -1 if unknown
+	Content    io.Reader
+	MimeType   string
+	OwnerID    string
+	OnConflict ConflictPolicy // Defaults to overwrite
+
+	// Preconditions on the file currently at the target path, in If-Match
+	// and If-None-Match syntax. Empty values are not checked.
+	IfMatch     string
+	IfNoneMatch string
+
+	// Digests claimed by the client; the upload fails if any does not match.
+	Digests Digests
 }
 
 // UploadResponse represents a file upload response.
@@ -55,74 +149,379 @@ type UploadResponse struct {
 	Size        int64
 	ContentHash string
 	Version     int64
+	ETag        string
 	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Replaced    bool              // Content of an existing file was replaced
+	Verdict     *metadata.Verdict // Pre-commit hook verdict, if hooks ran
 }
 
 // Upload uploads a file.
-func (s *FileService) Upload(ctx context.Context, req *UploadRequest) (*UploadResponse, error) {
-	// Generate file ID
-	fileID := uuid.New().String()
+// If a file already exists at the target path, req.OnConflict decides
+// whether it is overwritten, the upload fails or a free name is chosen.
+func (s *FileService) Upload(ctx context.Context, req *UploadRequest) (_ *UploadResponse, err error) {
+	ctx, span := startSpan(ctx, "Upload", attribute.String("file.path", req.Path), attribute.String("file.name", req.Name))
+	defer func() { tracing.End(span, err) }()
 
-	s.logger.Info("uploading file",
-		zap.String("file_id", fileID),
+	policy, err := ParseConflictPolicy(string(req.OnConflict))
+	if err != nil {
+		return nil, err
+	}
+
+	s.log(ctx).Info("uploading file",
 		zap.String("path", req.Path),
 		zap.String("name", req.Name),
 		zap.Int64("size", req.Size),
+		zap.String("on_conflict", string(policy)),
 	)
 
-	// Calculate hash while uploading
-	hashReader := newHashingReader(req.Content)
+	dir := CleanPath(req.Path)
+	name := req.Name
+	if name == "" || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return nil, errors.E("FileService.Upload", errors.ErrInvalidInput, nil, "invalid file name")
+	}
 
-	// Store the file
-	if err := s.storage.Put(ctx, fileID, hashReader, req.Size); err != nil {
-		s.logger.Error("failed to store file", zap.Error(err))
-		return nil, errors.E("FileService.Upload", errors.ErrStorageFull, err)
+	// Fail early, before the content is staged. A renamed upload only
+	// needs write access to the directory; its final path is checked once
+	// it is chosen.
+	if policy == ConflictRename {
+		err = s.authorize(ctx, "FileService.Upload", dir, "", metadata.PermWrite)
+	} else {
+		err = s.CheckAccess(ctx, filepath.Join(dir, name), metadata.PermWrite)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	contentHash := hashReader.Hash()
+	staged, err := s.stage(ctx, req.Content, req.Size, req.Digests)
+	if err != nil {
+		return nil, stageError("FileService.Upload", err)
+	}
+
+	proposed := metadata.NewFileMetadata("", name, filepath.Join(dir, name))
+	proposed.MimeType = detectMimeType(req.MimeType, name)
+	proposed.OwnerID = req.OwnerID
+	proposed.OriginRegion = s.regionID
+	verdict, err := s.checkContent(ctx, "FileService.Upload", staged, proposed)
+	if err != nil {
+		s.discard(ctx, staged)
+		return nil, err
+	}
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	if err := s.checkTarget(ctx, filepath.Join(dir, name)); err != nil {
+		s.discard(ctx, staged)
+		return nil, err
+	}
+
+	existing, err := s.metadata.GetByPath(ctx, filepath.Join(dir, name))
+	if err != nil && !errors.IsNotFound(err) {
+		s.discard(ctx, staged)
+		return nil, errors.E("FileService.Upload", errors.ErrInvalidMetadata, err)
+	}
+
+	if err := checkPreconditions("FileService.Upload", existing, req.IfMatch, req.IfNoneMatch); err != nil {
+		s.discard(ctx, staged)
+		return nil, err
+	}
+
+	if existing != nil && policy == ConflictOverwrite {
+		if err := s.authorizeFile(ctx, "FileService.Upload", existing, metadata.PermWrite); err != nil {
+			s.discard(ctx, staged)
+			return nil, err
+		}
+	}
 
-	// Detect MIME type if not provided
-	mimeType := req.MimeType
-	if mimeType == "" {
-		mimeType = mime.TypeByExtension(filepath.Ext(req.Name))
-		if mimeType == "" {
-			mimeType = "application/octet-stream"
+	if existing != nil {
+		switch policy {
+		case ConflictFail:
+			s.discard(ctx, staged)
+			return nil, errors.E("FileService.Upload", errors.ErrAlreadyExists, nil, "file already exists at "+existing.Path)
+		case ConflictRename:
+			if name, err = s.freeName(ctx, dir, name); err != nil {
+				s.discard(ctx, staged)
+				return nil, errors.E("FileService.Upload", errors.ErrInvalidMetadata, err)
+			}
+			if err := s.authorize(ctx, "FileService.Upload", filepath.Join(dir, name), "", metadata.PermWrite); err != nil {
+				s.discard(ctx, staged)
+				return nil, err
+			}
+		default:
+			return s.replaceContent(ctx, "FileService.Upload", existing, staged, verdict, req.MimeType, req.OwnerID)
 		}
 	}
 
+	// Generate file ID
+	fileID := uuid.New().String()
+	ctx = logger.ContextWith(ctx, logger.FileID(fileID))
+
+	// Move the staged content into place
+	if err := s.storage.Rename(ctx, staged.key, fileID); err != nil {
+		s.discard(ctx, staged)
+		s.log(ctx).Error("failed to store file", zap.Error(err))
+		return nil, errors.E("FileService.Upload", errors.ErrStorageFull, err)
+	}
+
 	// Create metadata
-	fullPath := filepath.Join(req.Path, req.Name)
-	meta := metadata.NewFileMetadata(fileID, req.Name, fullPath)
-	meta.Size = req.Size
-	meta.ContentHash = contentHash
-	meta.MimeType = mimeType
+	fullPath := filepath.Join(dir, name)
+	meta := metadata.NewFileMetadata(fileID, name, fullPath)
+	meta.Size = staged.size
+	meta.ContentHash = staged.hash
+	meta.Digests = staged.digests
+	meta.MimeType = detectMimeType(req.MimeType, name)
 	meta.OwnerID = req.OwnerID
 	meta.OriginRegion = s.regionID
 	meta.CreatedBy = req.OwnerID
 	meta.UpdatedBy = req.OwnerID
 	meta.IncrementClock(s.regionID)
+	applyVerdict(meta, verdict)
 
 	// Save metadata
 	if err := s.metadata.Save(ctx, meta); err != nil {
 		// Try to clean up the stored file
 		_ = s.storage.Delete(ctx, fileID)
-		s.logger.Error("failed to save metadata", zap.Error(err))
+		s.log(ctx).Error("failed to save metadata", zap.Error(err))
 		return nil, errors.E("FileService.Upload", errors.ErrInvalidMetadata, err)
 	}
 
-	s.logger.Info("file uploaded successfully",
-		zap.String("file_id", fileID),
-		zap.String("content_hash", contentHash),
+	s.notify(ctx, regionsync.ChangeTypeCreate, meta)
+
+	s.log(ctx).Info("file uploaded successfully",
+		zap.String("path", fullPath),
+		zap.String("content_hash", staged.hash),
 	)
 
+	return newUploadResponse(meta, false), nil
+}
+
+// UpdateRequest represents a request to replace the content of a file.
+type UpdateRequest struct {
+	FileID   string
+	Size     int64 // Declared content length, or -1 if unknown
+	Content  io.Reader
+	MimeType string
+	UserID   string
+	IfMatch  string  // Expected ETag(s) of the current version, if set
+	Digests  Digests // Digests claimed by the client, verified as in Upload
+}
+
+// Update replaces the content of an existing file, producing a new version.
+func (s *FileService) Update(ctx context.Context, req *UpdateRequest) (_ *UploadResponse, err error) {
+	ctx, span := startSpan(ctx, "Update", attribute.String("file.id", req.FileID))
+	defer func() { tracing.End(span, err) }()
+
+	meta, err := s.getLive(ctx, req.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail early, before the content is staged
+	if err := s.authorizeFile(ctx, "FileService.Update", meta, metadata.PermWrite); err != nil {
+		return nil, err
+	}
+	if err := checkPreconditions("FileService.Update", meta, req.IfMatch, ""); err != nil {
+		return nil, err
+	}
+
+	staged, err := s.stage(ctx, req.Content, req.Size, req.Digests)
+	if err != nil {
+		return nil, stageError("FileService.Update", err)
+	}
+
+	proposed := *meta
+	if req.MimeType != "" {
+		proposed.MimeType = req.MimeType
+	}
+	proposed.UpdatedBy = req.UserID
+	verdict, err := s.checkContent(ctx, "FileService.Update", staged, &proposed)
+	if err != nil {
+		s.discard(ctx, staged)
+		return nil, err
+	}
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	// Re-read under the lock, the file may have changed while staging
+	meta, err = s.getLive(ctx, req.FileID)
+	if err != nil {
+		s.discard(ctx, staged)
+		return nil, err
+	}
+	if err := checkPreconditions("FileService.Update", meta, req.IfMatch, ""); err != nil {
+		s.discard(ctx, staged)
+		return nil, err
+	}
+
+	return s.replaceContent(ctx, "FileService.Update", meta, staged, verdict, req.MimeType, req.UserID)
+}
+
+// replaceContent commits staged content as the new version of an existing file,
+// with the verdict of the pre-commit hooks. The previous content is restored
+// if the metadata fails to save. Must be called with commitMu held.
+func (s *FileService) replaceContent(ctx context.Context, op string, meta *metadata.FileMetadata, staged *stagedContent, verdict *metadata.Verdict, mimeType, userID string) (*UploadResponse, error) {
+	ctx = logger.ContextWith(ctx, logger.FileID(meta.ID))
+	backup, err := s.swapContent(ctx, staged, meta.ID)
+	if err != nil {
+		s.discard(ctx, staged)
+		s.log(ctx).Error("failed to replace file content", zap.Error(err))
+		return nil, errors.E(op, errors.ErrStorageFull, err)
+	}
+
+	meta.Size = staged.size
+	meta.ContentHash = staged.hash
+	meta.Digests = staged.digests
+	if mimeType != "" {
+		meta.MimeType = mimeType
+	}
+	meta.UpdatedBy = userID
+	meta.LocalState = metadata.LocalStatePresent
+	meta.SyncState = metadata.SyncStatePending
+	meta.IncrementClock(s.regionID)
+	applyVerdict(meta, verdict)
+
+	if err := s.metadata.Save(ctx, meta); err != nil {
+		s.restoreContent(ctx, meta.ID, backup)
+		s.log(ctx).Error("failed to save metadata", zap.Error(err))
+		return nil, errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	s.dropContent(ctx, backup)
+
+	s.notify(ctx, regionsync.ChangeTypeUpdate, meta)
+
+	s.log(ctx).Info("file content replaced",
+		zap.Int64("version", meta.Version),
+		zap.String("content_hash", meta.ContentHash),
+	)
+
+	return newUploadResponse(meta, true), nil
+}
+
+// checkTarget verifies that a file may be stored at the given path: the path
+// must not be a directory and none of its ancestors may be a file.
+func (s *FileService) checkTarget(ctx context.Context, fullPath string) error {
+	isDir, err := s.metadata.IsDir(ctx, fullPath)
+	if err != nil {
+		return errors.E("FileService.checkTarget", errors.ErrInvalidMetadata, err)
+	}
+	if isDir {
+		return errors.E("FileService.checkTarget", errors.ErrConflict, nil, fullPath+" is a directory")
+	}
+
+	for dir := filepath.Dir(fullPath); dir != "/"; dir = filepath.Dir(dir) {
+		_, err := s.metadata.GetByPath(ctx, dir)
+		if err == nil {
+			return errors.E("FileService.checkTarget", errors.ErrConflict, nil, dir+" is a file")
+		}
+		if !errors.IsNotFound(err) {
+			return errors.E("FileService.checkTarget", errors.ErrInvalidMetadata, err)
+		}
+	}
+
+	return nil
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match conditions against
+// the current version of a file, which is nil when no file exists.
+func checkPreconditions(op string, meta *metadata.FileMetadata, ifMatch, ifNoneMatch string) error {
+	etag := ""
+	if meta != nil {
+		etag = meta.ETag()
+	}
+
+	if ifMatch != "" && (meta == nil || !ETagMatches(ifMatch, etag, false)) {
+		return errors.E(op, errors.ErrVersionMismatch, nil, "If-Match precondition failed")
+	}
+	if ifNoneMatch != "" && meta != nil && ETagMatches(ifNoneMatch, etag, true) {
+		return errors.E(op, errors.ErrVersionMismatch, nil, "If-None-Match precondition failed")
+	}
+
+	return nil
+}
+
+// ETagMatches reports whether an If-Match or If-None-Match header value
+// matches etag. "*" matches any existing entity. Weak comparison ignores
+// the W/ prefix, as used by If-None-Match; strong comparison never matches
+// weak tags.
+func ETagMatches(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getLive retrieves metadata of a file that has not been deleted.
+func (s *FileService) getLive(ctx context.Context, fileID string) (*metadata.FileMetadata, error) {
+	meta, err := s.metadata.Get(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if meta.LocalState == metadata.LocalStateDeleted {
+		return nil, errors.E("FileService.getLive", errors.ErrNotFound, nil, "file deleted")
+	}
+	return meta, nil
+}
+
+// freeName returns the first name of the form "name (n).ext" not used in dir.
+func (s *FileService) freeName(ctx context.Context, dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		_, err := s.metadata.GetByPath(ctx, filepath.Join(dir, candidate))
+		if errors.IsNotFound(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func newUploadResponse(meta *metadata.FileMetadata, replaced bool) *UploadResponse {
 	return &UploadResponse{
-		FileID:      fileID,
-		Path:        fullPath,
-		Size:        req.Size,
-		ContentHash: contentHash,
+		FileID:      meta.ID,
+		Path:        meta.Path,
+		Size:        meta.Size,
+		ContentHash: meta.ContentHash,
 		Version:     meta.Version,
+		ETag:        meta.ETag(),
 		CreatedAt:   meta.CreatedAt,
-	}, nil
+		UpdatedAt:   meta.UpdatedAt,
+		Replaced:    replaced,
+		Verdict:     meta.Verdict,
+	}
+}
+
+// detectMimeType returns the given MIME type or one guessed from the file name.
+func detectMimeType(mimeType, name string) string {
+	if mimeType != "" {
+		return mimeType
+	}
+	if mimeType = mime.TypeByExtension(filepath.Ext(name)); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
 }
 
 // DownloadResponse represents a file download response.
@@ -132,14 +531,23 @@ type DownloadResponse struct {
 }
 
 // Download downloads a file by ID.
-func (s *FileService) Download(ctx context.Context, fileID string) (*DownloadResponse, error) {
+func (s *FileService) Download(ctx context.Context, fileID string) (_ *DownloadResponse, err error) {
+	ctx, span := startSpan(ctx, "Download", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
 	// Get metadata
 	meta, err := s.metadata.Get(ctx, fileID)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.authorizeFile(ctx, "FileService.Download", meta, metadata.PermRead); err != nil {
+		return nil, err
+	}
 
 	// Check local state
+	if meta.LocalState == metadata.LocalStateQuarantined {
+		return nil, errors.E("FileService.Download", errors.ErrForbidden, nil, "file quarantined")
+	}
 	if meta.LocalState != metadata.LocalStatePresent {
 		// TODO: Trigger fetch from origin region
 		return nil, errors.E("FileService.Download", errors.ErrNotFound, nil, "file not available locally")
@@ -158,17 +566,48 @@ func (s *FileService) Download(ctx context.Context, fileID string) (*DownloadRes
 }
 
 // GetMetadata retrieves file metadata.
-func (s *FileService) GetMetadata(ctx context.Context, fileID string) (*metadata.FileMetadata, error) {
-	return s.metadata.Get(ctx, fileID)
+func (s *FileService) GetMetadata(ctx context.Context, fileID string) (_ *metadata.FileMetadata, err error) {
+	ctx, span := startSpan(ctx, "GetMetadata", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	meta, err := s.metadata.Get(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeFile(ctx, "FileService.GetMetadata", meta, metadata.PermRead); err != nil {
+		return nil, err
+	}
+	return meta, nil
 }
 
 // Delete deletes a file.
 func (s *FileService) Delete(ctx context.Context, fileID string) error {
+	return s.DeleteIfMatch(ctx, fileID, "")
+}
+
+// DeleteIfMatch deletes a file if its current version matches ifMatch,
+// a list of ETags in If-Match syntax. An empty ifMatch always matches.
+func (s *FileService) DeleteIfMatch(ctx context.Context, fileID, ifMatch string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteIfMatch", attribute.String("file.id", fileID))
+	defer func() { tracing.End(span, err) }()
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
 	// Get metadata first
 	meta, err := s.metadata.Get(ctx, fileID)
 	if err != nil {
 		return err
 	}
+	if meta.LocalState == metadata.LocalStateDeleted {
+		return errors.E("FileService.Delete", errors.ErrNotFound, nil, "file already deleted")
+	}
+	if err := s.authorizeFile(ctx, "FileService.Delete", meta, metadata.PermDelete); err != nil {
+		return err
+	}
+	if err := checkPreconditions("FileService.Delete", meta, ifMatch, ""); err != nil {
+		return err
+	}
 
 	// Delete from storage
 	if err := s.storage.Delete(ctx, fileID); err != nil && !errors.IsNotFound(err) {
@@ -184,39 +623,354 @@ func (s *FileService) Delete(ctx context.Context, fileID string) error {
 		return errors.E("FileService.Delete", errors.ErrInvalidMetadata, err)
 	}
 
-	s.logger.Info("file deleted",
-		zap.String("file_id", fileID),
-	)
+	s.notify(ctx, regionsync.ChangeTypeDelete, meta)
+
+	s.log(ctx).Info("file deleted")
 
 	return nil
 }
 
 // ListDirectory lists files in a directory.
-func (s *FileService) ListDirectory(ctx context.Context, path string) ([]*metadata.DirectoryEntry, error) {
+func (s *FileService) ListDirectory(ctx context.Context, path string) (_ []*metadata.DirectoryEntry, err error) {
+	ctx, span := startSpan(ctx, "ListDirectory", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+
+	path = CleanPath(path)
+	if err := s.authorize(ctx, "FileService.ListDirectory", path, "", metadata.PermRead); err != nil {
+		return nil, err
+	}
 	return s.metadata.List(ctx, path)
 }
 
+// PathInfo describes what a path resolves to.
+type PathInfo struct {
+	Path  string
+	IsDir bool
+	File  *metadata.FileMetadata // Set for files only
+}
+
+// Stat resolves a path to a file or a directory. The caller needs read access.
+func (s *FileService) Stat(ctx context.Context, path string) (_ *PathInfo, err error) {
+	ctx, span := startSpan(ctx, "Stat", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+
+	info, err := s.stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerID := ""
+	if info.File != nil {
+		ownerID = info.File.OwnerID
+	}
+	if err := s.authorize(ctx, "FileService.Stat", info.Path, ownerID, metadata.PermRead); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// stat resolves a path without checking access.
+func (s *FileService) stat(ctx context.Context, path string) (*PathInfo, error) {
+	path = CleanPath(path)
+
+	meta, err := s.metadata.GetByPath(ctx, path)
+	if err == nil {
+		return &PathInfo{Path: path, File: meta}, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	isDir, err := s.metadata.IsDir(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir {
+		return nil, errors.E("FileService.Stat", errors.ErrNotFound, nil, path)
+	}
+
+	return &PathInfo{Path: path, IsDir: true}, nil
+}
+
+// DeletePath deletes the file or empty directory at a path.
+// For files, ifMatch is checked as in DeleteIfMatch.
+func (s *FileService) DeletePath(ctx context.Context, path, ifMatch string) (err error) {
+	ctx, span := startSpan(ctx, "DeletePath", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+
+	info, err := s.stat(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir {
+		if err := s.authorize(ctx, "FileService.DeletePath", info.Path, "", metadata.PermDelete); err != nil {
+			return err
+		}
+		s.commitMu.Lock()
+		defer s.commitMu.Unlock()
+		return s.metadata.DeleteDir(ctx, info.Path)
+	}
+
+	return s.DeleteIfMatch(ctx, info.File.ID, ifMatch)
+}
+
+// CleanPath normalizes a path to an absolute, slash separated form.
+func CleanPath(path string) string {
+	return filepath.Clean("/" + path)
+}
+
+// startSpan starts the span of a FileService operation. Operations on a
+// file log its ID with every line.
+func startSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	for _, attr := range attrs {
+		if attr.Key == "file.id" {
+			ctx = logger.ContextWith(ctx, logger.FileID(attr.Value.AsString()))
+		}
+	}
+	return tracing.Start(ctx, "FileService."+operation, trace.WithAttributes(attrs...))
+}
+
+// log returns the logger of the service with the request fields of ctx.
+func (s *FileService) log(ctx context.Context) *zap.Logger {
+	return logger.FromContext(ctx, s.logger)
+}
+
+// notify forwards a change event to the notifier, if any. Changes of
+// quarantined files are withheld, so they are not synced.
+func (s *FileService) notify(ctx context.Context, changeType regionsync.ChangeType, meta *metadata.FileMetadata) {
+	if s.notifier != nil && meta.LocalState != metadata.LocalStateQuarantined {
+		s.notifier.QueueChange(ctx, changeType, meta)
+	}
+}
+
+// stagedContent is uploaded content waiting to be committed under its file ID.
+type stagedContent struct {
+	key     string
+	size    int64
+	hash    string
+	digests map[string]string // Client digests that were verified
+}
+
+// stage streams content to a temporary storage key, computing its hash and size.
+// A non-negative size must match the content length exactly; with a negative
+// size the length is discovered while writing. Expected digests are checked
+// once the content is written, and the staged blob is discarded on mismatch.
+func (s *FileService) stage(ctx context.Context, content io.Reader, size int64, expected Digests) (*stagedContent, error) {
+	key := stagingPrefix + uuid.New().String()
+	if size >= 0 {
+		content = &exactReader{reader: content, remaining: size}
+	}
+	hashReader := newHashingReader(content, expected != nil)
+
+	if err := s.storage.Put(ctx, key, hashReader, size); err != nil {
+		s.log(ctx).Error("failed to stage file", zap.Error(err))
+		return nil, err
+	}
+
+	staged := &stagedContent{
+		key:  key,
+		size: hashReader.Size(),
+		hash: hashReader.Hash(),
+	}
+
+	digests, err := verifyDigests(expected, hashReader)
+	if err != nil {
+		s.log(ctx).Warn("rejecting upload", zap.Error(err))
+		s.discard(ctx, staged)
+		return nil, err
+	}
+	staged.digests = digests
+
+	return staged, nil
+}
+
+// checkContent runs the pre-commit hooks on staged content and returns the
+// verdict to record, or nil without hooks. A rejection is returned as an
+// error; the caller discards the content.
+func (s *FileService) checkContent(ctx context.Context, op string, staged *stagedContent, proposed *metadata.FileMetadata) (*metadata.Verdict, error) {
+	if s.hooks == nil {
+		return nil, nil
+	}
+
+	proposed.Size = staged.size
+	proposed.ContentHash = staged.hash
+	proposed.Digests = staged.digests
+	verdict, err := s.hooks.Check(ctx, &precommit.Upload{
+		Meta: proposed,
+		Open: func(ctx context.Context) (io.ReadCloser, error) {
+			return s.storage.Get(ctx, staged.key)
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(op, err)
+	}
+	if verdict.Action == metadata.VerdictReject {
+		return nil, errors.E(op, errors.ErrRejected, nil, verdict.Hook+": "+verdict.Reason)
+	}
+	return verdict, nil
+}
+
+// applyVerdict records a verdict on a file about to be committed;
+// quarantined content is withheld until replaced.
+func applyVerdict(meta *metadata.FileMetadata, verdict *metadata.Verdict) {
+	meta.Verdict = verdict
+	if verdict != nil && verdict.Action == metadata.VerdictQuarantine {
+		meta.LocalState = metadata.LocalStateQuarantined
+	}
+}
+
+// stageError classifies a staging failure: a body that does not match its
+// declared length is the client's fault, anything else is a storage failure.
+func stageError(op string, err error) error {
+	if errors.IsInvalidInput(err) {
+		return errors.E(op, errors.ErrInvalidInput, err)
+	}
+	return errors.E(op, errors.ErrStorageFull, err)
+}
+
+// discard removes staged content that will not be committed.
+func (s *FileService) discard(ctx context.Context, staged *stagedContent) {
+	if err := s.storage.Delete(ctx, staged.key); err != nil && !errors.IsNotFound(err) {
+		s.log(ctx).Warn("failed to discard staged file",
+			zap.String("key", staged.key),
+			zap.Error(err),
+		)
+	}
+}
+
+// swapContent moves staged content to the storage key of a file, setting its
+// previous content aside under a staging key until the metadata commit
+// settles. The returned key is empty when the file had no local content.
+func (s *FileService) swapContent(ctx context.Context, staged *stagedContent, fileID string) (string, error) {
+	backup := stagingPrefix + uuid.New().String()
+	if err := s.storage.Rename(ctx, fileID, backup); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		backup = ""
+	}
+
+	if err := s.storage.Rename(ctx, staged.key, fileID); err != nil {
+		s.restoreContent(ctx, fileID, backup)
+		return "", err
+	}
+	return backup, nil
+}
+
+// restoreContent puts the content set aside by swapContent back in place,
+// after the metadata of the new content failed to commit.
+func (s *FileService) restoreContent(ctx context.Context, fileID, backup string) {
+	var err error
+	if backup == "" {
+		err = s.storage.Delete(ctx, fileID)
+	} else {
+		err = s.storage.Rename(ctx, backup, fileID)
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		s.log(ctx).Error("failed to restore previous file content",
+			logger.FileID(fileID),
+			zap.String("backup", backup),
+			zap.Error(err),
+		)
+	}
+}
+
+// dropContent removes the content set aside by swapContent once the new
+// content is committed.
+func (s *FileService) dropContent(ctx context.Context, backup string) {
+	if backup == "" {
+		return
+	}
+	if err := s.storage.Delete(ctx, backup); err != nil && !errors.IsNotFound(err) {
+		s.log(ctx).Warn("failed to remove previous file content",
+			zap.String("key", backup),
+			zap.Error(err),
+		)
+	}
+}
+
+// stagingPrefix prefixes storage keys of uncommitted content.
+const stagingPrefix = "staging-"
+
 // hashingReader wraps a reader to compute hash while reading.
 type hashingReader struct {
 	reader io.Reader
 	hasher hash.Hash
+	md5    hash.Hash // Only computed when client digests are verified
+	size   int64
 }
 
-func newHashingReader(r io.Reader) *hashingReader {
-	h := sha256.New()
-	return &hashingReader{
-		reader: io.TeeReader(r, h),
-		hasher: h,
+func newHashingReader(r io.Reader, withMD5 bool) *hashingReader {
+	h := &hashingReader{hasher: sha256.New()}
+	if withMD5 {
+		h.md5 = md5.New()
+		h.reader = io.TeeReader(r, io.MultiWriter(h.hasher, h.md5))
+	} else {
+		h.reader = io.TeeReader(r, h.hasher)
 	}
+	return h
 }
 
 func (h *hashingReader) Read(p []byte) (n int, err error) {
-	return h.reader.Read(p)
+	n, err = h.reader.Read(p)
+	h.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes read so far.
+func (h *hashingReader) Size() int64 {
+	return h.size
 }
 
 func (h *hashingReader) Hash() string {
 	return hex.EncodeToString(h.hasher.Sum(nil))
 }
 
-// Ensure hashingReader implements io.Reader
-var _ io.Reader = (*hashingReader)(nil)
+// Sum returns the digest of the content read so far, or nil if the
+// algorithm was not computed.
+func (h *hashingReader) Sum(algorithm DigestAlgorithm) []byte {
+	switch {
+	case algorithm == DigestSHA256:
+		return h.hasher.Sum(nil)
+	case algorithm == DigestMD5 && h.md5 != nil:
+		return h.md5.Sum(nil)
+	default:
+		return nil
+	}
+}
+
+// exactReader fails a read when the content is shorter or longer than declared.
+type exactReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *exactReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		// Probe for trailing bytes beyond the declared length
+		var probe [1]byte
+		n, err := r.reader.Read(probe[:])
+		if n > 0 {
+			return 0, errors.E("FileService.stage", errors.ErrInvalidInput, nil, "content longer than declared size")
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		return n, errors.E("FileService.stage", errors.ErrInvalidInput, io.ErrUnexpectedEOF, "content shorter than declared size")
+	}
+	return n, err
+}
+
+// Ensure hashingReader and exactReader implement io.Reader
+var (
+	_ io.Reader = (*hashingReader)(nil)
+	_ io.Reader = (*exactReader)(nil)
+)
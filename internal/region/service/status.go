@@ -0,0 +1,166 @@
+// Package service provides the status report of a region.
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/storage"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+)
+
+// Region states.
+const (
+	StateHealthy  = "healthy"
+	StateDegraded = "degraded"
+)
+
+// Sync states of a region.
+const (
+	SyncConnected    = "connected"
+	SyncDisconnected = "disconnected"
+	SyncDisabled     = "disabled" // No coordinator configured
+)
+
+// queueHighWater is the share of the sync queue capacity past which a
+// region is degraded: new changes are about to be dropped.
+const queueHighWater = 0.9
+
+// StatusConfig holds the thresholds past which a region reports itself
+// degraded. Zero disables a check.
+type StatusConfig struct {
+	MaxContactAge time.Duration // Since the last successful coordinator call
+	MaxSyncLag    time.Duration // Age of the oldest change not yet pushed
+	MinFreeRatio  float64       // Of storage capacity
+}
+
+// SyncStatus reports the state of the sync agent.
+type SyncStatus interface {
+	Status() regionsync.AgentStatus
+}
+
+// StoreStatter reports the size of a metadata store.
+type StoreStatter interface {
+	Stats() metadata.StoreStats
+}
+
+// RegionStatus is the state of a region, with the reasons it is degraded.
+type RegionStatus struct {
+	RegionID  string                     `json:"region_id"`
+	Status    string                     `json:"status"` // healthy, degraded
+	Reasons   []string                   `json:"reasons,omitempty"`
+	SyncState string                     `json:"sync_state"` // connected, disconnected, disabled
+	Storage   *storage.Usage             `json:"storage,omitempty"`
+	Metadata  *metadata.StoreStats       `json:"metadata,omitempty"`
+	Files     map[metadata.SyncState]int `json:"files"` // By sync state, tombstones included
+	Sync      *regionsync.AgentStatus    `json:"sync,omitempty"`
+	CheckedAt time.Time                  `json:"checked_at"`
+}
+
+// StatusReporter assembles the status of a region from its storage,
+// metadata store and sync agent.
+type StatusReporter struct {
+	regionID string
+	storage  storage.Backend
+	metadata metadata.Store
+	sync     SyncStatus // Nil without a sync agent
+	config   StatusConfig
+	logger   *zap.Logger
+}
+
+// NewStatusReporter creates a StatusReporter. The sync agent may be nil.
+func NewStatusReporter(regionID string, storageBackend storage.Backend, metaStore metadata.Store, agent SyncStatus, cfg StatusConfig) *StatusReporter {
+	return &StatusReporter{
+		regionID: regionID,
+		storage:  storageBackend,
+		metadata: metaStore,
+		sync:     agent,
+		config:   cfg,
+		logger:   logger.WithComponent("StatusReporter"),
+	}
+}
+
+// Status checks the region. Parts that cannot be checked are left out
+// and make the region degraded.
+func (r *StatusReporter) Status(ctx context.Context) *RegionStatus {
+	status := &RegionStatus{
+		RegionID:  r.regionID,
+		SyncState: SyncDisabled,
+		CheckedAt: time.Now(),
+	}
+
+	usage, err := r.storage.Usage(ctx)
+	switch {
+	case err == nil:
+		status.Storage = usage
+		if usage.TotalBytes > 0 && float64(usage.FreeBytes) < r.config.MinFreeRatio*float64(usage.TotalBytes) {
+			status.degrade("storage has %d of %d bytes free", usage.FreeBytes, usage.TotalBytes)
+		}
+	case !stderrors.Is(err, errors.ErrNotImplemented):
+		logger.FromContext(ctx, r.logger).Warn("failed to check storage usage", zap.Error(err))
+		status.degrade("storage usage unavailable")
+	}
+
+	if statter, ok := r.metadata.(StoreStatter); ok {
+		stats := statter.Stats()
+		status.Metadata = &stats
+	}
+
+	counts, err := r.metadata.CountByState(ctx)
+	if err != nil {
+		logger.FromContext(ctx, r.logger).Warn("failed to count files by sync state", zap.Error(err))
+		status.degrade("metadata store unavailable")
+	} else {
+		status.Files = counts
+		if n := counts[metadata.SyncStateConflict]; n > 0 {
+			status.degrade("%d files conflict with the global version", n)
+		}
+	}
+
+	if r.sync != nil {
+		r.checkSync(status, r.sync.Status())
+	}
+
+	status.Status = StateHealthy
+	if len(status.Reasons) > 0 {
+		status.Status = StateDegraded
+	}
+	return status
+}
+
+// checkSync adds the state of the sync agent to a status.
+func (r *StatusReporter) checkSync(status *RegionStatus, sync regionsync.AgentStatus) {
+	status.Sync = &sync
+
+	if sync.QueueCapacity > 0 && float64(sync.QueueDepth) >= queueHighWater*float64(sync.QueueCapacity) {
+		status.degrade("sync queue holds %d of %d changes", sync.QueueDepth, sync.QueueCapacity)
+	}
+	if r.config.MaxSyncLag > 0 && sync.Lag > r.config.MaxSyncLag {
+		status.degrade("oldest unsynced change is %v old", sync.Lag.Round(time.Second))
+	}
+	if !sync.Coordinator {
+		return
+	}
+
+	status.SyncState = SyncConnected
+	switch {
+	case sync.LastContactAt.IsZero():
+		status.SyncState = SyncDisconnected
+		status.degrade("coordinator not reached yet")
+	case r.config.MaxContactAge > 0 && status.CheckedAt.Sub(sync.LastContactAt) > r.config.MaxContactAge:
+		status.SyncState = SyncDisconnected
+		status.degrade("coordinator not reached for %v", status.CheckedAt.Sub(sync.LastContactAt).Round(time.Second))
+	}
+}
+
+// degrade records a reason the region is degraded.
+func (s *RegionStatus) degrade(format string, args ...any) {
+	s.Reasons = append(s.Reasons, fmt.Sprintf(format, args...))
+}
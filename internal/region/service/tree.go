@@ -0,0 +1,273 @@
+// Package service provides directory creation, renames and recursive
+// deletion.
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/tracing"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+)
+
+// Mkdir creates a directory whose parent exists. The caller needs write
+// access to the new directory.
+func (s *FileService) Mkdir(ctx context.Context, path string) (err error) {
+	ctx, span := startSpan(ctx, "Mkdir", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+
+	const op = "FileService.Mkdir"
+	path = CleanPath(path)
+
+	if err := s.authorize(ctx, op, path, "", metadata.PermWrite); err != nil {
+		return err
+	}
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	if _, err := s.stat(ctx, path); err == nil {
+		return errors.E(op, errors.ErrAlreadyExists, nil, path+" already exists")
+	} else if !errors.IsNotFound(err) {
+		return errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	if err := s.checkParent(ctx, op, path); err != nil {
+		return err
+	}
+
+	if err := s.metadata.MkdirAll(ctx, path); err != nil {
+		return errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	return nil
+}
+
+// Move renames the file or directory at src to dst, whose parent must be
+// an existing directory and which must not exist yet. The caller needs
+// delete access to src and everything below it, and write access to dst,
+// which owning a moved file does not grant.
+//
+// Moved files keep their IDs and content; each is saved as a new version
+// and reported as an update, which notification subscribers see as a
+// rename. Paths carrying an ACL are not moved: the ACL would stay at the
+// old path, leaving the moved files unprotected. Quarantined files are not
+// moved, so a directory holding them remains at its old path as well.
+func (s *FileService) Move(ctx context.Context, src, dst, userID string) (_ *PathInfo, err error) {
+	ctx, span := startSpan(ctx, "Move", attribute.String("file.path", src), attribute.String("file.new_path", dst))
+	defer func() { tracing.End(span, err) }()
+
+	const op = "FileService.Move"
+	src, dst = CleanPath(src), CleanPath(dst)
+
+	if src == "/" || dst == "/" {
+		return nil, errors.E(op, errors.ErrInvalidInput, nil, "cannot move the root directory")
+	}
+	if dst == src || strings.HasPrefix(dst, src+"/") {
+		return nil, errors.E(op, errors.ErrInvalidInput, nil, "cannot move "+src+" into itself")
+	}
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
+	info, err := s.stat(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.stat(ctx, dst); err == nil {
+		return nil, errors.E(op, errors.ErrAlreadyExists, nil, dst+" already exists")
+	} else if !errors.IsNotFound(err) {
+		return nil, errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	if err := s.checkParent(ctx, op, dst); err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir {
+		if err := s.authorizeFile(ctx, op, info.File, metadata.PermDelete); err != nil {
+			return nil, err
+		}
+		if err := s.authorize(ctx, op, dst, "", metadata.PermWrite); err != nil {
+			return nil, err
+		}
+		if err := s.checkNoACL(ctx, op, src); err != nil {
+			return nil, err
+		}
+		s.relocate(info.File, dst, userID)
+		if err := s.metadata.Save(ctx, info.File); err != nil {
+			return nil, errors.E(op, errors.ErrInvalidMetadata, err)
+		}
+		s.notify(ctx, regionsync.ChangeTypeUpdate, info.File)
+		s.log(ctx).Info("file moved",
+			zap.String("file_id", info.File.ID),
+			zap.String("from", src),
+			zap.String("to", dst),
+		)
+		return &PathInfo{Path: dst, File: info.File}, nil
+	}
+
+	if err := s.authorize(ctx, op, src, "", metadata.PermDelete); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, op, dst, "", metadata.PermWrite); err != nil {
+		return nil, err
+	}
+	files, dirs, err := s.walk(ctx, src)
+	if err != nil {
+		return nil, errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	for _, meta := range files {
+		if err := s.authorizeFile(ctx, op, meta, metadata.PermDelete); err != nil {
+			return nil, err
+		}
+		if err := s.checkNoACL(ctx, op, meta.Path); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range dirs {
+		if err := s.checkNoACL(ctx, op, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, meta := range files {
+		s.relocate(meta, dst+strings.TrimPrefix(meta.Path, src), userID)
+	}
+	if err := s.metadata.SaveBatch(ctx, files); err != nil {
+		return nil, errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	for _, dir := range dirs {
+		if err := s.metadata.MkdirAll(ctx, dst+strings.TrimPrefix(dir, src)); err != nil {
+			return nil, errors.E(op, errors.ErrInvalidMetadata, err)
+		}
+	}
+	for _, meta := range files {
+		s.notify(ctx, regionsync.ChangeTypeUpdate, meta)
+	}
+
+	// Remove the old directories, deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := s.metadata.DeleteDir(ctx, dirs[i]); err != nil {
+			s.log(ctx).Warn("keeping directory after move",
+				zap.String("path", dirs[i]),
+				zap.Error(err),
+			)
+		}
+	}
+
+	s.log(ctx).Info("directory moved",
+		zap.String("from", src),
+		zap.String("to", dst),
+		zap.Int("files", len(files)),
+	)
+	return &PathInfo{Path: dst, IsDir: true}, nil
+}
+
+// RemoveAll deletes the file or directory at a path, including everything
+// below it. The caller needs delete access to each file and directory.
+// Deletion stops at the first failure, leaving the rest in place.
+func (s *FileService) RemoveAll(ctx context.Context, path string) (err error) {
+	ctx, span := startSpan(ctx, "RemoveAll", attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+
+	const op = "FileService.RemoveAll"
+
+	info, err := s.stat(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return s.DeleteIfMatch(ctx, info.File.ID, "")
+	}
+	if info.Path == "/" {
+		return errors.E(op, errors.ErrInvalidInput, nil, "cannot delete root directory")
+	}
+	if err := s.authorize(ctx, op, info.Path, "", metadata.PermDelete); err != nil {
+		return err
+	}
+
+	entries, err := s.metadata.List(ctx, info.Path)
+	if err != nil {
+		return errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir {
+			err = s.RemoveAll(ctx, entry.Path)
+		} else {
+			err = s.DeleteIfMatch(ctx, entry.ID, "")
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+	return s.metadata.DeleteDir(ctx, info.Path)
+}
+
+// checkNoACL refuses to move a path that carries an ACL.
+func (s *FileService) checkNoACL(ctx context.Context, op, path string) error {
+	_, err := s.metadata.GetACL(ctx, path)
+	if err == nil {
+		return errors.E(op, errors.ErrConflict, nil, path+" has an ACL, remove it before moving")
+	}
+	if !errors.IsNotFound(err) {
+		return errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	return nil
+}
+
+// checkParent verifies that the parent of path is an existing directory.
+func (s *FileService) checkParent(ctx context.Context, op, path string) error {
+	parent := filepath.Dir(path)
+	isDir, err := s.metadata.IsDir(ctx, parent)
+	if err != nil {
+		return errors.E(op, errors.ErrInvalidMetadata, err)
+	}
+	if !isDir {
+		return errors.E(op, errors.ErrNotFound, nil, "directory "+parent+" does not exist")
+	}
+	return nil
+}
+
+// relocate points a file at a new path, as a new version.
+func (s *FileService) relocate(meta *metadata.FileMetadata, path, userID string) {
+	meta.Path = path
+	meta.Name = filepath.Base(path)
+	meta.UpdatedBy = userID
+	meta.SyncState = metadata.SyncStatePending
+	meta.IncrementClock(s.regionID)
+}
+
+// walk returns the listed files below a directory, and the directory with
+// its subdirectories in breadth-first order, so parents come before their
+// children.
+func (s *FileService) walk(ctx context.Context, dir string) ([]*metadata.FileMetadata, []string, error) {
+	var files []*metadata.FileMetadata
+	dirs := []string{dir}
+
+	for i := 0; i < len(dirs); i++ {
+		entries, err := s.metadata.List(ctx, dirs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir {
+				dirs = append(dirs, entry.Path)
+				continue
+			}
+			meta, err := s.metadata.Get(ctx, entry.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			files = append(files, meta)
+		}
+	}
+
+	return files, dirs, nil
+}
@@ -15,6 +15,13 @@ type FileInfo struct {
 	IsDir   bool
 }
 
+// Usage represents the capacity of the volume a backend stores files on.
+type Usage struct {
+	TotalBytes int64 `json:"total_bytes"`
+	UsedBytes  int64 `json:"used_bytes"`
+	FreeBytes  int64 `json:"free_bytes"` // Available to the backend
+}
+
 // Backend defines the interface for file storage backends.
 type Backend interface {
 	// Put stores a file.
@@ -26,6 +33,9 @@ type Backend interface {
 	// Delete removes a file.
 	Delete(ctx context.Context, key string) error
 
+	// Rename moves a file to a new key, replacing any file stored there.
+	Rename(ctx context.Context, oldKey, newKey string) error
+
 	// Exists checks if a file exists.
 	Exists(ctx context.Context, key string) (bool, error)
 
@@ -35,6 +45,10 @@ type Backend interface {
 	// List lists files with the given prefix.
 	List(ctx context.Context, prefix string) ([]*FileInfo, error)
 
+	// Usage returns the capacity of the underlying volume. It fails with
+	// ErrNotImplemented where the capacity cannot be determined.
+	Usage(ctx context.Context) (*Usage, error)
+
 	// Close closes the backend.
 	Close() error
 }
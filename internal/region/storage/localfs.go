@@ -106,6 +106,25 @@ func (b *LocalFSBackend) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// Rename moves a file to a new key, replacing any file stored there.
+func (b *LocalFSBackend) Rename(ctx context.Context, oldKey, newKey string) error {
+	oldPath := b.keyToPath(oldKey)
+	newPath := b.keyToPath(newKey)
+
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		if os.IsNotExist(err) {
+			return errors.ErrNotFound
+		}
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+
+	return nil
+}
+
 // Exists checks if a file exists.
 func (b *LocalFSBackend) Exists(ctx context.Context, key string) (bool, error) {
 	filePath := b.keyToPath(key)
@@ -104,6 +104,52 @@ func TestLocalFSBackend(t *testing.T) {
 			t.Error("Delete should fail for non-existent key")
 		}
 	})
+
+	t.Run("Usage", func(t *testing.T) {
+		usage, err := backend.Usage(ctx)
+		if err != nil {
+			t.Fatalf("Usage failed: %v", err)
+		}
+		if usage.TotalBytes <= 0 || usage.FreeBytes > usage.TotalBytes || usage.UsedBytes > usage.TotalBytes {
+			t.Errorf("Usage() = %+v, want consistent capacity", usage)
+		}
+	})
+}
+
+func TestLocalFSBackend_Rename(t *testing.T) {
+	backend, err := NewLocalFSBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	defer backend.Close()
+
+	ctx := context.Background()
+
+	backend.Put(ctx, "staged", bytes.NewReader([]byte("new")), 3)
+	backend.Put(ctx, "target", bytes.NewReader([]byte("old")), 3)
+
+	if err := backend.Rename(ctx, "staged", "target"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	if exists, _ := backend.Exists(ctx, "staged"); exists {
+		t.Error("old key should not exist after Rename")
+	}
+
+	reader, err := backend.Get(ctx, "target")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer reader.Close()
+
+	content, _ := io.ReadAll(reader)
+	if string(content) != "new" {
+		t.Errorf("content = %q, want %q", content, "new")
+	}
+
+	if err := backend.Rename(ctx, "non-existent-key", "target"); err == nil {
+		t.Error("Rename should fail for non-existent key")
+	}
 }
 
 func TestLocalFSBackend_List(t *testing.T) {
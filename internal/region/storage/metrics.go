@@ -0,0 +1,150 @@
+// Package storage provides the Prometheus instrumentation of storage
+// backends.
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"asisaid.cn/JzSE/internal/common/metrics"
+)
+
+// InstrumentedBackend records the latency and errors of the operations of
+// a Backend, and the bytes written to and read from it.
+type InstrumentedBackend struct {
+	backend    Backend
+	operations *metrics.Operations
+	uploaded   prometheus.Counter
+	downloaded prometheus.Counter
+}
+
+var _ Backend = (*InstrumentedBackend)(nil)
+
+// NewInstrumentedBackend wraps a backend and registers its metrics.
+func NewInstrumentedBackend(backend Backend, reg prometheus.Registerer) (*InstrumentedBackend, error) {
+	operations, err := metrics.NewOperations(reg, "storage", "storage backend")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &InstrumentedBackend{
+		backend:    backend,
+		operations: operations,
+		uploaded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "storage",
+			Name:      "uploaded_bytes_total",
+			Help:      "Bytes of content stored.",
+		}),
+		downloaded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "storage",
+			Name:      "downloaded_bytes_total",
+			Help:      "Bytes of content read back.",
+		}),
+	}
+	if err := metrics.Register(reg, b.uploaded, b.downloaded); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Put stores a file, counting the bytes consumed from reader.
+func (b *InstrumentedBackend) Put(ctx context.Context, key string, reader io.Reader, size int64) (err error) {
+	defer b.observe("put", time.Now(), &err)
+	return b.backend.Put(ctx, key, &countingReader{Reader: reader, counter: b.uploaded}, size)
+}
+
+// Get retrieves a file. Bytes are counted as they are read; the latency
+// is that of opening the file. Content that can seek still can.
+func (b *InstrumentedBackend) Get(ctx context.Context, key string) (rc io.ReadCloser, err error) {
+	defer b.observe("get", time.Now(), &err)
+	rc, err = b.backend.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	counted := &countingReadCloser{ReadCloser: rc, counter: b.downloaded}
+	if seeker, ok := rc.(io.Seeker); ok {
+		return &countingReadSeekCloser{countingReadCloser: counted, Seeker: seeker}, nil
+	}
+	return counted, nil
+}
+
+// Delete removes a file.
+func (b *InstrumentedBackend) Delete(ctx context.Context, key string) (err error) {
+	defer b.observe("delete", time.Now(), &err)
+	return b.backend.Delete(ctx, key)
+}
+
+// Rename moves a file to a new key.
+func (b *InstrumentedBackend) Rename(ctx context.Context, oldKey, newKey string) (err error) {
+	defer b.observe("rename", time.Now(), &err)
+	return b.backend.Rename(ctx, oldKey, newKey)
+}
+
+// Exists checks if a file exists.
+func (b *InstrumentedBackend) Exists(ctx context.Context, key string) (exists bool, err error) {
+	defer b.observe("exists", time.Now(), &err)
+	return b.backend.Exists(ctx, key)
+}
+
+// Stat returns file information.
+func (b *InstrumentedBackend) Stat(ctx context.Context, key string) (info *FileInfo, err error) {
+	defer b.observe("stat", time.Now(), &err)
+	return b.backend.Stat(ctx, key)
+}
+
+// List lists files with the given prefix.
+func (b *InstrumentedBackend) List(ctx context.Context, prefix string) (files []*FileInfo, err error) {
+	defer b.observe("list", time.Now(), &err)
+	return b.backend.List(ctx, prefix)
+}
+
+// Usage returns the capacity of the underlying volume.
+func (b *InstrumentedBackend) Usage(ctx context.Context) (usage *Usage, err error) {
+	defer b.observe("usage", time.Now(), &err)
+	return b.backend.Usage(ctx)
+}
+
+// Close closes the backend.
+func (b *InstrumentedBackend) Close() error {
+	return b.backend.Close()
+}
+
+func (b *InstrumentedBackend) observe(operation string, start time.Time, err *error) {
+	b.operations.Observe(operation, start, *err)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	counter prometheus.Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
+
+// countingReadSeekCloser is a countingReadCloser over seekable content.
+type countingReadSeekCloser struct {
+	*countingReadCloser
+	io.Seeker
+}
@@ -0,0 +1,90 @@
+// Package storage provides the tracing of storage backends.
+package storage
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"asisaid.cn/JzSE/internal/common/tracing"
+)
+
+// TracedBackend starts a span around each operation of a Backend. The span
+// of Get covers opening the content, not reading it.
+type TracedBackend struct {
+	backend Backend
+}
+
+var _ Backend = (*TracedBackend)(nil)
+
+// NewTracedBackend wraps a backend.
+func NewTracedBackend(backend Backend) *TracedBackend {
+	return &TracedBackend{backend: backend}
+}
+
+// Put stores a file.
+func (b *TracedBackend) Put(ctx context.Context, key string, reader io.Reader, size int64) (err error) {
+	ctx, span := start(ctx, "storage.Put", attribute.String("storage.key", key), attribute.Int64("storage.size", size))
+	defer func() { tracing.End(span, err) }()
+	return b.backend.Put(ctx, key, reader, size)
+}
+
+// Get retrieves a file.
+func (b *TracedBackend) Get(ctx context.Context, key string) (rc io.ReadCloser, err error) {
+	ctx, span := start(ctx, "storage.Get", attribute.String("storage.key", key))
+	defer func() { tracing.End(span, err) }()
+	return b.backend.Get(ctx, key)
+}
+
+// Delete removes a file.
+func (b *TracedBackend) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := start(ctx, "storage.Delete", attribute.String("storage.key", key))
+	defer func() { tracing.End(span, err) }()
+	return b.backend.Delete(ctx, key)
+}
+
+// Rename moves a file to a new key.
+func (b *TracedBackend) Rename(ctx context.Context, oldKey, newKey string) (err error) {
+	ctx, span := start(ctx, "storage.Rename", attribute.String("storage.key", oldKey), attribute.String("storage.new_key", newKey))
+	defer func() { tracing.End(span, err) }()
+	return b.backend.Rename(ctx, oldKey, newKey)
+}
+
+// Exists checks if a file exists.
+func (b *TracedBackend) Exists(ctx context.Context, key string) (exists bool, err error) {
+	ctx, span := start(ctx, "storage.Exists", attribute.String("storage.key", key))
+	defer func() { tracing.End(span, err) }()
+	return b.backend.Exists(ctx, key)
+}
+
+// Stat returns file information.
+func (b *TracedBackend) Stat(ctx context.Context, key string) (info *FileInfo, err error) {
+	ctx, span := start(ctx, "storage.Stat", attribute.String("storage.key", key))
+	defer func() { tracing.End(span, err) }()
+	return b.backend.Stat(ctx, key)
+}
+
+// List lists files with the given prefix.
+func (b *TracedBackend) List(ctx context.Context, prefix string) (files []*FileInfo, err error) {
+	ctx, span := start(ctx, "storage.List", attribute.String("storage.prefix", prefix))
+	defer func() { tracing.End(span, err) }()
+	return b.backend.List(ctx, prefix)
+}
+
+// Usage returns the capacity of the underlying volume.
+func (b *TracedBackend) Usage(ctx context.Context) (usage *Usage, err error) {
+	ctx, span := start(ctx, "storage.Usage")
+	defer func() { tracing.End(span, err) }()
+	return b.backend.Usage(ctx)
+}
+
+// Close closes the backend.
+func (b *TracedBackend) Close() error {
+	return b.backend.Close()
+}
+
+func start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Start(ctx, name, trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+// Package storage provides a stub of the volume usage of the local
+// filesystem backend on systems without statfs.
+package storage
+
+import (
+	"context"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// Usage is not available on this system.
+func (b *LocalFSBackend) Usage(ctx context.Context) (*Usage, error) {
+	return nil, errors.E("LocalFSBackend.Usage", errors.ErrNotImplemented, nil, "filesystem usage")
+}
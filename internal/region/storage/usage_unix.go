@@ -0,0 +1,29 @@
+//go:build linux || darwin
+
+// Package storage provides the volume usage of the local filesystem
+// backend on systems with statfs.
+package storage
+
+import (
+	"context"
+	"syscall"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// Usage returns the capacity of the filesystem holding the base path.
+// Free space is what unprivileged processes may still use.
+func (b *LocalFSBackend) Usage(ctx context.Context) (*Usage, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(b.basePath, &fs); err != nil {
+		return nil, errors.Wrap("LocalFSBackend.Usage", err)
+	}
+
+	blockSize := int64(fs.Bsize)
+	total := int64(fs.Blocks) * blockSize
+	return &Usage{
+		TotalBytes: total,
+		UsedBytes:  total - int64(fs.Bfree)*blockSize,
+		FreeBytes:  int64(fs.Bavail) * blockSize,
+	}, nil
+}
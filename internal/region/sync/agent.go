@@ -6,8 +6,14 @@ import (
 	"sync"
 	"time"
 
+	"asisaid.cn/JzSE/internal/common/errors"
 	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/common/metrics"
+	"asisaid.cn/JzSE/internal/common/tracing"
 	"asisaid.cn/JzSE/internal/region/metadata"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -18,6 +24,7 @@ const (
 	ChangeTypeCreate ChangeType = "CREATE"
 	ChangeTypeUpdate ChangeType = "UPDATE"
 	ChangeTypeDelete ChangeType = "DELETE"
+	ChangeTypeACL    ChangeType = "ACL" // ACL of a path set or removed
 )
 
 // ChangeEvent represents a change that needs to be synced.
@@ -26,10 +33,19 @@ type ChangeEvent struct {
 	Type        ChangeType             `json:"type"`
 	FileID      string                 `json:"file_id"`
 	Metadata    *metadata.FileMetadata `json:"metadata"`
+	ACL         *metadata.ACL          `json:"acl,omitempty"` // For ACL events
 	VectorClock map[string]uint64      `json:"vector_clock"`
 	Timestamp   time.Time              `json:"timestamp"`
 	RegionID    string                 `json:"region_id"`
 	Attempts    int                    `json:"attempts"`
+
+	// TraceContext carries the trace of the operation that made the
+	// change, so that the coordinator continues it.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+
+	// RequestID is the ID of the request that made the change, logged
+	// with the event by the agent and the coordinator.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // AgentConfig holds configuration for the sync agent.
@@ -40,14 +56,56 @@ type AgentConfig struct {
 	BatchInterval time.Duration
 	RetryInterval time.Duration
 	MaxRetries    int
+
+	// Interval of heartbeats to the coordinator, zero to send none
+	HeartbeatInterval time.Duration
+}
+
+// Coordinator is the coordinator end of the sync protocol.
+type Coordinator interface {
+	// Register joins the region to the coordinator.
+	Register(ctx context.Context) error
+
+	// Heartbeat reports that the region is alive, with the number of
+	// changes it has yet to push. It fails with ErrNotFound if the
+	// coordinator does not know the region.
+	Heartbeat(ctx context.Context, pending int) error
+
+	// PushChanges sends change events and returns the outcome of each, in
+	// order: nil if the coordinator accepted the event, an ErrConflict
+	// error if it conflicts with the global version. The error is set if
+	// the events could not be delivered at all.
+	PushChanges(ctx context.Context, events []*ChangeEvent) ([]error, error)
+
+	// PullChanges fetches the changes other regions made since the last pull.
+	PullChanges(ctx context.Context) ([]*ChangeEvent, error)
+}
+
+// AgentStatus reports the state of the sync agent.
+type AgentStatus struct {
+	Mode          string        `json:"mode"`
+	Coordinator   bool          `json:"coordinator"` // Whether a coordinator is configured
+	QueueDepth    int           `json:"queue_depth"`
+	QueueCapacity int           `json:"queue_capacity"`
+	Lag           time.Duration `json:"lag"`                      // Age of the oldest queued change
+	LastContactAt time.Time     `json:"last_contact_at,omitzero"` // Last successful call to the coordinator
+	LastError     string        `json:"last_error,omitempty"`     // Of the last call, if it failed
 }
 
 // Agent handles synchronization between region and coordinator.
 type Agent struct {
-	config    AgentConfig
-	metaStore metadata.Store
-	queue     *ChangeQueue
-	logger    *zap.Logger
+	config      AgentConfig
+	metaStore   metadata.Store
+	queue       *ChangeQueue
+	coordinator Coordinator // Nil while changes are only tracked locally
+	logger      *zap.Logger
+
+	contactMu     sync.Mutex // Guards lastContactAt and lastError
+	lastContactAt time.Time
+	lastError     error
+
+	retried prometheus.Counter
+	dropped *prometheus.CounterVec // By reason
 
 	stopCh chan struct{}
 	wg     sync.WaitGroup
@@ -61,9 +119,43 @@ func NewAgent(cfg AgentConfig, metaStore metadata.Store) *Agent {
 		queue:     NewChangeQueue(10000),
 		logger:    logger.WithComponent("SyncAgent"),
 		stopCh:    make(chan struct{}),
+		retried: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "sync",
+			Name:      "events_retried_total",
+			Help:      "Change events queued again after a failed push.",
+		}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "sync",
+			Name:      "events_dropped_total",
+			Help:      "Change events given up on, by reason: queue_full or max_retries.",
+		}, []string{"reason"}),
 	}
 }
 
+// RegisterMetrics registers the metrics of the agent: the queue depth and
+// the events retried and dropped.
+func (a *Agent) RegisterMetrics(reg prometheus.Registerer) error {
+	return metrics.Register(reg,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "sync",
+			Name:      "queue_depth",
+			Help:      "Change events waiting to be pushed to the coordinator.",
+		}, func() float64 { return float64(a.queue.Len()) }),
+		a.retried,
+		a.dropped,
+	)
+}
+
+// SetCoordinator sets the coordinator the agent registers with and pushes
+// changes to. It must be called before Start; without a coordinator,
+// dequeued changes are discarded and their files stay pending sync.
+func (a *Agent) SetCoordinator(coordinator Coordinator) {
+	a.coordinator = coordinator
+}
+
 // Start starts the sync agent.
 func (a *Agent) Start(ctx context.Context) error {
 	a.logger.Info("starting sync agent",
@@ -86,6 +178,11 @@ func (a *Agent) Start(ctx context.Context) error {
 		go a.runPushMode(ctx)
 	}
 
+	if a.coordinator != nil && a.config.HeartbeatInterval > 0 {
+		a.wg.Add(1)
+		go a.runHeartbeat(ctx)
+	}
+
 	return nil
 }
 
@@ -96,20 +193,45 @@ func (a *Agent) Stop() {
 	a.wg.Wait()
 }
 
-// QueueChange adds a change event to the sync queue.
-func (a *Agent) QueueChange(changeType ChangeType, meta *metadata.FileMetadata) {
+// QueueChange adds a change event to the sync queue. The event carries
+// the trace and request ID of ctx.
+func (a *Agent) QueueChange(ctx context.Context, changeType ChangeType, meta *metadata.FileMetadata) {
+	event := &ChangeEvent{
+		ID:           generateEventID(),
+		Type:         changeType,
+		FileID:       meta.ID,
+		Metadata:     meta,
+		VectorClock:  copyClock(meta.VectorClock),
+		Timestamp:    time.Now(),
+		RegionID:     a.config.RegionID,
+		TraceContext: tracing.Inject(ctx),
+		RequestID:    logger.RequestIDFrom(ctx),
+	}
+
+	if err := a.queue.Push(event); err != nil {
+		a.dropped.WithLabelValues("queue_full").Inc()
+		logger.FromContext(ctx, a.logger).Error("failed to queue change", zap.Error(err))
+	}
+}
+
+// QueueACLChange adds an ACL change event to the sync queue. ACL events
+// travel through the same queue as file changes, so both reach the
+// coordinator in order.
+func (a *Agent) QueueACLChange(ctx context.Context, acl *metadata.ACL) {
 	event := &ChangeEvent{
-		ID:          generateEventID(),
-		Type:        changeType,
-		FileID:      meta.ID,
-		Metadata:    meta,
-		VectorClock: meta.VectorClock,
-		Timestamp:   time.Now(),
-		RegionID:    a.config.RegionID,
+		ID:           generateEventID(),
+		Type:         ChangeTypeACL,
+		ACL:          acl,
+		VectorClock:  copyClock(acl.VectorClock),
+		Timestamp:    time.Now(),
+		RegionID:     a.config.RegionID,
+		TraceContext: tracing.Inject(ctx),
+		RequestID:    logger.RequestIDFrom(ctx),
 	}
 
 	if err := a.queue.Push(event); err != nil {
-		a.logger.Error("failed to queue change", zap.Error(err))
+		a.dropped.WithLabelValues("queue_full").Inc()
+		logger.FromContext(ctx, a.logger).Error("failed to queue ACL change", zap.Error(err))
 	}
 }
 
@@ -118,6 +240,38 @@ func (a *Agent) GetQueueSize() int {
 	return a.queue.Len()
 }
 
+// Status returns the state of the agent.
+func (a *Agent) Status() AgentStatus {
+	status := AgentStatus{
+		Mode:          a.config.Mode,
+		Coordinator:   a.coordinator != nil,
+		QueueDepth:    a.queue.Len(),
+		QueueCapacity: a.queue.Cap(),
+	}
+	if oldest := a.queue.Oldest(); !oldest.IsZero() {
+		status.Lag = time.Since(oldest)
+	}
+
+	a.contactMu.Lock()
+	defer a.contactMu.Unlock()
+	status.LastContactAt = a.lastContactAt
+	if a.lastError != nil {
+		status.LastError = a.lastError.Error()
+	}
+	return status
+}
+
+// recordContact records the outcome of a call to the coordinator.
+func (a *Agent) recordContact(err error) {
+	a.contactMu.Lock()
+	defer a.contactMu.Unlock()
+
+	a.lastError = err
+	if err == nil {
+		a.lastContactAt = time.Now()
+	}
+}
+
 // runPushMode immediately pushes changes to coordinator.
 func (a *Agent) runPushMode(ctx context.Context) {
 	defer a.wg.Done()
@@ -136,8 +290,13 @@ func (a *Agent) runPushMode(ctx context.Context) {
 			}
 
 			if err := a.syncEvent(ctx, event); err != nil {
-				a.handleSyncError(event, err)
+				if a.handleSyncError(ctx, event, err) {
+					// Give the coordinator time to recover before retrying
+					a.pause(ctx, a.config.RetryInterval)
+				}
+				continue
 			}
+			a.acknowledge(ctx, event)
 		}
 	}
 }
@@ -180,15 +339,53 @@ func (a *Agent) runPullMode(ctx context.Context) {
 	}
 }
 
+// runHeartbeat registers the region with the coordinator, then sends
+// heartbeats. A coordinator that restarted has forgotten the region, so
+// the region registers again.
+func (a *Agent) runHeartbeat(ctx context.Context) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	registered := false
+	for {
+		if !registered {
+			err := a.coordinator.Register(ctx)
+			a.recordContact(err)
+			if err != nil {
+				a.logger.Warn("failed to register with coordinator", zap.Error(err))
+			} else {
+				registered = true
+				a.logger.Info("registered with coordinator")
+			}
+		} else {
+			err := a.coordinator.Heartbeat(ctx, a.queue.Len())
+			a.recordContact(err)
+			if err != nil {
+				a.logger.Warn("heartbeat failed", zap.Error(err))
+				registered = !errors.IsNotFound(err)
+			}
+		}
+
+		select {
+		case <-a.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // syncEvent syncs a single event to the coordinator.
 func (a *Agent) syncEvent(ctx context.Context, event *ChangeEvent) error {
-	// TODO: Implement actual coordinator communication
-	a.logger.Debug("syncing event",
+	a.eventLog(event).Debug("syncing event",
 		zap.String("event_id", event.ID),
 		zap.String("file_id", event.FileID),
 		zap.String("type", string(event.Type)),
 	)
-	return nil
+	return a.push(ctx, []*ChangeEvent{event})[0]
 }
 
 // syncBatch syncs a batch of events.
@@ -200,23 +397,185 @@ func (a *Agent) syncBatch(ctx context.Context) {
 
 	a.logger.Debug("syncing batch", zap.Int("count", len(events)))
 
-	for _, event := range events {
-		if err := a.syncEvent(ctx, event); err != nil {
-			a.handleSyncError(event, err)
+	for i, err := range a.push(ctx, events) {
+		if err != nil {
+			a.handleSyncError(ctx, events[i], err)
+			continue
 		}
+		a.acknowledge(ctx, events[i])
+	}
+}
+
+// push sends events to the coordinator, if any, and returns the outcome
+// of each. Without a coordinator the events are discarded, and left
+// unacknowledged. Each event is sent in a span continuing the trace of its
+// change, and carries that span to the coordinator.
+func (a *Agent) push(ctx context.Context, events []*ChangeEvent) []error {
+	results := make([]error, len(events))
+	if a.coordinator == nil {
+		return results
+	}
+
+	sent := make([]*ChangeEvent, len(events))
+	spans := make([]trace.Span, len(events))
+	for i, event := range events {
+		eventCtx, span := tracing.Continue(ctx, event.TraceContext, "SyncAgent.PushChange",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(eventAttributes(event)...),
+			trace.WithAttributes(attribute.Int("sync.attempts", event.Attempts)),
+		)
+		outgoing := *event
+		outgoing.TraceContext = tracing.Inject(eventCtx)
+		sent[i], spans[i] = &outgoing, span
+	}
+	defer func() {
+		for i, span := range spans {
+			tracing.End(span, results[i])
+		}
+	}()
+
+	acks, err := a.coordinator.PushChanges(ctx, sent)
+	a.recordContact(err)
+	if err == nil && len(acks) != len(events) {
+		err = errors.E("Agent.push", errors.ErrSyncFailed, nil, "coordinator acknowledged a different number of events")
+	}
+	if err != nil {
+		for i := range results {
+			results[i] = err
+		}
+		return results
+	}
+	copy(results, acks)
+	return results
+}
+
+// eventAttributes describes a change event on its spans.
+func eventAttributes(event *ChangeEvent) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("sync.event_id", event.ID),
+		attribute.String("sync.change_type", string(event.Type)),
+		attribute.String("sync.region_id", event.RegionID),
+		attribute.String("file.id", event.FileID),
+	}
+}
+
+// eventLog returns the logger of the agent with the request ID of a
+// change event, linking its lines to the request that made the change.
+func (a *Agent) eventLog(event *ChangeEvent) *zap.Logger {
+	if event.RequestID == "" {
+		return a.logger
+	}
+	return a.logger.With(logger.RequestID(event.RequestID))
+}
+
+// acknowledge marks the file as synced once the coordinator accepted the event.
+// Files changed again after the event was queued stay pending, and so do
+// all files without a coordinator: no other region received the change,
+// so tombstones of deleted files must not be compacted.
+func (a *Agent) acknowledge(ctx context.Context, event *ChangeEvent) {
+	if a.coordinator == nil {
+		return
+	}
+	a.setSyncState(ctx, event, metadata.SyncStateSynced)
+}
+
+// setSyncState records the outcome of syncing an event on the file or ACL
+// it changed, unless that changed again since.
+func (a *Agent) setSyncState(ctx context.Context, event *ChangeEvent, state metadata.SyncState) {
+	if event.Type == ChangeTypeACL {
+		a.setACLSyncState(ctx, event, state)
+		return
+	}
+
+	meta, err := a.metaStore.Get(ctx, event.FileID)
+	if err != nil {
+		a.eventLog(event).Debug("skipping ack for missing file",
+			zap.String("event_id", event.ID),
+			zap.String("file_id", event.FileID),
+		)
+		return
+	}
+
+	if meta.SyncState == state || meta.CompareClock(event.VectorClock) != metadata.ClockEqual {
+		return
+	}
+
+	meta.SyncState = state
+	if err := a.metaStore.Save(ctx, meta); err != nil {
+		a.eventLog(event).Warn("failed to update file sync state",
+			zap.String("file_id", event.FileID),
+			zap.String("sync_state", string(state)),
+			zap.Error(err),
+		)
+	}
+}
+
+// setACLSyncState records the sync state of an ACL, unless it changed
+// again or was removed.
+func (a *Agent) setACLSyncState(ctx context.Context, event *ChangeEvent, state metadata.SyncState) {
+	if event.ACL.Deleted {
+		return
+	}
+
+	acl, err := a.metaStore.GetACL(ctx, event.ACL.Path)
+	if err != nil || acl.SyncState == state || acl.Version != event.ACL.Version {
+		return
+	}
+
+	acl.SyncState = state
+	if err := a.metaStore.SaveACL(ctx, acl); err != nil {
+		a.logger.Warn("failed to update ACL sync state",
+			zap.String("path", acl.Path),
+			zap.Error(err),
+		)
 	}
 }
 
 // pullChanges pulls changes from the coordinator.
 func (a *Agent) pullChanges(ctx context.Context) {
-	// TODO: Implement pulling from coordinator
 	a.logger.Debug("pulling changes from coordinator")
+	if a.coordinator == nil {
+		return
+	}
+
+	events, err := a.coordinator.PullChanges(ctx)
+	a.recordContact(err)
+	if err != nil {
+		a.logger.Warn("failed to pull changes", zap.Error(err))
+		return
+	}
+	for _, event := range events {
+		_, span := tracing.Continue(ctx, event.TraceContext, "SyncAgent.ReceiveChange",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(eventAttributes(event)...),
+		)
+		// TODO: Apply remote changes once content is replicated between regions
+		a.eventLog(event).Debug("received change",
+			zap.String("event_id", event.ID),
+			zap.String("file_id", event.FileID),
+			zap.String("type", string(event.Type)),
+			zap.String("region_id", event.RegionID),
+		)
+		span.End()
+	}
 }
 
-// handleSyncError handles sync errors with retry logic.
-func (a *Agent) handleSyncError(event *ChangeEvent, err error) {
+// handleSyncError handles sync errors with retry logic and reports whether
+// the event was queued again. Conflicts are not retried: the file is
+// marked as conflicting until it changes again.
+func (a *Agent) handleSyncError(ctx context.Context, event *ChangeEvent, err error) bool {
+	if errors.IsConflict(err) {
+		a.eventLog(event).Warn("change conflicts with global version",
+			zap.String("event_id", event.ID),
+			zap.String("file_id", event.FileID),
+			zap.Error(err),
+		)
+		a.setSyncState(ctx, event, metadata.SyncStateConflict)
+		return false
+	}
+
 	event.Attempts++
-	a.logger.Warn("sync failed",
+	a.eventLog(event).Warn("sync failed",
 		zap.String("event_id", event.ID),
 		zap.Int("attempts", event.Attempts),
 		zap.Error(err),
@@ -224,12 +583,43 @@ func (a *Agent) handleSyncError(event *ChangeEvent, err error) {
 
 	if event.Attempts < a.config.MaxRetries {
 		// Re-queue for retry
-		_ = a.queue.Push(event)
-	} else {
-		a.logger.Error("max retries exceeded, dropping event",
-			zap.String("event_id", event.ID),
-		)
+		if err := a.queue.Push(event); err != nil {
+			a.dropped.WithLabelValues("queue_full").Inc()
+			a.eventLog(event).Error("failed to queue event for retry, dropping it",
+				zap.String("event_id", event.ID),
+				zap.Error(err),
+			)
+			return false
+		}
+		a.retried.Inc()
+		return true
+	}
+	a.dropped.WithLabelValues("max_retries").Inc()
+	a.eventLog(event).Error("max retries exceeded, dropping event",
+		zap.String("event_id", event.ID),
+	)
+	return false
+}
+
+// pause waits for d, or until the agent stops.
+func (a *Agent) pause(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-a.stopCh:
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// copyClock returns a copy of a vector clock.
+func copyClock(clock map[string]uint64) map[string]uint64 {
+	result := make(map[string]uint64, len(clock))
+	for k, v := range clock {
+		result[k] = v
 	}
+	return result
 }
 
 // generateEventID generates a unique event ID.
@@ -0,0 +1,208 @@
+package sync
+
+import (
+	"context"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/metadata"
+)
+
+// fakeCoordinator answers pushes with preset results and counts calls.
+type fakeCoordinator struct {
+	mu         gosync.Mutex
+	results    map[string]error // By file ID, accepted if absent
+	pushErr    error
+	pushed     []*ChangeEvent
+	registered int
+	heartbeats int
+	known      bool
+}
+
+func (c *fakeCoordinator) Register(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registered++
+	c.known = true
+	return nil
+}
+
+func (c *fakeCoordinator) Heartbeat(ctx context.Context, pending int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.heartbeats++
+	if !c.known {
+		return errors.E("fakeCoordinator.Heartbeat", errors.ErrNotFound, nil, "unknown region")
+	}
+	return nil
+}
+
+func (c *fakeCoordinator) PushChanges(ctx context.Context, events []*ChangeEvent) ([]error, error) {
+	if c.pushErr != nil {
+		return nil, c.pushErr
+	}
+	c.pushed = append(c.pushed, events...)
+	results := make([]error, len(events))
+	for i, event := range events {
+		results[i] = c.results[event.FileID]
+	}
+	return results, nil
+}
+
+func (c *fakeCoordinator) PullChanges(ctx context.Context) ([]*ChangeEvent, error) {
+	return nil, nil
+}
+
+func newTestAgent(t *testing.T, coordinator Coordinator) (*Agent, metadata.Store) {
+	t.Helper()
+
+	store, err := metadata.NewBadgerStore(metadata.StoreConfig{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	agent := NewAgent(AgentConfig{
+		RegionID:          "region-a",
+		Mode:              "batch",
+		BatchSize:         10,
+		BatchInterval:     time.Hour,
+		RetryInterval:     time.Millisecond,
+		MaxRetries:        2,
+		HeartbeatInterval: 10 * time.Millisecond,
+	}, store)
+	agent.SetCoordinator(coordinator)
+	return agent, store
+}
+
+func TestAgent_PushChanges(t *testing.T) {
+	ctx := context.Background()
+	coordinator := &fakeCoordinator{results: map[string]error{
+		"file-2": errors.E("fakeCoordinator.PushChanges", errors.ErrConflict, nil, "concurrent update"),
+	}}
+	agent, store := newTestAgent(t, coordinator)
+
+	for _, id := range []string{"file-1", "file-2"} {
+		meta := metadata.NewFileMetadata(id, id+".txt", "/"+id+".txt")
+		meta.VectorClock["region-a"] = 1
+		if err := store.Save(ctx, meta); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		agent.QueueChange(ctx, ChangeTypeCreate, meta)
+	}
+
+	if status := agent.Status(); status.QueueDepth != 2 || status.Lag <= 0 || !status.LastContactAt.IsZero() {
+		t.Errorf("status before push = %+v, want 2 queued changes and no contact", status)
+	}
+	agent.syncBatch(ctx)
+	if len(coordinator.pushed) != 2 || agent.GetQueueSize() != 0 {
+		t.Fatalf("pushed %d events leaving %d queued, want 2 and 0", len(coordinator.pushed), agent.GetQueueSize())
+	}
+	if status := agent.Status(); status.Lag != 0 || status.LastContactAt.IsZero() || status.LastError != "" {
+		t.Errorf("status after push = %+v, want a successful contact", status)
+	}
+
+	// Accepted changes are synced, conflicts are not retried
+	wantStates := map[string]metadata.SyncState{"file-1": metadata.SyncStateSynced, "file-2": metadata.SyncStateConflict}
+	for id, want := range wantStates {
+		meta, err := store.Get(ctx, id)
+		if err != nil {
+			t.Fatalf("Get(%v) failed: %v", id, err)
+		}
+		if meta.SyncState != want {
+			t.Errorf("%v sync state = %v, want %v", id, meta.SyncState, want)
+		}
+	}
+
+	// Undelivered changes are retried, then dropped
+	coordinator.pushErr = errors.E("fakeCoordinator.PushChanges", errors.ErrCoordinatorUnavailable, nil, "down")
+	agent.QueueChange(ctx, ChangeTypeUpdate, metadata.NewFileMetadata("file-3", "file-3.txt", "/file-3.txt"))
+	agent.syncBatch(ctx)
+	if agent.GetQueueSize() != 1 {
+		t.Errorf("queue size after failure = %v, want 1", agent.GetQueueSize())
+	}
+	if status := agent.Status(); status.LastError == "" || status.LastContactAt.IsZero() {
+		t.Errorf("status after failure = %+v, want the error and the last contact", status)
+	}
+	agent.syncBatch(ctx)
+	if agent.GetQueueSize() != 0 {
+		t.Errorf("queue size after max retries = %v, want 0", agent.GetQueueSize())
+	}
+	if retried := testutil.ToFloat64(agent.retried); retried != 1 {
+		t.Errorf("events retried = %v, want 1", retried)
+	}
+	if dropped := testutil.ToFloat64(agent.dropped.WithLabelValues("max_retries")); dropped != 1 {
+		t.Errorf("events dropped after max retries = %v, want 1", dropped)
+	}
+}
+
+func TestAgent_Heartbeat(t *testing.T) {
+	coordinator := &fakeCoordinator{}
+	agent, _ := newTestAgent(t, coordinator)
+
+	if err := agent.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer agent.Stop()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			coordinator.mu.Lock()
+			ok := cond()
+			coordinator.mu.Unlock()
+			if ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %v", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	waitFor("heartbeats", func() bool { return coordinator.registered == 1 && coordinator.heartbeats > 0 })
+
+	// A coordinator that forgot the region gets it registered again
+	coordinator.mu.Lock()
+	coordinator.known = false
+	coordinator.mu.Unlock()
+	waitFor("registration", func() bool { return coordinator.registered == 2 })
+}
+
+func TestAgent_NoCoordinator(t *testing.T) {
+	ctx := context.Background()
+	agent, store := newTestAgent(t, nil)
+
+	// A deletion no other region received
+	meta := metadata.NewFileMetadata("file-1", "file-1.txt", "/file-1.txt")
+	meta.LocalState = metadata.LocalStateDeleted
+	meta.UpdatedAt = time.Now().Add(-2 * time.Hour)
+	if err := store.Save(ctx, meta); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	agent.QueueChange(ctx, ChangeTypeDelete, meta)
+	agent.syncBatch(ctx)
+
+	got, err := store.Get(ctx, "file-1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.SyncState == metadata.SyncStateSynced {
+		t.Error("deletion acknowledged without a coordinator")
+	}
+
+	// Its tombstone survives compaction
+	compactor := metadata.NewCompactor(metadata.CompactorConfig{TombstoneTTL: time.Hour}, store)
+	if purged, err := compactor.Compact(ctx); err != nil || purged != 0 {
+		t.Errorf("Compact() = %v, %v, want nothing purged", purged, err)
+	}
+	if _, err := store.Get(ctx, "file-1"); err != nil {
+		t.Errorf("tombstone purged: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package sync
 
 import (
 	"sync"
+	"time"
 
 	"asisaid.cn/JzSE/internal/common/errors"
 )
@@ -76,6 +77,26 @@ func (q *ChangeQueue) Len() int {
 	return len(q.items)
 }
 
+// Cap returns the maximum number of events in the queue.
+func (q *ChangeQueue) Cap() int {
+	return q.maxSize
+}
+
+// Oldest returns the time of the oldest change in the queue, or the zero
+// time if the queue is empty. Retried events keep their original time.
+func (q *ChangeQueue) Oldest() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var oldest time.Time
+	for _, event := range q.items {
+		if oldest.IsZero() || event.Timestamp.Before(oldest) {
+			oldest = event.Timestamp
+		}
+	}
+	return oldest
+}
+
 // Clear removes all events from the queue.
 func (q *ChangeQueue) Clear() {
 	q.mu.Lock()
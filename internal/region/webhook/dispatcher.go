@@ -0,0 +1,574 @@
+// Package webhook provides outbound webhooks for file lifecycle events.
+// Each matching change is stored as a delivery per subscription, POSTed
+// as signed JSON and retried with exponential backoff until it succeeds
+// or is moved to the dead letters, from where it can be redelivered.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/events"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+)
+
+// Default delivery settings.
+const (
+	DefaultMaxAttempts    = 10
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = time.Hour
+	DefaultTimeout        = 10 * time.Second
+	DefaultWorkers        = 4
+)
+
+// Subscription is a webhook endpoint and the changes sent to it.
+type Subscription struct {
+	ID     string        `json:"id"`
+	URL    string        `json:"url"`
+	Secret []byte        `json:"-"`
+	Paths  []string      `json:"paths,omitempty"`  // Files at or below these paths; all if empty
+	Events []events.Type `json:"events,omitempty"` // All if empty
+}
+
+// Payload is the JSON body of a webhook request.
+type Payload struct {
+	ID        string                 `json:"id"` // Delivery ID
+	Webhook   string                 `json:"webhook"`
+	Type      events.Type            `json:"type"`
+	RegionID  string                 `json:"region_id"`
+	Timestamp time.Time              `json:"timestamp"`
+	File      *metadata.FileMetadata `json:"file"`
+}
+
+// Delivery is a payload on its way to a subscription.
+type Delivery struct {
+	ID            string          `json:"id"`
+	Webhook       string          `json:"webhook"`
+	Type          events.Type     `json:"type"`
+	Body          json.RawMessage `json:"body"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     string          `json:"last_error,omitempty"`
+	LastStatus    int             `json:"last_status,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Dead          bool            `json:"dead"`
+	DeadAt        time.Time       `json:"dead_at,omitempty"`
+}
+
+// DispatcherConfig holds configuration for the webhook dispatcher.
+type DispatcherConfig struct {
+	RegionID       string
+	MaxAttempts    int           // Attempts before a delivery is dead-lettered
+	InitialBackoff time.Duration // Delay before the first retry, doubled for each further retry
+	MaxBackoff     time.Duration
+	Timeout        time.Duration // Per request
+	Workers        int           // Concurrent requests
+}
+
+// Dispatcher delivers file changes to webhook subscriptions.
+type Dispatcher struct {
+	config DispatcherConfig
+	subs   []*Subscription
+	store  Store
+	client *http.Client
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	queued   []*Delivery // Created, waiting to be stored
+	pending  map[string]*Delivery
+	dead     map[string]*Delivery
+	inflight map[string]bool
+
+	wake   chan struct{}
+	save   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher for subs and loads the deliveries
+// left in store. Deliveries of subscriptions that no longer exist are
+// dead-lettered.
+func NewDispatcher(cfg DispatcherConfig, subs []*Subscription, store Store) (*Dispatcher, error) {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = DefaultInitialBackoff
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = max(DefaultMaxBackoff, cfg.InitialBackoff)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+
+	ids := make(map[string]bool)
+	for _, sub := range subs {
+		if err := validate(sub); err != nil {
+			return nil, err
+		}
+		if ids[sub.ID] {
+			return nil, errors.E("webhook.NewDispatcher", errors.ErrInvalidInput, nil, "duplicate webhook "+sub.ID)
+		}
+		ids[sub.ID] = true
+	}
+
+	d := &Dispatcher{
+		config:   cfg,
+		subs:     subs,
+		store:    store,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		logger:   logger.WithComponent("WebhookDispatcher"),
+		pending:  make(map[string]*Delivery),
+		dead:     make(map[string]*Delivery),
+		inflight: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+		save:     make(chan struct{}, 1),
+	}
+
+	stored, err := store.Load()
+	if err != nil {
+		return nil, errors.Wrap("webhook.NewDispatcher", err)
+	}
+	for _, delivery := range stored {
+		if !delivery.Dead && !ids[delivery.Webhook] {
+			d.kill(delivery, time.Now(), "webhook removed")
+		}
+		if delivery.Dead {
+			d.dead[delivery.ID] = delivery
+		} else {
+			d.pending[delivery.ID] = delivery
+		}
+	}
+	if len(stored) > 0 {
+		d.logger.Info("loaded webhook deliveries",
+			zap.Int("pending", len(d.pending)),
+			zap.Int("dead", len(d.dead)))
+	}
+	return d, nil
+}
+
+// validate checks a subscription and normalizes its paths.
+func validate(sub *Subscription) error {
+	const op = "webhook.validate"
+	if sub.ID == "" {
+		return errors.E(op, errors.ErrInvalidInput, nil, "webhook without id")
+	}
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.E(op, errors.ErrInvalidInput, err, fmt.Sprintf("webhook %s: invalid url %q", sub.ID, sub.URL))
+	}
+	if len(sub.Secret) == 0 {
+		return errors.E(op, errors.ErrInvalidInput, nil, fmt.Sprintf("webhook %s: secret required", sub.ID))
+	}
+	for i, p := range sub.Paths {
+		sub.Paths[i] = path.Clean("/" + p)
+	}
+	for _, t := range sub.Events {
+		switch t {
+		case events.TypeCreate, events.TypeUpdate, events.TypeDelete, events.TypeConflict:
+		default:
+			return errors.E(op, errors.ErrInvalidInput, nil, fmt.Sprintf("webhook %s: unsupported event %q", sub.ID, t))
+		}
+	}
+	return nil
+}
+
+// matches reports whether a change of type t at p is sent to sub.
+func (sub *Subscription) matches(t events.Type, p string) bool {
+	if len(sub.Events) > 0 {
+		found := false
+		for _, e := range sub.Events {
+			found = found || e == t
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(sub.Paths) == 0 {
+		return true
+	}
+	for _, dir := range sub.Paths {
+		if dir == "/" || p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscriptions returns the configured subscriptions.
+func (d *Dispatcher) Subscriptions() []*Subscription {
+	return d.subs
+}
+
+// QueueChange creates a delivery of a file change for each matching
+// subscription. The deliveries are stored in the background, since the
+// caller is committing the change, and sent once stored.
+func (d *Dispatcher) QueueChange(ctx context.Context, changeType regionsync.ChangeType, meta *metadata.FileMetadata) {
+	// Renames are reported as updates
+	eventType, ok := events.TypeOf(changeType, meta)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	var queued []*Delivery
+	for _, sub := range d.subs {
+		if !sub.matches(eventType, meta.Path) {
+			continue
+		}
+		delivery := &Delivery{
+			ID:            uuid.New().String(),
+			Webhook:       sub.ID,
+			Type:          eventType,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		body, err := json.Marshal(&Payload{
+			ID:        delivery.ID,
+			Webhook:   sub.ID,
+			Type:      eventType,
+			RegionID:  d.config.RegionID,
+			Timestamp: now,
+			File:      meta,
+		})
+		if err != nil {
+			d.logger.Error("failed to encode webhook payload", zap.String("webhook", sub.ID), zap.Error(err))
+			continue
+		}
+		delivery.Body = body
+		queued = append(queued, delivery)
+	}
+	if len(queued) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	d.queued = append(d.queued, queued...)
+	d.mu.Unlock()
+	select {
+	case d.save <- struct{}{}:
+	default:
+	}
+}
+
+// persist stores the queued deliveries and makes them pending.
+func (d *Dispatcher) persist() {
+	d.mu.Lock()
+	queued := d.queued
+	d.queued = nil
+	d.mu.Unlock()
+	if len(queued) == 0 {
+		return
+	}
+
+	for _, delivery := range queued {
+		// An unsaved delivery is still attempted, but lost on restart
+		if err := d.store.Save(delivery); err != nil {
+			d.logger.Error("failed to store webhook delivery", zap.String("delivery", delivery.ID), zap.Error(err))
+		}
+	}
+
+	d.mu.Lock()
+	for _, delivery := range queued {
+		d.pending[delivery.ID] = delivery
+	}
+	d.mu.Unlock()
+	d.notify()
+}
+
+// QueueACLChange ignores ACL changes; webhooks report file changes only.
+func (d *Dispatcher) QueueACLChange(ctx context.Context, acl *metadata.ACL) {}
+
+// DeadLetters returns the deliveries that ran out of attempts, oldest first.
+func (d *Dispatcher) DeadLetters() []*Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := make([]*Delivery, 0, len(d.dead))
+	for _, delivery := range d.dead {
+		copied := *delivery
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Redeliver moves a dead letter back to the queue with fresh attempts.
+func (d *Dispatcher) Redeliver(id string) (*Delivery, error) {
+	const op = "Dispatcher.Redeliver"
+
+	d.mu.Lock()
+	delivery, ok := d.dead[id]
+	if !ok {
+		d.mu.Unlock()
+		return nil, errors.E(op, errors.ErrNotFound, nil, id)
+	}
+	if d.subscription(delivery.Webhook) == nil {
+		d.mu.Unlock()
+		return nil, errors.E(op, errors.ErrConflict, nil, "webhook "+delivery.Webhook+" no longer exists")
+	}
+	delivery.Dead = false
+	delivery.DeadAt = time.Time{}
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	delete(d.dead, id)
+	d.pending[id] = delivery
+	copied := *delivery
+	d.mu.Unlock()
+
+	if err := d.store.Save(&copied); err != nil {
+		d.logger.Error("failed to store webhook delivery", zap.String("delivery", id), zap.Error(err))
+	}
+	d.notify()
+	return &copied, nil
+}
+
+// subscription returns the subscription with the given ID, or nil.
+func (d *Dispatcher) subscription(id string) *Subscription {
+	for _, sub := range d.subs {
+		if sub.ID == id {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Start starts delivering in the background until Stop is called.
+func (d *Dispatcher) Start(ctx context.Context) {
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.wg.Add(2)
+	go d.run(ctx)
+	go d.runPersist(ctx)
+	d.logger.Info("webhook dispatcher started", zap.Int("webhooks", len(d.subs)))
+}
+
+// Stop stops delivering and waits for requests in flight. Queued
+// deliveries are stored, and undelivered ones stay in the store.
+func (d *Dispatcher) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+	d.persist()
+	d.logger.Info("webhook dispatcher stopped")
+}
+
+// notify wakes the scheduler.
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runPersist stores queued deliveries as they arrive.
+func (d *Dispatcher) runPersist(ctx context.Context) {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.save:
+			d.persist()
+		}
+	}
+}
+
+// run starts due deliveries and sleeps until the next one is due.
+func (d *Dispatcher) run(ctx context.Context) {
+	defer d.wg.Done()
+
+	slots := make(chan struct{}, d.config.Workers)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		due, next := d.due(time.Now())
+		for _, delivery := range due {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			d.wg.Add(1)
+			go func(delivery *Delivery) {
+				defer d.wg.Done()
+				defer func() { <-slots }()
+				d.attempt(ctx, delivery)
+				d.notify()
+			}(delivery)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// due marks the pending deliveries due at now as in flight and returns
+// them, with the time the next other delivery is due (zero if none).
+func (d *Dispatcher) due(now time.Time) ([]*Delivery, time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var due []*Delivery
+	var next time.Time
+	for id, delivery := range d.pending {
+		if d.inflight[id] {
+			continue
+		}
+		if !delivery.NextAttemptAt.After(now) {
+			d.inflight[id] = true
+			due = append(due, delivery)
+		} else if next.IsZero() || delivery.NextAttemptAt.Before(next) {
+			next = delivery.NextAttemptAt
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+	return due, next
+}
+
+// attempt sends a delivery once and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery) {
+	d.mu.Lock()
+	sub := d.subscription(delivery.Webhook)
+	body := delivery.Body
+	d.mu.Unlock()
+
+	status, err := d.send(ctx, sub, delivery, body)
+	if ctx.Err() != nil {
+		// Stopping: retry the attempt after the restart
+		d.mu.Lock()
+		delete(d.inflight, delivery.ID)
+		d.mu.Unlock()
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inflight, delivery.ID)
+
+	if err == nil {
+		delete(d.pending, delivery.ID)
+		if err := d.store.Delete(delivery.ID); err != nil {
+			d.logger.Error("failed to delete webhook delivery", zap.String("delivery", delivery.ID), zap.Error(err))
+		}
+		d.logger.Debug("webhook delivered",
+			zap.String("webhook", delivery.Webhook),
+			zap.String("delivery", delivery.ID),
+			zap.Int("attempts", delivery.Attempts+1))
+		return
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatus = status
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.config.MaxAttempts {
+		d.kill(delivery, now, delivery.LastError)
+		delete(d.pending, delivery.ID)
+		d.dead[delivery.ID] = delivery
+		d.logger.Warn("webhook delivery dead-lettered",
+			zap.String("webhook", delivery.Webhook),
+			zap.String("delivery", delivery.ID),
+			zap.Int("attempts", delivery.Attempts),
+			zap.Error(err))
+	} else {
+		delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+		d.logger.Debug("webhook delivery failed",
+			zap.String("webhook", delivery.Webhook),
+			zap.String("delivery", delivery.ID),
+			zap.Int("attempts", delivery.Attempts),
+			zap.Time("next_attempt_at", delivery.NextAttemptAt),
+			zap.Error(err))
+	}
+	if err := d.store.Save(delivery); err != nil {
+		d.logger.Error("failed to store webhook delivery", zap.String("delivery", delivery.ID), zap.Error(err))
+	}
+}
+
+// kill marks a delivery as dead.
+func (d *Dispatcher) kill(delivery *Delivery, now time.Time, reason string) {
+	delivery.Dead = true
+	delivery.DeadAt = now
+	delivery.LastError = reason
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.config.InitialBackoff
+	for i := 1; i < attempts && delay < d.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.config.MaxBackoff)
+}
+
+// send POSTs a delivery to its subscription and returns the response
+// status. Any status other than 2xx is a failure.
+func (d *Dispatcher) send(ctx context.Context, sub *Subscription, delivery *Delivery, body []byte) (int, error) {
+	const op = "Dispatcher.send"
+	if sub == nil {
+		return 0, errors.E(op, errors.ErrNotFound, nil, "webhook "+delivery.Webhook+" no longer exists")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.E(op, errors.ErrInvalidInput, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "JzSE-Webhook/1")
+	req.Header.Set(HeaderWebhook, sub.ID)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderEvent, string(delivery.Type))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, time.Now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(op, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.E(op, errors.ErrSyncFailed, nil, sub.URL+": "+resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,190 @@
+package webhook_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"asisaid.cn/JzSE/internal/region/events"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+	"asisaid.cn/JzSE/internal/region/webhook"
+	"asisaid.cn/JzSE/internal/region/webhook/webhooktest"
+)
+
+var secret = []byte("s3cret")
+
+func newDispatcher(t *testing.T, dir string, cfg webhook.DispatcherConfig, subs ...*webhook.Subscription) *webhook.Dispatcher {
+	t.Helper()
+	store, err := webhook.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := webhook.NewDispatcher(cfg, subs, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func file(path string) *metadata.FileMetadata {
+	return &metadata.FileMetadata{ID: path, Path: path, Version: 1}
+}
+
+func TestSignature(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":"1"}`)
+	header := webhook.Sign(secret, now, body)
+
+	if err := webhook.Verify(secret, header, body, time.Minute, now); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	for name, err := range map[string]error{
+		"body":     webhook.Verify(secret, header, []byte(`{"id":"2"}`), time.Minute, now),
+		"secret":   webhook.Verify([]byte("other"), header, body, time.Minute, now),
+		"expired":  webhook.Verify(secret, header, body, time.Minute, now.Add(2*time.Minute)),
+		"garbage":  webhook.Verify(secret, "v1=abc", body, 0, now),
+		"no value": webhook.Verify(secret, "", body, 0, now),
+	} {
+		if err == nil {
+			t.Errorf("Verify() with wrong %s succeeded", name)
+		}
+	}
+}
+
+func TestNewDispatcher_Validates(t *testing.T) {
+	for name, sub := range map[string]*webhook.Subscription{
+		"id":     {URL: "http://localhost", Secret: secret},
+		"url":    {ID: "a", URL: "ftp://localhost", Secret: secret},
+		"secret": {ID: "a", URL: "http://localhost"},
+		"event":  {ID: "a", URL: "http://localhost", Secret: secret, Events: []events.Type{events.TypeReset}},
+	} {
+		store, _ := webhook.NewFileStore(t.TempDir())
+		if _, err := webhook.NewDispatcher(webhook.DispatcherConfig{}, []*webhook.Subscription{sub}, store); err == nil {
+			t.Errorf("subscription with invalid %s accepted", name)
+		}
+	}
+}
+
+func TestDispatcher_DeliversMatchingChanges(t *testing.T) {
+	ctx := context.Background()
+	receiver := webhooktest.NewReceiver(secret)
+	defer receiver.Close()
+
+	d := newDispatcher(t, t.TempDir(), webhook.DispatcherConfig{RegionID: "r1"}, &webhook.Subscription{
+		ID:     "docs",
+		URL:    receiver.URL,
+		Secret: secret,
+		Paths:  []string{"docs"},
+		Events: []events.Type{events.TypeCreate, events.TypeDelete},
+	})
+	d.Start(context.Background())
+	defer d.Stop()
+
+	d.QueueChange(ctx, regionsync.ChangeTypeCreate, file("/docs/a.txt"))
+	d.QueueChange(ctx, regionsync.ChangeTypeUpdate, file("/docs/a.txt"))
+	d.QueueChange(ctx, regionsync.ChangeTypeCreate, file("/other/b.txt"))
+	d.QueueChange(ctx, regionsync.ChangeTypeDelete, file("/docs/a.txt"))
+
+	if !receiver.Wait(2, 5*time.Second) {
+		t.Fatalf("received %d requests, want 2", len(receiver.Requests()))
+	}
+	time.Sleep(50 * time.Millisecond)
+	requests := receiver.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("received %d requests, want 2", len(requests))
+	}
+	seen := map[events.Type]bool{}
+	for _, r := range requests {
+		p := r.Payload
+		if p.Webhook != "docs" || p.RegionID != "r1" || p.File == nil || p.File.Path != "/docs/a.txt" {
+			t.Errorf("payload = %+v", p)
+		}
+		if r.Header.Get(webhook.HeaderDelivery) != p.ID || r.Header.Get(webhook.HeaderEvent) != string(p.Type) {
+			t.Errorf("headers = %v", r.Header)
+		}
+		seen[p.Type] = true
+	}
+	if !seen[events.TypeCreate] || !seen[events.TypeDelete] {
+		t.Errorf("event types = %v", seen)
+	}
+}
+
+func TestDispatcher_RetriesAndDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	receiver := webhooktest.NewReceiver(secret)
+	defer receiver.Close()
+
+	cfg := webhook.DispatcherConfig{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	sub := &webhook.Subscription{ID: "all", URL: receiver.URL, Secret: secret}
+	d := newDispatcher(t, t.TempDir(), cfg, sub)
+	d.Start(context.Background())
+	defer d.Stop()
+
+	// Succeeds on the last attempt
+	receiver.FailNext(2, http.StatusServiceUnavailable)
+	d.QueueChange(ctx, regionsync.ChangeTypeCreate, file("/a"))
+	if !receiver.Wait(1, 5*time.Second) {
+		t.Fatal("delivery not retried")
+	}
+
+	// Runs out of attempts
+	receiver.FailNext(3, http.StatusInternalServerError)
+	d.QueueChange(ctx, regionsync.ChangeTypeCreate, file("/b"))
+	var dead []*webhook.Delivery
+	for deadline := time.Now().Add(5 * time.Second); len(dead) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		dead = d.DeadLetters()
+	}
+	if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].LastStatus != http.StatusInternalServerError {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+
+	if _, err := d.Redeliver("missing"); err == nil {
+		t.Error("Redeliver() of an unknown delivery succeeded")
+	}
+	if _, err := d.Redeliver(dead[0].ID); err != nil {
+		t.Fatalf("Redeliver() = %v", err)
+	}
+	if !receiver.Wait(2, 5*time.Second) {
+		t.Fatal("redelivery not received")
+	}
+	if got := receiver.Requests()[1].Payload.ID; got != dead[0].ID {
+		t.Errorf("redelivered %s, want %s", got, dead[0].ID)
+	}
+	if len(d.DeadLetters()) != 0 {
+		t.Error("redelivered delivery still dead")
+	}
+}
+
+func TestDispatcher_Durable(t *testing.T) {
+	ctx := context.Background()
+	receiver := webhooktest.NewReceiver(secret)
+	defer receiver.Close()
+	dir := t.TempDir()
+	sub := func(id string) *webhook.Subscription {
+		return &webhook.Subscription{ID: id, URL: receiver.URL, Secret: secret}
+	}
+
+	// Queued while stopped, and stored on shutdown
+	d := newDispatcher(t, dir, webhook.DispatcherConfig{}, sub("a"), sub("b"))
+	d.QueueChange(ctx, regionsync.ChangeTypeCreate, file("/a"))
+	d.Stop()
+
+	// Restarted without webhook b
+	d = newDispatcher(t, dir, webhook.DispatcherConfig{}, sub("a"))
+	d.Start(context.Background())
+	defer d.Stop()
+
+	if !receiver.Wait(1, 5*time.Second) {
+		t.Fatal("stored delivery not sent after restart")
+	}
+	dead := d.DeadLetters()
+	if len(dead) != 1 || dead[0].Webhook != "b" {
+		t.Errorf("dead letters = %+v, want the delivery of the removed webhook", dead)
+	}
+	if _, err := d.Redeliver(dead[0].ID); err == nil {
+		t.Error("Redeliver() to a removed webhook succeeded")
+	}
+}
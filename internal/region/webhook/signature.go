@@ -0,0 +1,74 @@
+// Package webhook provides payload signing for outbound webhooks.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// Headers of a webhook request.
+const (
+	HeaderSignature = "X-JzSE-Signature" // t=<unix seconds>,v1=<hex HMAC-SHA256>
+	HeaderWebhook   = "X-JzSE-Webhook"   // Subscription ID
+	HeaderDelivery  = "X-JzSE-Delivery"  // Delivery ID, stable across retries
+	HeaderEvent     = "X-JzSE-Event"     // Event type
+)
+
+// Sign returns the signature header of a payload sent at t. The signature
+// is the HMAC-SHA256 of "<unix seconds>.<body>", so receivers can reject
+// replays of old payloads.
+func Sign(secret []byte, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// Verify checks a signature header against body. Signatures older or
+// newer than maxSkew are rejected; zero disables the check.
+func Verify(secret []byte, header string, body []byte, maxSkew time.Duration, now time.Time) error {
+	var timestamp, signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = append(timestamp, value)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if len(timestamp) != 1 || len(signatures) == 0 {
+		return errors.E("webhook.Verify", errors.ErrUnauthorized, nil, "malformed signature")
+	}
+
+	if maxSkew > 0 {
+		unix, err := strconv.ParseInt(timestamp[0], 10, 64)
+		if err != nil {
+			return errors.E("webhook.Verify", errors.ErrUnauthorized, err, "malformed signature timestamp")
+		}
+		if skew := now.Sub(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+			return errors.E("webhook.Verify", errors.ErrUnauthorized, nil, "signature timestamp out of range")
+		}
+	}
+
+	expected := signature(secret, timestamp[0], body)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return errors.E("webhook.Verify", errors.ErrUnauthorized, nil, "signature mismatch")
+}
+
+// signature is the hex HMAC-SHA256 of "<timestamp>.<body>".
+func signature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
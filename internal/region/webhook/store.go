@@ -0,0 +1,106 @@
+// Package webhook provides durable storage of webhook deliveries.
+package webhook
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// Store persists deliveries until they succeed, so pending and dead
+// deliveries survive restarts.
+type Store interface {
+	// Save creates or replaces a delivery.
+	Save(d *Delivery) error
+
+	// Delete removes a delivery. Deleting a missing delivery is not an error.
+	Delete(id string) error
+
+	// Load returns all stored deliveries.
+	Load() ([]*Delivery, error)
+}
+
+// FileStore stores each delivery as a JSON file in a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a FileStore in dir, creating it if necessary.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.E("webhook.NewFileStore", errors.ErrInvalidInput, err, dir)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Save writes a delivery atomically: to a temporary file first, which
+// is then renamed over the previous version.
+func (s *FileStore) Save(d *Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return errors.Wrap("FileStore.Save", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return errors.Wrap("FileStore.Save", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap("FileStore.Save", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap("FileStore.Save", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap("FileStore.Save", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(d.ID)); err != nil {
+		return errors.Wrap("FileStore.Save", err)
+	}
+	return nil
+}
+
+// Delete removes the file of a delivery.
+func (s *FileStore) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap("FileStore.Delete", err)
+	}
+	return nil
+}
+
+// Load reads all delivery files. Unreadable files are reported as an error.
+func (s *FileStore) Load() ([]*Delivery, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.Wrap("FileStore.Load", err)
+	}
+
+	var deliveries []*Delivery
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrap("FileStore.Load", err)
+		}
+		var d Delivery
+		if err := json.Unmarshal(data, &d); err != nil {
+			return nil, errors.E("FileStore.Load", errors.ErrInvalidInput, err, entry.Name())
+		}
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, nil
+}
+
+// path returns the file of a delivery.
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
@@ -0,0 +1,111 @@
+// Package webhooktest provides a local webhook receiver for tests.
+package webhooktest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"asisaid.cn/JzSE/internal/region/webhook"
+)
+
+// Request is a webhook request accepted by a Receiver.
+type Request struct {
+	Header  http.Header
+	Body    []byte
+	Payload webhook.Payload
+}
+
+// Receiver is a webhook endpoint on a local test server. It rejects
+// requests with invalid signatures with 401 and records the others.
+type Receiver struct {
+	*httptest.Server
+	secret []byte
+
+	mu       sync.Mutex
+	requests []Request
+	failures int
+	status   int
+	received chan struct{}
+}
+
+// NewReceiver starts a receiver verifying signatures with secret.
+// Call Close when done.
+func NewReceiver(secret []byte) *Receiver {
+	r := &Receiver{
+		secret:   secret,
+		received: make(chan struct{}, 1),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// FailNext makes the next n requests fail with status.
+func (r *Receiver) FailNext(n, status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = n
+	r.status = status
+}
+
+// Requests returns the requests accepted so far.
+func (r *Receiver) Requests() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Request(nil), r.requests...)
+}
+
+// Wait waits until n requests have been accepted, and reports false if
+// that takes longer than timeout.
+func (r *Receiver) Wait(n int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		if len(r.Requests()) >= n {
+			return true
+		}
+		select {
+		case <-r.received:
+		case <-deadline.C:
+			return len(r.Requests()) >= n
+		}
+	}
+}
+
+func (r *Receiver) serve(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := webhook.Verify(r.secret, req.Header.Get(webhook.HeaderSignature), body, 5*time.Minute, time.Now()); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mu.Lock()
+	if r.failures > 0 {
+		r.failures--
+		status := r.status
+		r.mu.Unlock()
+		w.WriteHeader(status)
+		return
+	}
+	request := Request{Header: req.Header.Clone(), Body: body}
+	if err := json.Unmarshal(body, &request.Payload); err != nil {
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, request)
+	r.mu.Unlock()
+
+	select {
+	case r.received <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,178 @@
+// Package grpc provides the region end of the sync protocol.
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/coordinator/registry"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+	"asisaid.cn/JzSE/pkg/protocol/pb"
+)
+
+// ClientConfig holds configuration for a CoordinatorClient.
+type ClientConfig struct {
+	Addr        string                           // Address of the coordinator's gRPC server
+	Timeout     time.Duration                    // Of each call, zero for none
+	Credentials credentials.PerRPCCredentials    // Nil for anonymous calls
+	TLS         credentials.TransportCredentials // Nil for plaintext, which HMAC credentials refuse
+	Region      registry.RegionInfo              // Sent on registration
+}
+
+// CoordinatorClient connects a region to the coordinator. It implements
+// the Coordinator interface of the sync agent.
+type CoordinatorClient struct {
+	config ClientConfig
+	conn   *grpc.ClientConn
+	client pb.CoordinatorServiceClient
+}
+
+var _ regionsync.Coordinator = (*CoordinatorClient)(nil)
+
+// NewCoordinatorClient creates a client of the coordinator. The
+// connection is established on the first call, and calls are traced.
+func NewCoordinatorClient(cfg ClientConfig) (*CoordinatorClient, error) {
+	transport := cfg.TLS
+	if transport == nil {
+		transport = insecure.NewCredentials()
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(transport),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+	if cfg.Credentials != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(cfg.Credentials))
+	}
+
+	conn, err := grpc.NewClient(cfg.Addr, opts...)
+	if err != nil {
+		return nil, errors.E("NewCoordinatorClient", errors.ErrInvalidInput, err, cfg.Addr)
+	}
+	return &CoordinatorClient{
+		config: cfg,
+		conn:   conn,
+		client: pb.NewCoordinatorServiceClient(conn),
+	}, nil
+}
+
+// Close closes the connection.
+func (c *CoordinatorClient) Close() error {
+	return c.conn.Close()
+}
+
+// withTimeout bounds a call by the configured timeout.
+func (c *CoordinatorClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.config.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.config.Timeout)
+}
+
+// Register implements regionsync.Coordinator.
+func (c *CoordinatorClient) Register(ctx context.Context) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	region := c.config.Region
+	region.Status.State = "healthy"
+	if _, err := c.client.RegisterRegion(ctx, &pb.RegisterRegionRequest{Region: toRegionInfo(&region)}); err != nil {
+		return fromStatus("CoordinatorClient.Register", err)
+	}
+	return nil
+}
+
+// Heartbeat implements regionsync.Coordinator. The pending changes are
+// reported as the sync lag.
+func (c *CoordinatorClient) Heartbeat(ctx context.Context, pending int) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.client.Heartbeat(ctx, &pb.HeartbeatRequest{
+		RegionId: c.config.Region.ID,
+		Status:   &pb.RegionStatus{State: "healthy", SyncLag: int64(pending)},
+	})
+	if err != nil {
+		return fromStatus("CoordinatorClient.Heartbeat", err)
+	}
+	return nil
+}
+
+// PushChanges implements regionsync.Coordinator.
+func (c *CoordinatorClient) PushChanges(ctx context.Context, events []*regionsync.ChangeEvent) ([]error, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	req := &pb.PushChangesRequest{RegionId: c.config.Region.ID, Events: make([]*pb.ChangeEvent, len(events))}
+	for i, event := range events {
+		req.Events[i] = toChangeEvent(event)
+	}
+
+	resp, err := c.client.PushChanges(ctx, req)
+	if err != nil {
+		return nil, fromStatus("CoordinatorClient.PushChanges", err)
+	}
+	if len(resp.Acks) != len(events) {
+		return nil, errors.E("CoordinatorClient.PushChanges", errors.ErrSyncFailed, nil, "coordinator acknowledged a different number of events")
+	}
+
+	results := make([]error, len(events))
+	for i, ack := range resp.Acks {
+		switch {
+		case ack.Accepted:
+		case ack.Conflict:
+			results[i] = errors.E("CoordinatorClient.PushChanges", errors.ErrConflict, nil, ack.Error)
+		default:
+			results[i] = errors.E("CoordinatorClient.PushChanges", errors.ErrSyncFailed, nil, ack.Error)
+		}
+	}
+	return results, nil
+}
+
+// PullChanges implements regionsync.Coordinator.
+func (c *CoordinatorClient) PullChanges(ctx context.Context) ([]*regionsync.ChangeEvent, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := c.client.PullChanges(ctx, &pb.PullChangesRequest{RegionId: c.config.Region.ID})
+	if err != nil {
+		return nil, fromStatus("CoordinatorClient.PullChanges", err)
+	}
+
+	events := make([]*regionsync.ChangeEvent, len(resp.Events))
+	for i, event := range resp.Events {
+		events[i] = fromChangeEvent(event)
+	}
+	return events, nil
+}
+
+// statusKinds maps gRPC status codes to error kinds. Other codes mean the
+// coordinator could not serve the call.
+var statusKinds = map[codes.Code]error{
+	codes.InvalidArgument:    errors.ErrInvalidInput,
+	codes.Unauthenticated:    errors.ErrUnauthorized,
+	codes.PermissionDenied:   errors.ErrForbidden,
+	codes.NotFound:           errors.ErrNotFound,
+	codes.AlreadyExists:      errors.ErrAlreadyExists,
+	codes.Aborted:            errors.ErrConflict,
+	codes.FailedPrecondition: errors.ErrVersionMismatch,
+	codes.Unimplemented:      errors.ErrNotImplemented,
+}
+
+// fromStatus converts the status error of a call to an error of the
+// matching kind.
+func fromStatus(op string, err error) error {
+	st := status.Convert(err)
+	kind, ok := statusKinds[st.Code()]
+	if !ok {
+		kind = errors.ErrCoordinatorUnavailable
+	}
+	return errors.E(op, kind, nil, st.Message())
+}
@@ -0,0 +1,352 @@
+// Package grpc provides conversions between the metadata models and their
+// protobuf messages.
+package grpc
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	globalmeta "asisaid.cn/JzSE/internal/coordinator/metadata"
+	"asisaid.cn/JzSE/internal/coordinator/registry"
+	coordsync "asisaid.cn/JzSE/internal/coordinator/sync"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/service"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+	"asisaid.cn/JzSE/pkg/protocol/pb"
+)
+
+// timestamp converts a time, leaving zero times unset.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// fromTimestamp converts a timestamp, mapping unset ones to the zero time.
+func fromTimestamp(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func toFileMetadata(m *metadata.FileMetadata) *pb.FileMetadata {
+	if m == nil {
+		return nil
+	}
+	return &pb.FileMetadata{
+		Id:           m.ID,
+		Name:         m.Name,
+		Path:         m.Path,
+		Size:         m.Size,
+		ContentHash:  m.ContentHash,
+		MimeType:     m.MimeType,
+		Digests:      m.Digests,
+		Version:      m.Version,
+		VectorClock:  m.VectorClock,
+		OwnerId:      m.OwnerID,
+		CreatedAt:    timestamp(m.CreatedAt),
+		UpdatedAt:    timestamp(m.UpdatedAt),
+		CreatedBy:    m.CreatedBy,
+		UpdatedBy:    m.UpdatedBy,
+		OriginRegion: m.OriginRegion,
+		LocalState:   string(m.LocalState),
+		SyncState:    string(m.SyncState),
+		CustomMeta:   m.CustomMeta,
+		Verdict:      toVerdict(m.Verdict),
+		Etag:         m.ETag(),
+	}
+}
+
+func fromFileMetadata(m *pb.FileMetadata) *metadata.FileMetadata {
+	if m == nil {
+		return nil
+	}
+	return &metadata.FileMetadata{
+		ID:           m.Id,
+		Name:         m.Name,
+		Path:         m.Path,
+		Size:         m.Size,
+		ContentHash:  m.ContentHash,
+		MimeType:     m.MimeType,
+		Digests:      m.Digests,
+		Version:      m.Version,
+		VectorClock:  m.VectorClock,
+		OwnerID:      m.OwnerId,
+		CreatedAt:    fromTimestamp(m.CreatedAt),
+		UpdatedAt:    fromTimestamp(m.UpdatedAt),
+		CreatedBy:    m.CreatedBy,
+		UpdatedBy:    m.UpdatedBy,
+		OriginRegion: m.OriginRegion,
+		LocalState:   metadata.LocalState(m.LocalState),
+		SyncState:    metadata.SyncState(m.SyncState),
+		CustomMeta:   m.CustomMeta,
+		Verdict:      fromVerdict(m.Verdict),
+	}
+}
+
+func toVerdict(v *metadata.Verdict) *pb.Verdict {
+	if v == nil {
+		return nil
+	}
+	return &pb.Verdict{
+		Action:    string(v.Action),
+		Hook:      v.Hook,
+		Reason:    v.Reason,
+		CheckedAt: timestamp(v.CheckedAt),
+	}
+}
+
+func fromVerdict(v *pb.Verdict) *metadata.Verdict {
+	if v == nil {
+		return nil
+	}
+	return &metadata.Verdict{
+		Action:    metadata.VerdictAction(v.Action),
+		Hook:      v.Hook,
+		Reason:    v.Reason,
+		CheckedAt: fromTimestamp(v.CheckedAt),
+	}
+}
+
+func toACL(a *metadata.ACL) *pb.ACL {
+	if a == nil {
+		return nil
+	}
+	entries := make([]*pb.ACLEntry, len(a.Entries))
+	for i, entry := range a.Entries {
+		perms := make([]string, len(entry.Permissions))
+		for j, perm := range entry.Permissions {
+			perms[j] = string(perm)
+		}
+		entries[i] = &pb.ACLEntry{Principal: entry.Principal, Permissions: perms}
+	}
+	return &pb.ACL{
+		Path:        a.Path,
+		Owner:       a.Owner,
+		Entries:     entries,
+		NoInherit:   a.NoInherit,
+		Version:     a.Version,
+		VectorClock: a.VectorClock,
+		SyncState:   string(a.SyncState),
+		Deleted:     a.Deleted,
+		UpdatedAt:   timestamp(a.UpdatedAt),
+		UpdatedBy:   a.UpdatedBy,
+	}
+}
+
+func fromACL(a *pb.ACL) *metadata.ACL {
+	if a == nil {
+		return nil
+	}
+	entries := make([]metadata.ACLEntry, len(a.Entries))
+	for i, entry := range a.Entries {
+		perms := make([]metadata.Permission, len(entry.Permissions))
+		for j, perm := range entry.Permissions {
+			perms[j] = metadata.Permission(perm)
+		}
+		entries[i] = metadata.ACLEntry{Principal: entry.Principal, Permissions: perms}
+	}
+	return &metadata.ACL{
+		Path:        a.Path,
+		Owner:       a.Owner,
+		Entries:     entries,
+		NoInherit:   a.NoInherit,
+		Version:     a.Version,
+		VectorClock: a.VectorClock,
+		SyncState:   metadata.SyncState(a.SyncState),
+		Deleted:     a.Deleted,
+		UpdatedAt:   fromTimestamp(a.UpdatedAt),
+		UpdatedBy:   a.UpdatedBy,
+	}
+}
+
+func toDirectoryEntry(e *metadata.DirectoryEntry) *pb.DirectoryEntry {
+	return &pb.DirectoryEntry{
+		Id:        e.ID,
+		Name:      e.Name,
+		Path:      e.Path,
+		IsDir:     e.IsDir,
+		Size:      e.Size,
+		UpdatedAt: timestamp(e.UpdatedAt),
+	}
+}
+
+func toUploadResponse(r *service.UploadResponse) *pb.UploadResponse {
+	return &pb.UploadResponse{
+		FileId:      r.FileID,
+		Path:        r.Path,
+		Size:        r.Size,
+		ContentHash: r.ContentHash,
+		Version:     r.Version,
+		Etag:        r.ETag,
+		CreatedAt:   timestamp(r.CreatedAt),
+		UpdatedAt:   timestamp(r.UpdatedAt),
+		Replaced:    r.Replaced,
+		Verdict:     toVerdict(r.Verdict),
+	}
+}
+
+// toChangeEvent converts a change event of a region.
+func toChangeEvent(e *regionsync.ChangeEvent) *pb.ChangeEvent {
+	return &pb.ChangeEvent{
+		Id:           e.ID,
+		Type:         string(e.Type),
+		FileId:       e.FileID,
+		Metadata:     toFileMetadata(e.Metadata),
+		Acl:          toACL(e.ACL),
+		VectorClock:  e.VectorClock,
+		Timestamp:    timestamp(e.Timestamp),
+		RegionId:     e.RegionID,
+		TraceContext: e.TraceContext,
+		RequestId:    e.RequestID,
+	}
+}
+
+// fromChangeEvent converts a change event to the region's form.
+func fromChangeEvent(e *pb.ChangeEvent) *regionsync.ChangeEvent {
+	return &regionsync.ChangeEvent{
+		ID:           e.Id,
+		Type:         regionsync.ChangeType(e.Type),
+		FileID:       e.FileId,
+		Metadata:     fromFileMetadata(e.Metadata),
+		ACL:          fromACL(e.Acl),
+		VectorClock:  e.VectorClock,
+		Timestamp:    fromTimestamp(e.Timestamp),
+		RegionID:     e.RegionId,
+		TraceContext: e.TraceContext,
+		RequestID:    e.RequestId,
+	}
+}
+
+// toEngineEvent converts a pushed change event for the coordinator's sync
+// engine. The pushing region is recorded as holding the file.
+func toEngineEvent(e *pb.ChangeEvent, now time.Time) *coordsync.ChangeEvent {
+	event := &coordsync.ChangeEvent{
+		ID:           e.Id,
+		Type:         e.Type,
+		FileID:       e.FileId,
+		VectorClock:  e.VectorClock,
+		Timestamp:    fromTimestamp(e.Timestamp),
+		RegionID:     e.RegionId,
+		TraceContext: e.TraceContext,
+		RequestID:    e.RequestId,
+	}
+	if meta := fromFileMetadata(e.Metadata); meta != nil {
+		event.Metadata = &globalmeta.GlobalFileMetadata{
+			FileMetadata: *meta,
+			Locations:    []globalmeta.RegionLocation{{RegionID: e.RegionId, State: "synced", LastSyncAt: now}},
+			Primary:      meta.OriginRegion,
+			Replicas:     1,
+		}
+	}
+	return event
+}
+
+// fromEngineEvent converts a change event queued by the coordinator's
+// sync engine.
+func fromEngineEvent(e *coordsync.ChangeEvent) *pb.ChangeEvent {
+	event := &pb.ChangeEvent{
+		Id:           e.ID,
+		Type:         e.Type,
+		FileId:       e.FileID,
+		VectorClock:  e.VectorClock,
+		Timestamp:    timestamp(e.Timestamp),
+		RegionId:     e.RegionID,
+		TraceContext: e.TraceContext,
+		RequestId:    e.RequestID,
+	}
+	if e.Metadata != nil {
+		event.Metadata = toFileMetadata(&e.Metadata.FileMetadata)
+	}
+	return event
+}
+
+func toGlobalFileMetadata(m *globalmeta.GlobalFileMetadata) *pb.GlobalFileMetadata {
+	locations := make([]*pb.RegionLocation, len(m.Locations))
+	for i, loc := range m.Locations {
+		locations[i] = &pb.RegionLocation{
+			RegionId:   loc.RegionID,
+			State:      loc.State,
+			LastSyncAt: timestamp(loc.LastSyncAt),
+		}
+	}
+	return &pb.GlobalFileMetadata{
+		File:      toFileMetadata(&m.FileMetadata),
+		Locations: locations,
+		Primary:   m.Primary,
+		Replicas:  int32(m.Replicas),
+	}
+}
+
+func toRegionInfo(r *registry.RegionInfo) *pb.RegionInfo {
+	return &pb.RegionInfo{
+		Id:       r.ID,
+		Name:     r.Name,
+		Endpoint: r.Endpoint,
+		Location: &pb.GeoLocation{
+			Latitude:  r.Location.Latitude,
+			Longitude: r.Location.Longitude,
+			City:      r.Location.City,
+			Country:   r.Location.Country,
+		},
+		Capacity: &pb.Capacity{
+			TotalBytes: r.Capacity.TotalBytes,
+			UsedBytes:  r.Capacity.UsedBytes,
+			FreeBytes:  r.Capacity.FreeBytes,
+		},
+		Status:     toRegionStatus(&r.Status),
+		JoinedAt:   timestamp(r.JoinedAt),
+		LastSeenAt: timestamp(r.LastSeenAt),
+	}
+}
+
+func fromRegionInfo(r *pb.RegionInfo) *registry.RegionInfo {
+	info := &registry.RegionInfo{
+		ID:       r.Id,
+		Name:     r.Name,
+		Endpoint: r.Endpoint,
+	}
+	if loc := r.Location; loc != nil {
+		info.Location = registry.GeoLocation{
+			Latitude:  loc.Latitude,
+			Longitude: loc.Longitude,
+			City:      loc.City,
+			Country:   loc.Country,
+		}
+	}
+	if c := r.Capacity; c != nil {
+		info.Capacity = registry.Capacity{
+			TotalBytes: c.TotalBytes,
+			UsedBytes:  c.UsedBytes,
+			FreeBytes:  c.FreeBytes,
+		}
+	}
+	if r.Status != nil {
+		info.Status = *fromRegionStatus(r.Status)
+	}
+	return info
+}
+
+func toRegionStatus(s *registry.RegionStatus) *pb.RegionStatus {
+	return &pb.RegionStatus{
+		State:       s.State,
+		SyncLag:     s.SyncLag,
+		LoadLevel:   s.LoadLevel,
+		LastCheckAt: timestamp(s.LastCheckAt),
+	}
+}
+
+func fromRegionStatus(s *pb.RegionStatus) *registry.RegionStatus {
+	if s == nil {
+		return &registry.RegionStatus{}
+	}
+	return &registry.RegionStatus{
+		State:       s.State,
+		SyncLag:     s.SyncLag,
+		LoadLevel:   s.LoadLevel,
+		LastCheckAt: fromTimestamp(s.LastCheckAt),
+	}
+}
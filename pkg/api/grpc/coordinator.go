@@ -0,0 +1,187 @@
+// Package grpc provides the coordinator service and the server end of the
+// sync protocol.
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	globalmeta "asisaid.cn/JzSE/internal/coordinator/metadata"
+	"asisaid.cn/JzSE/internal/coordinator/registry"
+	coordsync "asisaid.cn/JzSE/internal/coordinator/sync"
+	"asisaid.cn/JzSE/pkg/protocol/pb"
+)
+
+// RegionMethods are the coordinator methods called by regions, which
+// always require credentials when authentication is configured.
+var RegionMethods = []string{
+	pb.CoordinatorService_RegisterRegion_FullMethodName,
+	pb.CoordinatorService_Heartbeat_FullMethodName,
+	pb.CoordinatorService_PushChanges_FullMethodName,
+	pb.CoordinatorService_PullChanges_FullMethodName,
+}
+
+// CoordinatorServer serves the coordinator service.
+type CoordinatorServer struct {
+	pb.UnimplementedCoordinatorServiceServer
+
+	metaManager globalmeta.Manager
+	registry    *registry.Registry
+	engine      *coordsync.Engine
+	logger      *zap.Logger
+}
+
+// NewCoordinatorServer creates a CoordinatorServer.
+func NewCoordinatorServer(metaManager globalmeta.Manager, reg *registry.Registry, engine *coordsync.Engine) *CoordinatorServer {
+	return &CoordinatorServer{
+		metaManager: metaManager,
+		registry:    reg,
+		engine:      engine,
+		logger:      logger.WithComponent("CoordinatorServer"),
+	}
+}
+
+// Register registers the coordinator service with a gRPC server.
+func (s *CoordinatorServer) Register(server *grpc.Server) {
+	pb.RegisterCoordinatorServiceServer(server, s)
+}
+
+// RegisterRegion joins a region to the registry and the sync engine. A
+// region that is already registered is refreshed as by a heartbeat, and
+// keeps the changes pending for it.
+func (s *CoordinatorServer) RegisterRegion(ctx context.Context, req *pb.RegisterRegionRequest) (*pb.RegisterRegionResponse, error) {
+	const method = pb.CoordinatorService_RegisterRegion_FullMethodName
+
+	if req.Region == nil || req.Region.Id == "" {
+		return nil, apierror.GRPCStatus(method, errors.E("CoordinatorServer.RegisterRegion", errors.ErrInvalidInput, nil, "region id required"))
+	}
+	if err := auth.AuthorizeRegion(auth.IdentityFrom(ctx), "CoordinatorServer.RegisterRegion", req.Region.Id); err != nil {
+		return nil, apierror.GRPCStatus(method, err)
+	}
+
+	info := fromRegionInfo(req.Region)
+	err := s.registry.Register(ctx, info)
+	switch {
+	case err == nil:
+		s.engine.RegisterRegion(info.ID)
+	case errors.IsAlreadyExists(err):
+		status := info.Status
+		status.State = "healthy"
+		if err := s.registry.Heartbeat(ctx, info.ID, &status); err != nil {
+			return nil, apierror.GRPCStatus(method, err)
+		}
+	default:
+		return nil, apierror.GRPCStatus(method, err)
+	}
+
+	registered, err := s.registry.GetRegion(ctx, info.ID)
+	if err != nil {
+		return nil, apierror.GRPCStatus(method, err)
+	}
+	return &pb.RegisterRegionResponse{Region: toRegionInfo(registered)}, nil
+}
+
+// Heartbeat records the health reported by a region.
+func (s *CoordinatorServer) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	if err := auth.AuthorizeRegion(auth.IdentityFrom(ctx), "CoordinatorServer.Heartbeat", req.RegionId); err != nil {
+		return nil, apierror.GRPCStatus(pb.CoordinatorService_Heartbeat_FullMethodName, err)
+	}
+	if err := s.registry.Heartbeat(ctx, req.RegionId, fromRegionStatus(req.Status)); err != nil {
+		return nil, apierror.GRPCStatus(pb.CoordinatorService_Heartbeat_FullMethodName, err)
+	}
+	return &pb.HeartbeatResponse{}, nil
+}
+
+// PushChanges hands the change events of a region to the sync engine, in
+// order. Replayed events are accepted: a file created twice or deleted
+// when the coordinator does not know it was already handled. Events of
+// other regions are refused.
+func (s *CoordinatorServer) PushChanges(ctx context.Context, req *pb.PushChangesRequest) (*pb.PushChangesResponse, error) {
+	if err := auth.AuthorizeRegion(auth.IdentityFrom(ctx), "CoordinatorServer.PushChanges", req.RegionId); err != nil {
+		return nil, apierror.GRPCStatus(pb.CoordinatorService_PushChanges_FullMethodName, err)
+	}
+
+	now := time.Now()
+	resp := &pb.PushChangesResponse{Acks: make([]*pb.ChangeAck, len(req.Events))}
+	for i, event := range req.Events {
+		if event.RegionId == "" {
+			event.RegionId = req.RegionId
+		}
+
+		var err error
+		if event.RegionId != req.RegionId {
+			err = errors.E("CoordinatorServer.PushChanges", errors.ErrForbidden, nil, "change of region "+event.RegionId+" pushed by "+req.RegionId)
+		} else {
+			err = s.engine.HandleChange(ctx, toEngineEvent(event, now))
+		}
+		switch {
+		case event.Type == "CREATE" && errors.IsAlreadyExists(err),
+			event.Type == "DELETE" && errors.IsNotFound(err):
+			err = nil
+		case err != nil:
+			s.logger.Warn("change not applied",
+				zap.String("event_id", event.Id),
+				zap.String("file_id", event.FileId),
+				zap.String("region_id", event.RegionId),
+				logger.RequestID(event.RequestId),
+				zap.Error(err),
+			)
+		}
+
+		ack := &pb.ChangeAck{EventId: event.Id, Accepted: err == nil}
+		if err != nil {
+			ack.Conflict = errors.IsConflict(err)
+			ack.Error = apierror.Message(err)
+		}
+		resp.Acks[i] = ack
+	}
+	return resp, nil
+}
+
+// PullChanges returns the changes queued for a region.
+func (s *CoordinatorServer) PullChanges(ctx context.Context, req *pb.PullChangesRequest) (*pb.PullChangesResponse, error) {
+	if err := auth.AuthorizeRegion(auth.IdentityFrom(ctx), "CoordinatorServer.PullChanges", req.RegionId); err != nil {
+		return nil, apierror.GRPCStatus(pb.CoordinatorService_PullChanges_FullMethodName, err)
+	}
+
+	events, err := s.engine.GetPendingChanges(ctx, req.RegionId)
+	if err != nil {
+		return nil, apierror.GRPCStatus(pb.CoordinatorService_PullChanges_FullMethodName, err)
+	}
+
+	resp := &pb.PullChangesResponse{Events: make([]*pb.ChangeEvent, len(events))}
+	for i, event := range events {
+		resp.Events[i] = fromEngineEvent(event)
+	}
+	return resp, nil
+}
+
+// GetMetadata returns the global metadata of a file.
+func (s *CoordinatorServer) GetMetadata(ctx context.Context, req *pb.GetGlobalMetadataRequest) (*pb.GlobalFileMetadata, error) {
+	meta, err := s.metaManager.Get(ctx, req.FileId)
+	if err != nil {
+		return nil, apierror.GRPCStatus(pb.CoordinatorService_GetMetadata_FullMethodName, err)
+	}
+	return toGlobalFileMetadata(meta), nil
+}
+
+// ListRegions lists the regions that are not offline.
+func (s *CoordinatorServer) ListRegions(ctx context.Context, req *pb.ListRegionsRequest) (*pb.ListRegionsResponse, error) {
+	regions, err := s.registry.GetActiveRegions(ctx)
+	if err != nil {
+		return nil, apierror.GRPCStatus(pb.CoordinatorService_ListRegions_FullMethodName, err)
+	}
+
+	resp := &pb.ListRegionsResponse{Regions: make([]*pb.RegionInfo, len(regions))}
+	for i, region := range regions {
+		resp.Regions[i] = toRegionInfo(region)
+	}
+	return resp, nil
+}
@@ -0,0 +1,263 @@
+// Package grpc provides the file service of a region.
+package grpc
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/service"
+	"asisaid.cn/JzSE/pkg/protocol/pb"
+)
+
+// chunkSize is the size of the content chunks of downloads.
+const chunkSize = 64 << 10
+
+// FileServer serves the file service of a region.
+type FileServer struct {
+	pb.UnimplementedFileServiceServer
+
+	files         *service.FileService
+	maxUploadSize int64 // Zero for unlimited
+	logger        *zap.Logger
+}
+
+// NewFileServer creates a FileServer on top of a file service.
+func NewFileServer(files *service.FileService) *FileServer {
+	return &FileServer{
+		files:  files,
+		logger: logger.WithComponent("FileServer"),
+	}
+}
+
+// SetMaxUploadSize limits the size of uploaded files. Zero or a negative
+// size disables the limit.
+func (s *FileServer) SetMaxUploadSize(size int64) {
+	s.maxUploadSize = size
+}
+
+// Register registers the file service with a gRPC server.
+func (s *FileServer) Register(server *grpc.Server) {
+	pb.RegisterFileServiceServer(server, s)
+}
+
+// withCaller makes FileService operations authorized for the caller of a
+// call, as identified by the authentication interceptors.
+func withCaller(ctx context.Context) context.Context {
+	caller := &metadata.Principal{UserID: "anonymous", Anonymous: true}
+	if identity := auth.IdentityFrom(ctx); identity != nil {
+		caller = &metadata.Principal{UserID: identity.UserID, Groups: identity.Groups}
+	}
+	return service.WithCaller(ctx, caller)
+}
+
+// Upload stores a file sent as a header followed by content chunks.
+func (s *FileServer) Upload(stream pb.FileService_UploadServer) error {
+	const method = pb.FileService_Upload_FullMethodName
+	ctx := withCaller(stream.Context())
+
+	first, err := stream.Recv()
+	if err != nil {
+		return apierror.GRPCStatus(method, errors.E("FileServer.Upload", errors.ErrInvalidInput, err, "upload header"))
+	}
+	header := first.GetHeader()
+	if header == nil {
+		return apierror.GRPCStatus(method, errors.E("FileServer.Upload", errors.ErrInvalidInput, nil, "the first message must carry the upload header"))
+	}
+
+	req, err := s.uploadRequest(ctx, header)
+	if err != nil {
+		return apierror.GRPCStatus(method, err)
+	}
+	req.Content = &uploadReader{stream: stream, limit: s.maxUploadSize}
+
+	resp, err := s.files.Upload(ctx, req)
+	if err != nil {
+		return apierror.GRPCStatus(method, err)
+	}
+	return stream.SendAndClose(toUploadResponse(resp))
+}
+
+// uploadRequest builds the upload request described by a header.
+func (s *FileServer) uploadRequest(ctx context.Context, header *pb.UploadHeader) (*service.UploadRequest, error) {
+	if s.maxUploadSize > 0 && header.Size > s.maxUploadSize {
+		return nil, errors.E("FileServer.Upload", errors.ErrTooLarge, nil, "upload exceeds maximum size of "+strconv.FormatInt(s.maxUploadSize, 10)+" bytes")
+	}
+
+	policy, err := service.ParseConflictPolicy(header.OnConflict)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := service.Digests{}
+	if len(header.Md5) > 0 {
+		if err := digests.Add(service.DigestMD5, header.Md5); err != nil {
+			return nil, err
+		}
+	}
+	if len(header.Sha256) > 0 {
+		if err := digests.Add(service.DigestSHA256, header.Sha256); err != nil {
+			return nil, err
+		}
+	}
+
+	path := header.Path
+	if path == "" {
+		path = "/"
+	}
+	userID := "anonymous"
+	if caller := service.CallerFrom(ctx); caller != nil {
+		userID = caller.UserID
+	}
+
+	return &service.UploadRequest{
+		Path:        path,
+		Name:        header.Name,
+		Size:        header.Size,
+		MimeType:    header.MimeType,
+		OwnerID:     userID,
+		OnConflict:  policy,
+		IfMatch:     header.IfMatch,
+		IfNoneMatch: header.IfNoneMatch,
+		Digests:     digests,
+	}, nil
+}
+
+// uploadReader reads the content chunks of an upload stream, failing
+// reads past the upload limit with ErrTooLarge.
+type uploadReader struct {
+	stream pb.FileService_UploadServer
+	chunk  []byte
+	read   int64
+	limit  int64 // Zero for unlimited
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		msg, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		if msg.GetHeader() != nil {
+			return 0, errors.E("FileServer.Upload", errors.ErrInvalidInput, nil, "upload header sent twice")
+		}
+		r.chunk = msg.GetChunk()
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	r.read += int64(n)
+	if r.limit > 0 && r.read > r.limit {
+		return 0, errors.E("FileServer.Upload", errors.ErrTooLarge, nil, "upload exceeds maximum size of "+strconv.FormatInt(r.limit, 10)+" bytes")
+	}
+	return n, nil
+}
+
+// Download sends the metadata of a file, then its content in chunks.
+func (s *FileServer) Download(req *pb.DownloadRequest, stream pb.FileService_DownloadServer) error {
+	const method = pb.FileService_Download_FullMethodName
+	ctx := withCaller(stream.Context())
+
+	if req.Offset < 0 || req.Length < 0 {
+		return apierror.GRPCStatus(method, errors.E("FileServer.Download", errors.ErrInvalidInput, nil, "negative offset or length"))
+	}
+
+	resp, err := s.files.Download(ctx, req.FileId)
+	if err != nil {
+		return apierror.GRPCStatus(method, err)
+	}
+	defer resp.Content.Close()
+
+	if err := stream.Send(&pb.DownloadResponse{Data: &pb.DownloadResponse_Metadata{Metadata: toFileMetadata(resp.Metadata)}}); err != nil {
+		return err
+	}
+
+	var content io.Reader = resp.Content
+	if req.Offset > 0 {
+		if _, err := io.CopyN(io.Discard, content, req.Offset); err != nil && err != io.EOF {
+			return apierror.GRPCStatus(method, errors.Wrap("FileServer.Download", err))
+		}
+	}
+	if req.Length > 0 {
+		content = io.LimitReader(content, req.Length)
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&pb.DownloadResponse{Data: &pb.DownloadResponse_Chunk{Chunk: buf[:n]}}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logger.FromContext(stream.Context(), s.logger).Warn("download interrupted",
+				zap.String("file_id", req.FileId),
+				zap.Error(err),
+			)
+			return apierror.GRPCStatus(method, errors.Wrap("FileServer.Download", err))
+		}
+	}
+}
+
+// GetMetadata returns the metadata of a file.
+func (s *FileServer) GetMetadata(ctx context.Context, req *pb.GetMetadataRequest) (*pb.FileMetadata, error) {
+	meta, err := s.files.GetMetadata(withCaller(ctx), req.FileId)
+	if err != nil {
+		return nil, apierror.GRPCStatus(pb.FileService_GetMetadata_FullMethodName, err)
+	}
+	return toFileMetadata(meta), nil
+}
+
+// Stat resolves a path to a file or a directory.
+func (s *FileServer) Stat(ctx context.Context, req *pb.StatRequest) (*pb.StatResponse, error) {
+	info, err := s.files.Stat(withCaller(ctx), req.Path)
+	if err != nil {
+		return nil, apierror.GRPCStatus(pb.FileService_Stat_FullMethodName, err)
+	}
+	return &pb.StatResponse{Path: info.Path, IsDir: info.IsDir, File: toFileMetadata(info.File)}, nil
+}
+
+// ListDirectory lists the entries of a directory.
+func (s *FileServer) ListDirectory(ctx context.Context, req *pb.ListDirectoryRequest) (*pb.ListDirectoryResponse, error) {
+	entries, err := s.files.ListDirectory(withCaller(ctx), req.Path)
+	if err != nil {
+		return nil, apierror.GRPCStatus(pb.FileService_ListDirectory_FullMethodName, err)
+	}
+
+	resp := &pb.ListDirectoryResponse{Entries: make([]*pb.DirectoryEntry, len(entries))}
+	for i, entry := range entries {
+		resp.Entries[i] = toDirectoryEntry(entry)
+	}
+	return resp, nil
+}
+
+// Delete deletes a file by ID, or a file or empty directory by path.
+func (s *FileServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	ctx = withCaller(ctx)
+
+	var err error
+	switch target := req.Target.(type) {
+	case *pb.DeleteRequest_FileId:
+		err = s.files.DeleteIfMatch(ctx, target.FileId, req.IfMatch)
+	case *pb.DeleteRequest_Path:
+		err = s.files.DeletePath(ctx, target.Path, req.IfMatch)
+	default:
+		err = errors.E("FileServer.Delete", errors.ErrInvalidInput, nil, "file_id or path required")
+	}
+	if err != nil {
+		return nil, apierror.GRPCStatus(pb.FileService_Delete_FullMethodName, err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
@@ -0,0 +1,149 @@
+// Package grpc provides the gRPC API of the region and coordinator
+// services: the file service of a region, and the coordinator service
+// with the region-coordinator sync protocol. Messages are defined in
+// pkg/protocol/proto.
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/common/logger"
+)
+
+// requestIDKey is the metadata key of the request ID of a call, the
+// counterpart of the X-Request-ID header of the REST APIs.
+const requestIDKey = "x-request-id"
+
+// NewServer creates a gRPC server that traces and logs calls, assigns
+// each call its request ID, turns panics into internal errors and, with
+// an authenticator, authenticates calls as configured by opts, over TLS
+// if opts has it. Lines
+// logged while serving a call carry its request ID and fields, such as
+// the region serving it.
+func NewServer(authn auth.Authenticator, opts auth.GRPCOptions, fields ...zap.Field) *grpc.Server {
+	log := logger.WithComponent("grpc")
+
+	unary := []grpc.UnaryServerInterceptor{logUnary(log, fields), recoverUnary}
+	stream := []grpc.StreamServerInterceptor{logStream(log, fields), recoverStream}
+	if authn != nil {
+		unary = append(unary, auth.UnaryServerInterceptor(authn, opts))
+		stream = append(stream, auth.StreamServerInterceptor(authn, opts))
+	}
+
+	serverOpts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+	if opts.TLS != nil {
+		serverOpts = append(serverOpts, grpc.Creds(opts.TLS))
+	}
+	return grpc.NewServer(serverOpts...)
+}
+
+// logUnary logs unary calls with their status code and latency.
+func logUnary(log *zap.Logger, fields []zap.Field) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		ctx = withRequestID(ctx, fields)
+		resp, err := handler(ctx, req)
+		logCall(ctx, log, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// logStream logs streaming calls with their status code and latency.
+func logStream(log *zap.Logger, fields []zap.Field) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx := withRequestID(ss.Context(), fields)
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		logCall(ctx, log, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logCall(ctx context.Context, log *zap.Logger, method string, start time.Time, err error) {
+	logger.FromContext(ctx, log).Info("call",
+		zap.String("method", method),
+		zap.String("code", status.Code(err).String()),
+		zap.Duration("latency", time.Since(start)),
+	)
+}
+
+// withRequestID returns the context of a call carrying its request ID,
+// taken from the call metadata or generated, and fields. The ID is sent
+// back in the response header.
+func withRequestID(ctx context.Context, fields []zap.Field) context.Context {
+	var supplied string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDKey); len(values) > 0 {
+			supplied = values[0]
+		}
+	}
+	id := apierror.NewRequestID(supplied)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+	ctx = logger.ContextWith(ctx, logger.RequestID(id))
+	return logger.ContextWith(ctx, fields...)
+}
+
+// contextStream is a server stream with the context of its call.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// recoverUnary answers panics of unary handlers with an internal error.
+func recoverUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = apierror.GRPCStatus(info.FullMethod, fmt.Errorf("panic: %v", recovered))
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// recoverStream answers panics of streaming handlers with an internal
+// error.
+func recoverStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = apierror.GRPCStatus(info.FullMethod, fmt.Errorf("panic: %v", recovered))
+		}
+	}()
+	return handler(srv, ss)
+}
+
+// Shutdown stops a server gracefully, waiting for calls in progress to
+// finish, or forcibly once ctx is done, in which case it returns the
+// error of ctx.
+func Shutdown(ctx context.Context, server *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
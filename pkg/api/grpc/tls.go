@@ -0,0 +1,36 @@
+// Package grpc provides the TLS credentials of gRPC servers and clients.
+package grpc
+
+import (
+	"crypto/tls"
+
+	"google.golang.org/grpc/credentials"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// ServerTLS loads the TLS credentials of a server from PEM files. Without
+// files it returns nil, for a plaintext server.
+func ServerTLS(certFile, keyFile string) (credentials.TransportCredentials, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+	if err != nil {
+		return nil, errors.E("grpc.ServerTLS", errors.ErrInvalidInput, err, certFile)
+	}
+	return creds, nil
+}
+
+// ClientTLS returns the TLS credentials of a client, verifying servers
+// with the CA certificates of a PEM file, or the system roots without one.
+func ClientTLS(caFile string) (credentials.TransportCredentials, error) {
+	if caFile == "" {
+		return credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12}), nil
+	}
+	creds, err := credentials.NewClientTLSFromFile(caFile, "")
+	if err != nil {
+		return nil, errors.E("grpc.ClientTLS", errors.ErrInvalidInput, err, caFile)
+	}
+	return creds, nil
+}
@@ -0,0 +1,96 @@
+// Package http provides the access control API.
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/presign"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// aclRequest is the body of an ACL update.
+type aclRequest struct {
+	Owner     string              `json:"owner"` // Defaults to the current owner, or the caller for a new ACL
+	Entries   []metadata.ACLEntry `json:"entries"`
+	NoInherit bool                `json:"no_inherit"`
+}
+
+// identifyCaller is the middleware that makes FileService operations of a
+// request authorized for its caller. Presigned requests act as the user
+// who signed the URL, so ACLs changed since still apply.
+func (h *Handler) identifyCaller(c *gin.Context) {
+	caller := &metadata.Principal{UserID: userID(c), Anonymous: true}
+	if value, ok := c.Get(presignPolicyKey); ok {
+		if policy := value.(*presign.Policy); policy.UserID != "" {
+			caller = &metadata.Principal{UserID: policy.UserID, Groups: policy.Groups}
+		}
+	} else if value, ok := c.Get(auth.IdentityKey); ok {
+		identity := value.(*auth.Identity)
+		caller = &metadata.Principal{UserID: identity.UserID, Groups: identity.Groups}
+	}
+	c.Request = c.Request.WithContext(service.WithCaller(c.Request.Context(), caller))
+
+	c.Next()
+}
+
+// GetACL returns the ACL attached to a path. Inherited ACLs are not
+// included.
+// GET /api/v1/acl/*path
+func (h *Handler) GetACL(c *gin.Context) {
+	acl, err := h.fileService.GetACL(c.Request.Context(), c.Param("path"))
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, acl)
+}
+
+// SetACL attaches an ACL to a file or directory path, replacing any
+// previous one.
+// PUT /api/v1/acl/*path
+func (h *Handler) SetACL(c *gin.Context) {
+	var req aclRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, errors.E("Handler.SetACL", errors.ErrInvalidInput, err, "ACL"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	path := c.Param("path")
+	if req.Owner == "" {
+		if existing, err := h.fileService.GetACL(ctx, path); err == nil {
+			req.Owner = existing.Owner
+		}
+	}
+
+	acl, err := h.fileService.SetACL(ctx, &metadata.ACL{
+		Path:      path,
+		Owner:     req.Owner,
+		Entries:   req.Entries,
+		NoInherit: req.NoInherit,
+	})
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, acl)
+}
+
+// DeleteACL removes the ACL attached to a path.
+// DELETE /api/v1/acl/*path
+func (h *Handler) DeleteACL(c *gin.Context) {
+	if err := h.fileService.DeleteACL(c.Request.Context(), c.Param("path")); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+// Package http provides directory archive download handlers.
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// Content types of the archive formats.
+var archiveContentTypes = map[service.ArchiveFormat]string{
+	service.ArchiveZip:   "application/zip",
+	service.ArchiveTar:   "application/x-tar",
+	service.ArchiveTarGz: "application/gzip",
+}
+
+// DownloadArchive streams a directory and everything below it as a zip or
+// tar.gz archive. Files that are not available locally are left out and
+// listed in a manifest entry. The optional max_size query parameter lowers
+// the server's archive size limit.
+// GET /api/v1/directories/*path?archive=zip|tar|tar.gz
+func (h *Handler) DownloadArchive(c *gin.Context, dir string) {
+	format, err := service.ParseArchiveFormat(c.Query("archive"))
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	maxSize := h.maxArchiveSize
+	if value := c.Query("max_size"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			apierror.Abort(c, errors.E("Handler.DownloadArchive", errors.ErrInvalidInput, nil, "invalid max_size "+value))
+			return
+		}
+		if maxSize == 0 || limit < maxSize {
+			maxSize = limit
+		}
+	}
+
+	// Resolve everything up front so that limits and missing directories
+	// are reported before the first byte is sent
+	plan, err := h.fileService.PlanArchive(c.Request.Context(), dir, maxSize)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Header("Content-Type", archiveContentTypes[format])
+	c.Header("Content-Disposition", "attachment; filename="+service.ArchiveName(dir, format))
+	c.Status(http.StatusOK)
+
+	if err := h.fileService.WriteArchive(c.Request.Context(), c.Writer, plan, format); err != nil {
+		// Headers are sent already, the client sees a truncated archive
+		logger.FromContext(c.Request.Context(), h.logger).Error("archive download failed",
+			zap.String("path", dir),
+			zap.Error(err),
+		)
+		c.Abort()
+		return
+	}
+}
+
+// Archive formats accepted by content type when no archive parameter is given.
+var archiveFormatsByType = map[string]service.ArchiveFormat{
+	"application/zip":    service.ArchiveZip,
+	"application/x-tar":  service.ArchiveTar,
+	"application/gzip":   service.ArchiveTarGz,
+	"application/x-gzip": service.ArchiveTarGz,
+}
+
+// ExtractArchive extracts an uploaded zip, tar or tar.gz archive into a
+// directory and reports the outcome of every entry. The body is the raw
+// archive; its format comes from the archive query parameter or the
+// Content-Type header.
+// POST /api/v1/directories/*path?archive=zip|tar|tar.gz
+func (h *Handler) ExtractArchive(c *gin.Context) {
+	if !h.limitUpload(c) {
+		return
+	}
+
+	name := c.Query("archive")
+	format, ok := archiveFormatsByType[c.ContentType()]
+	if name != "" || !ok {
+		var err error
+		if format, err = service.ParseArchiveFormat(name); err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+	}
+
+	policy, err := service.ParseConflictPolicy(c.Query("on_conflict"))
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	req := &service.ExtractRequest{
+		Path:       c.Param("path"),
+		Format:     format,
+		Content:    c.Request.Body,
+		OwnerID:    userID(c),
+		OnConflict: policy,
+		MaxEntries: h.maxArchiveEntries,
+		MaxBytes:   h.maxArchiveSize,
+	}
+
+	resp, err := h.fileService.Extract(c.Request.Context(), req)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
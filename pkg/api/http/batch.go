@@ -0,0 +1,103 @@
+// Package http provides the batch metadata API.
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// batchRequest is the body of a batch request.
+type batchRequest struct {
+	Items []*service.BatchItem `json:"items"`
+}
+
+// batchItemResult reports the outcome of one batch item with an HTTP
+// status code, as the equivalent single-file request would have returned.
+type batchItemResult struct {
+	ID       string                 `json:"id,omitempty"`
+	Path     string                 `json:"path,omitempty"`
+	Status   int                    `json:"status"`
+	Code     apierror.Code          `json:"code,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	ETag     string                 `json:"etag,omitempty"`
+	Metadata *metadata.FileMetadata `json:"metadata,omitempty"`
+}
+
+// BatchGetMetadata retrieves the metadata of several files.
+// POST /api/v1/batch/metadata
+func (h *Handler) BatchGetMetadata(c *gin.Context) {
+	h.runBatch(c, http.StatusOK, true, h.fileService.GetMetadataBatch)
+}
+
+// BatchDelete deletes several files. Items may carry an if_match
+// precondition, like the If-Match header of a single delete.
+// POST /api/v1/batch/delete
+func (h *Handler) BatchDelete(c *gin.Context) {
+	h.runBatch(c, http.StatusNoContent, false, h.fileService.DeleteBatch)
+}
+
+// BatchPatchCustomMeta merges custom metadata into several files. Each
+// item's custom_meta is a merge patch: a null value removes the key.
+// POST /api/v1/batch/custom-meta
+func (h *Handler) BatchPatchCustomMeta(c *gin.Context) {
+	user := userID(c)
+	h.runBatch(c, http.StatusOK, true, func(ctx context.Context, items []*service.BatchItem) ([]*service.BatchResult, error) {
+		return h.fileService.PatchCustomMetaBatch(ctx, items, user)
+	})
+}
+
+// runBatch decodes a batch request, runs it and writes the per-item results.
+// The response is 200 OK even if some items failed; only errors affecting
+// the whole batch produce an error status.
+func (h *Handler) runBatch(c *gin.Context, okStatus int, withMetadata bool,
+	run func(context.Context, []*service.BatchItem) ([]*service.BatchResult, error)) {
+	var req batchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, errors.E("Handler.runBatch", errors.ErrInvalidInput, err, "batch request"))
+		return
+	}
+	if len(req.Items) == 0 {
+		apierror.Abort(c, errors.E("Handler.runBatch", errors.ErrInvalidInput, nil, "batch request has no items"))
+		return
+	}
+	if h.maxBatchSize > 0 && len(req.Items) > h.maxBatchSize {
+		apierror.Abort(c, errors.E("Handler.runBatch", errors.ErrTooLarge, nil, "batch exceeds maximum of "+strconv.Itoa(h.maxBatchSize)+" items"))
+		return
+	}
+
+	results, err := run(c.Request.Context(), req.Items)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	response := make([]*batchItemResult, len(results))
+	failed := 0
+	for i, result := range results {
+		item := &batchItemResult{ID: result.ID, Path: result.Path, Status: okStatus}
+		if result.Err != nil {
+			item.Status = apierror.Status(result.Err)
+			item.Code = apierror.CodeOf(result.Err)
+			item.Error = apierror.Message(result.Err)
+			failed++
+		} else if withMetadata {
+			item.ETag = result.Metadata.ETag()
+			item.Metadata = result.Metadata
+		}
+		response[i] = item
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":   response,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	})
+}
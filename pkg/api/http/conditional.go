@@ -0,0 +1,82 @@
+// Package http provides conditional request handling for file downloads.
+package http
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// serveFile writes a file response for GET and HEAD requests.
+// Validators are always sent; the content is only read for a GET that
+// is not answered with 304 Not Modified.
+func (h *Handler) serveFile(c *gin.Context, meta *metadata.FileMetadata) {
+	if meta.LocalState == metadata.LocalStateQuarantined {
+		apierror.Abort(c, errors.E("Handler.serveFile", errors.ErrForbidden, nil, "file quarantined"))
+		return
+	}
+	if meta.LocalState != metadata.LocalStatePresent {
+		apierror.Abort(c, errors.E("Handler.serveFile", errors.ErrNotFound, nil, "file not available locally"))
+		return
+	}
+
+	if notModified(c, meta) {
+		c.Header("ETag", meta.ETag())
+		c.Header("Last-Modified", lastModified(meta))
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	if c.Request.Method == http.MethodHead {
+		setFileHeaders(c, meta)
+		c.Header("Content-Length", strconv.FormatInt(meta.Size, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	resp, err := h.fileService.Download(c.Request.Context(), meta.ID)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	defer resp.Content.Close()
+
+	setFileHeaders(c, resp.Metadata)
+	c.Header("Content-Length", strconv.FormatInt(resp.Metadata.Size, 10))
+
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, resp.Content)
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since.
+// If-Modified-Since is ignored when If-None-Match is present.
+func notModified(c *gin.Context, meta *metadata.FileMetadata) bool {
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		return service.ETagMatches(ifNoneMatch, meta.ETag(), true)
+	}
+
+	ifModifiedSince := c.GetHeader("If-Modified-Since")
+	if ifModifiedSince == "" {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	// HTTP dates have second precision
+	return !meta.UpdatedAt.Truncate(time.Second).After(since)
+}
+
+// lastModified formats the modification time of a file as an HTTP date.
+func lastModified(meta *metadata.FileMetadata) string {
+	return meta.UpdatedAt.UTC().Format(http.TimeFormat)
+}
@@ -0,0 +1,387 @@
+// Package http provides the WebDAV file system backed by the file service.
+package http
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// davFS exposes the file service as a WebDAV file system. Every operation
+// goes through the file service with the caller of the request context,
+// so ACLs, pre-commit hooks and change events apply as for the REST API.
+type davFS struct {
+	files *service.FileService
+}
+
+// Mkdir implements webdav.FileSystem.
+func (fs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return davError("mkdir", name, fs.files.Mkdir(ctx, name))
+}
+
+// RemoveAll implements webdav.FileSystem.
+func (fs *davFS) RemoveAll(ctx context.Context, name string) error {
+	return davError("remove", name, fs.files.RemoveAll(ctx, name))
+}
+
+// Rename implements webdav.FileSystem.
+func (fs *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	_, err := fs.files.Move(ctx, oldName, newName, davUser(ctx))
+	return davError("rename", oldName, err)
+}
+
+// Stat implements webdav.FileSystem.
+func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.files.Stat(ctx, name)
+	if err != nil {
+		return nil, davError("stat", name, err)
+	}
+	return newDAVFileInfo(info.Path, info.File), nil
+}
+
+// OpenFile implements webdav.FileSystem. Files opened for writing are
+// streamed into an upload that is committed when the file is closed.
+func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return fs.create(ctx, name, flag)
+	}
+
+	info, err := fs.files.Stat(ctx, name)
+	if err != nil {
+		return nil, davError("open", name, err)
+	}
+	if info.IsDir {
+		return &davDir{fs: fs, ctx: ctx, path: info.Path}, nil
+	}
+	switch info.File.LocalState {
+	case metadata.LocalStatePresent:
+	case metadata.LocalStateQuarantined:
+		return nil, davError("open", name, errors.E("davFS.OpenFile", errors.ErrForbidden, nil, "file quarantined"))
+	default:
+		return nil, davError("open", name, errors.E("davFS.OpenFile", errors.ErrNotFound, nil, "file not available locally"))
+	}
+	return &davReader{fs: fs, ctx: ctx, meta: info.File}, nil
+}
+
+// create opens a file for writing, replacing any existing content.
+func (fs *davFS) create(ctx context.Context, name string, flag int) (webdav.File, error) {
+	fullPath := service.CleanPath(name)
+	if fullPath == "/" {
+		return nil, davError("open", name, errors.E("davFS.OpenFile", errors.ErrConflict, nil, "cannot write to the root directory"))
+	}
+
+	info, err := fs.files.Stat(ctx, fullPath)
+	switch {
+	case err == nil && info.IsDir:
+		return nil, davError("open", name, errors.E("davFS.OpenFile", errors.ErrConflict, nil, fullPath+" is a directory"))
+	case err == nil && flag&os.O_EXCL != 0:
+		return nil, davError("open", name, errors.E("davFS.OpenFile", errors.ErrAlreadyExists, nil, fullPath+" already exists"))
+	case errors.IsNotFound(err) && flag&os.O_CREATE == 0:
+		return nil, davError("open", name, err)
+	case err != nil && !errors.IsNotFound(err) && !errors.IsForbidden(err):
+		return nil, davError("open", name, err)
+	}
+	// Fail early, before the handler starts copying the body
+	if err := fs.files.CheckAccess(ctx, fullPath, metadata.PermWrite); err != nil {
+		return nil, davError("open", name, err)
+	}
+
+	policy := service.ConflictOverwrite
+	if flag&os.O_EXCL != 0 {
+		policy = service.ConflictFail
+	}
+
+	reader, writer := io.Pipe()
+	w := &davWriter{path: fullPath, pipe: writer, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		w.resp, w.err = fs.files.Upload(ctx, &service.UploadRequest{
+			Path:       path.Dir(fullPath),
+			Name:       path.Base(fullPath),
+			Size:       -1,
+			Content:    reader,
+			OwnerID:    davUser(ctx),
+			OnConflict: policy,
+		})
+		// Unblock writes if the upload ended before the content did
+		reader.CloseWithError(errors.E("davWriter.Write", errors.ErrConflict, w.err, "upload ended"))
+	}()
+	return w, nil
+}
+
+// davUser returns the user ID recorded on files written by the caller of
+// ctx.
+func davUser(ctx context.Context) string {
+	if caller := service.CallerFrom(ctx); caller != nil {
+		return caller.UserID
+	}
+	return "anonymous"
+}
+
+// davError translates file service errors into the os errors the WebDAV
+// handler maps to status codes. Other errors are returned as they are.
+func davError(op, name string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.IsNotFound(err):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case errors.IsForbidden(err):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	case errors.IsAlreadyExists(err):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+	default:
+		return err
+	}
+}
+
+// davFileInfo describes a file or directory. For files it reports the
+// ETag and MIME type of the REST API.
+type davFileInfo struct {
+	name string
+	meta *metadata.FileMetadata // Nil for directories
+}
+
+func newDAVFileInfo(fullPath string, meta *metadata.FileMetadata) *davFileInfo {
+	return &davFileInfo{name: path.Base(fullPath), meta: meta}
+}
+
+func (fi *davFileInfo) Name() string { return fi.name }
+func (fi *davFileInfo) IsDir() bool  { return fi.meta == nil }
+func (fi *davFileInfo) Sys() any     { return fi.meta }
+
+func (fi *davFileInfo) Size() int64 {
+	if fi.meta == nil {
+		return 0
+	}
+	return fi.meta.Size
+}
+
+func (fi *davFileInfo) Mode() os.FileMode {
+	if fi.meta == nil {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (fi *davFileInfo) ModTime() time.Time {
+	if fi.meta == nil {
+		return time.Time{}
+	}
+	return fi.meta.UpdatedAt
+}
+
+// ETag implements webdav.ETager.
+func (fi *davFileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.meta == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.meta.ETag(), nil
+}
+
+// ContentType implements webdav.ContentTyper.
+func (fi *davFileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.meta == nil || fi.meta.MimeType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.meta.MimeType, nil
+}
+
+// davDir is a directory opened for listing.
+type davDir struct {
+	fs      *davFS
+	ctx     context.Context
+	path    string
+	entries []os.FileInfo // Not yet returned by Readdir, nil before listing
+	listed  bool
+}
+
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.listed {
+		entries, err := d.fs.files.ListDirectory(d.ctx, d.path)
+		if err != nil {
+			return nil, davError("readdir", d.path, err)
+		}
+		for _, entry := range entries {
+			fi := &davFileInfo{name: entry.Name}
+			if !entry.IsDir {
+				fi.meta = &metadata.FileMetadata{ID: entry.ID, Name: entry.Name, Size: entry.Size, UpdatedAt: entry.UpdatedAt}
+			}
+			d.entries = append(d.entries, fi)
+		}
+		d.listed = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	count = min(count, len(d.entries))
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *davDir) Stat() (os.FileInfo, error) { return newDAVFileInfo(d.path, nil), nil }
+func (d *davDir) Close() error               { return nil }
+
+func (d *davDir) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.path, Err: errors.ErrInvalidInput}
+}
+
+func (d *davDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, &os.PathError{Op: "seek", Path: d.path, Err: errors.ErrInvalidInput}
+}
+
+func (d *davDir) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.path, Err: errors.ErrInvalidInput}
+}
+
+// davReader reads a file. The content is downloaded on the first read,
+// starting at the seek offset; seeking reopens it unless the storage
+// backend can seek itself.
+type davReader struct {
+	fs      *davFS
+	ctx     context.Context
+	meta    *metadata.FileMetadata
+	content io.ReadCloser // Nil until read
+	offset  int64
+}
+
+func (r *davReader) Read(p []byte) (int, error) {
+	if r.content == nil {
+		resp, err := r.fs.files.Download(r.ctx, r.meta.ID)
+		if err != nil {
+			return 0, davError("read", r.meta.Path, err)
+		}
+		r.content = resp.Content
+		if r.offset > 0 {
+			if err := skip(r.content, r.offset); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := r.content.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *davReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.meta.Size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: r.meta.Path, Err: errors.ErrInvalidInput}
+	}
+	if offset == r.offset {
+		return offset, nil
+	}
+
+	if seeker, ok := r.content.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	} else if r.content != nil {
+		r.content.Close()
+		r.content = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *davReader) Stat() (os.FileInfo, error) { return newDAVFileInfo(r.meta.Path, r.meta), nil }
+
+func (r *davReader) Close() error {
+	if r.content == nil {
+		return nil
+	}
+	return r.content.Close()
+}
+
+func (r *davReader) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: r.meta.Path, Err: errors.ErrInvalidInput}
+}
+
+func (r *davReader) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: r.meta.Path, Err: errors.ErrInvalidInput}
+}
+
+// skip reads and discards n bytes, or seeks past them if it can.
+func skip(r io.Reader, n int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
+
+// davWriter streams written content into an upload. The upload is
+// committed by Close, or by Stat, which the WebDAV handler calls before
+// Close to learn the ETag of the new version.
+type davWriter struct {
+	path string
+	pipe *io.PipeWriter
+	done chan struct{} // Closed when the upload has ended
+	resp *service.UploadResponse
+	err  error
+}
+
+func (w *davWriter) Write(p []byte) (int, error) {
+	n, err := w.pipe.Write(p)
+	if err != nil {
+		// The upload failed, report why
+		<-w.done
+		if w.err != nil {
+			err = w.err
+		}
+	}
+	return n, err
+}
+
+func (w *davWriter) Close() error {
+	w.pipe.Close()
+	<-w.done
+	return davError("close", w.path, w.err)
+}
+
+func (w *davWriter) Stat() (os.FileInfo, error) {
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &davFileInfo{name: path.Base(w.path), meta: &metadata.FileMetadata{
+		Path:        w.resp.Path,
+		Size:        w.resp.Size,
+		ContentHash: w.resp.ContentHash,
+		Version:     w.resp.Version,
+		UpdatedAt:   w.resp.UpdatedAt,
+	}}, nil
+}
+
+func (w *davWriter) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: w.path, Err: errors.ErrInvalidInput}
+}
+
+func (w *davWriter) Seek(offset int64, whence int) (int64, error) {
+	return 0, &os.PathError{Op: "seek", Path: w.path, Err: errors.ErrInvalidInput}
+}
+
+func (w *davWriter) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: w.path, Err: errors.ErrInvalidInput}
+}
@@ -0,0 +1,112 @@
+// Package http provides parsing of client-supplied content digests.
+package http
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// Headers that may carry a digest of the uploaded content.
+var digestHeaders = []string{"Content-MD5", "Digest", "Repr-Digest", "X-Content-SHA256"}
+
+// parseDigests adds the digests found in h to digests.
+//
+//	Content-MD5: <base64>
+//	Digest: SHA-256=<base64>, MD5=<base64>      (RFC 3230)
+//	Repr-Digest: sha-256=:<base64>:             (RFC 9530)
+//	X-Content-SHA256: <hex>
+//
+// Algorithms other than MD5 and SHA-256 are ignored.
+func parseDigests(h http.Header, digests service.Digests) error {
+	if value := h.Get("Content-MD5"); value != "" {
+		if err := addDigest(digests, service.DigestMD5, value, base64.StdEncoding.DecodeString); err != nil {
+			return err
+		}
+	}
+
+	if value := h.Get("X-Content-SHA256"); value != "" {
+		if err := addDigest(digests, service.DigestSHA256, value, hex.DecodeString); err != nil {
+			return err
+		}
+	}
+
+	for _, value := range h.Values("Digest") {
+		for _, item := range strings.Split(value, ",") {
+			algorithm, encoded, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				return errors.E("parseDigests", errors.ErrInvalidInput, nil, "malformed Digest header")
+			}
+			if err := addDigest(digests, digestAlgorithm(algorithm), encoded, base64.StdEncoding.DecodeString); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, value := range h.Values("Repr-Digest") {
+		for _, item := range strings.Split(value, ",") {
+			algorithm, encoded, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok || len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+				return errors.E("parseDigests", errors.ErrInvalidInput, nil, "malformed Repr-Digest header")
+			}
+			if err := addDigest(digests, digestAlgorithm(algorithm), encoded[1:len(encoded)-1], base64.StdEncoding.DecodeString); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// addDigest decodes a digest value and records it, skipping unsupported algorithms.
+func addDigest(digests service.Digests, algorithm service.DigestAlgorithm, encoded string, decode func(string) ([]byte, error)) error {
+	if algorithm != service.DigestMD5 && algorithm != service.DigestSHA256 {
+		return nil
+	}
+
+	value, err := decode(strings.TrimSpace(encoded))
+	if err != nil {
+		return errors.E("parseDigests", errors.ErrInvalidInput, err, "malformed "+string(algorithm)+" digest")
+	}
+	return digests.Add(algorithm, value)
+}
+
+// digestAlgorithm normalizes an algorithm name from a Digest or Repr-Digest header.
+func digestAlgorithm(name string) service.DigestAlgorithm {
+	return service.DigestAlgorithm(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// hasDigestTrailer reports whether the request announces a digest trailer.
+func hasDigestTrailer(r *http.Request) bool {
+	for _, name := range digestHeaders {
+		if _, ok := r.Trailer[http.CanonicalHeaderKey(name)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// trailerDigestReader collects digests from the request trailers once the
+// body has been read to the end, as sent with chunked transfer encoding.
+type trailerDigestReader struct {
+	body    io.Reader
+	request *http.Request
+	digests service.Digests
+	done    bool
+}
+
+func (r *trailerDigestReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if parseErr := parseDigests(r.request.Trailer, r.digests); parseErr != nil {
+			return n, parseErr
+		}
+	}
+	return n, err
+}
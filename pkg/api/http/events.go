@@ -0,0 +1,190 @@
+// Package http provides the change notification streams.
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/events"
+)
+
+// Default interval of keep-alive messages on idle event streams.
+const defaultEventKeepAlive = 30 * time.Second
+
+// Time allowed to write a WebSocket message.
+const eventWriteTimeout = 10 * time.Second
+
+// eventUpgrader upgrades event stream requests to WebSocket. Its default
+// origin check refuses cross-origin requests.
+var eventUpgrader = websocket.Upgrader{}
+
+// SetEventBroker enables the change notification streams. Idle streams
+// get a keep-alive message every keepAlive (zero for the default).
+func (h *Handler) SetEventBroker(broker *events.Broker, keepAlive time.Duration) {
+	if keepAlive <= 0 {
+		keepAlive = defaultEventKeepAlive
+	}
+	h.events = broker
+	h.eventKeepAlive = keepAlive
+}
+
+// StreamEvents streams change notifications of the paths given as "path"
+// query parameters (default "/") as Server-Sent Events. A reconnecting
+// client resumes after the event in its Last-Event-ID header or the
+// last_event_id query parameter. Events of files the caller cannot read
+// are left out.
+// GET /api/v1/events
+func (h *Handler) StreamEvents(c *gin.Context) {
+	sub, ok := h.subscribe(c, c.GetHeader("Last-Event-ID"))
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	// Streams outlive the server's write timeout
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(h.eventKeepAlive)
+	defer keepAlive.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Dropped or shutting down: the client reconnects
+				// and resumes from its last event
+				return
+			}
+			if event = h.visible(c, event); event == nil {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.FromContext(c.Request.Context(), h.logger).Error("failed to encode event", zap.Error(err))
+				continue
+			}
+			fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			c.Writer.Flush()
+		}
+	}
+}
+
+// StreamEventsWebSocket streams change notifications over a WebSocket,
+// one JSON event per text message, selected as for StreamEvents. A
+// connection ended with status 1013 (try again later) was too slow and
+// should reconnect with last_event_id.
+// GET /api/v1/events/ws
+func (h *Handler) StreamEventsWebSocket(c *gin.Context) {
+	sub, ok := h.subscribe(c, "")
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	conn, err := eventUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has answered the request
+		logger.FromContext(c.Request.Context(), h.logger).Debug("websocket upgrade failed", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	// Read until the client goes away, handling control messages
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(h.eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout)); err != nil {
+				return
+			}
+
+		case event, ok := <-sub.Events():
+			if !ok {
+				code, reason := websocket.CloseGoingAway, "server shutting down"
+				if sub.Dropped() {
+					code, reason = websocket.CloseTryAgainLater, "subscriber too slow"
+				}
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(eventWriteTimeout))
+				return
+			}
+			if event = h.visible(c, event); event == nil {
+				continue
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// subscribe subscribes to the paths requested by c, resuming after
+// lastEventID or the last_event_id query parameter.
+func (h *Handler) subscribe(c *gin.Context, lastEventID string) (*events.Subscription, bool) {
+	if h.events == nil {
+		apierror.Abort(c, errors.E("Handler.subscribe", errors.ErrNotImplemented, nil, "change notifications not available"))
+		return nil, false
+	}
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	return h.events.Subscribe(c.QueryArray("path"), lastEventID), true
+}
+
+// visible returns event as the caller of c may see it, or nil if the
+// caller may not read its file. A rename from a path the caller may not
+// read is shown as a create, so that the old path does not leak.
+func (h *Handler) visible(c *gin.Context, event *events.Event) *events.Event {
+	if event.Type == events.TypeReset {
+		return event
+	}
+	ctx := c.Request.Context()
+	if !h.fileService.CanRead(ctx, event.Path, event.OwnerID) {
+		return nil
+	}
+	if event.OldPath != "" && !h.fileService.CanRead(ctx, event.OldPath, event.OwnerID) {
+		created := *event
+		created.Type = events.TypeCreate
+		created.OldPath = ""
+		return &created
+	}
+	return event
+}
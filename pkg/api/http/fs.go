@@ -0,0 +1,123 @@
+// Package http provides path-based file access handlers.
+package http
+
+import (
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// GetPath downloads the file at a path, or lists it if it is a directory.
+// GET /api/v1/fs/*path
+func (h *Handler) GetPath(c *gin.Context) {
+	info, err := h.fileService.Stat(c.Request.Context(), c.Param("path"))
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	if info.IsDir {
+		entries, err := h.fileService.ListDirectory(c.Request.Context(), info.Path)
+		if err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"path":    info.Path,
+			"entries": entries,
+		})
+		return
+	}
+
+	h.serveFile(c, info.File)
+}
+
+// HeadPath reports what a path resolves to without a body.
+// HEAD /api/v1/fs/*path
+func (h *Handler) HeadPath(c *gin.Context) {
+	info, err := h.fileService.Stat(c.Request.Context(), c.Param("path"))
+	if err != nil {
+		apierror.AbortStatus(c, err)
+		return
+	}
+
+	if info.IsDir {
+		c.Header("X-Is-Directory", "true")
+		c.Status(http.StatusOK)
+		return
+	}
+
+	h.serveFile(c, info.File)
+}
+
+// PutPath streams the request body into the file at a path.
+// An existing file is overwritten unless on_conflict says otherwise.
+// If-Match and If-None-Match are checked against the existing file;
+// "If-None-Match: *" only creates.
+// PUT /api/v1/fs/*path
+func (h *Handler) PutPath(c *gin.Context) {
+	if !h.limitUpload(c) {
+		return
+	}
+
+	fullPath := service.CleanPath(c.Param("path"))
+	if fullPath == "/" {
+		apierror.Abort(c, errors.E("Handler.PutPath", errors.ErrConflict, nil, "cannot write to the root directory"))
+		return
+	}
+
+	policy, err := service.ParseConflictPolicy(c.Query("on_conflict"))
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	content, digests, err := rawContent(c)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	req := &service.UploadRequest{
+		Path:        filepath.Dir(fullPath),
+		Name:        filepath.Base(fullPath),
+		Size:        c.Request.ContentLength,
+		Content:     content,
+		Digests:     digests,
+		MimeType:    c.ContentType(),
+		OwnerID:     userID(c),
+		OnConflict:  policy,
+		IfMatch:     c.GetHeader("If-Match"),
+		IfNoneMatch: c.GetHeader("If-None-Match"),
+	}
+
+	resp, err := h.fileService.Upload(c.Request.Context(), req)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Header("ETag", resp.ETag)
+	if resp.Replaced {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// DeletePath deletes the file or empty directory at a path.
+// DELETE /api/v1/fs/*path
+func (h *Handler) DeletePath(c *gin.Context) {
+	if err := h.fileService.DeletePath(c.Request.Context(), c.Param("path"), c.GetHeader("If-Match")); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,196 @@
+// Package http provides presigned URL generation and verification.
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/presign"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// Context key of the policy of a verified presigned request.
+const presignPolicyKey = "presign_policy"
+
+// Lifetime of a presigned URL when the request does not set one.
+const defaultPresignExpiry = 15 * time.Minute
+
+// presignRequest asks for a presigned download or upload URL.
+type presignRequest struct {
+	Method      string `json:"method"`       // GET to download, PUT to upload
+	FileID      string `json:"file_id"`      // File to download
+	Path        string `json:"path"`         // File path to upload to
+	ExpiresIn   string `json:"expires_in"`   // Duration such as 15m
+	MaxSize     int64  `json:"max_size"`     // Upload size limit
+	ContentType string `json:"content_type"` // Required upload media type
+}
+
+// SetPresigner enables presigned URLs, signed by signer and valid for at
+// most maxExpiry (zero for no limit).
+func (h *Handler) SetPresigner(signer *presign.Signer, maxExpiry time.Duration) {
+	h.presigner = signer
+	h.maxPresignExpiry = maxExpiry
+}
+
+// CreatePresignedURL creates a URL that allows downloading a file, or
+// uploading to a path, without credentials until it expires. The URL
+// acts with the identity and groups of the caller that created it, whose
+// access is checked again when the URL is used.
+// POST /api/v1/presign
+func (h *Handler) CreatePresignedURL(c *gin.Context) {
+	if h.presigner == nil {
+		apierror.Abort(c, errors.E("Handler.CreatePresignedURL", errors.ErrNotImplemented, nil, "presigned URLs not available"))
+		return
+	}
+
+	var req presignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, errors.E("Handler.CreatePresignedURL", errors.ErrInvalidInput, err, "presign request"))
+		return
+	}
+
+	expiry := defaultPresignExpiry
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			apierror.Abort(c, errors.E("Handler.CreatePresignedURL", errors.ErrInvalidInput, nil, "invalid expires_in "+req.ExpiresIn))
+			return
+		}
+		expiry = d
+	}
+	if h.maxPresignExpiry > 0 && expiry > h.maxPresignExpiry {
+		apierror.Abort(c, errors.E("Handler.CreatePresignedURL", errors.ErrInvalidInput, nil, "expires_in exceeds the maximum of "+h.maxPresignExpiry.String()))
+		return
+	}
+
+	policy := &presign.Policy{
+		Method:  req.Method,
+		Expires: time.Now().Add(expiry).Truncate(time.Second),
+	}
+	if caller := service.CallerFrom(c.Request.Context()); caller != nil && !caller.Anonymous {
+		policy.UserID = caller.UserID
+		policy.Groups = caller.Groups
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		if req.FileID == "" {
+			apierror.Abort(c, errors.E("Handler.CreatePresignedURL", errors.ErrInvalidInput, nil, "file_id is required for GET"))
+			return
+		}
+		meta, err := h.fileService.GetMetadata(c.Request.Context(), req.FileID)
+		if errors.IsForbidden(err) {
+			apierror.Abort(c, err)
+			return
+		}
+		if err != nil || meta.LocalState == metadata.LocalStateDeleted {
+			apierror.Abort(c, errors.E("Handler.CreatePresignedURL", errors.ErrNotFound, nil, "file "+req.FileID))
+			return
+		}
+		policy.Path = "/api/v1/files/" + meta.ID
+
+	case http.MethodPut:
+		fullPath := service.CleanPath(req.Path)
+		if req.Path == "" || fullPath == "/" {
+			apierror.Abort(c, errors.E("Handler.CreatePresignedURL", errors.ErrInvalidInput, nil, "path is required for PUT"))
+			return
+		}
+		if req.MaxSize < 0 {
+			apierror.Abort(c, errors.E("Handler.CreatePresignedURL", errors.ErrInvalidInput, nil, "invalid max_size"))
+			return
+		}
+		if err := h.fileService.CheckAccess(c.Request.Context(), fullPath, metadata.PermWrite); err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+		policy.Path = path.Join("/api/v1/fs", fullPath)
+		policy.MaxSize = req.MaxSize
+		policy.ContentType = req.ContentType
+
+	default:
+		apierror.Abort(c, errors.E("Handler.CreatePresignedURL", errors.ErrInvalidInput, nil, "method must be GET or PUT"))
+		return
+	}
+
+	signed := url.URL{
+		Scheme:   requestScheme(c),
+		Host:     c.Request.Host,
+		Path:     policy.Path,
+		RawQuery: h.presigner.Sign(policy).Encode(),
+	}
+
+	logger.FromContext(c.Request.Context(), h.logger).Info("presigned URL created",
+		zap.String("method", policy.Method),
+		zap.String("path", policy.Path),
+		zap.String("user_id", userID(c)),
+		zap.Time("expires", policy.Expires),
+	)
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        signed.String(),
+		"method":     policy.Method,
+		"expires_at": policy.Expires,
+	})
+}
+
+// verifyPresigned is the middleware validating presigned requests. Requests
+// without a signature pass through unchanged. A valid signature makes the
+// request act as the user who created the URL; upload constraints are
+// enforced on the body. Invalid URLs are refused with 403 and the reason.
+func (h *Handler) verifyPresigned(c *gin.Context) {
+	query := c.Request.URL.Query()
+	if !presign.IsPresigned(query) {
+		c.Next()
+		return
+	}
+
+	if h.presigner == nil {
+		apierror.Abort(c, errors.E("Handler.verifyPresigned", errors.ErrForbidden, nil, "presigned URLs not available"))
+		return
+	}
+
+	policy, err := h.presigner.Verify(c.Request.Method, c.Request.URL.Path, query)
+	if err == nil && policy.Method == http.MethodPut {
+		err = policy.CheckUpload(c.ContentType(), c.Request.ContentLength)
+	}
+	if err != nil {
+		logger.FromContext(c.Request.Context(), h.logger).Warn("presigned request refused",
+			zap.String("path", c.Request.URL.Path),
+			zap.Error(err),
+		)
+		apierror.Abort(c, err)
+		return
+	}
+
+	if policy.MaxSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, policy.MaxSize)
+	}
+	if policy.UserID != "" {
+		c.Set(auth.UserIDKey, policy.UserID)
+	}
+	c.Set(presignPolicyKey, policy)
+
+	c.Next()
+}
+
+// requestScheme returns the scheme the client used, honoring a proxy's
+// X-Forwarded-Proto header.
+func requestScheme(c *gin.Context) string {
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -2,127 +2,324 @@
 package http
 
 import (
-	"io"
+	"context"
 	"net/http"
 	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
 
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/events"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/presign"
 	"asisaid.cn/JzSE/internal/region/service"
+	"asisaid.cn/JzSE/internal/region/webhook"
+	"asisaid.cn/JzSE/pkg/api/openapi"
 )
 
+// MetadataMaintainer exposes maintenance operations of the metadata store.
+type MetadataMaintainer interface {
+	RunValueLogGC(ctx context.Context) (*metadata.GCResult, error)
+	Stats() metadata.StoreStats
+}
+
 // Handler provides HTTP handlers for the region API.
 type Handler struct {
-	fileService *service.FileService
+	fileService       *service.FileService
+	maintainer        MetadataMaintainer
+	status            *service.StatusReporter // Nil when status reports are disabled
+	maxUploadSize     int64                   // Zero for unlimited
+	maxArchiveSize    int64                   // Zero for unlimited
+	maxArchiveEntries int                     // Zero for unlimited
+	maxBatchSize      int                     // Zero for unlimited
+	presigner         *presign.Signer
+	maxPresignExpiry  time.Duration      // Zero for unlimited
+	authn             auth.Authenticator // Nil when authentication is disabled
+	authenticate      gin.HandlerFunc    // Nil when authentication is disabled
+	adminGroup        string             // Group allowed to use the admin endpoints
+	events            *events.Broker     // Nil when change notifications are disabled
+	eventKeepAlive    time.Duration
+	webhooks          *webhook.Dispatcher // Nil when webhooks are disabled
+	webdav            *webdav.Handler     // Nil when WebDAV is disabled
+	authenticateDAV   gin.HandlerFunc     // Authenticates WebDAV requests, nil when disabled
+	logger            *zap.Logger
 }
 
 // NewHandler creates a new Handler.
 func NewHandler(fileService *service.FileService) *Handler {
 	return &Handler{
 		fileService: fileService,
+		logger:      logger.WithComponent("HTTPHandler"),
+	}
+}
+
+// SetMetadataMaintainer enables the metadata admin endpoints, restricted to
+// the admin group like all admin endpoints.
+func (h *Handler) SetMetadataMaintainer(m MetadataMaintainer) {
+	h.maintainer = m
+}
+
+// SetStatusReporter enables the region status endpoint.
+func (h *Handler) SetStatusReporter(r *service.StatusReporter) {
+	h.status = r
+}
+
+// SetMaxUploadSize limits the size of uploaded files. Zero disables the limit.
+func (h *Handler) SetMaxUploadSize(size int64) {
+	h.maxUploadSize = size
+}
+
+// SetMaxArchiveSize limits the total file size of directory archives,
+// downloaded or extracted. Zero disables the limit.
+func (h *Handler) SetMaxArchiveSize(size int64) {
+	h.maxArchiveSize = size
+}
+
+// SetMaxArchiveEntries limits the number of entries of extracted archives.
+// Zero disables the limit.
+func (h *Handler) SetMaxArchiveEntries(entries int) {
+	h.maxArchiveEntries = entries
+}
+
+// SetMaxBatchSize limits the number of items of a batch request.
+// Zero disables the limit.
+func (h *Handler) SetMaxBatchSize(items int) {
+	h.maxBatchSize = items
+}
+
+// SetAuthenticator enables authentication of API requests. With required
+// set, requests without credentials are refused, except health checks
+// and presigned URLs.
+func (h *Handler) SetAuthenticator(authn auth.Authenticator, required bool) {
+	h.authn = authn
+	h.authenticate = auth.Middleware(authn, auth.MiddlewareOptions{
+		Required:    required,
+		PublicPaths: []string{"/api/v1/health", openapi.SpecPath},
+		Skip: func(c *gin.Context) bool {
+			_, presigned := c.Get(presignPolicyKey)
+			return presigned
+		},
+	})
+	h.authenticateDAV = auth.Middleware(authn, auth.MiddlewareOptions{
+		Required:  required,
+		Challenge: webdavChallenge,
+	})
+}
+
+// SetAdminGroup sets the group whose members may use the admin endpoints.
+// Without an authenticator they are refused to everyone.
+func (h *Handler) SetAdminGroup(group string) {
+	h.adminGroup = group
+}
+
+// requireAdmin returns the middleware restricting the admin endpoints to
+// members of the admin group.
+func (h *Handler) requireAdmin() gin.HandlerFunc {
+	if h.authn == nil {
+		return func(c *gin.Context) {
+			apierror.Abort(c, errors.E("Handler.requireAdmin", errors.ErrForbidden, nil, "admin endpoints require authentication"))
+		}
 	}
+	return auth.RequireGroup(h.authn, h.adminGroup)
 }
 
-// RegisterRoutes registers all API routes.
+// RegisterRoutes registers all API routes and the WebDAV endpoint.
+// Unknown routes are answered with the error envelope, and API requests
+// not matching the OpenAPI specification are refused.
 func (h *Handler) RegisterRoutes(r *gin.Engine) {
+	r.NoRoute(apierror.NoRoute)
+	h.registerWebDAV(r)
+
+	spec := openapi.Region()
 	api := r.Group("/api/v1")
+	api.Use(h.verifyPresigned)
+	if h.authenticate != nil {
+		api.Use(h.authenticate)
+	}
+	api.Use(h.identifyCaller, openapi.Validator(spec))
 	{
+		// API specification
+		api.GET("/openapi.json", openapi.Serve(spec))
+
 		// File operations
 		api.POST("/files", h.UploadFile)
 		api.GET("/files/:id", h.DownloadFile)
+		api.HEAD("/files/:id", h.DownloadFile)
+		api.PUT("/files/:id", h.UpdateFile)
 		api.DELETE("/files/:id", h.DeleteFile)
 		api.GET("/files/:id/metadata", h.GetFileMetadata)
 
 		// Directory operations
 		api.GET("/directories/*path", h.ListDirectory)
+		api.POST("/directories/*path", h.ExtractArchive)
+
+		// Access control
+		api.GET("/acl/*path", h.GetACL)
+		api.PUT("/acl/*path", h.SetACL)
+		api.DELETE("/acl/*path", h.DeleteACL)
+
+		// Presigned URLs
+		api.POST("/presign", h.CreatePresignedURL)
+
+		// Change notifications
+		api.GET("/events", h.StreamEvents)
+		api.GET("/events/ws", h.StreamEventsWebSocket)
+
+		// Batch operations
+		batch := api.Group("/batch")
+		{
+			batch.POST("/metadata", h.BatchGetMetadata)
+			batch.POST("/delete", h.BatchDelete)
+			batch.POST("/custom-meta", h.BatchPatchCustomMeta)
+		}
+
+		// Path-based access
+		fs := api.Group("/fs")
+		{
+			fs.GET("/*path", h.GetPath)
+			fs.HEAD("/*path", h.HeadPath)
+			fs.PUT("/*path", h.PutPath)
+			fs.DELETE("/*path", h.DeletePath)
+		}
 
 		// Health check
 		api.GET("/health", h.HealthCheck)
 
 		// Region status
 		api.GET("/region/status", h.RegionStatus)
+
+		// Administration
+		admin := api.Group("/admin", h.requireAdmin())
+		{
+			admin.GET("/metadata/stats", h.MetadataStats)
+			admin.POST("/metadata/gc", h.RunMetadataGC)
+			admin.GET("/webhooks", h.ListWebhooks)
+			admin.GET("/webhooks/dead-letters", h.ListDeadLetters)
+			admin.POST("/webhooks/dead-letters/:id/redeliver", h.RedeliverWebhook)
+		}
 	}
 }
 
 // UploadFile handles file upload.
+// The body is either multipart/form-data with a "file" part, or the raw
+// file content with the name in the "name" query parameter. Either way it
+// is streamed to storage without buffering.
 // POST /api/v1/files
 func (h *Handler) UploadFile(c *gin.Context) {
-	// Get file from form
-	file, header, err := c.Request.FormFile("file")
+	if !h.limitUpload(c) {
+		return
+	}
+
+	body, err := readUpload(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "no file provided",
-		})
+		apierror.Abort(c, err)
 		return
 	}
-	defer file.Close()
+	defer body.Close()
 
 	// Get path from form
-	path := c.PostForm("path")
+	path := body.fields["path"]
 	if path == "" {
 		path = "/"
 	}
 
-	// Get owner ID (from auth in production)
-	ownerID := c.GetString("user_id")
-	if ownerID == "" {
-		ownerID = "anonymous"
+	policy, err := service.ParseConflictPolicy(body.fields["on_conflict"])
+	if err != nil {
+		apierror.Abort(c, err)
+		return
 	}
 
 	req := &service.UploadRequest{
-		Path:     path,
-		Name:     header.Filename,
-		Size:     header.Size,
-		Content:  file,
-		MimeType: header.Header.Get("Content-Type"),
-		OwnerID:  ownerID,
+		Path:       path,
+		Name:       body.name,
+		Size:       body.size,
+		Content:    body.content,
+		MimeType:   body.mimeType,
+		OwnerID:    userID(c),
+		OnConflict: policy,
+		Digests:    body.digests,
 	}
 
 	resp, err := h.fileService.Upload(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		apierror.Abort(c, err)
 		return
 	}
 
+	c.Header("ETag", resp.ETag)
+	if resp.Replaced {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
 	c.JSON(http.StatusCreated, resp)
 }
 
+// UpdateFile replaces the content of an existing file.
+// The body is read as in UploadFile. With an If-Match header the update
+// only succeeds if the file is unchanged.
+// PUT /api/v1/files/:id
+func (h *Handler) UpdateFile(c *gin.Context) {
+	if !h.limitUpload(c) {
+		return
+	}
+
+	body, err := readUpload(c)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	defer body.Close()
+
+	req := &service.UpdateRequest{
+		FileID:   c.Param("id"),
+		Size:     body.size,
+		Content:  body.content,
+		MimeType: body.mimeType,
+		UserID:   userID(c),
+		IfMatch:  c.GetHeader("If-Match"),
+		Digests:  body.digests,
+	}
+
+	resp, err := h.fileService.Update(c.Request.Context(), req)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Header("ETag", resp.ETag)
+	c.JSON(http.StatusOK, resp)
+}
+
 // DownloadFile handles file download.
-// GET /api/v1/files/:id
+// Conditional requests are answered with 304 Not Modified, and HEAD
+// returns the headers without reading the content.
+// GET, HEAD /api/v1/files/:id
 func (h *Handler) DownloadFile(c *gin.Context) {
 	fileID := c.Param("id")
 
-	resp, err := h.fileService.Download(c.Request.Context(), fileID)
+	meta, err := h.fileService.GetMetadata(c.Request.Context(), fileID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
-		})
+		apierror.Abort(c, err)
 		return
 	}
-	defer resp.Content.Close()
-
-	// Set headers
-	c.Header("Content-Type", resp.Metadata.MimeType)
-	c.Header("Content-Disposition", "attachment; filename="+resp.Metadata.Name)
-	c.Header("X-File-ID", resp.Metadata.ID)
-	c.Header("X-Content-Hash", resp.Metadata.ContentHash)
 
-	// Stream file content
-	c.Status(http.StatusOK)
-	io.Copy(c.Writer, resp.Content)
+	h.serveFile(c, meta)
 }
 
 // DeleteFile handles file deletion.
+// With an If-Match header the file is only deleted if it is unchanged.
 // DELETE /api/v1/files/:id
 func (h *Handler) DeleteFile(c *gin.Context) {
 	fileID := c.Param("id")
 
-	if err := h.fileService.Delete(c.Request.Context(), fileID); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
-		})
+	if err := h.fileService.DeleteIfMatch(c.Request.Context(), fileID, c.GetHeader("If-Match")); err != nil {
+		apierror.Abort(c, err)
 		return
 	}
 
@@ -136,16 +333,15 @@ func (h *Handler) GetFileMetadata(c *gin.Context) {
 
 	meta, err := h.fileService.GetMetadata(c.Request.Context(), fileID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
-		})
+		apierror.Abort(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, meta)
 }
 
-// ListDirectory lists files in a directory.
+// ListDirectory lists files in a directory, or downloads it as an archive
+// when the archive query parameter is zip, tar or tar.gz.
 // GET /api/v1/directories/*path
 func (h *Handler) ListDirectory(c *gin.Context) {
 	path := c.Param("path")
@@ -154,11 +350,14 @@ func (h *Handler) ListDirectory(c *gin.Context) {
 	}
 	path = filepath.Clean(path)
 
+	if c.Query("archive") != "" {
+		h.DownloadArchive(c, path)
+		return
+	}
+
 	entries, err := h.fileService.ListDirectory(c.Request.Context(), path)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+		apierror.Abort(c, err)
 		return
 	}
 
@@ -176,12 +375,60 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 	})
 }
 
-// RegionStatus returns the region status.
+// RegionStatus returns the region status: storage capacity, metadata
+// store size, sync health and the reasons the region is degraded, if so.
 // GET /api/v1/region/status
 func (h *Handler) RegionStatus(c *gin.Context) {
-	// TODO: Implement proper status checking
-	c.JSON(http.StatusOK, gin.H{
-		"status":     "healthy",
-		"sync_state": "connected",
-	})
+	if h.status == nil {
+		apierror.Abort(c, errors.E("Handler.RegionStatus", errors.ErrNotImplemented, nil, "region status not available"))
+		return
+	}
+
+	c.JSON(http.StatusOK, h.status.Status(c.Request.Context()))
+}
+
+// MetadataStats returns metadata store size and GC statistics.
+// GET /api/v1/admin/metadata/stats
+func (h *Handler) MetadataStats(c *gin.Context) {
+	if h.maintainer == nil {
+		apierror.Abort(c, errors.E("Handler.MetadataStats", errors.ErrNotImplemented, nil, "metadata maintenance not available"))
+		return
+	}
+
+	c.JSON(http.StatusOK, h.maintainer.Stats())
+}
+
+// RunMetadataGC triggers a metadata value log GC run.
+// POST /api/v1/admin/metadata/gc
+func (h *Handler) RunMetadataGC(c *gin.Context) {
+	if h.maintainer == nil {
+		apierror.Abort(c, errors.E("Handler.RunMetadataGC", errors.ErrNotImplemented, nil, "metadata maintenance not available"))
+		return
+	}
+
+	result, err := h.maintainer.RunValueLogGC(c.Request.Context())
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// userID returns the caller identity, or "anonymous" when unauthenticated.
+func userID(c *gin.Context) string {
+	if id := c.GetString(auth.UserIDKey); id != "" {
+		return id
+	}
+	return "anonymous"
+}
+
+// setFileHeaders sets the response headers describing a file.
+func setFileHeaders(c *gin.Context, meta *metadata.FileMetadata) {
+	c.Header("Content-Type", meta.MimeType)
+	c.Header("Content-Disposition", "attachment; filename="+meta.Name)
+	c.Header("X-File-ID", meta.ID)
+	c.Header("X-Content-Hash", meta.ContentHash)
+	c.Header("ETag", meta.ETag())
+	c.Header("Last-Modified", lastModified(meta))
 }
@@ -0,0 +1,169 @@
+// Package http provides streaming upload parsing.
+package http
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// uploadBody is the file content of an upload request, read as a stream.
+type uploadBody struct {
+	name     string
+	mimeType string
+	size     int64 // -1 if unknown
+	content  io.Reader
+	digests  service.Digests   // Nil if the client sent no checksums
+	fields   map[string]string // path, on_conflict, ...
+
+	part *multipart.Part
+}
+
+// Close releases the multipart part, if any.
+func (b *uploadBody) Close() error {
+	if b.part != nil {
+		return b.part.Close()
+	}
+	return nil
+}
+
+// readUpload returns the uploaded content without buffering it.
+//
+// A multipart/form-data body is parsed as a stream: fields sent before the
+// "file" part are collected, and the file part itself is handed on as the
+// content. Fields after the file part are not seen, so clients should send
+// them first or as query parameters. An optional "size" field declares the
+// file length.
+//
+// Any other body is a raw upload: the request body is the content, with
+// its size taken from Content-Length (unknown for chunked encoding) and the
+// name from the "name" query parameter or the X-File-Name header.
+//
+// Checksums are taken from the file part headers of a multipart body, or
+// from the request headers and trailers of a raw body.
+func readUpload(c *gin.Context) (*uploadBody, error) {
+	body := &uploadBody{
+		size:   -1,
+		fields: make(map[string]string),
+	}
+	for key, values := range c.Request.URL.Query() {
+		body.fields[key] = values[0]
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		body.name = body.fields["name"]
+		if body.name == "" {
+			body.name = c.GetHeader("X-File-Name")
+		}
+		body.mimeType = c.ContentType()
+		body.size = c.Request.ContentLength
+
+		content, digests, err := rawContent(c)
+		if err != nil {
+			return nil, err
+		}
+		body.content = content
+		body.digests = digests
+		return body, nil
+	}
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, errors.E("readUpload", errors.ErrInvalidInput, err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.E("readUpload", errors.ErrInvalidInput, nil, "no file provided")
+		}
+		if err != nil {
+			return nil, errors.E("readUpload", errors.ErrInvalidInput, err)
+		}
+
+		if part.FormName() == "file" {
+			body.name = part.FileName()
+			body.mimeType = part.Header.Get("Content-Type")
+			body.content = part
+			body.part = part
+
+			digests := service.Digests{}
+			if err := parseDigests(http.Header(part.Header), digests); err != nil {
+				part.Close()
+				return nil, err
+			}
+			if len(digests) > 0 {
+				body.digests = digests
+			}
+			break
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFieldSize+1))
+		part.Close()
+		if err != nil {
+			return nil, errors.E("readUpload", errors.ErrInvalidInput, err)
+		}
+		if len(value) > maxFieldSize {
+			return nil, errors.E("readUpload", errors.ErrInvalidInput, nil, "form field "+part.FormName()+" too large")
+		}
+		body.fields[part.FormName()] = string(value)
+	}
+
+	if size := body.fields["size"]; size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n < 0 {
+			body.Close()
+			return nil, errors.E("readUpload", errors.ErrInvalidInput, err, "invalid size "+size)
+		}
+		body.size = n
+	}
+
+	return body, nil
+}
+
+// rawContent returns the request body as upload content, together with
+// the digests claimed in the request headers. Digests announced as
+// trailers are collected once the body has been read.
+func rawContent(c *gin.Context) (io.Reader, service.Digests, error) {
+	digests := service.Digests{}
+	if err := parseDigests(c.Request.Header, digests); err != nil {
+		return nil, nil, err
+	}
+
+	if hasDigestTrailer(c.Request) {
+		return &trailerDigestReader{body: c.Request.Body, request: c.Request, digests: digests}, digests, nil
+	}
+	if len(digests) == 0 {
+		return c.Request.Body, nil, nil
+	}
+	return c.Request.Body, digests, nil
+}
+
+// Maximum size of a non-file multipart field.
+const maxFieldSize = 64 << 10
+
+// limitUpload enforces the maximum upload size on the request body.
+// Requests that declare a larger Content-Length are rejected up front with
+// 413; chunked bodies are cut off once they exceed the limit.
+func (h *Handler) limitUpload(c *gin.Context) bool {
+	if h.maxUploadSize <= 0 {
+		return true
+	}
+
+	if c.Request.ContentLength > h.maxUploadSize {
+		apierror.Abort(c, errors.E("Handler.limitUpload", errors.ErrTooLarge, nil, "upload exceeds maximum size of "+strconv.FormatInt(h.maxUploadSize, 10)+" bytes"))
+		return false
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadSize)
+	return true
+}
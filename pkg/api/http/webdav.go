@@ -0,0 +1,82 @@
+// Package http provides the WebDAV endpoint.
+package http
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+)
+
+// WebDAVPrefix is the path the region tree is served under over WebDAV.
+const WebDAVPrefix = "/webdav"
+
+// webdavChallenge asks WebDAV clients for Basic credentials, which carry
+// an API key as the password.
+const webdavChallenge = `Basic realm="JzSE"`
+
+// webdavMethods are the methods routed to the WebDAV handler.
+var webdavMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// SetWebDAV enables or disables the WebDAV endpoint. Locks are held in
+// memory, so they do not survive a restart.
+func (h *Handler) SetWebDAV(enabled bool) {
+	if !enabled {
+		h.webdav = nil
+		return
+	}
+	h.webdav = &webdav.Handler{
+		Prefix:     WebDAVPrefix,
+		FileSystem: &davFS{files: h.fileService},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !os.IsNotExist(err) {
+				logger.FromContext(r.Context(), h.logger).Warn("webdav request failed",
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.Error(err))
+			}
+		},
+	}
+}
+
+// registerWebDAV routes the WebDAV methods under WebDAVPrefix. Requests
+// are authenticated like API requests, but are not checked against the
+// OpenAPI specification.
+func (h *Handler) registerWebDAV(r *gin.Engine) {
+	dav := r.Group(WebDAVPrefix)
+	if h.authenticateDAV != nil {
+		dav.Use(h.authenticateDAV)
+	}
+	dav.Use(h.identifyCaller)
+	for _, method := range webdavMethods {
+		dav.Handle(method, "", h.ServeWebDAV)
+		dav.Handle(method, "/*path", h.ServeWebDAV)
+	}
+}
+
+// ServeWebDAV serves the region tree over WebDAV. Files and directories
+// are read and written through the file service, so ACLs, pre-commit
+// hooks and change events apply as for the path-based REST API; a MOVE is
+// reported as a rename of each moved file.
+// ANY /webdav/*path
+func (h *Handler) ServeWebDAV(c *gin.Context) {
+	if h.webdav == nil {
+		apierror.Abort(c, errors.E("Handler.ServeWebDAV", errors.ErrNotImplemented, nil, "WebDAV not available"))
+		return
+	}
+	if c.Request.Method == http.MethodPut && !h.limitUpload(c) {
+		return
+	}
+
+	h.webdav.ServeHTTP(c.Writer, c.Request)
+}
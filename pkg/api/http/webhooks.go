@@ -0,0 +1,59 @@
+// Package http provides the webhook administration endpoints.
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/webhook"
+)
+
+// SetWebhookDispatcher enables the webhook admin endpoints. Dead letters
+// carry file metadata, so they are restricted to the admin group.
+func (h *Handler) SetWebhookDispatcher(d *webhook.Dispatcher) {
+	h.webhooks = d
+}
+
+// ListWebhooks lists the webhook subscriptions; secrets are left out.
+// GET /api/v1/admin/webhooks
+func (h *Handler) ListWebhooks(c *gin.Context) {
+	if !h.requireWebhooks(c, "Handler.ListWebhooks") {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": h.webhooks.Subscriptions()})
+}
+
+// ListDeadLetters lists the deliveries that ran out of attempts.
+// GET /api/v1/admin/webhooks/dead-letters
+func (h *Handler) ListDeadLetters(c *gin.Context) {
+	if !h.requireWebhooks(c, "Handler.ListDeadLetters") {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": h.webhooks.DeadLetters()})
+}
+
+// RedeliverWebhook queues a dead letter for delivery again.
+// POST /api/v1/admin/webhooks/dead-letters/:id/redeliver
+func (h *Handler) RedeliverWebhook(c *gin.Context) {
+	if !h.requireWebhooks(c, "Handler.RedeliverWebhook") {
+		return
+	}
+	delivery, err := h.webhooks.Redeliver(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// requireWebhooks answers with 501 when webhooks are disabled.
+func (h *Handler) requireWebhooks(c *gin.Context, op string) bool {
+	if h.webhooks == nil {
+		apierror.Abort(c, errors.E(op, errors.ErrNotImplemented, nil, "webhooks not available"))
+		return false
+	}
+	return true
+}
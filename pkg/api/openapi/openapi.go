@@ -0,0 +1,198 @@
+// Package openapi provides the OpenAPI specifications of the region and
+// coordinator APIs, and middleware that serves them and validates requests
+// against them.
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// Path at which both services serve their specification.
+const SpecPath = "/api/v1/openapi.json"
+
+//go:embed region.yaml
+var regionSpec []byte
+
+//go:embed coordinator.yaml
+var coordinatorSpec []byte
+
+var (
+	region      = sync.OnceValue(func() *openapi3.T { return mustLoad("region", regionSpec) })
+	coordinator = sync.OnceValue(func() *openapi3.T { return mustLoad("coordinator", coordinatorSpec) })
+)
+
+// Region returns the specification of the region API.
+func Region() *openapi3.T {
+	return region()
+}
+
+// Coordinator returns the specification of the coordinator API.
+func Coordinator() *openapi3.T {
+	return coordinator()
+}
+
+// mustLoad parses and validates an embedded specification. The documents
+// are part of the binary, so an invalid one is a programming error.
+func mustLoad(name string, data []byte) *openapi3.T {
+	doc, err := openapi3.NewLoader().LoadFromData(data)
+	if err == nil {
+		err = doc.Validate(context.Background())
+	}
+	if err != nil {
+		panic(errors.E("openapi.load", errors.ErrInvalidInput, err, name+" specification"))
+	}
+	return doc
+}
+
+// Serve returns a handler answering with doc as JSON.
+func Serve(doc *openapi3.T) gin.HandlerFunc {
+	data, err := json.Marshal(doc)
+	return func(c *gin.Context) {
+		if err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "application/json", data)
+	}
+}
+
+// PathTemplate converts a gin route path to the OpenAPI path template
+// documenting it: ":id" and "*path" segments become "{id}" and "{path}".
+func PathTemplate(fullPath string) string {
+	segments := strings.Split(fullPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Validator returns the middleware that checks requests against doc.
+// Parameters are validated for every documented operation. Bodies are only
+// validated for operations taking JSON, since file content is streamed to
+// the handlers, and only when sent as JSON, since the handlers never
+// required the Content-Type. Requests not matching doc are refused with
+// invalid_input. Credentials are checked by the auth middleware, not here.
+func Validator(doc *openapi3.T) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := findRoute(doc, c)
+		if route == nil {
+			c.Next()
+			return
+		}
+
+		params := make(map[string]string, len(c.Params))
+		for _, param := range c.Params {
+			params[param.Key] = param.Value
+		}
+
+		options := &openapi3filter.Options{
+			ExcludeRequestBody:  !takesJSON(route.Operation) || c.ContentType() != "application/json",
+			AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+			SkipSettingDefaults: true,
+		}
+		options.WithCustomSchemaErrorFunc(schemaErrorMessage)
+
+		err := openapi3filter.ValidateRequest(c.Request.Context(), &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: params,
+			Route:      route,
+			Options:    options,
+		})
+		if err != nil {
+			apierror.Abort(c, errors.E("openapi.Validator", errors.ErrInvalidInput, err))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// findRoute returns the documented operation of the gin route matched by
+// the request, or nil if it is undocumented.
+func findRoute(doc *openapi3.T, c *gin.Context) *routers.Route {
+	fullPath := c.FullPath()
+	if fullPath == "" {
+		return nil
+	}
+
+	template := PathTemplate(fullPath)
+	item := doc.Paths.Value(template)
+	if item == nil {
+		return nil
+	}
+	operation := item.GetOperation(c.Request.Method)
+	if operation == nil {
+		return nil
+	}
+
+	return &routers.Route{
+		Spec:      doc,
+		Path:      template,
+		PathItem:  item,
+		Method:    c.Request.Method,
+		Operation: operation,
+	}
+}
+
+// takesJSON reports whether operation has a JSON request body.
+func takesJSON(operation *openapi3.Operation) bool {
+	if operation.RequestBody == nil || operation.RequestBody.Value == nil {
+		return false
+	}
+	_, ok := operation.RequestBody.Value.Content["application/json"]
+	return ok
+}
+
+// schemaErrorMessage reports a schema violation without the schema dump
+// of the default message.
+func schemaErrorMessage(err *openapi3.SchemaError) string {
+	if pointer := err.JSONPointer(); len(pointer) > 0 {
+		return strings.Join(pointer, ".") + ": " + err.Reason
+	}
+	return err.Reason
+}
+
+// Diff compares the routes registered on a router with the operations of
+// doc. It returns the registered routes doc does not describe and the
+// documented operations that are not registered, as "METHOD /path".
+// Only routes under /api/ are compared; other endpoints, such as WebDAV,
+// are not described by the specification.
+func Diff(doc *openapi3.T, routes gin.RoutesInfo) (undocumented, unregistered []string) {
+	registered := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		key := route.Method + " " + PathTemplate(route.Path)
+		registered[key] = true
+
+		item := doc.Paths.Value(PathTemplate(route.Path))
+		if item == nil || item.GetOperation(route.Method) == nil {
+			undocumented = append(undocumented, key)
+		}
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			if key := method + " " + path; !registered[key] {
+				unregistered = append(unregistered, key)
+			}
+		}
+	}
+	return undocumented, unregistered
+}
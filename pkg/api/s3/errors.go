@@ -0,0 +1,127 @@
+// Package s3 provides S3 error responses.
+package s3
+
+import (
+	"encoding/xml"
+	stderrors "errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+)
+
+// Error is an S3 error response.
+type Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId"`
+
+	status int
+}
+
+func newError(status int, code, message string) *Error {
+	return &Error{Code: code, Message: message, status: status}
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Status returns the HTTP status of the error.
+func (e *Error) Status() int {
+	return e.status
+}
+
+// resource says which S3 error code a missing resource is reported with.
+type resource int
+
+const (
+	bucketResource resource = iota // NoSuchBucket
+	objectResource                 // NoSuchKey
+	uploadResource                 // NoSuchUpload
+)
+
+// toError translates a file service error into an S3 error. The message
+// is the one the REST API would report.
+func toError(err error, res resource) *Error {
+	var s3err *Error
+	if stderrors.As(err, &s3err) {
+		return s3err
+	}
+
+	status := apierror.Status(err)
+	code := "InternalError"
+	switch {
+	case errors.IsNotFound(err):
+		code = [...]string{"NoSuchBucket", "NoSuchKey", "NoSuchUpload"}[res]
+	case errors.IsForbidden(err), errors.IsUnauthorized(err), errors.IsRejected(err):
+		status, code = http.StatusForbidden, "AccessDenied"
+	case errors.IsVersionMismatch(err):
+		code = "PreconditionFailed"
+	case errors.IsAlreadyExists(err):
+		if res == bucketResource {
+			code = "BucketAlreadyOwnedByYou"
+		} else {
+			status, code = http.StatusPreconditionFailed, "PreconditionFailed"
+		}
+	case errors.IsConflict(err):
+		code = "InvalidRequest"
+		if res == bucketResource && strings.Contains(err.Error(), "not empty") {
+			code = "BucketNotEmpty"
+		}
+	case errors.IsTooLarge(err):
+		status, code = http.StatusBadRequest, "EntityTooLarge"
+	case errors.IsInvalidInput(err):
+		code = "InvalidArgument"
+		if strings.Contains(err.Error(), "checksum mismatch") {
+			code = "BadDigest"
+		}
+	case stderrors.Is(err, errors.ErrNotImplemented):
+		code = "NotImplemented"
+	case status == http.StatusServiceUnavailable:
+		code = "ServiceUnavailable"
+	}
+	return newError(status, code, apierror.Message(err))
+}
+
+// abort writes an S3 error response and aborts the handler chain. Server
+// errors are logged with their full cause.
+func abort(c *gin.Context, err error, res resource) {
+	e := toError(err, res)
+	if e.status >= http.StatusInternalServerError {
+		logger.WithComponent("S3Gateway").Error("request failed",
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", e.status),
+			zap.Error(err),
+		)
+	}
+
+	body := *e
+	body.Resource = c.Request.URL.Path
+	body.RequestID = apierror.RequestID(c)
+	if c.Request.Method == http.MethodHead {
+		c.AbortWithStatus(e.status)
+		return
+	}
+	c.Abort()
+	writeXML(c, e.status, &body)
+}
+
+// writeXML writes an XML response.
+func writeXML(c *gin.Context, status int, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, "application/xml", append([]byte(xml.Header), data...))
+}
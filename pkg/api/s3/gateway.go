@@ -0,0 +1,349 @@
+// Package s3 provides an S3-compatible gateway to a region. Buckets are
+// the top-level directories of the region tree and object keys are the
+// paths below them, so objects written over S3 are ordinary files: ACLs,
+// pre-commit hooks, change events and sync apply as for the REST API.
+//
+// The gateway serves a subset of the S3 API with path-style addressing:
+// buckets, objects with ranged reads and conditional requests, listings
+// (versions 1 and 2) and multipart uploads. Requests are authenticated
+// with Signature Version 4, using HMAC keys as access keys.
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// Defaults of GatewayConfig.
+const (
+	DefaultRegion       = "us-east-1"
+	DefaultMaxSkew      = 15 * time.Minute
+	DefaultMultipartTTL = 7 * 24 * time.Hour
+)
+
+// xmlns is the namespace of S3 responses.
+const xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// GatewayConfig holds configuration for a Gateway.
+type GatewayConfig struct {
+	Region         string        // Reported by GetBucketLocation and expected in signatures
+	MaxSkew        time.Duration // Tolerated clock skew of signed requests
+	AllowAnonymous bool          // Serve unsigned requests as the anonymous user
+	MaxUploadSize  int64         // Zero for unlimited
+	MultipartPath  string        // Directory holding the parts of multipart uploads
+	MultipartTTL   time.Duration // Age at which unfinished multipart uploads are dropped
+}
+
+// Gateway serves the S3 API on top of a file service.
+type Gateway struct {
+	config   GatewayConfig
+	files    *service.FileService
+	verifier *verifier
+	uploads  *multipartStore
+	logger   *zap.Logger
+}
+
+// NewGateway creates a gateway authenticating requests with the given
+// HMAC keys, by access key ID.
+func NewGateway(cfg GatewayConfig, files *service.FileService, keys map[string]auth.HMACKey) (*Gateway, error) {
+	if cfg.Region == "" {
+		cfg.Region = DefaultRegion
+	}
+	if cfg.MaxSkew <= 0 {
+		cfg.MaxSkew = DefaultMaxSkew
+	}
+	if cfg.MultipartTTL <= 0 {
+		cfg.MultipartTTL = DefaultMultipartTTL
+	}
+
+	uploads, err := newMultipartStore(cfg.MultipartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gateway{
+		config:   cfg,
+		files:    files,
+		verifier: &verifier{keys: keys, region: cfg.Region, maxSkew: cfg.MaxSkew, now: time.Now},
+		uploads:  uploads,
+		logger:   logger.WithComponent("S3Gateway"),
+	}, nil
+}
+
+// RegisterRoutes routes all requests to the gateway.
+func (g *Gateway) RegisterRoutes(r *gin.Engine) {
+	r.Use(g.authenticate)
+	r.Any("/*path", g.serve)
+}
+
+// signatureKey is the gin context key of a request's verified signature.
+const signatureKey = "s3_signature"
+
+// authenticate verifies the request signature and sets the caller for
+// the file service.
+func (g *Gateway) authenticate(c *gin.Context) {
+	c.Header("x-amz-request-id", apierror.RequestID(c))
+
+	sig, err := g.verifier.verify(c.Request)
+	if err != nil {
+		logger.FromContext(c.Request.Context(), g.logger).Warn("request not authenticated",
+			zap.String("path", c.Request.URL.Path),
+			zap.Error(err))
+		abort(c, err, objectResource)
+		return
+	}
+
+	caller := &metadata.Principal{UserID: "anonymous", Anonymous: true}
+	if sig != nil {
+		caller = &metadata.Principal{UserID: sig.identity.UserID, Groups: sig.identity.Groups}
+		c.Set(signatureKey, sig)
+	} else if !g.config.AllowAnonymous {
+		abort(c, newError(http.StatusForbidden, "AccessDenied", "signed request required"), objectResource)
+		return
+	}
+	c.Request = c.Request.WithContext(service.WithCaller(c.Request.Context(), caller))
+	c.Next()
+}
+
+// serve dispatches a request by its path, method and query.
+func (g *Gateway) serve(c *gin.Context) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.Path, "/"), "/")
+	query := c.Request.URL.Query()
+	has := func(name string) bool { _, ok := query[name]; return ok }
+
+	switch {
+	case bucket == "":
+		if c.Request.Method == http.MethodGet {
+			g.listBuckets(c)
+			return
+		}
+
+	case key == "":
+		switch c.Request.Method {
+		case http.MethodGet:
+			switch {
+			case has("location"):
+				g.getBucketLocation(c, bucket)
+			case query.Get("list-type") == "2":
+				g.listObjects(c, bucket, true)
+			case has("uploads"), has("versioning"), has("acl"), has("policy"), has("lifecycle"), has("tagging"):
+				g.notImplemented(c)
+			default:
+				g.listObjects(c, bucket, false)
+			}
+			return
+		case http.MethodHead:
+			g.headBucket(c, bucket)
+			return
+		case http.MethodPut:
+			g.createBucket(c, bucket)
+			return
+		case http.MethodDelete:
+			g.deleteBucket(c, bucket)
+			return
+		case http.MethodPost:
+			if has("delete") {
+				g.deleteObjects(c, bucket)
+				return
+			}
+		}
+
+	default:
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			if has("uploadId") || has("acl") || has("tagging") {
+				g.notImplemented(c)
+				return
+			}
+			g.getObject(c, bucket, key)
+			return
+		case http.MethodPut:
+			switch {
+			case has("uploadId"):
+				g.uploadPart(c, bucket, key)
+			case c.GetHeader("X-Amz-Copy-Source") != "", has("acl"), has("tagging"):
+				g.notImplemented(c)
+			default:
+				g.putObject(c, bucket, key)
+			}
+			return
+		case http.MethodDelete:
+			if has("uploadId") {
+				g.abortMultipartUpload(c, bucket, key)
+				return
+			}
+			g.deleteObject(c, bucket, key)
+			return
+		case http.MethodPost:
+			switch {
+			case has("uploads"):
+				g.createMultipartUpload(c, bucket, key)
+				return
+			case has("uploadId"):
+				g.completeMultipartUpload(c, bucket, key)
+				return
+			}
+		}
+	}
+
+	abort(c, newError(http.StatusMethodNotAllowed, "MethodNotAllowed", c.Request.Method+" not allowed on "+c.Request.URL.Path), objectResource)
+}
+
+func (g *Gateway) notImplemented(c *gin.Context) {
+	abort(c, newError(http.StatusNotImplemented, "NotImplemented", "operation not supported by this gateway"), objectResource)
+}
+
+// owner returns the user ID recorded on objects written by the caller.
+func owner(ctx context.Context) string {
+	if caller := service.CallerFrom(ctx); caller != nil {
+		return caller.UserID
+	}
+	return "anonymous"
+}
+
+// bucketPath returns the directory of a bucket.
+func bucketPath(bucket string) string {
+	return "/" + bucket
+}
+
+// checkBucket verifies that a bucket exists.
+func (g *Gateway) checkBucket(ctx context.Context, bucket string) error {
+	if strings.ContainsAny(bucket, "\\") || bucket == "." || bucket == ".." {
+		return newError(http.StatusBadRequest, "InvalidBucketName", "invalid bucket name "+bucket)
+	}
+	info, err := g.files.Stat(ctx, bucketPath(bucket))
+	if err != nil {
+		return toError(err, bucketResource)
+	}
+	if !info.IsDir {
+		return newError(http.StatusNotFound, "NoSuchBucket", bucket+" is not a bucket")
+	}
+	return nil
+}
+
+// bucketName matches the names CreateBucket accepts: those valid for
+// path-style addressing in S3.
+var bucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// listAllMyBucketsResult is the ListBuckets response.
+type listAllMyBucketsResult struct {
+	XMLName xml.Name    `xml:"ListAllMyBucketsResult"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Owner   ownerXML    `xml:"Owner"`
+	Buckets []bucketXML `xml:"Buckets>Bucket"`
+}
+
+type ownerXML struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketXML struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+// listBuckets lists the top-level directories.
+// GET /
+func (g *Gateway) listBuckets(c *gin.Context) {
+	ctx := c.Request.Context()
+	entries, err := g.files.ListDirectory(ctx, "/")
+	if err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+
+	result := &listAllMyBucketsResult{Xmlns: xmlns, Owner: ownerXML{ID: owner(ctx), DisplayName: owner(ctx)}}
+	for _, entry := range entries {
+		if entry.IsDir {
+			result.Buckets = append(result.Buckets, bucketXML{Name: entry.Name, CreationDate: formatTime(entry.UpdatedAt)})
+		}
+	}
+	writeXML(c, http.StatusOK, result)
+}
+
+// getBucketLocation reports the configured region.
+// GET /:bucket?location
+func (g *Gateway) getBucketLocation(c *gin.Context, bucket string) {
+	if err := g.checkBucket(c.Request.Context(), bucket); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+	writeXML(c, http.StatusOK, &struct {
+		XMLName xml.Name `xml:"LocationConstraint"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Region  string   `xml:",chardata"`
+	}{Xmlns: xmlns, Region: g.config.Region})
+}
+
+// headBucket checks that a bucket exists and is readable.
+// HEAD /:bucket
+func (g *Gateway) headBucket(c *gin.Context, bucket string) {
+	if err := g.checkBucket(c.Request.Context(), bucket); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+	c.Header("x-amz-bucket-region", g.config.Region)
+	c.Status(http.StatusOK)
+}
+
+// createBucket creates a top-level directory.
+// PUT /:bucket
+func (g *Gateway) createBucket(c *gin.Context, bucket string) {
+	if !bucketName.MatchString(bucket) || strings.Contains(bucket, "..") {
+		abort(c, newError(http.StatusBadRequest, "InvalidBucketName", "invalid bucket name "+bucket), bucketResource)
+		return
+	}
+	if err := g.files.Mkdir(c.Request.Context(), bucketPath(bucket)); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+	c.Header("Location", "/"+bucket)
+	c.Status(http.StatusOK)
+}
+
+// deleteBucket deletes an empty top-level directory.
+// DELETE /:bucket
+func (g *Gateway) deleteBucket(c *gin.Context, bucket string) {
+	ctx := c.Request.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+	if err := g.files.DeletePath(ctx, bucketPath(bucket), ""); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// formatTime formats a time as S3 does in XML responses.
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// objectPath maps a bucket and key to a path. Keys that do not map to a
+// clean path, such as those with empty or "." segments, are refused. A
+// key ending in a slash names a directory.
+func objectPath(bucket, key string) (string, error) {
+	trimmed := strings.TrimSuffix(key, "/")
+	fullPath := bucketPath(bucket) + "/" + trimmed
+	if trimmed == "" || strings.Contains(key, "\\") || service.CleanPath(fullPath) != fullPath {
+		return "", errors.E("s3.objectPath", errors.ErrInvalidInput, nil, "key "+key+" cannot be stored as a path")
+	}
+	return fullPath, nil
+}
@@ -0,0 +1,279 @@
+// Package s3 provides object listings of the S3 gateway.
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+)
+
+// maxListKeys is the largest page a listing returns.
+const maxListKeys = 1000
+
+// listBucketResult is the ListObjects and ListObjectsV2 response.
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Marker                *string        `xml:"Marker"` // Version 1
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	KeyCount              *int           `xml:"KeyCount"` // Version 2
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Contents              []objectXML    `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type objectXML struct {
+	Key          string    `xml:"Key"`
+	LastModified string    `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+	Owner        *ownerXML `xml:"Owner,omitempty"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listEntry is a key found while walking a bucket.
+type listEntry struct {
+	key    string
+	fileID string // Empty for directories
+}
+
+// listObjects lists the objects of a bucket, in version 1 or 2 of the
+// API. Keys are the paths of files, relative to the bucket; empty
+// directories are listed as keys ending in a slash.
+// GET /:bucket
+// GET /:bucket?list-type=2
+func (g *Gateway) listObjects(c *gin.Context, bucket string, v2 bool) {
+	ctx := c.Request.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+
+	prefix := c.Query("prefix")
+	delimiter := c.Query("delimiter")
+	maxKeys := maxListKeys
+	if value := c.Query("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			abort(c, newError(http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer"), bucketResource)
+			return
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+	encodingType := c.Query("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		abort(c, newError(http.StatusBadRequest, "InvalidArgument", "invalid encoding-type "+encodingType), bucketResource)
+		return
+	}
+
+	result := &listBucketResult{
+		Xmlns:        xmlns,
+		Name:         bucket,
+		Prefix:       prefix,
+		Delimiter:    delimiter,
+		MaxKeys:      maxKeys,
+		EncodingType: encodingType,
+	}
+	marker := c.Query("marker")
+	if v2 {
+		result.StartAfter = c.Query("start-after")
+		marker = result.StartAfter
+		if token := c.Query("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				abort(c, newError(http.StatusBadRequest, "InvalidArgument", "invalid continuation-token"), bucketResource)
+				return
+			}
+			result.ContinuationToken = token
+			marker = string(decoded)
+		}
+	} else {
+		result.Marker = &marker
+	}
+
+	entries, err := g.walk(ctx, bucket, prefix, delimiter == "/")
+	if err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+
+	var page []listEntry
+	var last string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.key <= marker || !strings.HasPrefix(entry.key, prefix) {
+			continue
+		}
+
+		// Keys containing the delimiter after the prefix roll up into a
+		// common prefix, listed once
+		item := entry.key
+		isPrefix := false
+		if delimiter != "" {
+			if i := strings.Index(entry.key[len(prefix):], delimiter); i >= 0 {
+				item = entry.key[:len(prefix)+i+len(delimiter)]
+				isPrefix = true
+				if item <= marker || seen[item] {
+					continue
+				}
+			}
+		}
+
+		if len(page)+len(result.CommonPrefixes) == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if isPrefix {
+			seen[item] = true
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: item})
+		} else {
+			page = append(page, entry)
+		}
+		last = item
+	}
+
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else if delimiter != "" {
+			result.NextMarker = last
+		}
+	}
+
+	fetchOwner := !v2 || c.Query("fetch-owner") == "true"
+	for _, entry := range page {
+		object, err := g.describe(ctx, entry, fetchOwner)
+		if err != nil {
+			if errors.IsNotFound(err) || errors.IsForbidden(err) {
+				continue
+			}
+			abort(c, err, objectResource)
+			return
+		}
+		result.Contents = append(result.Contents, *object)
+	}
+	if v2 {
+		count := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &count
+	}
+
+	if encodingType == "url" {
+		encodeResult(result)
+	}
+	writeXML(c, http.StatusOK, result)
+}
+
+// describe fetches the listing details of a key.
+func (g *Gateway) describe(ctx context.Context, entry listEntry, withOwner bool) (*objectXML, error) {
+	if entry.fileID == "" {
+		return &objectXML{Key: entry.key, ETag: emptyETag, StorageClass: "STANDARD"}, nil
+	}
+	meta, err := g.files.GetMetadata(ctx, entry.fileID)
+	if err != nil {
+		return nil, err
+	}
+	object := &objectXML{
+		Key:          entry.key,
+		LastModified: formatTime(meta.UpdatedAt),
+		ETag:         meta.ETag(),
+		Size:         meta.Size,
+		StorageClass: "STANDARD",
+	}
+	if withOwner {
+		object.Owner = &ownerXML{ID: meta.OwnerID, DisplayName: meta.OwnerID}
+	}
+	return object, nil
+}
+
+// walk returns the keys of a bucket that may match a prefix, sorted. The
+// walk starts at the directory the prefix names. With shallow set only
+// that directory is read, and its subdirectories are returned as keys
+// ending in a slash; otherwise subdirectories are walked, skipping those
+// the caller may not read.
+func (g *Gateway) walk(ctx context.Context, bucket, prefix string, shallow bool) ([]listEntry, error) {
+	root := bucketPath(bucket)
+	start := root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		start = root + "/" + prefix[:i]
+		if path.Clean(start) != start {
+			return nil, nil
+		}
+	}
+
+	var entries []listEntry
+	dirs := []string{start}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		children, err := g.files.ListDirectory(ctx, dir)
+		if err != nil {
+			// A missing start directory matches nothing, and subdirectories
+			// may be hidden by their ACLs
+			if errors.IsNotFound(err) || (dir != start && errors.IsForbidden(err)) {
+				continue
+			}
+			return nil, err
+		}
+		if len(children) == 0 && dir != start {
+			entries = append(entries, listEntry{key: strings.TrimPrefix(dir, root+"/") + "/"})
+			continue
+		}
+
+		for _, child := range children {
+			key := strings.TrimPrefix(child.Path, root+"/")
+			switch {
+			case !child.IsDir:
+				entries = append(entries, listEntry{key: key, fileID: child.ID})
+			case shallow:
+				entries = append(entries, listEntry{key: key + "/"})
+			default:
+				dirs = append(dirs, child.Path)
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+// encodeResult URL-encodes the keys and prefixes of a listing, for
+// clients that asked for encoding-type=url.
+func encodeResult(result *listBucketResult) {
+	result.Prefix = url.QueryEscape(result.Prefix)
+	result.Delimiter = url.QueryEscape(result.Delimiter)
+	result.StartAfter = url.QueryEscape(result.StartAfter)
+	result.NextMarker = url.QueryEscape(result.NextMarker)
+	if result.Marker != nil {
+		marker := url.QueryEscape(*result.Marker)
+		result.Marker = &marker
+	}
+	for i := range result.Contents {
+		result.Contents[i].Key = url.QueryEscape(result.Contents[i].Key)
+	}
+	for i := range result.CommonPrefixes {
+		result.CommonPrefixes[i].Prefix = url.QueryEscape(result.CommonPrefixes[i].Prefix)
+	}
+}
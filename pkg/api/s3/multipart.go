@@ -0,0 +1,442 @@
+// Package s3 provides multipart uploads for the S3 gateway. Parts are
+// kept on local disk until the upload is completed, when they are
+// uploaded to the file service as one file.
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// maxPartNumber is the highest part number S3 accepts.
+const maxPartNumber = 10000
+
+// multipartUpload is an upload in progress.
+type multipartUpload struct {
+	ID          string    `json:"id"`
+	Bucket      string    `json:"bucket"`
+	Key         string    `json:"key"`
+	Initiator   string    `json:"initiator"` // User ID; only the initiator may continue the upload
+	ContentType string    `json:"content_type,omitempty"`
+	Initiated   time.Time `json:"initiated"`
+}
+
+// part is an uploaded part.
+type part struct {
+	number int
+	etag   string // Quoted MD5 of the part
+	size   int64
+	path   string
+}
+
+// multipartStore keeps multipart uploads in a directory, one
+// subdirectory per upload holding upload.json and the parts, which are
+// named <number>.<md5>.
+type multipartStore struct {
+	dir string
+	mu  sync.Mutex // Guards replacing, listing and removing parts
+}
+
+func newMultipartStore(dir string) (*multipartStore, error) {
+	if dir == "" {
+		return nil, errors.E("s3.newMultipartStore", errors.ErrInvalidInput, nil, "multipart path not set")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.E("s3.newMultipartStore", errors.ErrInvalidInput, err, dir)
+	}
+	return &multipartStore{dir: dir}, nil
+}
+
+// create records a new upload.
+func (s *multipartStore) create(u *multipartUpload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return errors.Wrap("multipartStore.create", err)
+	}
+	if err := os.Mkdir(filepath.Join(s.dir, u.ID), 0o755); err != nil {
+		return errors.Wrap("multipartStore.create", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, u.ID, "upload.json"), data, 0o644); err != nil {
+		return errors.Wrap("multipartStore.create", err)
+	}
+	return nil
+}
+
+// get loads an upload.
+func (s *multipartStore) get(id string) (*multipartUpload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, noSuchUpload(id)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id, "upload.json"))
+	if os.IsNotExist(err) {
+		return nil, noSuchUpload(id)
+	}
+	if err != nil {
+		return nil, errors.Wrap("multipartStore.get", err)
+	}
+	var u multipartUpload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, errors.Wrap("multipartStore.get", err)
+	}
+	return &u, nil
+}
+
+func noSuchUpload(id string) *Error {
+	return newError(http.StatusNotFound, "NoSuchUpload", "no such upload "+id)
+}
+
+// putPart stores a part, replacing an earlier upload of the same part
+// number. The part must match the expected size, unless it is negative,
+// and the expected digests.
+func (s *multipartStore) putPart(id string, number int, r io.Reader, size int64, expected service.Digests) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, id), ".part-*")
+	if err != nil {
+		return "", errors.Wrap("multipartStore.putPart", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	sums := map[service.DigestAlgorithm]hash.Hash{service.DigestMD5: md5.New(), service.DigestSHA256: sha256.New()}
+	written, err := io.Copy(io.MultiWriter(tmp, sums[service.DigestMD5], sums[service.DigestSHA256]), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Wrap("multipartStore.putPart", err)
+	}
+	if size >= 0 && written != size {
+		return "", newError(http.StatusBadRequest, "IncompleteBody", "part does not match its declared length")
+	}
+	for algorithm, want := range expected {
+		if !bytes.Equal(want, sums[algorithm].Sum(nil)) {
+			return "", newError(http.StatusBadRequest, "BadDigest", "checksum mismatch for "+string(algorithm))
+		}
+	}
+
+	sum := hex.EncodeToString(sums[service.DigestMD5].Sum(nil))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts, err := s.parts(id)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range parts {
+		if p.number == number {
+			os.Remove(p.path)
+		}
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, id, fmt.Sprintf("%05d.%s", number, sum))); err != nil {
+		return "", errors.Wrap("multipartStore.putPart", err)
+	}
+	return `"` + sum + `"`, nil
+}
+
+// parts lists the parts of an upload by number. The caller holds mu.
+func (s *multipartStore) parts(id string) ([]part, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, id))
+	if os.IsNotExist(err) {
+		return nil, noSuchUpload(id)
+	}
+	if err != nil {
+		return nil, errors.Wrap("multipartStore.parts", err)
+	}
+
+	var parts []part
+	for _, entry := range entries {
+		numberField, sum, ok := strings.Cut(entry.Name(), ".")
+		number, err := strconv.Atoi(numberField)
+		if !ok || err != nil || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.Wrap("multipartStore.parts", err)
+		}
+		parts = append(parts, part{
+			number: number,
+			etag:   `"` + sum + `"`,
+			size:   info.Size(),
+			path:   filepath.Join(s.dir, id, entry.Name()),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].number < parts[j].number })
+	return parts, nil
+}
+
+// remove deletes an upload and its parts.
+func (s *multipartStore) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.RemoveAll(filepath.Join(s.dir, id)); err != nil {
+		return errors.Wrap("multipartStore.remove", err)
+	}
+	return nil
+}
+
+// sweep removes uploads initiated before a cutoff, returning how many.
+func (s *multipartStore) sweep(before time.Time) int {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		initiated := time.Time{}
+		if u, err := s.get(entry.Name()); err == nil {
+			initiated = u.Initiated
+		} else if info, err := entry.Info(); err == nil {
+			initiated = info.ModTime()
+		}
+		if initiated.Before(before) && s.remove(entry.Name()) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// upload loads an upload for a request, which must come from the
+// initiator and name the same object.
+func (g *Gateway) upload(c *gin.Context, bucket, key string) (*multipartUpload, error) {
+	u, err := g.uploads.get(c.Query("uploadId"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Bucket != bucket || u.Key != key {
+		return nil, noSuchUpload(u.ID)
+	}
+	if u.Initiator != owner(c.Request.Context()) {
+		return nil, newError(http.StatusForbidden, "AccessDenied", "upload was initiated by another user")
+	}
+	return u, nil
+}
+
+type initiateResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+// createMultipartUpload starts a multipart upload. Uploads left
+// unfinished for longer than the configured TTL are dropped on the way.
+// POST /:bucket/*key?uploads
+func (g *Gateway) createMultipartUpload(c *gin.Context, bucket, key string) {
+	ctx := c.Request.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+	fullPath, err := objectPath(bucket, key)
+	if err == nil && strings.HasSuffix(key, "/") {
+		err = newError(http.StatusBadRequest, "InvalidArgument", "multipart uploads cannot create directory markers")
+	}
+	if err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+	if err := g.files.CheckAccess(ctx, fullPath, metadata.PermWrite); err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+
+	if n := g.uploads.sweep(time.Now().Add(-g.config.MultipartTTL)); n > 0 {
+		logger.FromContext(ctx, g.logger).Info("dropped expired multipart uploads", zap.Int("count", n))
+	}
+
+	u := &multipartUpload{
+		ID:          uuid.New().String(),
+		Bucket:      bucket,
+		Key:         key,
+		Initiator:   owner(ctx),
+		ContentType: c.GetHeader("Content-Type"),
+		Initiated:   time.Now().UTC(),
+	}
+	if err := g.uploads.create(u); err != nil {
+		abort(c, err, uploadResource)
+		return
+	}
+	writeXML(c, http.StatusOK, &initiateResult{Xmlns: xmlns, Bucket: bucket, Key: key, UploadID: u.ID})
+}
+
+// uploadPart stores a part of a multipart upload.
+// PUT /:bucket/*key?partNumber=N&uploadId=ID
+func (g *Gateway) uploadPart(c *gin.Context, bucket, key string) {
+	number, err := strconv.Atoi(c.Query("partNumber"))
+	if err != nil || number < 1 || number > maxPartNumber {
+		abort(c, newError(http.StatusBadRequest, "InvalidArgument", "partNumber must be between 1 and 10000"), uploadResource)
+		return
+	}
+	u, err := g.upload(c, bucket, key)
+	if err != nil {
+		abort(c, err, uploadResource)
+		return
+	}
+
+	body, size, err := g.body(c)
+	if err != nil {
+		abort(c, err, uploadResource)
+		return
+	}
+	digests, err := requestDigests(c)
+	if err != nil {
+		abort(c, err, uploadResource)
+		return
+	}
+	etag, err := g.uploads.putPart(u.ID, number, body, size, digests)
+	if err != nil {
+		abort(c, err, uploadResource)
+		return
+	}
+	c.Header("ETag", etag)
+	c.Status(http.StatusOK)
+}
+
+// completeRequest is the CompleteMultipartUpload request body.
+type completeRequest struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// completeMultipartUpload uploads the listed parts, in order, as one file
+// and ends the upload. Conditional headers apply to the file being
+// replaced, as for PutObject.
+// POST /:bucket/*key?uploadId=ID
+func (g *Gateway) completeMultipartUpload(c *gin.Context, bucket, key string) {
+	ctx := c.Request.Context()
+	u, err := g.upload(c, bucket, key)
+	if err != nil {
+		abort(c, err, uploadResource)
+		return
+	}
+
+	var req completeRequest
+	if err := xml.NewDecoder(io.LimitReader(c.Request.Body, 2<<20)).Decode(&req); err != nil || len(req.Parts) == 0 {
+		abort(c, newError(http.StatusBadRequest, "MalformedXML", "malformed complete request"), uploadResource)
+		return
+	}
+
+	g.uploads.mu.Lock()
+	stored, err := g.uploads.parts(u.ID)
+	g.uploads.mu.Unlock()
+	if err != nil {
+		abort(c, err, uploadResource)
+		return
+	}
+	byNumber := make(map[int]part, len(stored))
+	for _, p := range stored {
+		byNumber[p.number] = p
+	}
+
+	var readers []io.Reader
+	var size int64
+	for i, listed := range req.Parts {
+		if i > 0 && listed.PartNumber <= req.Parts[i-1].PartNumber {
+			abort(c, newError(http.StatusBadRequest, "InvalidPartOrder", "parts must be listed in ascending order"), uploadResource)
+			return
+		}
+		p, ok := byNumber[listed.PartNumber]
+		if !ok || strings.Trim(listed.ETag, `"`) != strings.Trim(p.etag, `"`) {
+			abort(c, newError(http.StatusBadRequest, "InvalidPart", "part "+strconv.Itoa(listed.PartNumber)+" not found"), uploadResource)
+			return
+		}
+		f, err := os.Open(p.path)
+		if err != nil {
+			abort(c, newError(http.StatusBadRequest, "InvalidPart", "part "+strconv.Itoa(listed.PartNumber)+" not found"), uploadResource)
+			return
+		}
+		defer f.Close()
+		readers = append(readers, f)
+		size += p.size
+	}
+	if g.config.MaxUploadSize > 0 && size > g.config.MaxUploadSize {
+		abort(c, entityTooLarge(g.config.MaxUploadSize), uploadResource)
+		return
+	}
+
+	fullPath, err := objectPath(bucket, key)
+	if err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+	resp, err := g.files.Upload(ctx, &service.UploadRequest{
+		Path:        path.Dir(fullPath),
+		Name:        path.Base(fullPath),
+		Size:        size,
+		Content:     io.MultiReader(readers...),
+		MimeType:    u.ContentType,
+		OwnerID:     u.Initiator,
+		IfMatch:     c.GetHeader("If-Match"),
+		IfNoneMatch: c.GetHeader("If-None-Match"),
+	})
+	if err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+
+	if err := g.uploads.remove(u.ID); err != nil {
+		logger.FromContext(c.Request.Context(), g.logger).Warn("failed to remove completed multipart upload",
+			zap.String("upload_id", u.ID),
+			zap.Error(err))
+	}
+	writeXML(c, http.StatusOK, &completeResult{
+		Xmlns:    xmlns,
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     resp.ETag,
+	})
+}
+
+// abortMultipartUpload discards a multipart upload and its parts.
+// DELETE /:bucket/*key?uploadId=ID
+func (g *Gateway) abortMultipartUpload(c *gin.Context, bucket, key string) {
+	u, err := g.upload(c, bucket, key)
+	if err != nil {
+		abort(c, err, uploadResource)
+		return
+	}
+	if err := g.uploads.remove(u.ID); err != nil {
+		abort(c, err, uploadResource)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
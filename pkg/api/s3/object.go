@@ -0,0 +1,482 @@
+// Package s3 provides the object operations of the S3 gateway.
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/service"
+)
+
+// emptyETag is the ETag of directory markers: the MD5 of no content, as
+// S3 reports for empty objects.
+var emptyETag = func() string {
+	sum := md5.Sum(nil)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}()
+
+// putObject uploads an object. Keys ending in a slash create directory
+// markers, which map to directories.
+// PUT /:bucket/*key
+func (g *Gateway) putObject(c *gin.Context, bucket, key string) {
+	ctx := c.Request.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+	fullPath, err := objectPath(bucket, key)
+	if err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+
+	if strings.HasSuffix(key, "/") {
+		if err := g.mkdirAll(ctx, fullPath); err != nil {
+			abort(c, err, objectResource)
+			return
+		}
+		c.Header("ETag", emptyETag)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	body, size, err := g.body(c)
+	if err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+	digests, err := requestDigests(c)
+	if err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+
+	resp, err := g.files.Upload(ctx, &service.UploadRequest{
+		Path:        path.Dir(fullPath),
+		Name:        path.Base(fullPath),
+		Size:        size,
+		Content:     body,
+		MimeType:    c.GetHeader("Content-Type"),
+		OwnerID:     owner(ctx),
+		IfMatch:     c.GetHeader("If-Match"),
+		IfNoneMatch: c.GetHeader("If-None-Match"),
+		Digests:     digests,
+	})
+	if err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+
+	c.Header("ETag", resp.ETag)
+	c.Status(http.StatusOK)
+}
+
+// body returns the decoded payload of an upload and its size, or -1 if
+// the size is unknown. Payloads larger than the upload limit are refused.
+func (g *Gateway) body(c *gin.Context) (io.Reader, int64, error) {
+	r := c.Request
+	size := r.ContentLength
+	var body io.Reader = r.Body
+
+	if value, ok := c.Get(signatureKey); ok && value.(*signature).streaming() {
+		decoded, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil || decoded < 0 {
+			return nil, 0, newError(http.StatusLengthRequired, "MissingContentLength", "missing x-amz-decoded-content-length")
+		}
+		size = decoded
+		body = newChunkReader(r.Body, value.(*signature))
+	}
+
+	if g.config.MaxUploadSize > 0 {
+		if size > g.config.MaxUploadSize {
+			return nil, 0, entityTooLarge(g.config.MaxUploadSize)
+		}
+		if size < 0 {
+			body = &limitedReader{r: body, remaining: g.config.MaxUploadSize}
+		}
+	}
+	return body, size, nil
+}
+
+// limitedReader fails reads past a size limit with EntityTooLarge.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, entityTooLarge(0)
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, entityTooLarge(0)
+	}
+	return n, err
+}
+
+func entityTooLarge(limit int64) *Error {
+	msg := "upload exceeds the maximum size"
+	if limit > 0 {
+		msg += " of " + strconv.FormatInt(limit, 10) + " bytes"
+	}
+	return newError(http.StatusBadRequest, "EntityTooLarge", msg)
+}
+
+// requestDigests collects the digests a request claims for its payload:
+// Content-MD5 and a hex x-amz-content-sha256.
+func requestDigests(c *gin.Context) (service.Digests, error) {
+	digests := service.Digests{}
+	if value := c.GetHeader("Content-MD5"); value != "" {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, newError(http.StatusBadRequest, "InvalidDigest", "malformed Content-MD5")
+		}
+		if err := digests.Add(service.DigestMD5, sum); err != nil {
+			return nil, newError(http.StatusBadRequest, "InvalidDigest", "malformed Content-MD5")
+		}
+	}
+	if value := c.GetHeader("X-Amz-Content-Sha256"); len(value) == 64 {
+		sum, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, newError(http.StatusBadRequest, "InvalidArgument", "malformed x-amz-content-sha256")
+		}
+		if err := digests.Add(service.DigestSHA256, sum); err != nil {
+			return nil, err
+		}
+	}
+	if len(digests) == 0 {
+		return nil, nil
+	}
+	return digests, nil
+}
+
+// mkdirAll creates a directory and its missing parents.
+func (g *Gateway) mkdirAll(ctx context.Context, dir string) error {
+	parts := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+	for i := range parts {
+		current := "/" + strings.Join(parts[:i+1], "/")
+		info, err := g.files.Stat(ctx, current)
+		switch {
+		case err == nil && !info.IsDir:
+			return newError(http.StatusConflict, "InvalidRequest", current+" is an object")
+		case err == nil:
+			continue
+		case !errors.IsNotFound(err):
+			return err
+		}
+		if err := g.files.Mkdir(ctx, current); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getObject serves an object, or its headers for HEAD requests. A single
+// byte range may be requested.
+// GET /:bucket/*key
+// HEAD /:bucket/*key
+func (g *Gateway) getObject(c *gin.Context, bucket, key string) {
+	ctx := c.Request.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+	fullPath, err := objectPath(bucket, key)
+	if err != nil {
+		abort(c, newError(http.StatusNotFound, "NoSuchKey", "no such key "+key), objectResource)
+		return
+	}
+	info, err := g.files.Stat(ctx, fullPath)
+	if err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+
+	// Directories are only visible as markers, through keys ending in a slash
+	if info.IsDir != strings.HasSuffix(key, "/") {
+		abort(c, newError(http.StatusNotFound, "NoSuchKey", "no such key "+key), objectResource)
+		return
+	}
+	if info.IsDir {
+		c.Header("ETag", emptyETag)
+		c.Header("Content-Length", "0")
+		c.Status(http.StatusOK)
+		return
+	}
+
+	meta := info.File
+	if meta.LocalState == metadata.LocalStateQuarantined {
+		abort(c, newError(http.StatusForbidden, "AccessDenied", "object quarantined"), objectResource)
+		return
+	}
+	if status := checkConditions(c, meta); status != 0 {
+		c.Header("ETag", meta.ETag())
+		c.Header("Last-Modified", meta.UpdatedAt.UTC().Format(http.TimeFormat))
+		if status == http.StatusPreconditionFailed {
+			abort(c, newError(status, "PreconditionFailed", "at least one of the preconditions did not hold"), objectResource)
+			return
+		}
+		c.Status(status)
+		return
+	}
+
+	start, length := int64(0), meta.Size
+	ranged := false
+	if header := c.GetHeader("Range"); header != "" {
+		var ok bool
+		start, length, ok = parseRange(header, meta.Size)
+		if !ok {
+			c.Header("Content-Range", "bytes */"+strconv.FormatInt(meta.Size, 10))
+			abort(c, newError(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "the requested range is not satisfiable"), objectResource)
+			return
+		}
+		ranged = true
+	}
+
+	setObjectHeaders(c, meta)
+	c.Header("Content-Length", strconv.FormatInt(length, 10))
+	status := http.StatusOK
+	if ranged {
+		c.Header("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(start+length-1, 10)+"/"+strconv.FormatInt(meta.Size, 10))
+		status = http.StatusPartialContent
+	}
+	if c.Request.Method == http.MethodHead {
+		c.Status(status)
+		return
+	}
+
+	resp, err := g.files.Download(ctx, meta.ID)
+	if err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+	defer resp.Content.Close()
+
+	if start > 0 {
+		if err := skip(resp.Content, start); err != nil {
+			abort(c, errors.Wrap("Gateway.getObject", err), objectResource)
+			return
+		}
+	}
+	c.Status(status)
+	io.CopyN(c.Writer, resp.Content, length)
+}
+
+// setObjectHeaders sets the headers describing an object, applying any
+// response-* query overrides.
+func setObjectHeaders(c *gin.Context, meta *metadata.FileMetadata) {
+	c.Header("ETag", meta.ETag())
+	c.Header("Last-Modified", meta.UpdatedAt.UTC().Format(http.TimeFormat))
+	c.Header("Content-Type", meta.MimeType)
+	c.Header("Accept-Ranges", "bytes")
+	for name, value := range meta.CustomMeta {
+		c.Header("x-amz-meta-"+name, value)
+	}
+
+	overrides := map[string]string{
+		"response-content-type":        "Content-Type",
+		"response-content-language":    "Content-Language",
+		"response-expires":             "Expires",
+		"response-cache-control":       "Cache-Control",
+		"response-content-disposition": "Content-Disposition",
+		"response-content-encoding":    "Content-Encoding",
+	}
+	for param, header := range overrides {
+		if value := c.Query(param); value != "" {
+			c.Header(header, value)
+		}
+	}
+}
+
+// checkConditions evaluates the conditional request headers in the order
+// S3 does, returning 412 or 304 if the object should not be served, or
+// zero if it should.
+func checkConditions(c *gin.Context, meta *metadata.FileMetadata) int {
+	modified := meta.UpdatedAt.Truncate(time.Second)
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch != "" && !service.ETagMatches(ifMatch, meta.ETag(), false) {
+		return http.StatusPreconditionFailed
+	}
+	if since, err := http.ParseTime(c.GetHeader("If-Unmodified-Since")); ifMatch == "" && err == nil && modified.After(since) {
+		return http.StatusPreconditionFailed
+	}
+	ifNoneMatch := c.GetHeader("If-None-Match")
+	if ifNoneMatch != "" && service.ETagMatches(ifNoneMatch, meta.ETag(), true) {
+		return http.StatusNotModified
+	}
+	if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); ifNoneMatch == "" && err == nil && !modified.After(since) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// parseRange parses a single range of a Range header against an object
+// size, returning the start and length of the range. Multiple ranges are
+// not supported by S3 and are refused.
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
+
+// skip advances a reader by n bytes, seeking if it can.
+func skip(r io.Reader, n int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
+
+// deleteObject deletes an object. Deleting a missing key succeeds, as in S3.
+// DELETE /:bucket/*key
+func (g *Gateway) deleteObject(c *gin.Context, bucket, key string) {
+	ctx := c.Request.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+	if err := g.delete(ctx, bucket, key, c.GetHeader("If-Match")); err != nil {
+		abort(c, err, objectResource)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// delete deletes the file or empty directory a key maps to. Missing keys
+// are not an error.
+func (g *Gateway) delete(ctx context.Context, bucket, key, ifMatch string) error {
+	fullPath, err := objectPath(bucket, key)
+	if err != nil {
+		return nil
+	}
+	info, err := g.files.Stat(ctx, fullPath)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir != strings.HasSuffix(key, "/") {
+		return nil
+	}
+
+	err = g.files.DeletePath(ctx, fullPath, ifMatch)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteRequest is the DeleteObjects request body.
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+// deleteResult is the DeleteObjects response.
+type deleteResult struct {
+	XMLName xml.Name         `xml:"DeleteResult"`
+	Xmlns   string           `xml:"xmlns,attr"`
+	Deleted []deletedXML     `xml:"Deleted"`
+	Errors  []deleteErrorXML `xml:"Error"`
+}
+
+type deletedXML struct {
+	Key string `xml:"Key"`
+}
+
+type deleteErrorXML struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// maxDeleteKeys is the number of keys a DeleteObjects request may name.
+const maxDeleteKeys = 1000
+
+// deleteObjects deletes several objects, reporting the outcome per key.
+// POST /:bucket?delete
+func (g *Gateway) deleteObjects(c *gin.Context, bucket string) {
+	ctx := c.Request.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		abort(c, err, bucketResource)
+		return
+	}
+
+	var req deleteRequest
+	if err := xml.NewDecoder(io.LimitReader(c.Request.Body, 2<<20)).Decode(&req); err != nil {
+		abort(c, newError(http.StatusBadRequest, "MalformedXML", "malformed delete request"), objectResource)
+		return
+	}
+	if len(req.Objects) == 0 || len(req.Objects) > maxDeleteKeys {
+		abort(c, newError(http.StatusBadRequest, "MalformedXML", "a delete request names 1 to 1000 keys"), objectResource)
+		return
+	}
+
+	result := &deleteResult{Xmlns: xmlns}
+	for _, object := range req.Objects {
+		if err := g.delete(ctx, bucket, object.Key, ""); err != nil {
+			e := toError(err, objectResource)
+			result.Errors = append(result.Errors, deleteErrorXML{Key: object.Key, Code: e.Code, Message: e.Message})
+			continue
+		}
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedXML{Key: object.Key})
+		}
+	}
+	writeXML(c, http.StatusOK, result)
+}
@@ -0,0 +1,425 @@
+// Package s3 provides AWS Signature Version 4 verification.
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"asisaid.cn/JzSE/internal/common/auth"
+)
+
+// Signature Version 4 constants.
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat  = "20060102T150405Z"
+	scopeDate      = "20060102"
+	maxPresignAge  = 7 * 24 * time.Hour
+
+	// Payload hashes that are not hashes
+	unsignedPayload        = "UNSIGNED-PAYLOAD"
+	streamingPayload       = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrail  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrail = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// signature is a verified request signature.
+type signature struct {
+	identity *auth.Identity
+	key      []byte // Signing key, for chunk signatures
+	scope    string // <date>/<region>/s3/aws4_request
+	amzDate  string
+	seed     string // Signature of the request, the first chunk's predecessor
+	payload  string // Declared payload hash, or one of the payload constants
+}
+
+// verifier checks Signature Version 4 signatures made with HMAC keys,
+// sent in the Authorization header or as presigned URL query parameters.
+type verifier struct {
+	keys    map[string]auth.HMACKey // By access key ID
+	region  string                  // Expected in the credential scope
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+// verify checks the signature of a request. It returns nil without an
+// error for requests that are not signed.
+func (v *verifier) verify(r *http.Request) (*signature, error) {
+	query := r.URL.Query()
+	switch {
+	case strings.HasPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" "):
+		return v.verifyHeader(r)
+	case query.Get("X-Amz-Algorithm") != "":
+		return v.verifyQuery(r, query)
+	case r.Header.Get("Authorization") != "":
+		return nil, newError(http.StatusBadRequest, "InvalidArgument", "unsupported authorization type")
+	default:
+		return nil, nil
+	}
+}
+
+// verifyHeader checks a signature sent in the Authorization header:
+//
+//	AWS4-HMAC-SHA256 Credential=<key>/<scope>, SignedHeaders=<h1;h2>, Signature=<hex>
+func (v *verifier) verifyHeader(r *http.Request) (*signature, error) {
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), sigV4Algorithm), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		return nil, newError(http.StatusBadRequest, "InvalidRequest", "missing x-amz-content-sha256")
+	}
+
+	sig, err := v.check(r, fields["Credential"], fields["SignedHeaders"], fields["Signature"], amzDate, payload, r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if skew := v.now().Sub(sig.time()); skew > v.maxSkew || skew < -v.maxSkew {
+		return nil, newError(http.StatusForbidden, "RequestTimeTooSkewed", "request time differs too much from the server time")
+	}
+	return sig, nil
+}
+
+// verifyQuery checks the signature of a presigned URL. The payload of
+// presigned requests is never signed.
+func (v *verifier) verifyQuery(r *http.Request, query url.Values) (*signature, error) {
+	if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+		return nil, newError(http.StatusBadRequest, "InvalidArgument", "unsupported X-Amz-Algorithm")
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignAge {
+		return nil, newError(http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Expires must be between 0 and 604800 seconds")
+	}
+
+	signed := make(url.Values, len(query))
+	for name, values := range query {
+		if name != "X-Amz-Signature" {
+			signed[name] = values
+		}
+	}
+	sig, err := v.check(r, query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Signature"),
+		query.Get("X-Amz-Date"), unsignedPayload, signed)
+	if err != nil {
+		return nil, err
+	}
+
+	now := v.now()
+	if now.Before(sig.time().Add(-v.maxSkew)) {
+		return nil, newError(http.StatusForbidden, "AccessDenied", "request is not valid yet")
+	}
+	if now.After(sig.time().Add(time.Duration(expires) * time.Second)) {
+		return nil, newError(http.StatusForbidden, "AccessDenied", "request has expired")
+	}
+	return sig, nil
+}
+
+// check recomputes a signature from its parts.
+func (v *verifier) check(r *http.Request, credential, signedHeaders, provided, amzDate, payload string, query url.Values) (*signature, error) {
+	keyID, scope, _ := strings.Cut(credential, "/")
+	scopeParts := strings.Split(scope, "/")
+	if keyID == "" || len(scopeParts) != 4 || scopeParts[2] != "s3" || scopeParts[3] != "aws4_request" ||
+		signedHeaders == "" || provided == "" {
+		return nil, newError(http.StatusBadRequest, "AuthorizationHeaderMalformed", "malformed signature credentials")
+	}
+	if scopeParts[1] != v.region {
+		return nil, newError(http.StatusBadRequest, "AuthorizationHeaderMalformed", "the region "+scopeParts[1]+" is wrong; expecting "+v.region)
+	}
+	if t, err := time.Parse(amzDateFormat, amzDate); err != nil || t.Format(scopeDate) != scopeParts[0] {
+		return nil, newError(http.StatusForbidden, "AccessDenied", "missing or malformed request date")
+	}
+
+	key, ok := v.keys[keyID]
+	if !ok {
+		return nil, newError(http.StatusForbidden, "InvalidAccessKeyId", "unknown access key "+keyID)
+	}
+
+	headers := strings.Split(signedHeaders, ";")
+	if !contains(headers, "host") {
+		return nil, newError(http.StatusBadRequest, "AuthorizationHeaderMalformed", "host header must be signed")
+	}
+	signingKey := signingKey(key.Secret, scopeParts[0], scopeParts[1])
+	canonical := canonicalRequest(r, query, headers, payload)
+	expected := hmacHex(signingKey, stringToSign(amzDate, scope, canonical))
+	if !hmac.Equal([]byte(provided), []byte(expected)) {
+		return nil, newError(http.StatusForbidden, "SignatureDoesNotMatch", "the request signature does not match")
+	}
+
+	userID := key.UserID
+	if userID == "" {
+		userID = keyID
+	}
+	return &signature{
+		identity: &auth.Identity{UserID: userID, Groups: key.Groups, Method: auth.MethodHMAC},
+		key:      signingKey,
+		scope:    scope,
+		amzDate:  amzDate,
+		seed:     provided,
+		payload:  payload,
+	}, nil
+}
+
+// time returns the signing time.
+func (s *signature) time() time.Time {
+	t, _ := time.Parse(amzDateFormat, s.amzDate)
+	return t
+}
+
+// streaming reports whether the payload is sent in aws-chunked encoding.
+func (s *signature) streaming() bool {
+	switch s.payload {
+	case streamingPayload, streamingPayloadTrail, streamingUnsignedTrail:
+		return true
+	}
+	return false
+}
+
+// SignRequest signs a request with Signature Version 4 in the
+// Authorization header, for clients and tests. The payload is signed if
+// the X-Amz-Content-Sha256 header holds its hash, and left unsigned if the
+// header is unset.
+func SignRequest(r *http.Request, keyID string, secret []byte, region string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	scope := now.UTC().Format(scopeDate) + "/" + region + "/s3/aws4_request"
+	r.Header.Set("X-Amz-Date", amzDate)
+	if r.Header.Get("X-Amz-Content-Sha256") == "" {
+		r.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	}
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	for name := range r.Header {
+		if name := strings.ToLower(name); !contains(headers, name) && (name == "content-md5" || name == "content-type" ||
+			strings.HasPrefix(name, "x-amz-")) {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+
+	canonical := canonicalRequest(r, r.URL.Query(), headers, r.Header.Get("X-Amz-Content-Sha256"))
+	key := signingKey(secret, now.UTC().Format(scopeDate), region)
+	r.Header.Set("Authorization", sigV4Algorithm+" Credential="+keyID+"/"+scope+
+		", SignedHeaders="+strings.Join(headers, ";")+", Signature="+hmacHex(key, stringToSign(amzDate, scope, canonical)))
+}
+
+// canonicalRequest builds the canonical form of a request.
+func canonicalRequest(r *http.Request, query url.Values, headers []string, payload string) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte('\n')
+	b.WriteString(uriEncode(r.URL.Path, false))
+	b.WriteByte('\n')
+	b.WriteString(canonicalQuery(query))
+	b.WriteByte('\n')
+	for _, name := range headers {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(headerValue(r, name))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	b.WriteString(strings.Join(headers, ";"))
+	b.WriteByte('\n')
+	b.WriteString(payload)
+	return b.String()
+}
+
+// canonicalQuery encodes query parameters sorted by name and value.
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// headerValue returns the canonical value of a signed header: its values
+// trimmed, with inner runs of spaces collapsed, joined by commas.
+func headerValue(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		values = []string{r.Host}
+	case "content-length":
+		values = r.Header.Values("Content-Length")
+		if len(values) == 0 && r.ContentLength >= 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	default:
+		values = r.Header.Values(name)
+	}
+	for i, value := range values {
+		values[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func stringToSign(amzDate, scope, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	return sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+}
+
+// signingKey derives the key of a day and region from a secret.
+func signingKey(secret []byte, date, region string) []byte {
+	key := hmacSum(append([]byte("AWS4"), secret...), date)
+	key = hmacSum(key, region)
+	key = hmacSum(key, "s3")
+	return hmacSum(key, "aws4_request")
+}
+
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hmacHex(key []byte, data string) string {
+	return hex.EncodeToString(hmacSum(key, data))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// chunkReader decodes an aws-chunked payload:
+//
+//	<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n ... 0[;...]\r\n[trailers]\r\n
+//
+// For signed payloads each chunk signature is verified before its data is
+// returned, each chaining from the previous one. Trailers, such as
+// checksums, are skipped.
+type chunkReader struct {
+	r        *bufio.Reader
+	sig      *signature // Nil for unsigned payloads
+	previous string
+	chunk    []byte // Unread data of the current chunk
+	done     bool
+}
+
+func newChunkReader(r io.Reader, sig *signature) *chunkReader {
+	cr := &chunkReader{r: bufio.NewReader(r)}
+	if sig.payload != streamingUnsignedTrail {
+		cr.sig = sig
+		cr.previous = sig.seed
+	}
+	return cr
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.chunk) == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, cr.chunk)
+	cr.chunk = cr.chunk[n:]
+	return n, nil
+}
+
+// next reads and verifies the next chunk.
+func (cr *chunkReader) next() error {
+	line, err := cr.line()
+	if err != nil {
+		return err
+	}
+	sizeField, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 || size > 16<<20 {
+		return errMalformedChunk
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		return errMalformedChunk
+	}
+	if size > 0 {
+		if crlf, err := cr.line(); err != nil || crlf != "" {
+			return errMalformedChunk
+		}
+	}
+
+	if cr.sig != nil {
+		provided, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok {
+			return errMalformedChunk
+		}
+		hash := sha256.Sum256(data)
+		expected := hmacHex(cr.sig.key, strings.Join([]string{
+			sigV4Algorithm + "-PAYLOAD", cr.sig.amzDate, cr.sig.scope, cr.previous, emptySHA256, hex.EncodeToString(hash[:]),
+		}, "\n"))
+		if !hmac.Equal([]byte(provided), []byte(expected)) {
+			return newError(http.StatusForbidden, "SignatureDoesNotMatch", "chunk signature does not match")
+		}
+		cr.previous = provided
+	}
+
+	if size == 0 {
+		// Skip trailers up to the blank line ending the payload
+		for {
+			line, err := cr.line()
+			if err == io.EOF || (err == nil && line == "") {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		cr.done = true
+	}
+	cr.chunk = data
+	return nil
+}
+
+// line reads a CRLF terminated line without the terminator.
+func (cr *chunkReader) line() (string, error) {
+	line, err := cr.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line == "" {
+			return "", io.EOF
+		}
+		return "", errMalformedChunk
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+var errMalformedChunk = newError(http.StatusBadRequest, "IncompleteBody", "malformed aws-chunked payload")
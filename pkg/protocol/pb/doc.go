@@ -0,0 +1,4 @@
+// Package pb provides the protobuf messages and gRPC services of the
+// region and coordinator APIs, generated from pkg/protocol/proto by
+// "make proto".
+package pb
@@ -9,11 +9,23 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/metrics"
 	"asisaid.cn/JzSE/internal/coordinator/conflict"
 	"asisaid.cn/JzSE/internal/coordinator/metadata"
 	"asisaid.cn/JzSE/internal/coordinator/registry"
 	coordsync "asisaid.cn/JzSE/internal/coordinator/sync"
+	regionmeta "asisaid.cn/JzSE/internal/region/metadata"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+	grpcapi "asisaid.cn/JzSE/pkg/api/grpc"
+	"asisaid.cn/JzSE/pkg/protocol/pb"
 )
 
 // CoordinatorTestEnv provides a test environment for coordinator tests.
@@ -74,7 +86,7 @@ func registerTestRoutes(r *gin.Engine, metaManager metadata.Manager, reg *regist
 			regionID := c.Param("id")
 			info, err := reg.GetRegion(c.Request.Context(), regionID)
 			if err != nil {
-				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				apierror.Abort(c, err)
 				return
 			}
 			c.JSON(http.StatusOK, info)
@@ -233,3 +245,234 @@ func TestSyncEngine_HandleChange(t *testing.T) {
 		t.Errorf("pending for source region = %v, want 0", len(pendingA))
 	}
 }
+
+func TestCoordinatorAPI_GRPC(t *testing.T) {
+	env := SetupCoordinatorTestEnv(t)
+	defer env.Cleanup()
+
+	secret := []byte("region-secret")
+	// Each region signs with a key of its own ID
+	authn := auth.NewHMACAuthenticator(map[string]auth.HMACKey{
+		"region-a": {Secret: secret},
+		"region-b": {Secret: secret},
+	}, time.Minute)
+	serverTLS, clientTLS := testTLS(t)
+	server := grpcapi.NewServer(authn, auth.GRPCOptions{Protected: grpcapi.RegionMethods, TLS: serverTLS})
+	// Eager sync queues each change for the other regions to pull
+	engine := coordsync.NewEngine(coordsync.EngineConfig{DefaultStrategy: "eager", BatchSize: 100}, env.MetaManager)
+	grpcapi.NewCoordinatorServer(env.MetaManager, env.Registry, engine).Register(server)
+	addr := serveGRPC(t, server)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(clientTLS))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	newClient := func(regionID string, creds *auth.HMACCredentials) *grpcapi.CoordinatorClient {
+		cfg := grpcapi.ClientConfig{
+			Addr:    addr,
+			Timeout: 5 * time.Second,
+			TLS:     clientTLS,
+			Region:  registry.RegionInfo{ID: regionID, Name: regionID, Endpoint: regionID + ":9090"},
+		}
+		if creds != nil {
+			cfg.Credentials = creds
+		}
+		client, err := grpcapi.NewCoordinatorClient(cfg)
+		if err != nil {
+			t.Fatalf("NewCoordinatorClient failed: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	creds := &auth.HMACCredentials{KeyID: "region-a", Secret: secret}
+	regionA := newClient("region-a", creds)
+	regionB := newClient("region-b", &auth.HMACCredentials{KeyID: "region-b", Secret: secret})
+
+	// Region methods require credentials, other methods do not
+	if err := newClient("region-x", nil).Register(ctx); !errors.IsUnauthorized(err) {
+		t.Errorf("anonymous Register = %v, want unauthorized", err)
+	}
+	if err := newClient("region-x", &auth.HMACCredentials{KeyID: "region-a", Secret: []byte("wrong")}).Register(ctx); !errors.IsUnauthorized(err) {
+		t.Errorf("Register with a wrong secret = %v, want unauthorized", err)
+	}
+	// Signed calls are never sent in plaintext, where they could be
+	// replayed with other messages
+	if _, err := grpcapi.NewCoordinatorClient(grpcapi.ClientConfig{Addr: addr, Credentials: creds}); !errors.IsInvalidInput(err) {
+		t.Errorf("NewCoordinatorClient signing over plaintext = %v, want invalid input", err)
+	}
+	coordinator := pb.NewCoordinatorServiceClient(conn)
+	if _, err := coordinator.ListRegions(ctx, &pb.ListRegionsRequest{}); err != nil {
+		t.Errorf("anonymous ListRegions failed: %v", err)
+	}
+
+	// Registration is idempotent, and heartbeats need it
+	if err := regionA.Heartbeat(ctx, 0); !errors.IsNotFound(err) {
+		t.Errorf("Heartbeat before Register = %v, want not found", err)
+	}
+	for _, client := range []*grpcapi.CoordinatorClient{regionA, regionB, regionA} {
+		if err := client.Register(ctx); err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+	}
+	if err := regionA.Heartbeat(ctx, 3); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	info, err := env.Registry.GetRegion(ctx, "region-a")
+	if err != nil || info.Status.SyncLag != 3 {
+		t.Errorf("region-a = %v, %v, want a sync lag of 3", info, err)
+	}
+
+	// A region cannot act for another
+	impostor := newClient("region-b", creds)
+	if err := impostor.Register(ctx); !errors.IsForbidden(err) {
+		t.Errorf("Register as another region = %v, want forbidden", err)
+	}
+	if err := impostor.Heartbeat(ctx, 0); !errors.IsForbidden(err) {
+		t.Errorf("Heartbeat as another region = %v, want forbidden", err)
+	}
+	if _, err := impostor.PullChanges(ctx); !errors.IsForbidden(err) {
+		t.Errorf("PullChanges as another region = %v, want forbidden", err)
+	}
+	if _, err := impostor.PushChanges(ctx, nil); !errors.IsForbidden(err) {
+		t.Errorf("PushChanges as another region = %v, want forbidden", err)
+	}
+	forged := &regionsync.ChangeEvent{ID: "event-0", Type: regionsync.ChangeTypeDelete, FileID: "file-0", RegionID: "region-b", Timestamp: time.Now()}
+	if results, err := regionA.PushChanges(ctx, []*regionsync.ChangeEvent{forged}); err != nil || results[0] == nil {
+		t.Errorf("PushChanges of another region's change = %v, %v, want it refused", results, err)
+	}
+	regions, err := coordinator.ListRegions(ctx, &pb.ListRegionsRequest{})
+	if err != nil || len(regions.Regions) != 2 {
+		t.Errorf("ListRegions = %v, %v, want 2 regions", regions, err)
+	}
+
+	// Changes are acknowledged one by one; a replayed create is accepted
+	meta := &regionmeta.FileMetadata{
+		ID:           "file-1",
+		Name:         "a.txt",
+		Path:         "/a.txt",
+		Size:         5,
+		Version:      1,
+		VectorClock:  map[string]uint64{"region-a": 1},
+		OriginRegion: "region-a",
+	}
+	create := &regionsync.ChangeEvent{ID: "event-1", Type: regionsync.ChangeTypeCreate, FileID: "file-1", Metadata: meta, VectorClock: meta.VectorClock, Timestamp: time.Now()}
+	results, err := regionA.PushChanges(ctx, []*regionsync.ChangeEvent{create, create})
+	if err != nil {
+		t.Fatalf("PushChanges failed: %v", err)
+	}
+	for i, result := range results {
+		if result != nil {
+			t.Errorf("ack %d = %v, want accepted", i, result)
+		}
+	}
+
+	global, err := coordinator.GetMetadata(ctx, &pb.GetGlobalMetadataRequest{FileId: "file-1"})
+	if err != nil {
+		t.Fatalf("GetMetadata failed: %v", err)
+	}
+	if global.File.GetPath() != "/a.txt" || global.Primary != "region-a" || len(global.Locations) != 1 {
+		t.Errorf("global metadata = %v", global)
+	}
+	if _, err := coordinator.GetMetadata(ctx, &pb.GetGlobalMetadataRequest{FileId: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetMetadata(missing) = %v, want %v", status.Code(err), codes.NotFound)
+	}
+
+	// The other region pulls the change
+	pulled, err := regionB.PullChanges(ctx)
+	if err != nil {
+		t.Fatalf("PullChanges failed: %v", err)
+	}
+	if len(pulled) == 0 || pulled[0].FileID != "file-1" || pulled[0].Type != regionsync.ChangeTypeCreate || pulled[0].Metadata.Path != "/a.txt" {
+		t.Errorf("pulled = %v, want the creation of file-1", pulled)
+	}
+
+	// A concurrent update is a conflict
+	concurrent := *meta
+	concurrent.Version = 2
+	concurrent.VectorClock = map[string]uint64{"region-b": 1}
+	update := &regionsync.ChangeEvent{ID: "event-2", Type: regionsync.ChangeTypeUpdate, FileID: "file-1", Metadata: &concurrent, VectorClock: concurrent.VectorClock, Timestamp: time.Now()}
+	results, err = regionB.PushChanges(ctx, []*regionsync.ChangeEvent{update})
+	if err != nil {
+		t.Fatalf("PushChanges failed: %v", err)
+	}
+	if !errors.IsConflict(results[0]) {
+		t.Errorf("concurrent update = %v, want conflict", results[0])
+	}
+}
+
+func TestCoordinatorAPI_Metrics(t *testing.T) {
+	env := SetupCoordinatorTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+	metaManager, err := metadata.NewInstrumentedManager(env.MetaManager, reg)
+	if err != nil {
+		t.Fatalf("NewInstrumentedManager failed: %v", err)
+	}
+	engine := coordsync.NewEngine(coordsync.EngineConfig{DefaultStrategy: "eager", BatchSize: 100}, metaManager)
+	httpMetrics, err := metrics.NewHTTP(reg)
+	if err != nil {
+		t.Fatalf("NewHTTP failed: %v", err)
+	}
+	for _, register := range []func(prometheus.Registerer) error{env.Registry.RegisterMetrics, engine.RegisterMetrics, env.Resolver.RegisterMetrics} {
+		if err := register(reg); err != nil {
+			t.Fatalf("RegisterMetrics failed: %v", err)
+		}
+	}
+
+	router := gin.New()
+	router.Use(httpMetrics.Middleware())
+	router.GET("/metrics", metrics.Handler(reg))
+	registerTestRoutes(router, metaManager, env.Registry, engine)
+
+	for _, id := range []string{"region-a", "region-b", "region-c"} {
+		if err := env.Registry.Register(ctx, &registry.RegionInfo{ID: id, Name: id}); err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+		engine.RegisterRegion(id)
+	}
+	if err := env.Registry.Heartbeat(ctx, "region-b", &registry.RegionStatus{State: "degraded", SyncLag: 4}); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/regions/region-a", nil))
+
+	// A change is queued for the other regions; a concurrent update of it
+	// is refused as a conflict
+	file := &metadata.GlobalFileMetadata{FileMetadata: regionmeta.FileMetadata{ID: "file-1", Path: "/a.txt", VectorClock: map[string]uint64{"region-a": 1}}}
+	if err := engine.HandleChange(ctx, &coordsync.ChangeEvent{ID: "event-1", Type: "CREATE", FileID: "file-1", RegionID: "region-a", Metadata: file}); err != nil {
+		t.Fatalf("HandleChange failed: %v", err)
+	}
+	concurrent := &metadata.GlobalFileMetadata{FileMetadata: regionmeta.FileMetadata{ID: "file-1", Path: "/a.txt", VectorClock: map[string]uint64{"region-b": 1}}}
+	if err := engine.HandleChange(ctx, &coordsync.ChangeEvent{ID: "event-2", Type: "UPDATE", FileID: "file-1", RegionID: "region-b", Metadata: concurrent}); !errors.IsConflict(err) {
+		t.Fatalf("concurrent HandleChange = %v, want conflict", err)
+	}
+	if c := env.Resolver.Detect(file, concurrent); c == nil {
+		t.Fatal("Detect found no conflict")
+	} else if _, err := env.Resolver.Resolve(ctx, c, conflict.StrategyLWW); err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	series := scrapeMetrics(t, router)
+	want := map[string]float64{
+		`jzse_http_requests_total{method="GET",route="/api/v1/regions/:id",status="200"}`: 1,
+		`jzse_coordinator_regions{state="healthy"}`:                                       2,
+		`jzse_coordinator_regions{state="degraded"}`:                                      1,
+		`jzse_coordinator_regions{state="offline"}`:                                       0,
+		`jzse_coordinator_region_sync_lag{region="region-b"}`:                             4,
+		`jzse_coordinator_pending_events{region="region-a"}`:                              0,
+		`jzse_coordinator_pending_events{region="region-b"}`:                              1,
+		`jzse_coordinator_pending_events{region="region-c"}`:                              1,
+		`jzse_coordinator_conflicts_detected_total`:                                       1,
+		`jzse_coordinator_conflicts_resolved_total{strategy="last_writer_wins"}`:          1,
+		`jzse_global_metadata_operation_duration_seconds_count{operation="register"}`:     1,
+		`jzse_global_metadata_operation_errors_total{code="conflict",operation="update"}`: 1,
+	}
+	for name, value := range want {
+		if got, ok := series[name]; !ok || got != value {
+			t.Errorf("%v = %v (present %v), want %v", name, got, ok, value)
+		}
+	}
+}
@@ -2,25 +2,79 @@
 package integration
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"math/big"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"asisaid.cn/JzSE/internal/common/apierror"
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/common/errors"
+	"asisaid.cn/JzSE/internal/common/logger"
+	"asisaid.cn/JzSE/internal/common/metrics"
+	"asisaid.cn/JzSE/internal/common/tracing"
+	"asisaid.cn/JzSE/internal/coordinator/registry"
+	coordsync "asisaid.cn/JzSE/internal/coordinator/sync"
+	"asisaid.cn/JzSE/internal/region/events"
 	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/internal/region/precommit"
+	"asisaid.cn/JzSE/internal/region/presign"
 	"asisaid.cn/JzSE/internal/region/service"
 	"asisaid.cn/JzSE/internal/region/storage"
+	regionsync "asisaid.cn/JzSE/internal/region/sync"
+	"asisaid.cn/JzSE/internal/region/webhook"
+	"asisaid.cn/JzSE/internal/region/webhook/webhooktest"
+	grpcapi "asisaid.cn/JzSE/pkg/api/grpc"
 	httpapi "asisaid.cn/JzSE/pkg/api/http"
+	"asisaid.cn/JzSE/pkg/api/openapi"
+	"asisaid.cn/JzSE/pkg/protocol/pb"
 )
 
 // TestEnv provides a test environment for integration tests.
 type TestEnv struct {
 	Router   *gin.Engine
+	Handler  *httpapi.Handler
 	TmpDir   string
 	Storage  storage.Backend
 	Metadata metadata.Store
@@ -44,7 +98,7 @@ func SetupTestEnv(t *testing.T) *TestEnv {
 		t.Fatalf("failed to create storage: %v", err)
 	}
 
-	metaStore, err := metadata.NewBadgerStore(tmpDir + "/metadata")
+	metaStore, err := metadata.NewBadgerStore(metadata.StoreConfig{Path: tmpDir + "/metadata"})
 	if err != nil {
 		storageBackend.Close()
 		os.RemoveAll(tmpDir)
@@ -59,6 +113,7 @@ func SetupTestEnv(t *testing.T) *TestEnv {
 
 	return &TestEnv{
 		Router:   router,
+		Handler:  handler,
 		TmpDir:   tmpDir,
 		Storage:  storageBackend,
 		Metadata: metaStore,
@@ -205,6 +260,44 @@ func TestRegionAPI_MetadataEndpoint(t *testing.T) {
 	}
 }
 
+func TestRegionAPI_MetadataAdmin(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	env.Handler.SetMetadataMaintainer(env.Metadata.(*metadata.BadgerStore))
+	env.Handler.SetAuthenticator(auth.NewAPIKeyAuthenticator(map[string]auth.APIKey{
+		"admin-key": {UserID: "ops", Groups: []string{"admins"}},
+		"user-key":  {UserID: "alice"},
+	}), false)
+	env.Handler.SetAdminGroup("admins")
+	env.Router = gin.New()
+	env.Handler.RegisterRoutes(env.Router)
+
+	for _, tt := range []struct {
+		key  string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"user-key", http.StatusForbidden},
+		{"admin-key", http.StatusOK},
+	} {
+		for _, route := range []struct{ method, path string }{
+			{"GET", "/api/v1/admin/metadata/stats"},
+			{"POST", "/api/v1/admin/metadata/gc"},
+		} {
+			req := httptest.NewRequest(route.method, route.path, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			env.Router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("%v %v with key %q = %v, want %v: %s", route.method, route.path, tt.key, w.Code, tt.want, w.Body.String())
+			}
+		}
+	}
+}
+
 func TestRegionAPI_NotFound(t *testing.T) {
 	env := SetupTestEnv(t)
 	defer env.Cleanup()
@@ -217,3 +310,2858 @@ func TestRegionAPI_NotFound(t *testing.T) {
 		t.Errorf("status = %v, want %v", w.Code, http.StatusNotFound)
 	}
 }
+
+func TestRegionAPI_ErrorEnvelope(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	serve := func(method, path, body string) (*httptest.ResponseRecorder, apierror.Body) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(apierror.RequestIDHeader, "req-42")
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+
+		var envelope apierror.Envelope
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("%s %s: invalid envelope %q", method, path, w.Body.String())
+		}
+		return w, envelope.Error
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               apierror.Code
+	}{
+		{"GET", "/api/v1/files/missing", "", http.StatusNotFound, apierror.CodeNotFound},
+		{"DELETE", "/api/v1/files/missing", "", http.StatusNotFound, apierror.CodeNotFound},
+		{"PUT", "/api/v1/fs/a.txt?on_conflict=maybe", "x", http.StatusBadRequest, apierror.CodeInvalidInput},
+		{"POST", "/api/v1/presign", `{"method":"GET"}`, http.StatusNotImplemented, apierror.CodeNotImplemented},
+		{"GET", "/api/v1/nowhere", "", http.StatusNotFound, apierror.CodeNotFound},
+	}
+	for _, tt := range tests {
+		w, body := serve(tt.method, tt.path, tt.body)
+		if w.Code != tt.status || body.Code != tt.code {
+			t.Errorf("%s %s = %v %s, want %v %s", tt.method, tt.path, w.Code, body.Code, tt.status, tt.code)
+		}
+		if body.RequestID != "req-42" || w.Header().Get(apierror.RequestIDHeader) != "req-42" {
+			t.Errorf("%s %s request ID = %q", tt.method, tt.path, body.RequestID)
+		}
+		if body.Message == "" || strings.Contains(body.Message, "FileService") || body.Retryable {
+			t.Errorf("%s %s body = %+v", tt.method, tt.path, body)
+		}
+	}
+}
+
+func TestRegionAPI_OpenAPI(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	undocumented, unregistered := openapi.Diff(openapi.Region(), env.Router.Routes())
+	if len(undocumented) > 0 {
+		t.Errorf("routes missing from the OpenAPI specification: %v", undocumented)
+	}
+	if len(unregistered) > 0 {
+		t.Errorf("documented operations without a route: %v", unregistered)
+	}
+
+	req := httptest.NewRequest("GET", openapi.SpecPath, nil)
+	w := httptest.NewRecorder()
+	env.Router.ServeHTTP(w, req)
+	var doc struct {
+		OpenAPI string         `json:"openapi"`
+		Paths   map[string]any `json:"paths"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &doc) != nil {
+		t.Fatalf("GET %s = %d %q", openapi.SpecPath, w.Code, w.Body.String())
+	}
+	if doc.OpenAPI == "" || doc.Paths["/api/v1/files/{id}"] == nil {
+		t.Errorf("specification = %+v", doc)
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"PUT", "/api/v1/acl/docs", `{"entries":[{"principal":"user:bob","permissions":["fly"]}]}`, http.StatusBadRequest},
+		{"PUT", "/api/v1/acl/docs", `{"entries":"bob"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/presign", `{"method":"POST"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/batch/metadata", `{"items":[]}`, http.StatusBadRequest},
+		{"GET", "/api/v1/directories/docs?archive=rar", "", http.StatusBadRequest},
+		{"GET", "/api/v1/directories/docs?archive=zip&max_size=0", "", http.StatusBadRequest},
+		{"PUT", "/api/v1/acl/", `{"entries":[{"principal":"user:bob","permissions":["read"]}]}`, http.StatusForbidden},
+		{"POST", "/api/v1/batch/metadata", `{"items":[{"id":"missing"}]}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s %s = %d, want %d: %s", tt.method, tt.path, tt.body, w.Code, tt.status, w.Body.String())
+			continue
+		}
+		if tt.status == http.StatusBadRequest {
+			var envelope apierror.Envelope
+			if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error.Code != apierror.CodeInvalidInput {
+				t.Errorf("%s %s error = %q", tt.method, tt.path, w.Body.String())
+			}
+		}
+	}
+}
+
+// recordingNotifier records queued change events.
+type recordingNotifier struct {
+	changes []regionsync.ChangeType
+}
+
+func (n *recordingNotifier) QueueChange(ctx context.Context, changeType regionsync.ChangeType, meta *metadata.FileMetadata) {
+	n.changes = append(n.changes, changeType)
+}
+
+func (n *recordingNotifier) QueueACLChange(ctx context.Context, acl *metadata.ACL) {
+	n.changes = append(n.changes, regionsync.ChangeTypeACL)
+}
+
+func TestRegionAPI_UploadConflictPolicies(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	notifier := &recordingNotifier{}
+	env.Service.SetChangeNotifier(notifier)
+
+	ctx := context.Background()
+	upload := func(content string, policy service.ConflictPolicy) (*service.UploadResponse, error) {
+		return env.Service.Upload(ctx, &service.UploadRequest{
+			Path:       "/docs",
+			Name:       "report.docx",
+			Size:       int64(len(content)),
+			Content:    bytes.NewReader([]byte(content)),
+			OwnerID:    "test-user",
+			OnConflict: policy,
+		})
+	}
+
+	first, err := upload("v1", "")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	t.Run("overwrite", func(t *testing.T) {
+		resp, err := upload("v2", service.ConflictOverwrite)
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if !resp.Replaced || resp.FileID != first.FileID {
+			t.Errorf("overwrite should replace %v, got %+v", first.FileID, resp)
+		}
+		if resp.Version != first.Version+1 {
+			t.Errorf("Version = %v, want %v", resp.Version, first.Version+1)
+		}
+
+		meta, _ := env.Metadata.Get(ctx, first.FileID)
+		if meta.VectorClock["test-region"] != 2 {
+			t.Errorf("VectorClock[test-region] = %v, want 2", meta.VectorClock["test-region"])
+		}
+
+		download, err := env.Service.Download(ctx, first.FileID)
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		defer download.Content.Close()
+		content, _ := io.ReadAll(download.Content)
+		if string(content) != "v2" {
+			t.Errorf("content = %q, want v2", content)
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		_, err := upload("v3", service.ConflictFail)
+		if !errors.IsAlreadyExists(err) {
+			t.Errorf("error = %v, want already exists", err)
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		resp, err := upload("v3", service.ConflictRename)
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		if resp.Path != "/docs/report (1).docx" {
+			t.Errorf("Path = %v, want /docs/report (1).docx", resp.Path)
+		}
+	})
+
+	t.Run("change events", func(t *testing.T) {
+		want := []regionsync.ChangeType{
+			regionsync.ChangeTypeCreate,
+			regionsync.ChangeTypeUpdate,
+			regionsync.ChangeTypeCreate,
+		}
+		if len(notifier.changes) != len(want) {
+			t.Fatalf("changes = %v, want %v", notifier.changes, want)
+		}
+		for i := range want {
+			if notifier.changes[i] != want[i] {
+				t.Errorf("changes[%d] = %v, want %v", i, notifier.changes[i], want[i])
+			}
+		}
+	})
+}
+
+func TestRegionAPI_UpdateFile(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+	uploadResp, err := env.Service.Upload(ctx, &service.UploadRequest{
+		Path:    "/",
+		Name:    "notes.txt",
+		Size:    3,
+		Content: bytes.NewReader([]byte("old")),
+		OwnerID: "test-user",
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	body, contentType := multipartFile(t, "notes.txt", []byte("new content"))
+	req := httptest.NewRequest("PUT", "/api/v1/files/"+uploadResp.FileID, body)
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	env.Router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	meta, _ := env.Metadata.Get(ctx, uploadResp.FileID)
+	if meta.Size != int64(len("new content")) {
+		t.Errorf("Size = %v, want %v", meta.Size, len("new content"))
+	}
+	if meta.Version != uploadResp.Version+1 {
+		t.Errorf("Version = %v, want %v", meta.Version, uploadResp.Version+1)
+	}
+
+	// Updating a missing file
+	body, contentType = multipartFile(t, "notes.txt", []byte("new content"))
+	req = httptest.NewRequest("PUT", "/api/v1/files/non-existent-id", body)
+	req.Header.Set("Content-Type", contentType)
+	w = httptest.NewRecorder()
+	env.Router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	// A failed metadata commit leaves the previous content in place
+	failing := service.NewFileService("test-region", env.Storage, failingStore{Store: env.Metadata})
+	if _, err := failing.Update(ctx, &service.UpdateRequest{
+		FileID:  uploadResp.FileID,
+		Size:    -1,
+		Content: strings.NewReader("lost content"),
+		UserID:  "test-user",
+	}); err == nil {
+		t.Fatal("Update should fail when the metadata commit fails")
+	}
+	download, err := env.Service.Download(ctx, uploadResp.FileID)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	content, _ := io.ReadAll(download.Content)
+	download.Content.Close()
+	if string(content) != "new content" {
+		t.Errorf("content after failed update = %q, want %q", content, "new content")
+	}
+}
+
+// multipartFile builds a multipart body holding a single "file" part.
+func multipartFile(t *testing.T, name string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	return body, writer.FormDataContentType()
+}
+
+func TestRegionAPI_PathAccess(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("PUT creates", func(t *testing.T) {
+		w := do("PUT", "/api/v1/fs/projects/readme.md", []byte("# hello"))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+	})
+
+	t.Run("PUT overwrites", func(t *testing.T) {
+		w := do("PUT", "/api/v1/fs/projects/readme.md", []byte("# hello again"))
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+		}
+
+		w = do("PUT", "/api/v1/fs/projects/readme.md?on_conflict=fail", []byte("x"))
+		if w.Code != http.StatusConflict {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("GET file", func(t *testing.T) {
+		w := do("GET", "/api/v1/fs/projects/readme.md", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+		}
+		if w.Body.String() != "# hello again" {
+			t.Errorf("body = %q, want %q", w.Body.String(), "# hello again")
+		}
+	})
+
+	t.Run("HEAD file", func(t *testing.T) {
+		w := do("HEAD", "/api/v1/fs/projects/readme.md", nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+		}
+		if w.Header().Get("X-File-ID") == "" {
+			t.Error("X-File-ID header should be set")
+		}
+		if w.Body.Len() != 0 {
+			t.Error("HEAD response should have no body")
+		}
+	})
+
+	t.Run("GET directory", func(t *testing.T) {
+		w := do("GET", "/api/v1/fs/projects", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+		}
+		if !bytes.Contains(w.Body.Bytes(), []byte("readme.md")) {
+			t.Errorf("listing should contain readme.md: %s", w.Body.String())
+		}
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		// Writing over a directory
+		if w := do("PUT", "/api/v1/fs/projects", []byte("x")); w.Code != http.StatusConflict {
+			t.Errorf("PUT over directory status = %v, want %v", w.Code, http.StatusConflict)
+		}
+		// Writing below a file
+		if w := do("PUT", "/api/v1/fs/projects/readme.md/child", []byte("x")); w.Code != http.StatusConflict {
+			t.Errorf("PUT below file status = %v, want %v", w.Code, http.StatusConflict)
+		}
+		// Deleting a non-empty directory
+		if w := do("DELETE", "/api/v1/fs/projects", nil); w.Code != http.StatusConflict {
+			t.Errorf("DELETE non-empty directory status = %v, want %v", w.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("DELETE", func(t *testing.T) {
+		if w := do("DELETE", "/api/v1/fs/projects/readme.md", nil); w.Code != http.StatusNoContent {
+			t.Errorf("DELETE file status = %v, want %v", w.Code, http.StatusNoContent)
+		}
+		if w := do("GET", "/api/v1/fs/projects/readme.md", nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET deleted file status = %v, want %v", w.Code, http.StatusNotFound)
+		}
+		if w := do("DELETE", "/api/v1/fs/projects", nil); w.Code != http.StatusNoContent {
+			t.Errorf("DELETE empty directory status = %v, want %v", w.Code, http.StatusNoContent)
+		}
+		if w := do("GET", "/api/v1/fs/missing", nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET missing path status = %v, want %v", w.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestRegionAPI_ConditionalRequests(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+	uploadResp, err := env.Service.Upload(ctx, &service.UploadRequest{
+		Path:    "/",
+		Name:    "doc.txt",
+		Size:    2,
+		Content: bytes.NewReader([]byte("v1")),
+		OwnerID: "test-user",
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	fileURL := "/api/v1/files/" + uploadResp.FileID
+	do := func(method, url string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", fileURL, nil)
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag != uploadResp.ETag {
+		t.Fatalf("ETag = %q, want %q", etag, uploadResp.ETag)
+	}
+	lastModified := w.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Fatal("Last-Modified header should be set")
+	}
+
+	t.Run("If-None-Match", func(t *testing.T) {
+		if w := do("GET", fileURL, map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusNotModified)
+		}
+		if w := do("GET", fileURL, map[string]string{"If-None-Match": "W/" + etag}); w.Code != http.StatusNotModified {
+			t.Errorf("weak tag status = %v, want %v", w.Code, http.StatusNotModified)
+		}
+		if w := do("GET", fileURL, map[string]string{"If-None-Match": `"other"`}); w.Code != http.StatusOK {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("If-Modified-Since", func(t *testing.T) {
+		if w := do("GET", fileURL, map[string]string{"If-Modified-Since": lastModified}); w.Code != http.StatusNotModified {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusNotModified)
+		}
+		past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+		if w := do("GET", fileURL, map[string]string{"If-Modified-Since": past}); w.Code != http.StatusOK {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		w := do("HEAD", fileURL, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("ETag = %q, want %q", w.Header().Get("ETag"), etag)
+		}
+		if w.Header().Get("Content-Length") != "2" {
+			t.Errorf("Content-Length = %q, want 2", w.Header().Get("Content-Length"))
+		}
+		if w.Body.Len() != 0 {
+			t.Error("HEAD response should have no body")
+		}
+	})
+
+	t.Run("If-Match on update", func(t *testing.T) {
+		body, contentType := multipartFile(t, "doc.txt", []byte("v2"))
+		req := httptest.NewRequest("PUT", fileURL, body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("If-Match", `"stale"`)
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		if w.Code != http.StatusPreconditionFailed {
+			t.Fatalf("stale If-Match status = %v, want %v", w.Code, http.StatusPreconditionFailed)
+		}
+
+		body, contentType = multipartFile(t, "doc.txt", []byte("v2"))
+		req = httptest.NewRequest("PUT", fileURL, body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("If-Match", etag)
+		w = httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		if newTag := w.Header().Get("ETag"); newTag == "" || newTag == etag {
+			t.Errorf("ETag after update = %q, want a new tag", newTag)
+		}
+	})
+
+	t.Run("If-Match on delete", func(t *testing.T) {
+		// etag now refers to the previous version
+		if w := do("DELETE", fileURL, map[string]string{"If-Match": etag}); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("stale If-Match status = %v, want %v", w.Code, http.StatusPreconditionFailed)
+		}
+
+		current := do("HEAD", fileURL, nil).Header().Get("ETag")
+		if w := do("DELETE", fileURL, map[string]string{"If-Match": current}); w.Code != http.StatusNoContent {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusNoContent)
+		}
+	})
+
+	t.Run("If-None-Match on path PUT", func(t *testing.T) {
+		put := func() int {
+			req := httptest.NewRequest("PUT", "/api/v1/fs/new.txt", bytes.NewReader([]byte("x")))
+			req.Header.Set("If-None-Match", "*")
+			w := httptest.NewRecorder()
+			env.Router.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		if code := put(); code != http.StatusCreated {
+			t.Errorf("first PUT status = %v, want %v", code, http.StatusCreated)
+		}
+		if code := put(); code != http.StatusPreconditionFailed {
+			t.Errorf("second PUT status = %v, want %v", code, http.StatusPreconditionFailed)
+		}
+	})
+}
+
+func TestRegionAPI_StreamingUploads(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+	readBack := func(t *testing.T, w *httptest.ResponseRecorder) string {
+		t.Helper()
+
+		var resp service.UploadResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		download, err := env.Service.Download(ctx, resp.FileID)
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		defer download.Content.Close()
+
+		content, _ := io.ReadAll(download.Content)
+		if download.Metadata.Size != int64(len(content)) {
+			t.Errorf("Size = %v, want %v", download.Metadata.Size, len(content))
+		}
+		return string(content)
+	}
+
+	t.Run("raw body", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/files?name=raw.txt&path=/raw", bytes.NewReader([]byte("raw content")))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		if got := readBack(t, w); got != "raw content" {
+			t.Errorf("content = %q, want %q", got, "raw content")
+		}
+	})
+
+	t.Run("chunked body", func(t *testing.T) {
+		// A reader of unknown length, as with chunked transfer encoding
+		body := io.MultiReader(bytes.NewReader([]byte("chunk-1 ")), bytes.NewReader([]byte("chunk-2")))
+		req := httptest.NewRequest("POST", "/api/v1/files?name=chunked.txt", body)
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		if got := readBack(t, w); got != "chunk-1 chunk-2" {
+			t.Errorf("content = %q, want %q", got, "chunk-1 chunk-2")
+		}
+	})
+
+	t.Run("multipart with leading fields", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("path", "/multipart")
+		writer.WriteField("size", "9")
+		part, _ := writer.CreateFormFile("file", "form.txt")
+		part.Write([]byte("form body"))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/files", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		if _, err := env.Metadata.GetByPath(ctx, "/multipart/form.txt"); err != nil {
+			t.Errorf("GetByPath failed: %v", err)
+		}
+	})
+
+	t.Run("multipart without file", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("path", "/")
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/files", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("declared size mismatch", func(t *testing.T) {
+		for _, size := range []int64{3, 20} {
+			_, err := env.Service.Upload(ctx, &service.UploadRequest{
+				Path:    "/",
+				Name:    "mismatch.txt",
+				Size:    size,
+				Content: bytes.NewReader([]byte("ten bytes!")),
+				OwnerID: "test-user",
+			})
+			if !errors.IsInvalidInput(err) {
+				t.Errorf("Size %v: error = %v, want invalid input", size, err)
+			}
+		}
+
+		if _, err := env.Metadata.GetByPath(ctx, "/mismatch.txt"); !errors.IsNotFound(err) {
+			t.Errorf("mismatched upload should not be committed, err = %v", err)
+		}
+		filepath.Walk(env.TmpDir+"/storage", func(path string, info os.FileInfo, err error) error {
+			if err == nil && strings.HasPrefix(info.Name(), "staging-") {
+				t.Errorf("staged blob %v was not discarded", path)
+			}
+			return nil
+		})
+	})
+
+	t.Run("max upload size", func(t *testing.T) {
+		env.Handler.SetMaxUploadSize(8)
+		defer env.Handler.SetMaxUploadSize(0)
+
+		req := httptest.NewRequest("PUT", "/api/v1/fs/big.bin", bytes.NewReader(make([]byte, 16)))
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("declared length status = %v, want %v", w.Code, http.StatusRequestEntityTooLarge)
+		}
+
+		req = httptest.NewRequest("PUT", "/api/v1/fs/big.bin", io.MultiReader(bytes.NewReader(make([]byte, 16))))
+		req.ContentLength = -1
+		w = httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("chunked status = %v, want %v: %s", w.Code, http.StatusRequestEntityTooLarge, w.Body.String())
+		}
+
+		req = httptest.NewRequest("PUT", "/api/v1/fs/small.bin", bytes.NewReader(make([]byte, 8)))
+		w = httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusCreated)
+		}
+	})
+}
+
+func TestRegionAPI_ChecksumVerification(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+	content := []byte("checksummed content")
+	md5Sum := md5.Sum(content)
+	sha256Sum := sha256.Sum256(content)
+	md5B64 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	sha256B64 := base64.StdEncoding.EncodeToString(sha256Sum[:])
+	wrongSum := sha256.Sum256([]byte("something else"))
+
+	put := func(path string, headers, trailers http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/fs"+path, bytes.NewReader(content))
+		for k, v := range headers {
+			req.Header[k] = v
+		}
+		if trailers != nil {
+			req.ContentLength = -1
+			req.Trailer = trailers
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		headers  http.Header
+		trailers http.Header
+		want     int
+	}{
+		{"Content-MD5", http.Header{"Content-Md5": {md5B64}}, nil, http.StatusCreated},
+		{"Digest", http.Header{"Digest": {"SHA-256=" + sha256B64 + ", MD5=" + md5B64}}, nil, http.StatusCreated},
+		{"Digest unsupported algorithm", http.Header{"Digest": {"SHA-512=AAAA"}}, nil, http.StatusCreated},
+		{"Repr-Digest", http.Header{"Repr-Digest": {"sha-256=:" + sha256B64 + ":"}}, nil, http.StatusCreated},
+		{"X-Content-SHA256", http.Header{"X-Content-Sha256": {hex.EncodeToString(sha256Sum[:])}}, nil, http.StatusCreated},
+		{"trailer", nil, http.Header{"Content-Md5": {md5B64}}, http.StatusCreated},
+		{"wrong sha-256", http.Header{"X-Content-Sha256": {hex.EncodeToString(wrongSum[:])}}, nil, http.StatusBadRequest},
+		{"wrong Repr-Digest", http.Header{"Repr-Digest": {"sha-256=:" + base64.StdEncoding.EncodeToString(wrongSum[:]) + ":"}}, nil, http.StatusBadRequest},
+		{"wrong trailer", nil, http.Header{"X-Content-Sha256": {hex.EncodeToString(wrongSum[:])}}, http.StatusBadRequest},
+		{"malformed Content-MD5", http.Header{"Content-Md5": {"not base64!"}}, nil, http.StatusBadRequest},
+		{"conflicting digests", http.Header{"Content-Md5": {md5B64}, "Digest": {"MD5=" + sha256B64[:24]}}, nil, http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := fmt.Sprintf("/file-%d.txt", i)
+			w := put(path, tt.headers, tt.trailers)
+			if w.Code != tt.want {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.want, w.Body.String())
+			}
+
+			_, err := env.Metadata.GetByPath(ctx, path)
+			if tt.want != http.StatusCreated && !errors.IsNotFound(err) {
+				t.Errorf("rejected upload should not be committed, err = %v", err)
+			}
+		})
+	}
+
+	t.Run("verified digests are recorded", func(t *testing.T) {
+		meta, err := env.Metadata.GetByPath(ctx, "/file-1.txt")
+		if err != nil {
+			t.Fatalf("GetByPath failed: %v", err)
+		}
+		if meta.Digests["md5"] != hex.EncodeToString(md5Sum[:]) {
+			t.Errorf("Digests[md5] = %v, want %v", meta.Digests["md5"], hex.EncodeToString(md5Sum[:]))
+		}
+		if meta.Digests["sha-256"] != meta.ContentHash {
+			t.Errorf("Digests[sha-256] = %v, want %v", meta.Digests["sha-256"], meta.ContentHash)
+		}
+	})
+
+	t.Run("multipart part checksum", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		partHeader := textproto.MIMEHeader{}
+		partHeader.Set("Content-Disposition", `form-data; name="file"; filename="part.txt"`)
+		partHeader.Set("Content-MD5", base64.StdEncoding.EncodeToString(wrongSum[:16]))
+		part, _ := writer.CreatePart(partHeader)
+		part.Write(content)
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/files", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("staged blobs are discarded", func(t *testing.T) {
+		filepath.Walk(env.TmpDir+"/storage", func(path string, info os.FileInfo, err error) error {
+			if err == nil && strings.HasPrefix(info.Name(), "staging-") {
+				t.Errorf("staged blob %v was not discarded", path)
+			}
+			return nil
+		})
+	})
+}
+
+func TestRegionAPI_DirectoryArchive(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+	files := map[string]string{
+		"/proj/a.txt":       "alpha",
+		"/proj/sub/b.txt":   "bravo",
+		"/proj/gone.txt":    "deleted",
+		"/elsewhere/c.txt":  "charlie",
+		"/proj/sub/d/e.txt": "echo",
+	}
+	ids := make(map[string]string)
+	for path, content := range files {
+		resp, err := env.Service.Upload(ctx, &service.UploadRequest{
+			Path:    filepath.Dir(path),
+			Name:    filepath.Base(path),
+			Size:    int64(len(content)),
+			Content: strings.NewReader(content),
+			OwnerID: "test-user",
+		})
+		if err != nil {
+			t.Fatalf("Upload %v failed: %v", path, err)
+		}
+		ids[path] = resp.FileID
+	}
+	if err := env.Service.Delete(ctx, ids["/proj/gone.txt"]); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// A file replicated from another region but not fetched yet
+	remote := metadata.NewFileMetadata("remote-id", "remote.txt", "/proj/remote.txt")
+	remote.LocalState = metadata.LocalStatePending
+	remote.Size = 100
+	env.Metadata.Save(ctx, remote)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	wantFiles := map[string]string{
+		"a.txt":       "alpha",
+		"sub/b.txt":   "bravo",
+		"sub/d/e.txt": "echo",
+	}
+	checkManifest := func(t *testing.T, data []byte) {
+		t.Helper()
+
+		var manifest service.ArchiveManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			t.Fatalf("invalid manifest: %v", err)
+		}
+		if len(manifest.Skipped) != 1 || manifest.Skipped[0].Path != "remote.txt" {
+			t.Errorf("Skipped = %+v, want [remote.txt]", manifest.Skipped)
+		}
+		if manifest.Files != len(wantFiles) {
+			t.Errorf("Files = %v, want %v", manifest.Files, len(wantFiles))
+		}
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		w := get("/api/v1/directories/proj?archive=zip")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != "application/zip" {
+			t.Errorf("Content-Type = %v, want application/zip", w.Header().Get("Content-Type"))
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatalf("invalid zip: %v", err)
+		}
+
+		got := make(map[string]string)
+		for _, f := range zr.File {
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+
+			switch {
+			case f.Name == service.ArchiveManifestName:
+				checkManifest(t, data)
+			case strings.HasSuffix(f.Name, "/"):
+				if f.Name != "sub/" && f.Name != "sub/d/" {
+					t.Errorf("unexpected directory %v", f.Name)
+				}
+			default:
+				got[f.Name] = string(data)
+			}
+
+			if f.Name == "a.txt" {
+				meta, _ := env.Metadata.Get(ctx, ids["/proj/a.txt"])
+				if f.Modified.Unix() != meta.UpdatedAt.Unix() {
+					t.Errorf("Modified = %v, want %v", f.Modified, meta.UpdatedAt)
+				}
+			}
+		}
+
+		if len(got) != len(wantFiles) {
+			t.Errorf("files = %v, want %v", got, wantFiles)
+		}
+		for name, content := range wantFiles {
+			if got[name] != content {
+				t.Errorf("%v = %q, want %q", name, got[name], content)
+			}
+		}
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		w := get("/api/v1/directories/proj?archive=tar.gz")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("invalid gzip: %v", err)
+		}
+		tr := tar.NewReader(gr)
+
+		got := make(map[string]string)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("invalid tar: %v", err)
+			}
+			data, _ := io.ReadAll(tr)
+
+			switch {
+			case header.Name == service.ArchiveManifestName:
+				checkManifest(t, data)
+			case header.Typeflag == tar.TypeReg:
+				got[header.Name] = string(data)
+			}
+		}
+
+		for name, content := range wantFiles {
+			if got[name] != content {
+				t.Errorf("%v = %q, want %q", name, got[name], content)
+			}
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		if w := get("/api/v1/directories/proj?archive=zip&max_size=8"); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusRequestEntityTooLarge)
+		}
+
+		env.Handler.SetMaxArchiveSize(8)
+		defer env.Handler.SetMaxArchiveSize(0)
+		if w := get("/api/v1/directories/proj?archive=zip&max_size=1000"); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("server limit status = %v, want %v", w.Code, http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if w := get("/api/v1/directories/missing?archive=zip"); w.Code != http.StatusNotFound {
+			t.Errorf("missing directory status = %v, want %v", w.Code, http.StatusNotFound)
+		}
+		if w := get("/api/v1/directories/proj?archive=rar"); w.Code != http.StatusBadRequest {
+			t.Errorf("unknown format status = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestRegionAPI_ExtractArchive(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+
+	buildTarGz := func(t *testing.T) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		addDir := func(name string) {
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755})
+		}
+		addFile := func(name, content string) {
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))})
+			tw.Write([]byte(content))
+		}
+		addDir("./")
+		addDir("proj/")
+		addFile("proj/a.txt", "alpha")
+		addFile("proj/sub/b.txt", "bravo")
+		addDir("empty/")
+		addFile("../evil.txt", "escape")
+		addFile("/etc/passwd", "absolute")
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc/passwd"})
+		addFile("proj/c.txt", "charlie")
+		addFile("proj/c.txt/child", "below a file")
+		tw.Close()
+		gw.Close()
+		return buf.Bytes()
+	}
+
+	post := func(url string, body []byte, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", url, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("tar.gz", func(t *testing.T) {
+		w := post("/api/v1/directories/seed?archive=tar.gz", buildTarGz(t), "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+
+		var resp service.ExtractResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.Created != 3 || resp.Rejected != 3 || resp.Skipped != 1 {
+			t.Errorf("created/rejected/skipped = %v/%v/%v, want 3/3/1: %+v",
+				resp.Created, resp.Rejected, resp.Skipped, resp.Entries)
+		}
+
+		statuses := make(map[string]string)
+		for _, entry := range resp.Entries {
+			statuses[entry.Name] = entry.Status
+		}
+		for name, want := range map[string]string{
+			"proj/a.txt":       service.ExtractCreated,
+			"proj/sub/b.txt":   service.ExtractCreated,
+			"empty/":           service.ExtractDirectory,
+			"../evil.txt":      service.ExtractRejected,
+			"/etc/passwd":      service.ExtractRejected,
+			"link":             service.ExtractSkipped,
+			"proj/c.txt":       service.ExtractRejected,
+			"proj/c.txt/child": service.ExtractCreated,
+		} {
+			if statuses[name] != want {
+				t.Errorf("status of %v = %v, want %v", name, statuses[name], want)
+			}
+		}
+
+		meta, err := env.Metadata.GetByPath(ctx, "/seed/proj/sub/b.txt")
+		if err != nil {
+			t.Fatalf("GetByPath failed: %v", err)
+		}
+		download, _ := env.Service.Download(ctx, meta.ID)
+		content, _ := io.ReadAll(download.Content)
+		download.Content.Close()
+		if string(content) != "bravo" {
+			t.Errorf("content = %q, want bravo", content)
+		}
+
+		if isDir, _ := env.Metadata.IsDir(ctx, "/seed/empty"); !isDir {
+			t.Error("empty directory should be created")
+		}
+		if _, err := env.Metadata.GetByPath(ctx, "/evil.txt"); !errors.IsNotFound(err) {
+			t.Error("zip-slip entry must not escape the target directory")
+		}
+	})
+
+	t.Run("zip with conflict policy", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		f, _ := zw.Create("proj/a.txt")
+		f.Write([]byte("alpha v2"))
+		f, _ = zw.Create("proj/new.txt")
+		f.Write([]byte("new"))
+		zw.Close()
+
+		w := post("/api/v1/directories/seed?on_conflict=fail", buf.Bytes(), "application/zip")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+
+		var resp service.ExtractResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Created != 1 || resp.Rejected != 1 {
+			t.Errorf("created/rejected = %v/%v, want 1/1: %+v", resp.Created, resp.Rejected, resp.Entries)
+		}
+	})
+
+	t.Run("quotas", func(t *testing.T) {
+		env.Handler.SetMaxArchiveEntries(2)
+		defer env.Handler.SetMaxArchiveEntries(0)
+
+		w := post("/api/v1/directories/quota?archive=tar.gz", buildTarGz(t), "")
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusRequestEntityTooLarge)
+		}
+		if isDir, _ := env.Metadata.IsDir(ctx, "/quota"); isDir {
+			t.Error("aborted extraction must not create anything")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if w := post("/api/v1/directories/seed?archive=zip", []byte("not a zip"), ""); w.Code != http.StatusBadRequest {
+			t.Errorf("malformed zip status = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+		if w := post("/api/v1/directories/seed/proj/sub/b.txt?archive=tar.gz", buildTarGz(t), ""); w.Code != http.StatusConflict {
+			t.Errorf("file target status = %v, want %v", w.Code, http.StatusConflict)
+		}
+		filepath.Walk(env.TmpDir+"/storage", func(path string, info os.FileInfo, err error) error {
+			if err == nil && strings.HasPrefix(info.Name(), "staging-") {
+				t.Errorf("staged blob %v was not discarded", path)
+			}
+			return nil
+		})
+	})
+
+	t.Run("failed commit", func(t *testing.T) {
+		spool := filepath.Join(env.TmpDir, "spool")
+		os.Mkdir(spool, 0755)
+		failing := service.NewFileService("test-region", env.Storage, failingStore{Store: env.Metadata})
+		failing.SetTempDir(spool)
+
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		f, _ := zw.Create("proj/a.txt")
+		f.Write([]byte("alpha v3"))
+		f, _ = zw.Create("proj/lost.txt")
+		f.Write([]byte("lost"))
+		zw.Close()
+
+		_, err := failing.Extract(ctx, &service.ExtractRequest{
+			Path:    "/seed",
+			Format:  service.ArchiveZip,
+			Content: &buf,
+		})
+		if err == nil {
+			t.Fatal("Extract should fail when the metadata commit fails")
+		}
+
+		meta, err := env.Metadata.GetByPath(ctx, "/seed/proj/a.txt")
+		if err != nil {
+			t.Fatalf("GetByPath failed: %v", err)
+		}
+		download, err := env.Service.Download(ctx, meta.ID)
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		content, _ := io.ReadAll(download.Content)
+		download.Content.Close()
+		if string(content) != "alpha" {
+			t.Errorf("content of overwritten file = %q, want the previous content alpha", content)
+		}
+
+		filepath.Walk(env.TmpDir+"/storage", func(path string, info os.FileInfo, err error) error {
+			if err == nil && strings.HasPrefix(info.Name(), "staging-") {
+				t.Errorf("staged blob %v was not discarded", path)
+			}
+			return nil
+		})
+		if spooled, _ := os.ReadDir(spool); len(spooled) != 0 {
+			t.Errorf("spool directory holds %v files after extraction", len(spooled))
+		}
+	})
+}
+
+// failingStore is a metadata store whose commits fail.
+type failingStore struct {
+	metadata.Store
+}
+
+func (s failingStore) Save(ctx context.Context, meta *metadata.FileMetadata) error {
+	return fmt.Errorf("metadata volume is full")
+}
+
+func (s failingStore) SaveBatch(ctx context.Context, metas []*metadata.FileMetadata) error {
+	return fmt.Errorf("metadata volume is full")
+}
+
+func TestRegionAPI_BatchOperations(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+
+	notifier := &recordingNotifier{}
+	env.Service.SetChangeNotifier(notifier)
+
+	var ids []string
+	for _, name := range []string{"one.txt", "two.txt", "three.txt"} {
+		resp, err := env.Service.Upload(ctx, &service.UploadRequest{
+			Path:    "/batch",
+			Name:    name,
+			Size:    5,
+			Content: bytes.NewReader([]byte("hello")),
+			OwnerID: "test-user",
+		})
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		ids = append(ids, resp.FileID)
+	}
+
+	type result struct {
+		ID       string                 `json:"id"`
+		Path     string                 `json:"path"`
+		Status   int                    `json:"status"`
+		Error    string                 `json:"error"`
+		ETag     string                 `json:"etag"`
+		Metadata *metadata.FileMetadata `json:"metadata"`
+	}
+	batch := func(t *testing.T, op, body string) (int, []result) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/v1/batch/"+op, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+
+		var resp struct {
+			Results []result `json:"results"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Results
+	}
+	statuses := func(results []result) []int {
+		codes := make([]int, len(results))
+		for i, r := range results {
+			codes[i] = r.Status
+		}
+		return codes
+	}
+
+	t.Run("metadata", func(t *testing.T) {
+		code, results := batch(t, "metadata", `{"items":[
+			{"id":"`+ids[0]+`"},
+			{"path":"/batch/two.txt"},
+			{"id":"missing"},
+			{}
+		]}`)
+		if code != http.StatusOK {
+			t.Fatalf("status = %v, want %v", code, http.StatusOK)
+		}
+		if got := statuses(results); !reflect.DeepEqual(got, []int{200, 200, 404, 400}) {
+			t.Errorf("item statuses = %v, want [200 200 404 400]", got)
+		}
+		if results[1].ID != ids[1] || results[1].Metadata == nil || results[1].Metadata.Name != "two.txt" {
+			t.Errorf("lookup by path returned %+v", results[1])
+		}
+	})
+
+	t.Run("custom meta", func(t *testing.T) {
+		meta, _ := env.Metadata.Get(ctx, ids[0])
+		version := meta.Version
+		code, results := batch(t, "custom-meta", `{"items":[
+			{"id":"`+ids[0]+`","if_match":`+strconv.Quote(meta.ETag())+`,"custom_meta":{"tag":"red","owner":"ops"}},
+			{"id":"`+ids[1]+`","if_match":"\"stale\"","custom_meta":{"tag":"red"}},
+			{"path":"/batch/one.txt","custom_meta":{"tag":"blue"}}
+		]}`)
+		if code != http.StatusOK {
+			t.Fatalf("status = %v, want %v", code, http.StatusOK)
+		}
+		if got := statuses(results); !reflect.DeepEqual(got, []int{200, 412, 409}) {
+			t.Errorf("item statuses = %v, want [200 412 409]", got)
+		}
+
+		code, results = batch(t, "custom-meta", `{"items":[
+			{"id":"`+ids[0]+`","custom_meta":{"owner":null}}
+		]}`)
+		if code != http.StatusOK || results[0].Status != http.StatusOK {
+			t.Fatalf("status = %v/%+v", code, results)
+		}
+
+		meta, _ = env.Metadata.Get(ctx, ids[0])
+		if !reflect.DeepEqual(meta.CustomMeta, map[string]string{"tag": "red"}) {
+			t.Errorf("CustomMeta = %v, want map[tag:red]", meta.CustomMeta)
+		}
+		if meta.SyncState != metadata.SyncStatePending || meta.Version != version+2 {
+			t.Errorf("patched file should be pending sync at version %v, got %v/%v", version+2, meta.SyncState, meta.Version)
+		}
+		if results[0].ETag != meta.ETag() {
+			t.Errorf("etag = %v, want %v", results[0].ETag, meta.ETag())
+		}
+	})
+
+	t.Run("failed delete", func(t *testing.T) {
+		failing := service.NewFileService("test-region", env.Storage, failingStore{Store: env.Metadata})
+
+		if _, err := failing.DeleteBatch(ctx, []*service.BatchItem{{ID: ids[2]}}); err == nil {
+			t.Fatal("DeleteBatch should fail when the metadata commit fails")
+		}
+
+		download, err := env.Service.Download(ctx, ids[2])
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		content, _ := io.ReadAll(download.Content)
+		download.Content.Close()
+		if string(content) != "hello" {
+			t.Errorf("content of file kept by a failed delete = %q, want hello", content)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		notifier.changes = nil
+
+		code, results := batch(t, "delete", `{"items":[
+			{"id":"`+ids[0]+`"},
+			{"path":"/batch/two.txt"},
+			{"id":"missing"}
+		]}`)
+		if code != http.StatusOK {
+			t.Fatalf("status = %v, want %v", code, http.StatusOK)
+		}
+		if got := statuses(results); !reflect.DeepEqual(got, []int{204, 204, 404}) {
+			t.Errorf("item statuses = %v, want [204 204 404]", got)
+		}
+		if len(notifier.changes) != 2 {
+			t.Errorf("notified %d changes, want 2", len(notifier.changes))
+		}
+
+		if _, err := env.Metadata.GetByPath(ctx, "/batch/two.txt"); !errors.IsNotFound(err) {
+			t.Errorf("deleted file still visible by path, err = %v", err)
+		}
+		if _, err := env.Metadata.GetByPath(ctx, "/batch/three.txt"); err != nil {
+			t.Errorf("untouched file missing: %v", err)
+		}
+
+		// Deleting again reports the files as gone
+		_, results = batch(t, "delete", `{"items":[{"id":"`+ids[0]+`"}]}`)
+		if results[0].Status != http.StatusNotFound {
+			t.Errorf("repeated delete status = %v, want %v", results[0].Status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		env.Handler.SetMaxBatchSize(2)
+		defer env.Handler.SetMaxBatchSize(0)
+
+		if code, _ := batch(t, "metadata", `{"items":[{"id":"a"},{"id":"b"},{"id":"c"}]}`); code != http.StatusRequestEntityTooLarge {
+			t.Errorf("oversized batch status = %v, want %v", code, http.StatusRequestEntityTooLarge)
+		}
+		if code, _ := batch(t, "metadata", `{"items":[]}`); code != http.StatusBadRequest {
+			t.Errorf("empty batch status = %v, want %v", code, http.StatusBadRequest)
+		}
+		if code, _ := batch(t, "delete", `not json`); code != http.StatusBadRequest {
+			t.Errorf("malformed batch status = %v, want %v", code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestRegionAPI_PresignedURLs(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+
+	presignURL := func(t *testing.T, body string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/v1/presign", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+
+		var resp struct {
+			URL string `json:"url"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.URL
+	}
+	do := func(method, target, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	if code, _ := presignURL(t, `{"method":"GET","file_id":"x"}`); code != http.StatusNotImplemented {
+		t.Errorf("presign without secret status = %v, want %v", code, http.StatusNotImplemented)
+	}
+
+	signer, err := presign.NewSigner([]byte("region-secret"))
+	if err != nil {
+		t.Fatalf("NewSigner failed: %v", err)
+	}
+	env.Handler.SetPresigner(signer, time.Hour)
+
+	uploadResp, err := env.Service.Upload(ctx, &service.UploadRequest{
+		Path:    "/shared",
+		Name:    "report.txt",
+		Size:    5,
+		Content: bytes.NewReader([]byte("hello")),
+		OwnerID: "test-user",
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	t.Run("download", func(t *testing.T) {
+		code, signed := presignURL(t, `{"method":"GET","file_id":"`+uploadResp.FileID+`"}`)
+		if code != http.StatusOK {
+			t.Fatalf("presign status = %v, want %v", code, http.StatusOK)
+		}
+
+		w := do("GET", signed, "", nil)
+		if w.Code != http.StatusOK || w.Body.String() != "hello" {
+			t.Errorf("GET = %v %q, want 200 hello", w.Code, w.Body.String())
+		}
+		if w := do("HEAD", signed, "", nil); w.Code != http.StatusOK {
+			t.Errorf("HEAD status = %v, want %v", w.Code, http.StatusOK)
+		}
+
+		// The signature is bound to the file
+		other := strings.Replace(signed, uploadResp.FileID, "other-file", 1)
+		w = do("GET", other, "", nil)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "signature does not match") {
+			t.Errorf("GET other file = %v %s, want 403 signature mismatch", w.Code, w.Body.String())
+		}
+
+		if code, _ := presignURL(t, `{"method":"GET","file_id":"missing"}`); code != http.StatusNotFound {
+			t.Errorf("presign missing file status = %v, want %v", code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		code, signed := presignURL(t, `{"method":"PUT","path":"/inbox/photo.txt","max_size":10,"content_type":"text/plain","expires_in":"15m"}`)
+		if code != http.StatusOK {
+			t.Fatalf("presign status = %v, want %v", code, http.StatusOK)
+		}
+
+		if w := do("PUT", signed, "image/png", []byte("hello")); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "content type") {
+			t.Errorf("wrong content type = %v %s, want 403", w.Code, w.Body.String())
+		}
+		if w := do("PUT", signed, "text/plain", []byte("far too long content")); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "size") {
+			t.Errorf("oversized upload = %v %s, want 403", w.Code, w.Body.String())
+		}
+		if w := do("GET", signed, "", nil); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "method not allowed") {
+			t.Errorf("GET with PUT URL = %v %s, want 403", w.Code, w.Body.String())
+		}
+
+		tampered := strings.Replace(signed, "X-JzSE-Max-Size=10", "X-JzSE-Max-Size=1000", 1)
+		if w := do("PUT", tampered, "text/plain", []byte("hello")); w.Code != http.StatusForbidden {
+			t.Errorf("tampered URL status = %v, want %v", w.Code, http.StatusForbidden)
+		}
+
+		w := do("PUT", signed, "text/plain", []byte("hello"))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("presigned upload status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		meta, err := env.Metadata.GetByPath(ctx, "/inbox/photo.txt")
+		if err != nil {
+			t.Fatalf("GetByPath failed: %v", err)
+		}
+		if meta.Size != 5 {
+			t.Errorf("uploaded size = %v, want 5", meta.Size)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		query := signer.Sign(&presign.Policy{
+			Method:  http.MethodGet,
+			Path:    "/api/v1/files/" + uploadResp.FileID,
+			Expires: time.Now().Add(-time.Minute),
+		})
+		w := do("GET", "/api/v1/files/"+uploadResp.FileID+"?"+query.Encode(), "", nil)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "expired") {
+			t.Errorf("expired URL = %v %s, want 403 expired", w.Code, w.Body.String())
+		}
+
+		if code, _ := presignURL(t, `{"method":"GET","file_id":"`+uploadResp.FileID+`","expires_in":"48h"}`); code != http.StatusBadRequest {
+			t.Errorf("expiry above maximum status = %v, want %v", code, http.StatusBadRequest)
+		}
+		if code, _ := presignURL(t, `{"method":"DELETE","file_id":"`+uploadResp.FileID+`"}`); code != http.StatusBadRequest {
+			t.Errorf("unsupported method status = %v, want %v", code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestRegionAPI_Authentication(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+
+	hmacSecret := []byte("hmac-secret")
+	authn := auth.Chain{
+		auth.NewAPIKeyAuthenticator(map[string]auth.APIKey{"alice-key": {UserID: "alice"}}),
+		auth.NewHMACAuthenticator(map[string]auth.HMACKey{"bob-key": {Secret: hmacSecret, UserID: "bob"}}, time.Minute),
+	}
+	signer, _ := presign.NewSigner([]byte("region-secret"))
+	env.Handler.SetPresigner(signer, time.Hour)
+	env.Handler.SetAuthenticator(authn, true)
+	env.Router = gin.New()
+	env.Handler.RegisterRoutes(env.Router)
+
+	put := func(path, content string, sign func(r *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", path, strings.NewReader(content))
+		if sign != nil {
+			sign(req)
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+	owner := func(t *testing.T, path string) *metadata.FileMetadata {
+		t.Helper()
+		meta, err := env.Metadata.GetByPath(ctx, path)
+		if err != nil {
+			t.Fatalf("GetByPath(%v) failed: %v", path, err)
+		}
+		return meta
+	}
+
+	// Credentials are required, except for health checks
+	w := put("/api/v1/fs/anon.txt", "hello", nil)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("anonymous upload = %v, want 401 with challenge", w.Code)
+	}
+	health := httptest.NewRecorder()
+	env.Router.ServeHTTP(health, httptest.NewRequest("GET", "/api/v1/health", nil))
+	if health.Code != http.StatusOK {
+		t.Errorf("health status = %v, want %v", health.Code, http.StatusOK)
+	}
+
+	w = put("/api/v1/fs/bad.txt", "hello", func(r *http.Request) { r.Header.Set("X-API-Key", "wrong") })
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid key status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// The caller becomes the owner
+	w = put("/api/v1/fs/alice.txt", "hello", func(r *http.Request) { r.Header.Set("X-API-Key", "alice-key") })
+	if w.Code != http.StatusCreated {
+		t.Fatalf("API key upload status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if meta := owner(t, "/alice.txt"); meta.OwnerID != "alice" || meta.UpdatedBy != "alice" {
+		t.Errorf("owner = %v/%v, want alice", meta.OwnerID, meta.UpdatedBy)
+	}
+
+	// Updates by another user are recorded
+	w = put("/api/v1/fs/alice.txt", "changed", func(r *http.Request) {
+		auth.SignRequest(r, "bob-key", hmacSecret, time.Now())
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("HMAC upload status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if meta := owner(t, "/alice.txt"); meta.OwnerID != "alice" || meta.UpdatedBy != "bob" {
+		t.Errorf("owner/updater = %v/%v, want alice/bob", meta.OwnerID, meta.UpdatedBy)
+	}
+
+	// Presigned URLs need no credentials and act as their creator
+	req := httptest.NewRequest("POST", "/api/v1/presign", strings.NewReader(`{"method":"PUT","path":"/shared.txt"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "alice-key")
+	w = httptest.NewRecorder()
+	env.Router.ServeHTTP(w, req)
+	var presigned struct {
+		URL string `json:"url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &presigned)
+
+	w = put(presigned.URL, "hello", nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("presigned upload status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if meta := owner(t, "/shared.txt"); meta.OwnerID != "alice" {
+		t.Errorf("presigned upload owner = %v, want alice", meta.OwnerID)
+	}
+}
+
+func TestRegionAPI_AccessControl(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	notifier := &recordingNotifier{}
+	env.Service.SetChangeNotifier(notifier)
+
+	env.Handler.SetAuthenticator(auth.NewAPIKeyAuthenticator(map[string]auth.APIKey{
+		"admin-key":   {UserID: "admin", Groups: []string{"admins"}},
+		"alice-key":   {UserID: "alice", Groups: []string{"eng"}},
+		"bob-key":     {UserID: "bob"},
+		"mallory-key": {UserID: "mallory"},
+	}), true)
+	env.Service.SetAdminGroup("admins")
+	signer, _ := presign.NewSigner([]byte("region-secret"))
+	env.Handler.SetPresigner(signer, time.Hour)
+	env.Router = gin.New()
+	env.Handler.RegisterRoutes(env.Router)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		if strings.HasPrefix(body, "{") {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+	expect := func(t *testing.T, w *httptest.ResponseRecorder, want int, what string) {
+		t.Helper()
+		if w.Code != want {
+			t.Errorf("%s status = %v, want %v: %s", what, w.Code, want, w.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/eng/spec.txt", "/eng/private/plan.txt", "/public.txt"} {
+		expect(t, do("PUT", "/api/v1/fs"+path, "admin-key", "content"), http.StatusCreated, "admin upload "+path)
+	}
+
+	// Without ACLs, access is open, but only admins and file owners attach
+	// the first ACL
+	expect(t, do("GET", "/api/v1/fs/public.txt", "mallory-key", ""), http.StatusOK, "open read")
+	expect(t, do("PUT", "/api/v1/acl/", "mallory-key", `{"entries":[]}`), http.StatusForbidden, "root ACL by non-admin")
+	expect(t, do("PUT", "/api/v1/acl/public.txt", "mallory-key", `{"entries":[]}`), http.StatusForbidden, "first file ACL by non-owner")
+	expect(t, do("PUT", "/api/v1/fs/bob-notes.txt", "bob-key", "notes"), http.StatusCreated, "user upload")
+	expect(t, do("PUT", "/api/v1/acl/bob-notes.txt", "bob-key", `{"entries":[]}`), http.StatusOK, "first file ACL by owner")
+
+	// Lock down the tree, then grant eng read and bob write below /eng
+	expect(t, do("PUT", "/api/v1/acl/", "admin-key", `{"entries":[]}`), http.StatusOK, "root ACL")
+	w := do("PUT", "/api/v1/acl/eng", "admin-key", `{"entries":[
+		{"principal":"group:eng","permissions":["read"]},
+		{"principal":"user:bob","permissions":["write"]}
+	]}`)
+	expect(t, w, http.StatusOK, "eng ACL")
+	var acl metadata.ACL
+	json.Unmarshal(w.Body.Bytes(), &acl)
+	if acl.Owner != "admin" || len(acl.Entries) != 2 || acl.Version != 1 {
+		t.Errorf("eng ACL = %+v, want owner admin, 2 entries, version 1", acl)
+	}
+
+	expect(t, do("GET", "/api/v1/fs/public.txt", "mallory-key", ""), http.StatusForbidden, "read below root ACL")
+	expect(t, do("GET", "/api/v1/fs/public.txt", "admin-key", ""), http.StatusOK, "ACL owner read")
+
+	// Group read, inherited by files
+	expect(t, do("GET", "/api/v1/fs/eng/spec.txt", "alice-key", ""), http.StatusOK, "group read")
+	expect(t, do("GET", "/api/v1/directories/eng", "alice-key", ""), http.StatusOK, "group list")
+	expect(t, do("PUT", "/api/v1/fs/eng/spec.txt", "alice-key", "changed"), http.StatusForbidden, "group write")
+	expect(t, do("DELETE", "/api/v1/fs/eng/spec.txt", "alice-key", ""), http.StatusForbidden, "group delete")
+	expect(t, do("GET", "/api/v1/fs/eng/spec.txt", "mallory-key", ""), http.StatusForbidden, "other read")
+	expect(t, do("GET", "/api/v1/directories/eng", "mallory-key", ""), http.StatusForbidden, "other list")
+
+	meta, _ := env.Metadata.GetByPath(context.Background(), "/eng/spec.txt")
+	expect(t, do("GET", "/api/v1/files/"+meta.ID+"/metadata", "mallory-key", ""), http.StatusForbidden, "metadata by ID")
+
+	// Presigned URLs act as their creator, with the groups they had
+	w = do("POST", "/api/v1/presign", "alice-key", `{"method":"GET","file_id":"`+meta.ID+`"}`)
+	expect(t, w, http.StatusOK, "presign group read")
+	var presigned struct {
+		URL string `json:"url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &presigned)
+	expect(t, do("GET", presigned.URL, "", ""), http.StatusOK, "presigned group read")
+
+	// User write: bob may replace files, but not read them
+	expect(t, do("PUT", "/api/v1/fs/eng/spec.txt", "bob-key", "changed"), http.StatusOK, "user write")
+	expect(t, do("GET", "/api/v1/fs/eng/spec.txt", "bob-key", ""), http.StatusForbidden, "user read")
+
+	// Files created by a user are theirs
+	expect(t, do("PUT", "/api/v1/fs/eng/bob.txt", "bob-key", "mine"), http.StatusCreated, "user create")
+	expect(t, do("GET", "/api/v1/fs/eng/bob.txt", "bob-key", ""), http.StatusOK, "file owner read")
+	expect(t, do("DELETE", "/api/v1/fs/eng/bob.txt", "bob-key", ""), http.StatusNoContent, "file owner delete")
+
+	// Batch items are authorized one by one
+	w = do("POST", "/api/v1/batch/metadata", "alice-key", `{"items":[{"path":"/eng/spec.txt"},{"path":"/public.txt"}]}`)
+	expect(t, w, http.StatusOK, "batch metadata")
+	var batch struct {
+		Results []struct {
+			Status int `json:"status"`
+		} `json:"results"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &batch)
+	if len(batch.Results) != 2 || batch.Results[0].Status != http.StatusOK || batch.Results[1].Status != http.StatusForbidden {
+		t.Errorf("batch results = %+v, want 200 and 403", batch.Results)
+	}
+
+	// NoInherit cuts off the grants of ancestors
+	expect(t, do("PUT", "/api/v1/acl/eng/private", "admin-key", `{"no_inherit":true}`), http.StatusOK, "private ACL")
+	expect(t, do("GET", "/api/v1/fs/eng/private/plan.txt", "alice-key", ""), http.StatusForbidden, "read without inheritance")
+	expect(t, do("GET", "/api/v1/fs/eng/private/plan.txt", "admin-key", ""), http.StatusOK, "file owner read without inheritance")
+
+	// Managing ACLs needs share permission
+	expect(t, do("GET", "/api/v1/acl/eng", "alice-key", ""), http.StatusOK, "read ACL")
+	expect(t, do("PUT", "/api/v1/acl/eng", "alice-key", `{"entries":[]}`), http.StatusForbidden, "ACL change without share")
+	expect(t, do("PUT", "/api/v1/acl/eng", "admin-key", `{"entries":[{"principal":"eng","permissions":["read"]}]}`), http.StatusBadRequest, "invalid principal")
+	expect(t, do("PUT", "/api/v1/acl/missing", "admin-key", `{"entries":[]}`), http.StatusNotFound, "ACL on missing path")
+
+	expect(t, do("DELETE", "/api/v1/acl/eng", "admin-key", ""), http.StatusNoContent, "delete ACL")
+	expect(t, do("GET", "/api/v1/acl/eng", "admin-key", ""), http.StatusNotFound, "deleted ACL")
+	expect(t, do("GET", "/api/v1/fs/eng/spec.txt", "alice-key", ""), http.StatusForbidden, "read after ACL removal")
+	expect(t, do("GET", presigned.URL, "", ""), http.StatusForbidden, "presigned read after ACL removal")
+
+	acls := 0
+	for _, change := range notifier.changes {
+		if change == regionsync.ChangeTypeACL {
+			acls++
+		}
+	}
+	if acls != 5 {
+		t.Errorf("queued %d ACL changes, want 5", acls)
+	}
+}
+
+func TestRegionAPI_ChangeEvents(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	// Disabled without a broker
+	req := httptest.NewRequest("GET", "/api/v1/events", nil)
+	w := httptest.NewRecorder()
+	env.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("events without broker status = %v, want %v", w.Code, http.StatusNotImplemented)
+	}
+
+	broker := events.NewBroker(events.BrokerConfig{})
+	env.Service.SetChangeNotifier(service.Notifiers{&recordingNotifier{}, broker})
+	env.Handler.SetEventBroker(broker, time.Hour)
+
+	server := httptest.NewServer(env.Router)
+	defer server.Close()
+	defer broker.Close() // Ends the streams, which server.Close waits for
+
+	ctx := context.Background()
+	upload := func(dir, name string) {
+		t.Helper()
+		if _, err := env.Service.Upload(ctx, &service.UploadRequest{
+			Path: dir, Name: name, Size: -1, Content: strings.NewReader(name), OwnerID: "alice",
+		}); err != nil {
+			t.Fatalf("upload %s/%s: %v", dir, name, err)
+		}
+	}
+
+	// Only alice can read /private; requests here are anonymous
+	upload("/private", "setup.txt")
+	if _, err := env.Service.SetACL(ctx, &metadata.ACL{Path: "/private", Owner: "alice"}); err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+
+	// stream subscribes over SSE and returns the received events.
+	stream := func(query, lastEventID string) <-chan events.Event {
+		t.Helper()
+		req, _ := http.NewRequest("GET", server.URL+"/api/v1/events?"+query, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET /api/v1/events: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("GET /api/v1/events = %v %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+
+		received := make(chan events.Event, 16)
+		go func() {
+			defer resp.Body.Close()
+			defer close(received)
+			scanner := bufio.NewScanner(resp.Body)
+			var id string
+			for scanner.Scan() {
+				line := scanner.Text()
+				switch {
+				case strings.HasPrefix(line, "id: "):
+					id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "data: "):
+					var event events.Event
+					if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil || event.ID != id {
+						t.Errorf("malformed event %q (id %q)", line, id)
+					}
+					received <- event
+				}
+			}
+		}()
+		return received
+	}
+	next := func(received <-chan events.Event) events.Event {
+		t.Helper()
+		select {
+		case event := <-received:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+			return events.Event{}
+		}
+	}
+
+	all := stream("", "")
+	public := stream("path=/public", "")
+
+	upload("/private", "secret.txt")
+	upload("/other", "b.txt")
+	upload("/public", "a.txt")
+
+	// The private file is filtered out, the other path is not subscribed
+	if event := next(all); event.Type != events.TypeCreate || event.Path != "/other/b.txt" {
+		t.Errorf("first event on / = %+v", event)
+	}
+	first := next(public)
+	if first.Type != events.TypeCreate || first.Path != "/public/a.txt" || first.Dir != "/public" || first.FileID == "" {
+		t.Errorf("first event on /public = %+v", first)
+	}
+
+	// Resuming replays what was missed
+	if err := env.Service.DeletePath(ctx, "/public/a.txt", ""); err != nil {
+		t.Fatalf("DeletePath: %v", err)
+	}
+	upload("/public", "c.txt")
+	resumed := stream("path=/public", first.ID)
+	if event := next(resumed); event.Type != events.TypeDelete || event.Path != "/public/a.txt" {
+		t.Errorf("first resumed event = %+v", event)
+	}
+	if event := next(resumed); event.Type != events.TypeCreate || event.Path != "/public/c.txt" {
+		t.Errorf("second resumed event = %+v", event)
+	}
+	if event := next(stream("", "unknown-1")); event.Type != events.TypeReset {
+		t.Errorf("event after an unknown ID = %+v", event)
+	}
+
+	// A move out of a path the caller cannot read looks like a create
+	if _, err := env.Service.Move(ctx, "/private/secret.txt", "/public/moved.txt", "alice"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if event := next(resumed); event.Type != events.TypeCreate || event.Path != "/public/moved.txt" || event.OldPath != "" {
+		t.Errorf("event of a move from an unreadable path = %+v", event)
+	}
+
+	// WebSocket
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/events/ws?path=/public", nil)
+	if err != nil {
+		t.Fatalf("websocket dial: %v", err)
+	}
+	defer conn.Close()
+	upload("/private", "hidden.txt")
+	upload("/public", "d.txt")
+	var event events.Event
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&event); err != nil || event.Path != "/public/d.txt" {
+		t.Errorf("websocket event = %+v, %v", event, err)
+	}
+
+	broker.Close()
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("websocket after broker close: %v", err)
+	}
+}
+
+func TestRegionAPI_Webhooks(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	serve := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Refused to everyone without authentication
+	if w := serve("GET", "/api/v1/admin/webhooks", ""); w.Code != http.StatusForbidden {
+		t.Errorf("webhooks without authentication status = %v, want %v", w.Code, http.StatusForbidden)
+	}
+
+	// Restricted to the admin group
+	env.Handler.SetAuthenticator(auth.NewAPIKeyAuthenticator(map[string]auth.APIKey{
+		"admin-key": {UserID: "ops", Groups: []string{"admins"}},
+		"user-key":  {UserID: "alice"},
+	}), false)
+	env.Handler.SetAdminGroup("admins")
+	env.Router = gin.New()
+	env.Handler.RegisterRoutes(env.Router)
+	for _, path := range []string{"/api/v1/admin/webhooks", "/api/v1/admin/webhooks/dead-letters"} {
+		if w := serve("GET", path, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous GET %v status = %v, want %v", path, w.Code, http.StatusUnauthorized)
+		}
+		if w := serve("GET", path, "user-key"); w.Code != http.StatusForbidden {
+			t.Errorf("non-admin GET %v status = %v, want %v", path, w.Code, http.StatusForbidden)
+		}
+	}
+	if w := serve("POST", "/api/v1/admin/webhooks/dead-letters/any/redeliver", "user-key"); w.Code != http.StatusForbidden {
+		t.Errorf("non-admin redeliver status = %v, want %v", w.Code, http.StatusForbidden)
+	}
+
+	// Disabled without a dispatcher
+	if w := serve("GET", "/api/v1/admin/webhooks", "admin-key"); w.Code != http.StatusNotImplemented {
+		t.Fatalf("webhooks without dispatcher status = %v, want %v", w.Code, http.StatusNotImplemented)
+	}
+
+	secret := []byte("webhook-secret")
+	receiver := webhooktest.NewReceiver(secret)
+	defer receiver.Close()
+
+	store, err := webhook.NewFileStore(filepath.Join(env.TmpDir, "webhooks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher, err := webhook.NewDispatcher(webhook.DispatcherConfig{
+		RegionID:       "test-region",
+		MaxAttempts:    2,
+		InitialBackoff: 10 * time.Millisecond,
+	}, []*webhook.Subscription{{ID: "docs", URL: receiver.URL, Secret: secret, Paths: []string{"/docs"}}}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Start(context.Background())
+	defer dispatcher.Stop()
+	env.Service.SetChangeNotifier(service.Notifiers{&recordingNotifier{}, dispatcher})
+	env.Handler.SetWebhookDispatcher(dispatcher)
+
+	ctx := context.Background()
+	upload := func(dir, name string) {
+		t.Helper()
+		if _, err := env.Service.Upload(ctx, &service.UploadRequest{
+			Path: dir, Name: name, Size: -1, Content: strings.NewReader(name), OwnerID: "alice",
+		}); err != nil {
+			t.Fatalf("upload %s/%s: %v", dir, name, err)
+		}
+	}
+
+	upload("/other", "a.txt")
+	upload("/docs", "b.txt")
+	if !receiver.Wait(1, 5*time.Second) {
+		t.Fatal("webhook not delivered")
+	}
+	payload := receiver.Requests()[0].Payload
+	if payload.Type != events.TypeCreate || payload.RegionID != "test-region" || payload.File.Path != "/docs/b.txt" {
+		t.Errorf("payload = %+v", payload)
+	}
+
+	w := serve("GET", "/api/v1/admin/webhooks", "admin-key")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"docs"`) || strings.Contains(w.Body.String(), string(secret)) {
+		t.Errorf("GET /api/v1/admin/webhooks = %v %s", w.Code, w.Body.String())
+	}
+
+	// Failed deliveries end up in the dead letters, and can be redelivered
+	receiver.FailNext(2, http.StatusBadGateway)
+	upload("/docs", "c.txt")
+	var dead struct {
+		Deliveries []webhook.Delivery `json:"deliveries"`
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(dead.Deliveries) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		w = serve("GET", "/api/v1/admin/webhooks/dead-letters", "admin-key")
+		if err := json.Unmarshal(w.Body.Bytes(), &dead); err != nil {
+			t.Fatalf("GET dead-letters = %v %s", w.Code, w.Body.String())
+		}
+	}
+	if len(dead.Deliveries) != 1 || dead.Deliveries[0].LastStatus != http.StatusBadGateway {
+		t.Fatalf("dead letters = %+v", dead.Deliveries)
+	}
+
+	w = serve("POST", "/api/v1/admin/webhooks/dead-letters/unknown/redeliver", "admin-key")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("redeliver unknown = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	w = serve("POST", "/api/v1/admin/webhooks/dead-letters/"+dead.Deliveries[0].ID+"/redeliver", "admin-key")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("redeliver = %v %s", w.Code, w.Body.String())
+	}
+	if !receiver.Wait(2, 5*time.Second) {
+		t.Fatal("redelivery not received")
+	}
+	if payload := receiver.Requests()[1].Payload; payload.File.Path != "/docs/c.txt" {
+		t.Errorf("redelivered payload = %+v", payload)
+	}
+}
+
+func TestRegionAPI_PreCommitHooks(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	// The scanner quarantines anything suspicious
+	scanner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("content")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		decision := precommit.Decision{Action: metadata.VerdictAccept}
+		if bytes.Contains(content, []byte("suspicious")) {
+			decision = precommit.Decision{Action: metadata.VerdictQuarantine, Reason: "heuristic match"}
+		}
+		json.NewEncoder(w).Encode(decision)
+	}))
+	defer scanner.Close()
+
+	notifier := &recordingNotifier{}
+	env.Service.SetChangeNotifier(notifier)
+	env.Service.SetPreCommitHooks(precommit.NewPipeline(precommit.PipelineConfig{},
+		precommit.NewFileTypeHook([]string{"exe"}, nil),
+		precommit.NewHTTPHook("scanner", scanner.URL),
+	))
+
+	put := func(path, content string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("PUT", "/api/v1/fs"+path, strings.NewReader(content))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// Rejected: nothing is stored
+	w := put("/docs/setup.exe", "MZ")
+	var envelope apierror.Envelope
+	if w.Code != http.StatusUnprocessableEntity || json.Unmarshal(w.Body.Bytes(), &envelope) != nil ||
+		envelope.Error.Code != apierror.CodeRejected || !strings.Contains(envelope.Error.Message, "file type .exe not allowed") {
+		t.Fatalf("rejected upload = %v %s", w.Code, w.Body.String())
+	}
+	if w := get("/api/v1/fs/docs/setup.exe"); w.Code != http.StatusNotFound {
+		t.Errorf("rejected file status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	// Accepted: the verdict is recorded
+	w = put("/docs/clean.txt", "hello")
+	var accepted service.UploadResponse
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &accepted) != nil ||
+		accepted.Verdict == nil || accepted.Verdict.Action != metadata.VerdictAccept {
+		t.Fatalf("accepted upload = %v %s", w.Code, w.Body.String())
+	}
+
+	// Quarantined: stored, but neither served, listed nor synced
+	w = put("/docs/odd.txt", "suspicious bytes")
+	var quarantined service.UploadResponse
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &quarantined) != nil ||
+		quarantined.Verdict == nil || quarantined.Verdict.Action != metadata.VerdictQuarantine {
+		t.Fatalf("quarantined upload = %v %s", w.Code, w.Body.String())
+	}
+	if w := get("/api/v1/files/" + quarantined.FileID); w.Code != http.StatusForbidden {
+		t.Errorf("quarantined download status = %v, want %v", w.Code, http.StatusForbidden)
+	}
+	w = get("/api/v1/files/" + quarantined.FileID + "/metadata")
+	var meta metadata.FileMetadata
+	if json.Unmarshal(w.Body.Bytes(), &meta) != nil || meta.LocalState != metadata.LocalStateQuarantined ||
+		meta.Verdict == nil || meta.Verdict.Hook != "scanner" || meta.Verdict.Reason != "heuristic match" {
+		t.Errorf("quarantined metadata = %s", w.Body.String())
+	}
+	if w := get("/api/v1/directories/docs"); strings.Contains(w.Body.String(), "odd.txt") || !strings.Contains(w.Body.String(), "clean.txt") {
+		t.Errorf("listing = %s", w.Body.String())
+	}
+	if len(notifier.changes) != 1 {
+		t.Errorf("changes = %v, want only the accepted upload", notifier.changes)
+	}
+
+	// Clean content releases the file
+	if w := put("/docs/odd.txt", "fixed"); w.Code != http.StatusOK {
+		t.Fatalf("replacing quarantined file = %v %s", w.Code, w.Body.String())
+	}
+	if w := get("/api/v1/files/" + quarantined.FileID); w.Code != http.StatusOK || w.Body.String() != "fixed" {
+		t.Errorf("released download = %v %q", w.Code, w.Body.String())
+	}
+
+	// Archive entries are checked one by one
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string]string{"ok.txt": "fine", "tool.exe": "MZ", "weird.txt": "suspicious"} {
+		f, _ := zw.Create(name)
+		f.Write([]byte(content))
+	}
+	zw.Close()
+	req := httptest.NewRequest("POST", "/api/v1/directories/unpacked?archive=zip", &archive)
+	w = httptest.NewRecorder()
+	env.Router.ServeHTTP(w, req)
+	var extracted service.ExtractResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &extracted) != nil ||
+		extracted.Created != 1 || extracted.Rejected != 1 || extracted.Quarantined != 1 {
+		t.Errorf("extract = %v %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRegionAPI_WebDAV(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	dav := func(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Disabled by default
+	if w := dav("PROPFIND", "/webdav/", ""); w.Code != http.StatusNotImplemented {
+		t.Fatalf("PROPFIND while disabled = %v, want %v", w.Code, http.StatusNotImplemented)
+	}
+
+	broker := events.NewBroker(events.BrokerConfig{})
+	defer broker.Close()
+	sub := broker.Subscribe([]string{"/"}, "")
+	defer sub.Close()
+	env.Service.SetChangeNotifier(broker)
+	env.Handler.SetWebDAV(true)
+
+	nextEvent := func() *events.Event {
+		t.Helper()
+		select {
+		case event := <-sub.Events():
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no change event")
+			return nil
+		}
+	}
+
+	// Create a directory and a file in it
+	if w := dav("MKCOL", "/webdav/docs", ""); w.Code != http.StatusCreated {
+		t.Fatalf("MKCOL = %v: %s", w.Code, w.Body.String())
+	}
+	if w := dav("MKCOL", "/webdav/missing/docs", ""); w.Code != http.StatusConflict {
+		t.Errorf("MKCOL without parent = %v, want %v", w.Code, http.StatusConflict)
+	}
+	w := dav("PUT", "/webdav/docs/a.txt", "hello webdav")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %v: %s", w.Code, w.Body.String())
+	}
+	meta, err := env.Metadata.GetByPath(context.Background(), "/docs/a.txt")
+	if err != nil {
+		t.Fatalf("GetByPath after PUT: %v", err)
+	}
+	if w.Header().Get("ETag") != meta.ETag() {
+		t.Errorf("PUT ETag = %q, want %q", w.Header().Get("ETag"), meta.ETag())
+	}
+	if event := nextEvent(); event.Type != events.TypeCreate || event.Path != "/docs/a.txt" {
+		t.Errorf("PUT event = %+v", event)
+	}
+
+	// Read it back, whole and in part
+	w = dav("GET", "/webdav/docs/a.txt", "")
+	if w.Code != http.StatusOK || w.Body.String() != "hello webdav" || w.Header().Get("ETag") != meta.ETag() {
+		t.Errorf("GET = %v %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	w = dav("GET", "/webdav/docs/a.txt", "", "Range", "bytes=6-")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "webdav" {
+		t.Errorf("ranged GET = %v %q", w.Code, w.Body.String())
+	}
+
+	// Listings show the tree as the REST API does
+	w = dav("PROPFIND", "/webdav/docs/", "", "Depth", "1")
+	if w.Code != http.StatusMultiStatus || !strings.Contains(w.Body.String(), "/webdav/docs/a.txt") {
+		t.Errorf("PROPFIND = %v: %s", w.Code, w.Body.String())
+	}
+
+	// Overwriting updates the same file
+	if w := dav("PUT", "/webdav/docs/a.txt", "hello again"); w.Code != http.StatusCreated {
+		t.Fatalf("overwriting PUT = %v: %s", w.Code, w.Body.String())
+	}
+	if event := nextEvent(); event.Type != events.TypeUpdate || event.FileID != meta.ID {
+		t.Errorf("overwrite event = %+v", event)
+	}
+
+	// Moving a directory renames the files in it
+	w = dav("MOVE", "/webdav/docs", "", "Destination", "/webdav/archive")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("MOVE = %v: %s", w.Code, w.Body.String())
+	}
+	if event := nextEvent(); event.Type != events.TypeRename || event.OldPath != "/docs/a.txt" || event.Path != "/archive/a.txt" {
+		t.Errorf("MOVE event = %+v", event)
+	}
+	moved, err := env.Service.Stat(context.Background(), "/archive/a.txt")
+	if err != nil || moved.File.ID != meta.ID {
+		t.Errorf("Stat after MOVE = %+v, %v", moved, err)
+	}
+	if _, err := env.Service.Stat(context.Background(), "/docs"); !errors.IsNotFound(err) {
+		t.Errorf("Stat of moved directory error = %v, want not found", err)
+	}
+	w = httptest.NewRecorder()
+	env.Router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/fs/archive/a.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "hello again" {
+		t.Errorf("REST GET after MOVE = %v %q", w.Code, w.Body.String())
+	}
+
+	// Copies are new files
+	w = dav("COPY", "/webdav/archive/a.txt", "", "Destination", "/webdav/b.txt")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("COPY = %v: %s", w.Code, w.Body.String())
+	}
+	if event := nextEvent(); event.Type != events.TypeCreate || event.Path != "/b.txt" || event.FileID == meta.ID {
+		t.Errorf("COPY event = %+v", event)
+	}
+	w = dav("COPY", "/webdav/archive/a.txt", "", "Destination", "/webdav/b.txt", "Overwrite", "F")
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("COPY without overwrite = %v, want %v", w.Code, http.StatusPreconditionFailed)
+	}
+
+	// Locked files cannot be written without the lock token
+	lockBody := `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	w = dav("LOCK", "/webdav/b.txt", lockBody, "Timeout", "Second-60")
+	token := w.Header().Get("Lock-Token")
+	if w.Code != http.StatusOK || token == "" {
+		t.Fatalf("LOCK = %v: %s", w.Code, w.Body.String())
+	}
+	if w := dav("PUT", "/webdav/b.txt", "intruder"); w.Code != http.StatusLocked {
+		t.Errorf("PUT on locked file = %v, want %v", w.Code, http.StatusLocked)
+	}
+	if w := dav("PUT", "/webdav/b.txt", "holder", "If", "("+token+")"); w.Code != http.StatusCreated {
+		t.Errorf("PUT with lock token = %v: %s", w.Code, w.Body.String())
+	}
+	nextEvent()
+	if w := dav("UNLOCK", "/webdav/b.txt", "", "Lock-Token", token); w.Code != http.StatusNoContent {
+		t.Errorf("UNLOCK = %v", w.Code)
+	}
+
+	// Deleting a directory deletes everything in it
+	if w := dav("DELETE", "/webdav/archive", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %v: %s", w.Code, w.Body.String())
+	}
+	if event := nextEvent(); event.Type != events.TypeDelete || event.FileID != meta.ID {
+		t.Errorf("DELETE event = %+v", event)
+	}
+	if w := dav("GET", "/webdav/archive/a.txt", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRegionAPI_WebDAVAccess(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+	if _, err := env.Service.Upload(ctx, &service.UploadRequest{
+		Path: "/private", Name: "secret.txt", Size: -1, Content: strings.NewReader("secret"), OwnerID: "alice",
+	}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if _, err := env.Service.SetACL(ctx, &metadata.ACL{Path: "/private", Owner: "alice"}); err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+
+	env.Handler.SetWebDAV(true)
+	env.Handler.SetAuthenticator(auth.NewAPIKeyAuthenticator(map[string]auth.APIKey{
+		"alice-key": {UserID: "alice"},
+		"bob-key":   {UserID: "bob"},
+	}), true)
+	env.Router = gin.New()
+	env.Handler.RegisterRoutes(env.Router)
+
+	get := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if key != "" {
+			req.SetBasicAuth("user", key)
+		}
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// WebDAV clients are challenged for Basic credentials
+	w := get("/webdav/private/secret.txt", "")
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Errorf("anonymous GET = %v %v, want 401 with Basic challenge", w.Code, w.Header())
+	}
+	if w := get("/webdav/private/secret.txt", "alice-key"); w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Errorf("owner GET = %v %q", w.Code, w.Body.String())
+	}
+	if w := get("/webdav/private/secret.txt", "bob-key"); w.Code == http.StatusOK {
+		t.Errorf("GET denied by ACL = %v, want refused", w.Code)
+	}
+
+	// Moves need delete access to the source
+	req := httptest.NewRequest("MOVE", "/webdav/private/secret.txt", nil)
+	req.SetBasicAuth("bob", "bob-key")
+	req.Header.Set("Destination", "/webdav/stolen.txt")
+	w = httptest.NewRecorder()
+	env.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("MOVE denied by ACL = %v, want %v", w.Code, http.StatusForbidden)
+	}
+	if _, err := env.Metadata.GetByPath(ctx, "/private/secret.txt"); err != nil {
+		t.Errorf("file moved despite ACL: %v", err)
+	}
+
+	// Paths carrying an ACL are not moved away from it, not even by the owner
+	req = httptest.NewRequest("MOVE", "/webdav/private", nil)
+	req.SetBasicAuth("alice", "alice-key")
+	req.Header.Set("Destination", "/webdav/public")
+	w = httptest.NewRecorder()
+	env.Router.ServeHTTP(w, req)
+	if w.Code < 400 {
+		t.Errorf("MOVE of a directory with an ACL = %v, want refused", w.Code)
+	}
+	if _, err := env.Metadata.GetByPath(ctx, "/public/secret.txt"); !errors.IsNotFound(err) {
+		t.Errorf("file moved away from its ACL: %v", err)
+	}
+
+	// Owning a file does not grant write access to where it is moved
+	if err := env.Service.Mkdir(ctx, "/locked"); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if _, err := env.Service.SetACL(ctx, &metadata.ACL{Path: "/locked", Owner: "admin"}); err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+	alice := service.WithCaller(ctx, &metadata.Principal{UserID: "alice"})
+	if _, err := env.Service.Move(alice, "/private/secret.txt", "/locked/secret.txt", "alice"); !errors.IsForbidden(err) {
+		t.Errorf("Move into a write-protected directory = %v, want forbidden", err)
+	}
+
+	if _, err := env.Service.Upload(ctx, &service.UploadRequest{
+		Path: "/team/notes", Name: "plan.txt", Size: -1, Content: strings.NewReader("plan"), OwnerID: "alice",
+	}); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if _, err := env.Service.SetACL(ctx, &metadata.ACL{Path: "/team/notes/plan.txt", Owner: "alice"}); err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+	if _, err := env.Service.Move(ctx, "/team", "/moved", "alice"); !errors.IsConflict(err) {
+		t.Errorf("Move of a directory holding a file with an ACL = %v, want conflict", err)
+	}
+	if _, err := env.Service.Move(ctx, "/team/notes/plan.txt", "/team/plan.txt", "alice"); !errors.IsConflict(err) {
+		t.Errorf("Move of a file with an ACL = %v, want conflict", err)
+	}
+	if err := env.Service.DeleteACL(ctx, "/team/notes/plan.txt"); err != nil {
+		t.Fatalf("DeleteACL: %v", err)
+	}
+	if _, err := env.Service.Move(ctx, "/team", "/moved", "alice"); err != nil {
+		t.Errorf("Move without ACLs: %v", err)
+	}
+}
+
+// startGRPC serves a gRPC server on a local port and returns a connection
+// to it, authenticated by creds if non-nil.
+func startGRPC(t *testing.T, server *grpc.Server, creds credentials.PerRPCCredentials) *grpc.ClientConn {
+	t.Helper()
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if creds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(creds))
+	}
+	conn, err := grpc.NewClient(serveGRPC(t, server), opts...)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// serveGRPC serves server on a local port and returns its address.
+func serveGRPC(t *testing.T, server *grpc.Server) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// testTLS returns the TLS credentials of a server with a self-signed
+// certificate for 127.0.0.1, and those of clients trusting it.
+func testTLS(t *testing.T) (server, client credentials.TransportCredentials) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "jzse-test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = credentials.NewServerTLSFromCert(&tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key})
+	return server, credentials.NewClientTLSFromCert(pool, "")
+}
+
+func TestRegionAPI_GRPC(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	authn := auth.NewAPIKeyAuthenticator(map[string]auth.APIKey{"alice-key": {UserID: "alice"}})
+	server := grpcapi.NewServer(authn, auth.GRPCOptions{Required: true})
+	files := grpcapi.NewFileServer(env.Service)
+	files.SetMaxUploadSize(1 << 20)
+	files.Register(server)
+
+	client := pb.NewFileServiceClient(startGRPC(t, server, nil))
+	ctx := grpcmd.AppendToOutgoingContext(context.Background(), "x-api-key", "alice-key")
+
+	upload := func(ctx context.Context, header *pb.UploadHeader, chunks ...string) (*pb.UploadResponse, error) {
+		stream, err := client.Upload(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&pb.UploadRequest{Data: &pb.UploadRequest_Header{Header: header}}); err != nil {
+			return nil, err
+		}
+		for _, chunk := range chunks {
+			if err := stream.Send(&pb.UploadRequest{Data: &pb.UploadRequest_Chunk{Chunk: []byte(chunk)}}); err != nil {
+				break
+			}
+		}
+		return stream.CloseAndRecv()
+	}
+	download := func(req *pb.DownloadRequest) (*pb.FileMetadata, string, error) {
+		stream, err := client.Download(ctx, req)
+		if err != nil {
+			return nil, "", err
+		}
+		var meta *pb.FileMetadata
+		var content bytes.Buffer
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return meta, content.String(), nil
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			if m := resp.GetMetadata(); m != nil {
+				meta = m
+			}
+			content.Write(resp.GetChunk())
+		}
+	}
+
+	// Credentials are required
+	_, err := upload(context.Background(), &pb.UploadHeader{Path: "/", Name: "anon.txt", Size: -1}, "hello")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("anonymous upload = %v, want %v", status.Code(err), codes.Unauthenticated)
+	}
+
+	// Upload in chunks, with a digest, and download the file back
+	content := "hello, gRPC"
+	sum := sha256.Sum256([]byte(content))
+	uploaded, err := upload(ctx, &pb.UploadHeader{Path: "/docs", Name: "hello.txt", Size: int64(len(content)), Sha256: sum[:]}, content[:5], content[5:])
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if uploaded.Path != "/docs/hello.txt" || uploaded.Size != int64(len(content)) || uploaded.Etag == "" {
+		t.Errorf("upload response = %v", uploaded)
+	}
+
+	meta, got, err := download(&pb.DownloadRequest{FileId: uploaded.FileId})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if got != content || meta.GetOwnerId() != "alice" || meta.GetEtag() != uploaded.Etag {
+		t.Errorf("download = %q owned by %v, want %q owned by alice", got, meta.GetOwnerId(), content)
+	}
+	if _, got, _ = download(&pb.DownloadRequest{FileId: uploaded.FileId, Offset: 7, Length: 2}); got != "gR" {
+		t.Errorf("ranged download = %q, want %q", got, "gR")
+	}
+
+	// Failures map to status codes
+	wrong := sha256.Sum256([]byte("other"))
+	if _, err := upload(ctx, &pb.UploadHeader{Path: "/", Name: "bad.txt", Size: -1, Sha256: wrong[:]}, "hello"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("digest mismatch = %v, want %v", status.Code(err), codes.InvalidArgument)
+	}
+	if _, err := upload(ctx, &pb.UploadHeader{Path: "/", Name: "big.txt", Size: -1}, strings.Repeat("x", 1<<20+1)); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("oversized upload = %v, want %v", status.Code(err), codes.ResourceExhausted)
+	}
+	if _, err := upload(ctx, &pb.UploadHeader{Path: "/docs", Name: "hello.txt", Size: -1, OnConflict: "fail"}, "again"); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("conflicting upload = %v, want %v", status.Code(err), codes.AlreadyExists)
+	}
+	if _, err := client.GetMetadata(ctx, &pb.GetMetadataRequest{FileId: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetMetadata(missing) = %v, want %v", status.Code(err), codes.NotFound)
+	}
+
+	// Browse the tree
+	stat, err := client.Stat(ctx, &pb.StatRequest{Path: "/docs"})
+	if err != nil || !stat.IsDir {
+		t.Errorf("Stat(/docs) = %v, %v, want a directory", stat, err)
+	}
+	list, err := client.ListDirectory(ctx, &pb.ListDirectoryRequest{Path: "/docs"})
+	if err != nil || len(list.Entries) != 1 || list.Entries[0].Name != "hello.txt" {
+		t.Errorf("ListDirectory(/docs) = %v, %v, want hello.txt", list, err)
+	}
+
+	// Deletes honor preconditions
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Target: &pb.DeleteRequest_Path{Path: "/docs/hello.txt"}, IfMatch: `"stale"`})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("stale delete = %v, want %v", status.Code(err), codes.FailedPrecondition)
+	}
+	if _, err := client.Delete(ctx, &pb.DeleteRequest{Target: &pb.DeleteRequest_FileId{FileId: uploaded.FileId}, IfMatch: uploaded.Etag}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if deleted, err := client.GetMetadata(ctx, &pb.GetMetadataRequest{FileId: uploaded.FileId}); err != nil || deleted.LocalState != string(metadata.LocalStateDeleted) {
+		t.Errorf("GetMetadata after delete = %v, %v, want a tombstone", deleted, err)
+	}
+}
+
+// staticSyncStatus reports a fixed sync agent state.
+type staticSyncStatus regionsync.AgentStatus
+
+func (s staticSyncStatus) Status() regionsync.AgentStatus {
+	return regionsync.AgentStatus(s)
+}
+
+func TestRegionAPI_RegionStatus(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	ctx := context.Background()
+	cfg := service.StatusConfig{MaxContactAge: time.Minute, MaxSyncLag: 5 * time.Minute}
+
+	getStatus := func(t *testing.T, sync service.SyncStatus) service.RegionStatus {
+		t.Helper()
+		env.Handler.SetStatusReporter(service.NewStatusReporter("test-region", env.Storage, env.Metadata, sync, cfg))
+		w := httptest.NewRecorder()
+		env.Router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/region/status", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		var status service.RegionStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		return status
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := env.Service.Upload(ctx, &service.UploadRequest{Path: "/", Name: name, Size: 5, Content: strings.NewReader("hello")}); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+	}
+
+	// Without a coordinator, a region tracks changes locally and is healthy
+	status := getStatus(t, regionsync.NewAgent(regionsync.AgentConfig{Mode: "push"}, env.Metadata))
+	if status.Status != service.StateHealthy || status.SyncState != service.SyncDisabled || len(status.Reasons) != 0 {
+		t.Errorf("status = %v/%v %v, want healthy and disabled", status.Status, status.SyncState, status.Reasons)
+	}
+	if status.Storage == nil || status.Storage.TotalBytes <= 0 {
+		t.Errorf("storage = %+v, want the volume capacity", status.Storage)
+	}
+	if status.Metadata == nil {
+		t.Error("metadata store stats missing")
+	}
+	if status.Files[metadata.SyncStatePending] != 2 {
+		t.Errorf("files = %v, want 2 pending", status.Files)
+	}
+	if status.Sync == nil || status.Sync.QueueCapacity == 0 {
+		t.Errorf("sync = %+v, want the agent state", status.Sync)
+	}
+
+	// A recently reached coordinator is connected
+	status = getStatus(t, staticSyncStatus{Coordinator: true, QueueCapacity: 100, LastContactAt: time.Now()})
+	if status.Status != service.StateHealthy || status.SyncState != service.SyncConnected {
+		t.Errorf("status = %v/%v %v, want healthy and connected", status.Status, status.SyncState, status.Reasons)
+	}
+
+	// A region cut off for hours is degraded, with the reasons
+	status = getStatus(t, staticSyncStatus{
+		Coordinator:   true,
+		QueueDepth:    95,
+		QueueCapacity: 100,
+		Lag:           3 * time.Hour,
+		LastContactAt: time.Now().Add(-3 * time.Hour),
+		LastError:     "coordinator unavailable",
+	})
+	if status.Status != service.StateDegraded || status.SyncState != service.SyncDisconnected || len(status.Reasons) != 3 {
+		t.Errorf("status = %v/%v %v, want degraded and disconnected for 3 reasons", status.Status, status.SyncState, status.Reasons)
+	}
+	if status.Sync == nil || status.Sync.LastError == "" {
+		t.Errorf("sync = %+v, want the last error", status.Sync)
+	}
+
+	// Conflicts need attention
+	meta, err := env.Metadata.GetByPath(ctx, "/a.txt")
+	if err != nil {
+		t.Fatalf("GetByPath failed: %v", err)
+	}
+	meta.SyncState = metadata.SyncStateConflict
+	if err := env.Metadata.Save(ctx, meta); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	status = getStatus(t, nil)
+	if status.Status != service.StateDegraded || status.Files[metadata.SyncStateConflict] != 1 {
+		t.Errorf("status = %v %v, files %v, want degraded by a conflict", status.Status, status.Reasons, status.Files)
+	}
+}
+
+// scrapeMetrics returns the series served on /metrics with their values.
+func scrapeMetrics(t *testing.T, router http.Handler) map[string]float64 {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("metrics status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	series := make(map[string]float64)
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("invalid sample %q: %v", line, err)
+		}
+		series[line[:i]] = value
+	}
+	return series
+}
+
+func TestRegionAPI_Metrics(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+
+	reg := metrics.NewRegistry()
+	backend, err := storage.NewInstrumentedBackend(env.Storage, reg)
+	if err != nil {
+		t.Fatalf("NewInstrumentedBackend failed: %v", err)
+	}
+	store, err := metadata.NewInstrumentedStore(env.Metadata, reg)
+	if err != nil {
+		t.Fatalf("NewInstrumentedStore failed: %v", err)
+	}
+	agent := regionsync.NewAgent(regionsync.AgentConfig{RegionID: "test-region", Mode: "batch"}, store)
+	if err := agent.RegisterMetrics(reg); err != nil {
+		t.Fatalf("RegisterMetrics failed: %v", err)
+	}
+	httpMetrics, err := metrics.NewHTTP(reg)
+	if err != nil {
+		t.Fatalf("NewHTTP failed: %v", err)
+	}
+
+	fileService := service.NewFileService("test-region", backend, store)
+	fileService.SetChangeNotifier(agent)
+	router := gin.New()
+	router.Use(httpMetrics.Middleware())
+	router.GET("/metrics", metrics.Handler(reg))
+	httpapi.NewHandler(fileService).RegisterRoutes(router)
+
+	uploaded, err := fileService.Upload(context.Background(), &service.UploadRequest{Path: "/", Name: "a.txt", Size: 5, Content: strings.NewReader("hello")})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	for _, id := range []string{uploaded.FileID, uploaded.FileID, "missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/files/"+id, nil))
+	}
+
+	series := scrapeMetrics(t, router)
+	want := map[string]float64{
+		`jzse_http_requests_total{method="GET",route="/api/v1/files/:id",status="200"}`:    2,
+		`jzse_http_requests_total{method="GET",route="/api/v1/files/:id",status="404"}`:    1,
+		`jzse_http_request_duration_seconds_count{method="GET",route="/api/v1/files/:id"}`: 3,
+		`jzse_storage_uploaded_bytes_total`:                                                5,
+		`jzse_storage_downloaded_bytes_total`:                                              10,
+		`jzse_storage_operation_duration_seconds_count{operation="put"}`:                   1,
+		`jzse_metadata_operation_errors_total{code="not_found",operation="get"}`:           1,
+		`jzse_sync_queue_depth`: 1,
+	}
+	for name, value := range want {
+		if got, ok := series[name]; !ok || got != value {
+			t.Errorf("%v = %v (present %v), want %v", name, got, ok, value)
+		}
+	}
+	for _, name := range []string{"jzse_metadata_lsm_size_bytes", "jzse_metadata_vlog_size_bytes", "go_goroutines"} {
+		if _, ok := series[name]; !ok {
+			t.Errorf("%v missing", name)
+		}
+	}
+}
+
+func TestRegionAPI_Tracing(t *testing.T) {
+	if _, err := tracing.Init(context.Background(), tracing.Config{}); err != nil {
+		t.Fatalf("tracing.Init failed: %v", err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	// A coordinator with two regions: region-a pushes its changes, region-b
+	// pulls them
+	coordinator := SetupCoordinatorTestEnv(t)
+	defer coordinator.Cleanup()
+	server := grpcapi.NewServer(nil, auth.GRPCOptions{})
+	engine := coordsync.NewEngine(coordsync.EngineConfig{DefaultStrategy: "eager", BatchSize: 100}, coordinator.MetaManager)
+	grpcapi.NewCoordinatorServer(coordinator.MetaManager, coordinator.Registry, engine).Register(server)
+	addr := startGRPC(t, server, nil).Target()
+
+	ctx := context.Background()
+	startAgent := func(regionID, mode string, store metadata.Store) *regionsync.Agent {
+		client, err := grpcapi.NewCoordinatorClient(grpcapi.ClientConfig{
+			Addr:    addr,
+			Timeout: 5 * time.Second,
+			Region:  registry.RegionInfo{ID: regionID, Name: regionID, Endpoint: regionID + ":9090"},
+		})
+		if err != nil {
+			t.Fatalf("NewCoordinatorClient failed: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		agent := regionsync.NewAgent(regionsync.AgentConfig{
+			RegionID:          regionID,
+			Mode:              mode,
+			BatchInterval:     10 * time.Millisecond,
+			RetryInterval:     10 * time.Millisecond,
+			MaxRetries:        3,
+			HeartbeatInterval: 10 * time.Millisecond,
+		}, store)
+		agent.SetCoordinator(client)
+		if err := agent.Start(ctx); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		t.Cleanup(agent.Stop)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for _, err := coordinator.Registry.GetRegion(ctx, regionID); err != nil; _, err = coordinator.Registry.GetRegion(ctx, regionID) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%v did not register", regionID)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return agent
+	}
+
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+	other := SetupTestEnv(t)
+	defer other.Cleanup()
+	startAgent("region-b", "pull", other.Metadata)
+
+	store := metadata.NewTracedStore(env.Metadata)
+	fileService := service.NewFileService("region-a", storage.NewTracedBackend(env.Storage), store)
+	fileService.SetChangeNotifier(startAgent("region-a", "push", store))
+	router := gin.New()
+	router.Use(tracing.Middleware())
+	httpapi.NewHandler(fileService).RegisterRoutes(router)
+
+	req := httptest.NewRequest("POST", "/api/v1/files?name=a.txt&path=/docs", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	// The upload is followed into region-b, through the coordinator
+	var upload tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "POST /api/v1/files" {
+			upload = span
+		}
+	}
+	if !upload.SpanContext.IsValid() {
+		t.Fatal("upload request not traced")
+	}
+	spans := make(map[string]tracetest.SpanStub)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		for _, span := range exporter.GetSpans() {
+			if span.SpanContext.TraceID() == upload.SpanContext.TraceID() {
+				spans[span.Name] = span
+			}
+		}
+		if _, ok := spans["SyncAgent.ReceiveChange"]; ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upload trace = %v, want it to reach region-b", reflect.ValueOf(spans).MapKeys())
+		}
+	}
+	for _, name := range []string{"FileService.Upload", "storage.Put", "metadata.Save", "SyncAgent.PushChange", "Engine.HandleChange"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("upload trace has no %v span", name)
+		}
+	}
+	if spans["Engine.HandleChange"].Parent.SpanID() != spans["SyncAgent.PushChange"].SpanContext.SpanID() {
+		t.Error("Engine.HandleChange does not continue the push of the change")
+	}
+	if spans["SyncAgent.ReceiveChange"].Parent.SpanID() != spans["Engine.HandleChange"].SpanContext.SpanID() {
+		t.Error("SyncAgent.ReceiveChange does not continue the handling of the change")
+	}
+}
+
+func TestRegionAPI_RequestID(t *testing.T) {
+	// A coordinator broadcasting the changes of region-a to region-b
+	coordinator := SetupCoordinatorTestEnv(t)
+	defer coordinator.Cleanup()
+	server := grpcapi.NewServer(nil, auth.GRPCOptions{})
+	engine := coordsync.NewEngine(coordsync.EngineConfig{DefaultStrategy: "eager", BatchSize: 100}, coordinator.MetaManager)
+	engine.RegisterRegion("region-b")
+	grpcapi.NewCoordinatorServer(coordinator.MetaManager, coordinator.Registry, engine).Register(server)
+	addr := startGRPC(t, server, nil).Target()
+
+	ctx := context.Background()
+	client, err := grpcapi.NewCoordinatorClient(grpcapi.ClientConfig{
+		Addr:    addr,
+		Timeout: 5 * time.Second,
+		Region:  registry.RegionInfo{ID: "region-a", Name: "region-a", Endpoint: "region-a:9090"},
+	})
+	if err != nil {
+		t.Fatalf("NewCoordinatorClient failed: %v", err)
+	}
+	defer client.Close()
+
+	env := SetupTestEnv(t)
+	defer env.Cleanup()
+	agent := regionsync.NewAgent(regionsync.AgentConfig{
+		RegionID:          "region-a",
+		Mode:              "push",
+		RetryInterval:     10 * time.Millisecond,
+		MaxRetries:        3,
+		HeartbeatInterval: time.Minute,
+	}, env.Metadata)
+	agent.SetCoordinator(client)
+	if err := agent.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer agent.Stop()
+
+	env.Service.SetChangeNotifier(agent)
+	router := gin.New()
+	router.Use(apierror.RequestContext(logger.RegionID("region-a")))
+	router.Use(apierror.Recovery())
+	httpapi.NewHandler(env.Service).RegisterRoutes(router)
+
+	// The ID of the upload is echoed, and travels with its change to the
+	// other region
+	req := httptest.NewRequest("POST", "/api/v1/files?name=a.txt&path=/docs", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set(apierror.RequestIDHeader, "req-7")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if got := w.Header().Get(apierror.RequestIDHeader); got != "req-7" {
+		t.Errorf("%s = %q, want req-7", apierror.RequestIDHeader, got)
+	}
+
+	var pending []*coordsync.ChangeEvent
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pending) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("change did not reach region-b")
+		}
+		time.Sleep(5 * time.Millisecond)
+		pending, _ = engine.GetPendingChanges(ctx, "region-b")
+	}
+	if pending[0].RequestID != "req-7" {
+		t.Errorf("request ID of the change = %q, want req-7", pending[0].RequestID)
+	}
+	fileID := pending[0].FileID
+
+	// Requests without an ID are given one, also reported by their errors
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/files/missing", nil))
+	var body struct {
+		Error apierror.Body `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid envelope %q: %v", w.Body.String(), err)
+	}
+	if id := w.Header().Get(apierror.RequestIDHeader); id == "" || body.Error.RequestID != id {
+		t.Errorf("generated request ID = %q, envelope has %q", id, body.Error.RequestID)
+	}
+
+	// gRPC calls echo theirs in the response header
+	fileServer := grpcapi.NewServer(nil, auth.GRPCOptions{}, logger.RegionID("region-a"))
+	grpcapi.NewFileServer(env.Service).Register(fileServer)
+	files := pb.NewFileServiceClient(startGRPC(t, fileServer, nil))
+	var header grpcmd.MD
+	callCtx := grpcmd.AppendToOutgoingContext(ctx, "x-request-id", "req-8")
+	if _, err := files.GetMetadata(callCtx, &pb.GetMetadataRequest{FileId: fileID}, grpc.Header(&header)); err != nil {
+		t.Fatalf("GetMetadata failed: %v", err)
+	}
+	if got := header.Get("x-request-id"); len(got) != 1 || got[0] != "req-8" {
+		t.Errorf("x-request-id = %v, want req-8", got)
+	}
+}
@@ -0,0 +1,491 @@
+// Package integration provides integration tests for the S3 gateway.
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"asisaid.cn/JzSE/internal/common/auth"
+	"asisaid.cn/JzSE/internal/region/metadata"
+	"asisaid.cn/JzSE/pkg/api/s3"
+)
+
+const (
+	s3KeyID  = "AKIDALICE"
+	s3Secret = "alice-secret"
+)
+
+// s3Env serves the S3 gateway over a region test environment.
+type s3Env struct {
+	*TestEnv
+	gateway *gin.Engine
+}
+
+func setupS3Env(t *testing.T, cfg s3.GatewayConfig) *s3Env {
+	t.Helper()
+	env := SetupTestEnv(t)
+
+	cfg.MultipartPath = env.TmpDir + "/multipart"
+	gateway, err := s3.NewGateway(cfg, env.Service, map[string]auth.HMACKey{
+		s3KeyID:   {Secret: []byte(s3Secret), UserID: "alice"},
+		"AKIDBOB": {Secret: []byte("bob-secret"), UserID: "bob"},
+	})
+	if err != nil {
+		env.Cleanup()
+		t.Fatalf("NewGateway: %v", err)
+	}
+	router := gin.New()
+	gateway.RegisterRoutes(router)
+	return &s3Env{TestEnv: env, gateway: router}
+}
+
+// do sends a request signed by alice, or unsigned if sign is false. The
+// payload hash is signed.
+func (e *s3Env) do(method, target string, body []byte, header http.Header, sign bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if sign {
+		if req.Header.Get("X-Amz-Content-Sha256") == "" {
+			sum := sha256.Sum256(body)
+			req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+		}
+		s3.SignRequest(req, s3KeyID, []byte(s3Secret), s3.DefaultRegion, time.Now())
+	}
+	w := httptest.NewRecorder()
+	e.gateway.ServeHTTP(w, req)
+	return w
+}
+
+func s3Code(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var e s3.Error
+	if err := xml.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatalf("response %d is not an S3 error: %q", w.Code, w.Body.String())
+	}
+	return e.Code
+}
+
+type listResult struct {
+	KeyCount              int
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key  string
+		ETag string
+		Size int64
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+func (e *s3Env) list(t *testing.T, query string) *listResult {
+	t.Helper()
+	w := e.do("GET", "/photos?list-type=2&"+query, nil, nil, true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ListObjectsV2 %q = %v: %s", query, w.Code, w.Body.String())
+	}
+	var result listResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("ListObjectsV2 response: %v", err)
+	}
+	return &result
+}
+
+func TestS3Gateway_Objects(t *testing.T) {
+	env := setupS3Env(t, s3.GatewayConfig{})
+	defer env.Cleanup()
+
+	if w := env.do("PUT", "/photos", nil, nil, true); w.Code != http.StatusOK {
+		t.Fatalf("CreateBucket = %v: %s", w.Code, w.Body.String())
+	}
+	if w := env.do("PUT", "/Bad_Bucket", nil, nil, true); w.Code != http.StatusBadRequest || s3Code(t, w) != "InvalidBucketName" {
+		t.Errorf("CreateBucket with invalid name = %v", w.Code)
+	}
+	if w := env.do("HEAD", "/photos", nil, nil, true); w.Code != http.StatusOK {
+		t.Errorf("HeadBucket = %v", w.Code)
+	}
+	if w := env.do("GET", "/", nil, nil, true); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<Name>photos</Name>") {
+		t.Errorf("ListBuckets = %v: %s", w.Code, w.Body.String())
+	}
+
+	// Objects are ordinary files, owned by the signing user
+	content := []byte("0123456789abcdefghij")
+	sum := md5.Sum(content)
+	header := http.Header{}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	header.Set("Content-Type", "text/plain")
+	w := env.do("PUT", "/photos/2024/beach.txt", content, header, true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PutObject = %v: %s", w.Code, w.Body.String())
+	}
+	meta, err := env.Metadata.GetByPath(context.Background(), "/photos/2024/beach.txt")
+	if err != nil {
+		t.Fatalf("uploaded object has no file: %v", err)
+	}
+	if meta.OwnerID != "alice" || meta.Size != int64(len(content)) || w.Header().Get("ETag") != meta.ETag() {
+		t.Errorf("file = owner %q size %d etag %q, response ETag %q", meta.OwnerID, meta.Size, meta.ETag(), w.Header().Get("ETag"))
+	}
+
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(make([]byte, md5.Size)))
+	if w := env.do("PUT", "/photos/bad.txt", content, header, true); w.Code != http.StatusBadRequest || s3Code(t, w) != "BadDigest" {
+		t.Errorf("PutObject with wrong Content-MD5 = %v", w.Code)
+	}
+	if w := env.do("PUT", "/missing/a.txt", content, nil, true); w.Code != http.StatusNotFound || s3Code(t, w) != "NoSuchBucket" {
+		t.Errorf("PutObject to missing bucket = %v", w.Code)
+	}
+
+	// Reads, whole and ranged
+	w = env.do("GET", "/photos/2024/beach.txt", nil, nil, true)
+	if w.Code != http.StatusOK || w.Body.String() != string(content) || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("GetObject = %v %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	ranges := []struct {
+		header, body, contentRange string
+	}{
+		{"bytes=2-5", "2345", "bytes 2-5/20"},
+		{"bytes=15-", "fghij", "bytes 15-19/20"},
+		{"bytes=-3", "hij", "bytes 17-19/20"},
+		{"bytes=18-100", "ij", "bytes 18-19/20"},
+	}
+	for _, tt := range ranges {
+		header := http.Header{}
+		header.Set("Range", tt.header)
+		w := env.do("GET", "/photos/2024/beach.txt", nil, header, true)
+		if w.Code != http.StatusPartialContent || w.Body.String() != tt.body || w.Header().Get("Content-Range") != tt.contentRange {
+			t.Errorf("Range %s = %v %q %q", tt.header, w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+		}
+	}
+	header = http.Header{}
+	header.Set("Range", "bytes=20-")
+	if w := env.do("GET", "/photos/2024/beach.txt", nil, header, true); w.Code != http.StatusRequestedRangeNotSatisfiable || s3Code(t, w) != "InvalidRange" {
+		t.Errorf("unsatisfiable Range = %v", w.Code)
+	}
+
+	w = env.do("HEAD", "/photos/2024/beach.txt", nil, nil, true)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != "20" || w.Body.Len() != 0 {
+		t.Errorf("HeadObject = %v %v", w.Code, w.Header())
+	}
+	header = http.Header{}
+	header.Set("If-None-Match", meta.ETag())
+	if w := env.do("GET", "/photos/2024/beach.txt", nil, header, true); w.Code != http.StatusNotModified {
+		t.Errorf("GetObject If-None-Match = %v, want %v", w.Code, http.StatusNotModified)
+	}
+	if w := env.do("HEAD", "/photos/2024/missing.txt", nil, nil, true); w.Code != http.StatusNotFound {
+		t.Errorf("HeadObject of missing key = %v", w.Code)
+	}
+	if w := env.do("GET", "/photos/2024", nil, nil, true); w.Code != http.StatusNotFound || s3Code(t, w) != "NoSuchKey" {
+		t.Errorf("GetObject of a directory = %v", w.Code)
+	}
+
+	// Listings with prefixes, delimiters and pages
+	for _, key := range []string{"2024/sunset.txt", "2025/snow.txt", "index.txt"} {
+		if w := env.do("PUT", "/photos/"+key, []byte(key), nil, true); w.Code != http.StatusOK {
+			t.Fatalf("PutObject %s = %v", key, w.Code)
+		}
+	}
+	if w := env.do("PUT", "/photos/empty/", nil, nil, true); w.Code != http.StatusOK {
+		t.Fatalf("PutObject of a directory marker = %v: %s", w.Code, w.Body.String())
+	}
+
+	all := env.list(t, "")
+	var keys []string
+	for _, c := range all.Contents {
+		keys = append(keys, c.Key)
+	}
+	want := "2024/beach.txt 2024/sunset.txt 2025/snow.txt empty/ index.txt"
+	if strings.Join(keys, " ") != want || all.KeyCount != 5 {
+		t.Errorf("keys = %v (%d), want %s", keys, all.KeyCount, want)
+	}
+	if all.Contents[0].ETag != meta.ETag() || all.Contents[0].Size != 20 {
+		t.Errorf("listed object = %+v", all.Contents[0])
+	}
+
+	shallow := env.list(t, "delimiter=/")
+	if len(shallow.Contents) != 1 || shallow.Contents[0].Key != "index.txt" || len(shallow.CommonPrefixes) != 3 ||
+		shallow.CommonPrefixes[0].Prefix != "2024/" {
+		t.Errorf("delimited listing = %+v", shallow)
+	}
+	prefixed := env.list(t, "prefix=2024/s&delimiter=/")
+	if len(prefixed.Contents) != 1 || prefixed.Contents[0].Key != "2024/sunset.txt" {
+		t.Errorf("prefixed listing = %+v", prefixed)
+	}
+
+	var paged []string
+	query := "max-keys=2"
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("listing does not end")
+		}
+		page := env.list(t, query)
+		for _, c := range page.Contents {
+			paged = append(paged, c.Key)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		query = "max-keys=2&continuation-token=" + page.NextContinuationToken
+	}
+	if strings.Join(paged, " ") != want {
+		t.Errorf("paged keys = %v, want %s", paged, want)
+	}
+
+	// Deletes
+	if w := env.do("DELETE", "/photos", nil, nil, true); w.Code != http.StatusConflict || s3Code(t, w) != "BucketNotEmpty" {
+		t.Errorf("DeleteBucket of a non-empty bucket = %v", w.Code)
+	}
+	if w := env.do("DELETE", "/photos/2024/beach.txt", nil, nil, true); w.Code != http.StatusNoContent {
+		t.Errorf("DeleteObject = %v", w.Code)
+	}
+	if w := env.do("DELETE", "/photos/2024/beach.txt", nil, nil, true); w.Code != http.StatusNoContent {
+		t.Errorf("DeleteObject of a missing key = %v", w.Code)
+	}
+	if _, err := env.Metadata.GetByPath(context.Background(), "/photos/2024/beach.txt"); err == nil {
+		t.Error("file still present after DeleteObject")
+	}
+
+	deleteBody := []byte(`<Delete><Object><Key>2024/sunset.txt</Key></Object><Object><Key>nothing.txt</Key></Object></Delete>`)
+	w = env.do("POST", "/photos?delete", deleteBody, nil, true)
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "<Deleted>") != 2 {
+		t.Errorf("DeleteObjects = %v: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestS3Gateway_Authentication(t *testing.T) {
+	env := setupS3Env(t, s3.GatewayConfig{})
+	defer env.Cleanup()
+
+	if w := env.do("GET", "/", nil, nil, false); w.Code != http.StatusForbidden || s3Code(t, w) != "AccessDenied" {
+		t.Errorf("unsigned request = %v, want %v", w.Code, http.StatusForbidden)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	s3.SignRequest(req, s3KeyID, []byte("wrong-secret"), s3.DefaultRegion, time.Now())
+	w := httptest.NewRecorder()
+	env.gateway.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || s3Code(t, w) != "SignatureDoesNotMatch" {
+		t.Errorf("wrong secret = %v", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	s3.SignRequest(req, s3KeyID, []byte(s3Secret), s3.DefaultRegion, time.Now().Add(-time.Hour))
+	w = httptest.NewRecorder()
+	env.gateway.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || s3Code(t, w) != "RequestTimeTooSkewed" {
+		t.Errorf("stale signature = %v", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	s3.SignRequest(req, "AKIDNOBODY", []byte(s3Secret), s3.DefaultRegion, time.Now())
+	w = httptest.NewRecorder()
+	env.gateway.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || s3Code(t, w) != "InvalidAccessKeyId" {
+		t.Errorf("unknown access key = %v", w.Code)
+	}
+
+	// A signed payload hash must match the payload
+	if w := env.do("PUT", "/docs", nil, nil, true); w.Code != http.StatusOK {
+		t.Fatalf("CreateBucket = %v", w.Code)
+	}
+	header := http.Header{}
+	header.Set("X-Amz-Content-Sha256", hex.EncodeToString(make([]byte, sha256.Size)))
+	if w := env.do("PUT", "/docs/a.txt", []byte("content"), header, true); w.Code != http.StatusBadRequest || s3Code(t, w) != "BadDigest" {
+		t.Errorf("PutObject with wrong payload hash = %v", w.Code)
+	}
+
+	// ACLs apply to S3 requests as to the REST API
+	if _, err := env.Service.SetACL(context.Background(), &metadata.ACL{Path: "/docs", Owner: "bob"}); err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+	if w := env.do("PUT", "/docs/b.txt", []byte("content"), nil, true); w.Code != http.StatusForbidden || s3Code(t, w) != "AccessDenied" {
+		t.Errorf("PutObject denied by ACL = %v", w.Code)
+	}
+}
+
+func TestS3Gateway_Anonymous(t *testing.T) {
+	env := setupS3Env(t, s3.GatewayConfig{AllowAnonymous: true})
+	defer env.Cleanup()
+
+	if w := env.do("PUT", "/public", nil, nil, false); w.Code != http.StatusOK {
+		t.Fatalf("anonymous CreateBucket = %v: %s", w.Code, w.Body.String())
+	}
+	if w := env.do("PUT", "/public/a.txt", []byte("hello"), nil, false); w.Code != http.StatusOK {
+		t.Fatalf("anonymous PutObject = %v: %s", w.Code, w.Body.String())
+	}
+	meta, err := env.Metadata.GetByPath(context.Background(), "/public/a.txt")
+	if err != nil || meta.OwnerID != "anonymous" {
+		t.Errorf("anonymous upload = %+v, %v", meta, err)
+	}
+}
+
+func TestS3Gateway_ChunkedUpload(t *testing.T) {
+	env := setupS3Env(t, s3.GatewayConfig{})
+	defer env.Cleanup()
+
+	if w := env.do("PUT", "/data", nil, nil, true); w.Code != http.StatusOK {
+		t.Fatalf("CreateBucket = %v", w.Code)
+	}
+
+	chunks := [][]byte{bytes.Repeat([]byte("a"), 1000), bytes.Repeat([]byte("b"), 500)}
+	upload := func(tamper bool) *httptest.ResponseRecorder {
+		now := time.Now().UTC()
+		req := httptest.NewRequest("PUT", "/data/chunked.bin", nil)
+		req.Header.Set("X-Amz-Content-Sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD")
+		req.Header.Set("X-Amz-Decoded-Content-Length", "1500")
+		req.Header.Set("Content-Encoding", "aws-chunked")
+		s3.SignRequest(req, s3KeyID, []byte(s3Secret), s3.DefaultRegion, now)
+		_, previous, _ := strings.Cut(req.Header.Get("Authorization"), "Signature=")
+
+		// Each chunk signature chains from the previous one
+		key := []byte("AWS4" + s3Secret)
+		for _, part := range []string{now.Format("20060102"), s3.DefaultRegion, "s3", "aws4_request"} {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(part))
+			key = mac.Sum(nil)
+		}
+		scope := now.Format("20060102") + "/" + s3.DefaultRegion + "/s3/aws4_request"
+		emptyHash := sha256.Sum256(nil)
+
+		var body bytes.Buffer
+		for _, chunk := range append(chunks, nil) {
+			hash := sha256.Sum256(chunk)
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(strings.Join([]string{"AWS4-HMAC-SHA256-PAYLOAD", now.Format("20060102T150405Z"), scope, previous,
+				hex.EncodeToString(emptyHash[:]), hex.EncodeToString(hash[:])}, "\n")))
+			previous = hex.EncodeToString(mac.Sum(nil))
+			if tamper && len(chunk) == 500 {
+				chunk = bytes.Repeat([]byte("c"), 500)
+			}
+			fmt.Fprintf(&body, "%x;chunk-signature=%s\r\n", len(chunk), previous)
+			body.Write(chunk)
+			if len(chunk) > 0 {
+				body.WriteString("\r\n")
+			}
+		}
+		body.WriteString("\r\n")
+		req.Body = io.NopCloser(&body)
+		req.ContentLength = int64(body.Len())
+
+		w := httptest.NewRecorder()
+		env.gateway.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := upload(false); w.Code != http.StatusOK {
+		t.Fatalf("chunked PutObject = %v: %s", w.Code, w.Body.String())
+	}
+	w := env.do("GET", "/data/chunked.bin", nil, nil, true)
+	if w.Body.String() != string(chunks[0])+string(chunks[1]) {
+		t.Errorf("chunked object = %d bytes, want 1500", w.Body.Len())
+	}
+
+	if w := upload(true); w.Code != http.StatusForbidden || s3Code(t, w) != "SignatureDoesNotMatch" {
+		t.Errorf("tampered chunk = %v: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestS3Gateway_Multipart(t *testing.T) {
+	env := setupS3Env(t, s3.GatewayConfig{})
+	defer env.Cleanup()
+
+	if w := env.do("PUT", "/media", nil, nil, true); w.Code != http.StatusOK {
+		t.Fatalf("CreateBucket = %v", w.Code)
+	}
+
+	initiate := func() string {
+		w := env.do("POST", "/media/video.bin?uploads", nil, nil, true)
+		var result struct{ UploadId string }
+		if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &result) != nil || result.UploadId == "" {
+			t.Fatalf("CreateMultipartUpload = %v: %s", w.Code, w.Body.String())
+		}
+		return result.UploadId
+	}
+	uploadPart := func(id string, number int, content []byte) string {
+		w := env.do("PUT", "/media/video.bin?partNumber="+strconv.Itoa(number)+"&uploadId="+id, content, nil, true)
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+			t.Fatalf("UploadPart %d = %v: %s", number, w.Code, w.Body.String())
+		}
+		return w.Header().Get("ETag")
+	}
+	complete := func(id string, etags map[int]string, order ...int) *httptest.ResponseRecorder {
+		var b strings.Builder
+		b.WriteString("<CompleteMultipartUpload>")
+		for _, n := range order {
+			fmt.Fprintf(&b, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", n, etags[n])
+		}
+		b.WriteString("</CompleteMultipartUpload>")
+		return env.do("POST", "/media/video.bin?uploadId="+id, []byte(b.String()), nil, true)
+	}
+
+	id := initiate()
+	parts := map[int][]byte{1: bytes.Repeat([]byte("1"), 100), 2: bytes.Repeat([]byte("2"), 50), 3: []byte("3")}
+	etags := map[int]string{}
+	for n, content := range parts {
+		etags[n] = uploadPart(id, n, content)
+	}
+	sum := md5.Sum(parts[2])
+	if etags[2] != `"`+hex.EncodeToString(sum[:])+`"` {
+		t.Errorf("part ETag = %s, want the part's MD5", etags[2])
+	}
+
+	// Another user cannot continue the upload
+	req := httptest.NewRequest("PUT", "/media/video.bin?partNumber=4&uploadId="+id, strings.NewReader("x"))
+	s3.SignRequest(req, "AKIDBOB", []byte("bob-secret"), s3.DefaultRegion, time.Now())
+	w := httptest.NewRecorder()
+	env.gateway.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("UploadPart by another user = %v", w.Code)
+	}
+
+	if w := complete(id, etags, 2, 1); w.Code != http.StatusBadRequest || s3Code(t, w) != "InvalidPartOrder" {
+		t.Errorf("complete out of order = %v", w.Code)
+	}
+	if w := complete(id, map[int]string{1: `"00"`}, 1); w.Code != http.StatusBadRequest || s3Code(t, w) != "InvalidPart" {
+		t.Errorf("complete with wrong ETag = %v", w.Code)
+	}
+
+	// Unlisted parts are dropped
+	w = complete(id, etags, 1, 2)
+	if w.Code != http.StatusOK {
+		t.Fatalf("CompleteMultipartUpload = %v: %s", w.Code, w.Body.String())
+	}
+	w = env.do("GET", "/media/video.bin", nil, nil, true)
+	if w.Body.String() != string(parts[1])+string(parts[2]) {
+		t.Errorf("completed object = %q", w.Body.String())
+	}
+	meta, err := env.Metadata.GetByPath(context.Background(), "/media/video.bin")
+	if err != nil || meta.OwnerID != "alice" || meta.Size != 150 {
+		t.Errorf("completed file = %+v, %v", meta, err)
+	}
+	if w := complete(id, etags, 1, 2); w.Code != http.StatusNotFound || s3Code(t, w) != "NoSuchUpload" {
+		t.Errorf("complete twice = %v", w.Code)
+	}
+
+	// Aborted uploads are gone
+	id = initiate()
+	uploadPart(id, 1, []byte("x"))
+	if w := env.do("DELETE", "/media/video.bin?uploadId="+id, nil, nil, true); w.Code != http.StatusNoContent {
+		t.Errorf("AbortMultipartUpload = %v", w.Code)
+	}
+	if w := env.do("PUT", "/media/video.bin?partNumber=2&uploadId="+id, []byte("y"), nil, true); w.Code != http.StatusNotFound || s3Code(t, w) != "NoSuchUpload" {
+		t.Errorf("UploadPart after abort = %v", w.Code)
+	}
+}